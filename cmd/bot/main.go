@@ -2,67 +2,169 @@ package main
 
 import (
 	"context"
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/botjoker/sambacrm-business-tg/internal/admin"
 	"github.com/botjoker/sambacrm-business-tg/internal/bot"
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
 	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
 	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/hibiken/asynq"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 )
 
+var logger = utils.Module("main")
+
 func main() {
 	// Загрузка переменных окружения
-	if err := godotenv.Load(); err != nil {
-		log.Println("Warning: .env file not found")
+	envErr := godotenv.Load()
+
+	// Настройки: env, *_FILE секреты и необязательный YAML (CONFIG_FILE)
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", err)
 	}
 
-	ctx := context.Background()
+	utils.SetupLogger(utils.LogOptions{
+		Format: cfg.Log.Format,
+		Level:  cfg.Log.Level,
+		Levels: cfg.Log.Levels,
+		Redact: cfg.Log.Redact,
+	})
+	if envErr != nil {
+		logger.Warn("Warning: .env file not found")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Трейсинг: экспорт в OTLP, если задан OTEL_EXPORTER_OTLP_ENDPOINT
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		fatal("Unable to setup tracing", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Warn("⚠️ Не удалось выгрузить трейсы", "error", err)
+		}
+	}()
 
 	// Подключение к БД
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL is required")
+	poolConfig, err := pgxpool.ParseConfig(cfg.Database.URL)
+	if err != nil {
+		fatal("Invalid DATABASE_URL", err)
 	}
+	poolConfig.ConnConfig.Tracer = tracing.PgxTracer{}
 
-	pool, err := pgxpool.New(ctx, dbURL)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v", err)
+		fatal("Unable to connect to database", err)
 	}
 	defer pool.Close()
 
 	// Подключение к Redis
-	redisClient, err := utils.NewRedisClient()
+	redisClient, err := utils.NewRedisClient(&redis.Options{
+		Addr:     cfg.Redis.Addr(),
+		Username: cfg.Redis.User,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
 	if err != nil {
-		log.Fatalf("Unable to connect to Redis: %v", err)
+		fatal("Unable to connect to Redis", err)
 	}
 	defer redisClient.Close()
 
-	log.Println("✅ Подключено к PostgreSQL и Redis")
+	logger.Info("✅ Подключено к PostgreSQL и Redis")
 
 	// Создаем storage
 	queries := storage.New(pool)
 
+	// Клиент очереди для отложенных задач (таймауты, задержки)
+	tasks, err := queue.NewAsynqClient(cfg.Redis)
+	if err != nil {
+		fatal("Unable to create Asynq client", err)
+	}
+	defer tasks.Close()
+
+	// Инспектор очередей: готовность, мониторинг, перенос задач остановленных реплик
+	inspector := queue.NewAsynqInspector(cfg.Redis)
+	defer inspector.Close()
+
 	// Создаем Bot Manager
-	manager := bot.NewManager(pool, queries)
+	manager := bot.NewManager(cfg, pool, queries, tasks, inspector, redisClient)
 
-	// Загружаем и запускаем всех активных ботов
-	if err := manager.LoadAndStartBots(ctx); err != nil {
-		log.Fatalf("Failed to start bots: %v", err)
+	// Запускаем ботов, принадлежащих этой реплике, и следим за распределением
+	if err := manager.RunCluster(ctx); err != nil {
+		fatal("Failed to start bots", err)
 	}
 
-	log.Println("✅ Telegram Bot Service запущен")
-	log.Printf("📊 Запущено ботов: %d", manager.ActiveBotsCount())
+	// Статусы оплаты заказов из PostgreSQL NOTIFY (узел payment)
+	go manager.ListenPaymentEvents(ctx)
+
+	// Запускаем обработчик очереди
+	taskServer, err := queue.NewAsynqServer(cfg.Redis, cfg.Queue, cfg.Shutdown, manager.ReplicaID())
+	if err != nil {
+		fatal("Unable to create Asynq server", err)
+	}
+
+	mux := asynq.NewServeMux()
+	mux.Use(tracing.AsynqMiddleware)
+	mux.HandleFunc(queue.TypeWorkflowDelay, queue.HandleDelayWorkflow)
+	mux.HandleFunc(queue.TypeWorkflowSchedule, queue.HandleScheduleWorkflow)
+	manager.RegisterTaskHandlers(mux)
+
+	if err := taskServer.Start(mux); err != nil {
+		fatal("Failed to start Asynq server", err)
+	}
+
+	// Служебный HTTP сервер: /healthz, /readyz, /bots, вебхук оплаты
+	adminServer := admin.NewServer(cfg.Admin, cfg.Payments, pool, redisClient, inspector, manager)
+	adminServer.Start()
+
+	logger.Info("✅ Telegram Bot Service запущен")
+	logger.Info("📊 Запущено ботов", "count", manager.ActiveBotsCount(), "replica_id", manager.ReplicaID())
 
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("🛑 Остановка сервиса...")
-	manager.StopAll()
-	log.Println("✅ Сервис остановлен")
+	logger.Info("🛑 Остановка сервиса...")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer shutdownCancel()
+
+	// 1. Новые задачи из очереди не берем, активные продолжают выполняться
+	taskServer.Stop()
+	// 2. Останавливаем распределение ботов между репликами
+	cancel()
+	// 3. Останавливаем поллеры и ждем обработчики уже полученных апдейтов (и их запросы к AI).
+	//    Отдельного буфера исходящих сообщений нет: ответы отправляются из обработчиков,
+	//    поэтому после этого шага все ответы отправлены, а боты освобождены для других реплик
+	manager.Shutdown(shutdownCtx)
+	// 4. Ждем активные задачи Asynq (не дольше SHUTDOWN_TIMEOUT)
+	taskServer.Shutdown()
+
+	adminCtx, adminCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer adminCancel()
+	adminServer.Shutdown(adminCtx)
+	logger.Info("✅ Сервис остановлен")
+}
+
+// fatal логирует ошибку запуска и завершает процесс
+func fatal(msg string, err error) {
+	if err != nil {
+		logger.Error(msg, "error", err)
+	} else {
+		logger.Error(msg)
+	}
+	os.Exit(1)
 }
@@ -0,0 +1,244 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/bot"
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/metrics"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+var logger = utils.Module("admin")
+
+// checkTimeout - таймаут одной проверки зависимости в /readyz
+const checkTimeout = 2 * time.Second
+
+// Server - служебный HTTP сервер для оркестратора и администраторов:
+//
+//	GET /healthz - процесс жив
+//	GET /readyz  - доступны PostgreSQL, Redis и очередь Asynq
+//	GET /bots    - состояние ботов этой реплики (заголовок X-Admin-Secret)
+//	GET /metrics - метрики в формате Prometheus
+//	POST /webhooks/payment - смена статуса заказа на оплату (если задан PAYMENT_WEBHOOK_SECRET)
+type Server struct {
+	payments  config.Payments
+	pool      *pgxpool.Pool
+	redis     *redis.Client
+	inspector *asynq.Inspector
+	manager   *bot.Manager
+	http      *http.Server
+}
+
+// NewServer создает сервер на адресе ADMIN_ADDR (по умолчанию :8080)
+func NewServer(cfg config.Admin, payments config.Payments, pool *pgxpool.Pool, redisClient *redis.Client, inspector *asynq.Inspector, manager *bot.Manager) *Server {
+	s := &Server{
+		payments:  payments,
+		pool:      pool,
+		redis:     redisClient,
+		inspector: inspector,
+		manager:   manager,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	// Без ADMIN_SECRET /bots отвечает 401 на любой запрос
+	if cfg.Secret == "" {
+		logger.Warn("⚠️ ADMIN_SECRET не задан - /bots закрыт")
+	}
+	mux.HandleFunc("/bots", requireSecret("X-Admin-Secret", cfg.Secret, s.handleBots))
+	mux.Handle("/metrics", metrics.Default.Handler())
+	if payments.WebhookSecret != "" {
+		mux.HandleFunc("/webhooks/payment", s.handlePaymentWebhook)
+	}
+
+	manager.RegisterMetrics(metrics.Default)
+	metrics.Default.OnScrape(s.collectQueueSize)
+
+	s.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return s
+}
+
+// Start запускает сервер в отдельной горутине
+func (s *Server) Start() {
+	go func() {
+		logger.Info("🩺 Admin сервер запущен", "addr", s.http.Addr)
+		if err := s.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("❌ Admin сервер остановлен", "error", err)
+		}
+	}()
+}
+
+// Shutdown останавливает сервер
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"postgres": func(ctx context.Context) error {
+			return s.pool.Ping(ctx)
+		},
+		"redis": func(ctx context.Context) error {
+			return s.redis.Ping(ctx).Err()
+		},
+		"asynq": func(ctx context.Context) error {
+			// Inspector не принимает контекст - ограничиваем ожидание сами
+			done := make(chan error, 1)
+			go func() {
+				_, err := s.inspector.Queues()
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string, len(checks))
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		err := check(ctx)
+		cancel()
+
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	writeJSON(w, status, map[string]interface{}{
+		"status": http.StatusText(status),
+		"checks": results,
+	})
+}
+
+func (s *Server) handleBots(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"replica_id": s.manager.ReplicaID(),
+		"bots":       s.manager.BotStatuses(),
+	})
+}
+
+// requireSecret пропускает к обработчику только запросы с секретом в заголовке header
+func requireSecret(header, secret string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !validSecret(r, header, secret) {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid secret"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validSecret сравнивает секрет из заголовка за постоянное время.
+// Пустой секрет не подходит ни к одному запросу
+func validSecret(r *http.Request, header, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(secret)) == 1
+}
+
+// maxWebhookBody - ограничение размера тела вебхука
+const maxWebhookBody = 64 << 10
+
+// handlePaymentWebhook принимает смену статуса заказа от CRM:
+//
+//	POST /webhooks/payment
+//	X-Webhook-Secret: <PAYMENT_WEBHOOK_SECRET>
+//	{"payment_order_id": "...", "status": "paid"}
+//
+// Событие уходит в очередь; бот, чей workflow ждет оплату, продолжит его
+func (s *Server) handlePaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if !validSecret(r, "X-Webhook-Secret", s.payments.WebhookSecret) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid secret"})
+		return
+	}
+
+	var body struct {
+		PaymentOrderID uuid.UUID `json:"payment_order_id"`
+		Status         string    `json:"status"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&body); err != nil || body.PaymentOrderID == uuid.Nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "payment_order_id is required"})
+		return
+	}
+
+	if err := s.manager.NotifyPaymentStatus(r.Context(), body.PaymentOrderID, body.Status); err != nil {
+		logger.ErrorContext(r.Context(), "❌ Не удалось принять статус оплаты", "payment_order_id", body.PaymentOrderID.String(), "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to enqueue"})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+// collectQueueSize обновляет глубину очередей Asynq
+func (s *Server) collectQueueSize() {
+	queues, err := s.inspector.Queues()
+	if err != nil {
+		logger.Warn("⚠️ Не удалось получить очереди Asynq", "error", err)
+		return
+	}
+
+	err = metrics.Refill(metrics.QueueSize, func(set func(float64, ...string) error) error {
+		for _, name := range queues {
+			info, err := s.inspector.GetQueueInfo(name)
+			if err != nil {
+				logger.Warn("⚠️ Не удалось получить очередь", "queue", name, "error", err)
+				continue
+			}
+
+			for state, size := range map[string]int{
+				"pending":   info.Pending,
+				"active":    info.Active,
+				"scheduled": info.Scheduled,
+				"retry":     info.Retry,
+				"archived":  info.Archived,
+			} {
+				if err := set(float64(size), name, state); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("❌ Не удалось обновить tg_asynq_queue_size", "error", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Failed to write response", "error", err)
+	}
+}
@@ -2,33 +2,70 @@ package ai
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
-	"os"
 
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
 	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
 	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var logger = utils.Module("ai")
+
 // Provider - интерфейс для AI провайдеров
 type Provider interface {
-	GenerateResponse(ctx context.Context, systemPrompt, userMessage, ragContext string) (string, error)
+	// GenerateResponse генерирует ответ; images передаются vision-моделям вместе с текстом
+	GenerateResponse(ctx context.Context, systemPrompt, userMessage, ragContext string, images ...Image) (*Response, error)
+	// Model возвращает модель, которой отвечает провайдер
+	Model() string
+}
+
+// Response - ответ модели и израсходованные токены
+type Response struct {
+	Text  string
+	Model string
+	Usage Usage
+}
+
+// Usage - токены, израсходованные на запрос
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Image - изображение от пользователя для мультимодальных моделей
+type Image struct {
+	Data     []byte
+	MIMEType string
+}
+
+// dataURL кодирует изображение в data URL для передачи в API
+func (i Image) dataURL() string {
+	mimeType := i.MIMEType
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(i.Data))
 }
 
 // NewProvider создает AI провайдера на основе конфигурации бота
-func NewProvider(config storage.TelegramBot) Provider {
+func NewProvider(cfg config.AI, botConfig storage.TelegramBot) Provider {
 	provider := "openai"
-	if config.AiProvider.Valid {
-		provider = config.AiProvider.String
+	if botConfig.AiProvider.Valid {
+		provider = botConfig.AiProvider.String
 	}
 
 	switch provider {
 	case "openai":
-		return NewOpenAIProvider(config)
+		return NewOpenAIProvider(cfg, botConfig)
 	case "anthropic":
 		// TODO: реализовать Anthropic
-		return NewOpenAIProvider(config) // fallback
+		return NewOpenAIProvider(cfg, botConfig) // fallback
 	default:
-		return NewOpenAIProvider(config)
+		return NewOpenAIProvider(cfg, botConfig)
 	}
 }
 
@@ -40,26 +77,24 @@ type OpenAIProvider struct {
 	maxTokens   int
 }
 
-func NewOpenAIProvider(config storage.TelegramBot) *OpenAIProvider {
-	// API ключ из env переменной (credentials будем подключать позже)
-	apiKey := os.Getenv("OPENAI_API_KEY")
-
-	client := openai.NewClient(apiKey)
+func NewOpenAIProvider(cfg config.AI, botConfig storage.TelegramBot) *OpenAIProvider {
+	// API ключ сервиса (credentials бота будем подключать позже)
+	client := openai.NewClient(cfg.OpenAIAPIKey)
 
 	model := "gpt-4"
-	if config.AiModel.Valid {
-		model = config.AiModel.String
+	if botConfig.AiModel.Valid {
+		model = botConfig.AiModel.String
 	}
 
 	temperature := float32(0.7)
-	if config.AiTemperature.Valid {
+	if botConfig.AiTemperature.Valid {
 		// TODO: конвертировать pgtype.Numeric в float32
 		temperature = 0.7
 	}
 
 	maxTokens := 2000
-	if config.AiMaxTokens.Valid {
-		maxTokens = int(config.AiMaxTokens.Int32)
+	if botConfig.AiMaxTokens.Valid {
+		maxTokens = int(botConfig.AiMaxTokens.Int32)
 	}
 
 	return &OpenAIProvider{
@@ -70,7 +105,11 @@ func NewOpenAIProvider(config storage.TelegramBot) *OpenAIProvider {
 	}
 }
 
-func (p *OpenAIProvider) GenerateResponse(ctx context.Context, systemPrompt, userMessage, ragContext string) (string, error) {
+func (p *OpenAIProvider) Model() string {
+	return p.model
+}
+
+func (p *OpenAIProvider) GenerateResponse(ctx context.Context, systemPrompt, userMessage, ragContext string, images ...Image) (*Response, error) {
 	messages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
@@ -86,10 +125,40 @@ func (p *OpenAIProvider) GenerateResponse(ctx context.Context, systemPrompt, use
 		})
 	}
 
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: userMessage,
-	})
+	if len(images) == 0 {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: userMessage,
+		})
+	} else {
+		// Мультимодальное сообщение: подпись + изображения
+		parts := make([]openai.ChatMessagePart, 0, len(images)+1)
+		if userMessage != "" {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeText,
+				Text: userMessage,
+			})
+		}
+		for _, img := range images {
+			parts = append(parts, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeImageURL,
+				ImageURL: &openai.ChatMessageImageURL{
+					URL:    img.dataURL(),
+					Detail: openai.ImageURLDetailAuto,
+				},
+			})
+		}
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:         openai.ChatMessageRoleUser,
+			MultiContent: parts,
+		})
+	}
+
+	ctx, span := tracing.Start(ctx, "ai.generate",
+		attribute.String("ai.provider", "openai"),
+		attribute.String("ai.model", p.model),
+		attribute.Int("ai.images", len(images)),
+	)
 
 	resp, err := p.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model:       p.model,
@@ -98,13 +167,33 @@ func (p *OpenAIProvider) GenerateResponse(ctx context.Context, systemPrompt, use
 		MaxTokens:   p.maxTokens,
 	})
 
+	if err == nil && len(resp.Choices) == 0 {
+		err = fmt.Errorf("no response from OpenAI")
+	} else if err != nil {
+		err = fmt.Errorf("openai error: %w", err)
+	}
 	if err != nil {
-		return "", fmt.Errorf("openai error: %w", err)
+		tracing.End(span, err)
+		return nil, err
 	}
 
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+	span.SetAttributes(
+		attribute.Int("ai.prompt_tokens", resp.Usage.PromptTokens),
+		attribute.Int("ai.completion_tokens", resp.Usage.CompletionTokens),
+	)
+	span.End()
+
+	model := resp.Model
+	if model == "" {
+		model = p.model
 	}
 
-	return resp.Choices[0].Message.Content, nil
+	return &Response{
+		Text:  resp.Choices[0].Message.Content,
+		Model: model,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
 }
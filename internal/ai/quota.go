@@ -0,0 +1,103 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// quotaCacheTTL - как долго доверять последней проверке лимита профиля
+const quotaCacheTTL = time.Minute
+
+// QuotaLimits - месячные лимиты токенов по тарифу профиля (CoreProfile.Tariff).
+// 0 - без ограничений.
+type QuotaLimits struct {
+	ByTariff map[string]int64
+	Default  int64
+}
+
+// QuotaLimitsFromConfig берет лимиты из настроек сервиса
+// (AI_TOKEN_LIMITS="free:50000,basic:500000,pro:0" и AI_DEFAULT_TOKEN_LIMIT)
+func QuotaLimitsFromConfig(cfg config.AI) QuotaLimits {
+	return QuotaLimits{
+		ByTariff: cfg.TokenLimits,
+		Default:  cfg.DefaultTokenLimit,
+	}
+}
+
+// Limit возвращает лимит для тарифа
+func (l QuotaLimits) Limit(tariff string) int64 {
+	if limit, ok := l.ByTariff[tariff]; ok {
+		return limit
+	}
+	return l.Default
+}
+
+// Quota учитывает расход токенов и проверяет лимиты тарифа.
+// Расход хранится в telegram_ai_usage по дням, лимит действует на календарный месяц.
+type Quota struct {
+	queries *storage.Queries
+	limits  QuotaLimits
+
+	mu    sync.Mutex
+	cache map[[16]byte]quotaCheck
+}
+
+type quotaCheck struct {
+	exceeded  bool
+	checkedAt time.Time
+}
+
+func NewQuota(queries *storage.Queries, limits QuotaLimits) *Quota {
+	return &Quota{
+		queries: queries,
+		limits:  limits,
+		cache:   make(map[[16]byte]quotaCheck),
+	}
+}
+
+// Exceeded проверяет, исчерпан ли месячный лимит токенов профиля
+func (q *Quota) Exceeded(ctx context.Context, profileID pgtype.UUID) (bool, error) {
+	q.mu.Lock()
+	check, ok := q.cache[profileID.Bytes]
+	q.mu.Unlock()
+	if ok && time.Since(check.checkedAt) < quotaCacheTTL {
+		return check.exceeded, nil
+	}
+
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	usage, err := q.queries.GetProfileAIUsage(ctx, storage.GetProfileAIUsageParams{
+		Since:     pgtype.Date{Time: monthStart, Valid: true},
+		ProfileID: profileID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to load AI usage: %w", err)
+	}
+
+	limit := q.limits.Limit(usage.Tariff.String)
+	exceeded := limit > 0 && usage.Tokens >= limit
+
+	q.mu.Lock()
+	q.cache[profileID.Bytes] = quotaCheck{exceeded: exceeded, checkedAt: now}
+	q.mu.Unlock()
+
+	return exceeded, nil
+}
+
+// Record добавляет расход токенов запроса в дневную статистику бота
+func (q *Quota) Record(ctx context.Context, botID, profileID pgtype.UUID, resp *Response) error {
+	return q.queries.RecordAIUsage(ctx, storage.RecordAIUsageParams{
+		ProfileID:        profileID,
+		BotID:            botID,
+		Model:            resp.Model,
+		PromptTokens:     int64(resp.Usage.PromptTokens),
+		CompletionTokens: int64(resp.Usage.CompletionTokens),
+	})
+}
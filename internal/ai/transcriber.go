@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Transcriber - интерфейс для распознавания речи (speech-to-text)
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio io.Reader, filename string) (string, error)
+}
+
+// NewTranscriber создает STT провайдера по настройкам сервиса.
+//
+// STT_PROVIDER:
+//   - "openai" - OpenAI Whisper API (по умолчанию, если задан OPENAI_API_KEY)
+//   - "local"  - локальный Whisper-совместимый сервер по адресу STT_BASE_URL
+//   - "none"   - распознавание отключено
+//
+// Возвращает nil, если распознавание отключено.
+func NewTranscriber(cfg config.AI) Transcriber {
+	provider := cfg.STT.Provider
+	if provider == "" {
+		provider = "none"
+		if cfg.OpenAIAPIKey != "" {
+			provider = "openai"
+		}
+	}
+
+	switch provider {
+	case "openai":
+		clientConfig := openai.DefaultConfig(cfg.OpenAIAPIKey)
+		if cfg.STT.BaseURL != "" {
+			clientConfig.BaseURL = cfg.STT.BaseURL
+		}
+		return NewWhisperTranscriber(clientConfig, cfg.STT)
+	case "local":
+		baseURL := cfg.STT.BaseURL
+		if baseURL == "" {
+			baseURL = "http://localhost:8000/v1"
+		}
+		// Локальным серверам ключ обычно не нужен, но передаем если задан
+		clientConfig := openai.DefaultConfig(cfg.STT.APIKey)
+		clientConfig.BaseURL = baseURL
+		return NewWhisperTranscriber(clientConfig, cfg.STT)
+	default:
+		return nil
+	}
+}
+
+// WhisperTranscriber реализует Transcriber для OpenAI Whisper-совместимого API
+type WhisperTranscriber struct {
+	client   *openai.Client
+	model    string
+	language string
+}
+
+func NewWhisperTranscriber(clientConfig openai.ClientConfig, cfg config.STT) *WhisperTranscriber {
+	model := cfg.Model
+	if model == "" {
+		model = openai.Whisper1
+	}
+
+	return &WhisperTranscriber{
+		client:   openai.NewClientWithConfig(clientConfig),
+		model:    model,
+		language: cfg.Language,
+	}
+}
+
+func (t *WhisperTranscriber) Transcribe(ctx context.Context, audio io.Reader, filename string) (string, error) {
+	ctx, span := tracing.Start(ctx, "ai.transcribe", attribute.String("ai.model", t.model))
+
+	resp, err := t.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    t.model,
+		FilePath: filename,
+		Reader:   audio,
+		Language: t.language,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		err = fmt.Errorf("whisper error: %w", err)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(resp.Text), nil
+}
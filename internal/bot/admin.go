@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Команды сотрудников бизнеса.
+//
+// Сотрудник - владелец профиля (core_profiles.contact_telegram) или аккаунт CRM,
+// привязанный к Telegram (core_accounts.tg), с правами telegram.* в роли
+// (core_role_permissions). Для остальных пользователей команды не существуют:
+// они обрабатываются как обычный текст и не показываются в меню.
+
+// Права сотрудника в боте (core_permissions.name)
+const (
+	permTelegramStats         = "telegram.stats"
+	permTelegramLeads         = "telegram.leads"
+	permTelegramBroadcast     = "telegram.broadcast"
+	permTelegramNotifications = "telegram.notifications"
+)
+
+// Callback кнопок предпросмотра рассылки
+const (
+	callbackAdminPrefix          = "admin:"
+	callbackAdminBroadcastSend   = "admin:broadcast:send"
+	callbackAdminBroadcastCancel = "admin:broadcast:cancel"
+)
+
+const (
+	// recentLeadsLimit - сколько новых клиентов показывает /leads
+	recentLeadsLimit = 10
+	// broadcastDraftKey - черновик рассылки в контексте разговора сотрудника
+	broadcastDraftKey = "broadcast_draft"
+)
+
+// adminCommand - команда сотрудника для меню и /help
+type adminCommand struct {
+	command     string
+	description string
+	permission  string
+}
+
+var adminCommands = []adminCommand{
+	{"admin", "Команды сотрудника", ""},
+	{"stats", "Статистика за сегодня", permTelegramStats},
+	{"leads", "Новые клиенты", permTelegramLeads},
+	{"broadcast", "Рассылка: /broadcast текст", permTelegramBroadcast},
+	{"notifications", "Уведомления о заказах и записях: on / off", permTelegramNotifications},
+}
+
+// staffUser - сотрудник, написавший боту
+type staffUser struct {
+	AccountID pgtype.UUID
+	Owner     bool
+	perms     map[string]bool
+}
+
+func (u *staffUser) can(permission string) bool {
+	return u.Owner || permission == "" || u.perms[permission]
+}
+
+// staffUser возвращает сотрудника по Telegram пользователя; nil - обычный клиент
+func (h *MessageHandler) staffUser(ctx context.Context, user *tele.User) (*staffUser, error) {
+	owner, err := h.queries.IsProfileOwnerTelegram(ctx, storage.IsProfileOwnerTelegramParams{
+		ID:             h.botConfig.ProfileID,
+		TelegramUserID: strconv.FormatInt(user.ID, 10),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check profile owner: %w", err)
+	}
+
+	staff := &staffUser{Owner: owner, perms: make(map[string]bool)}
+
+	account, err := h.operatorAccount(ctx, user)
+	switch {
+	case errors.Is(err, errOperatorNotLinked):
+	case err != nil:
+		return nil, err
+	default:
+		staff.AccountID = account.ID
+		if account.RoleID.Valid {
+			perms, err := h.queries.GetRolePermissions(ctx, storage.GetRolePermissionsParams{
+				ProfileID: h.botConfig.ProfileID,
+				RoleID:    account.RoleID,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to load permissions: %w", err)
+			}
+			for _, perm := range perms {
+				if strings.HasPrefix(perm, "telegram.") {
+					staff.perms[perm] = true
+				}
+			}
+		}
+	}
+
+	if !staff.Owner && len(staff.perms) == 0 {
+		return nil, nil
+	}
+	return staff, nil
+}
+
+// staffOnly оборачивает команду сотрудника: клиенту она не видна (уходит в HandleText),
+// сотруднику без права permission бот отказывает
+func (h *MessageHandler) staffOnly(permission string, fn func(ctx context.Context, c tele.Context, staff *staffUser) error) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if c.Chat().Type != tele.ChatPrivate {
+			return h.HandleText(c)
+		}
+
+		ctx := requestContext(c)
+		staff, err := h.staffUser(ctx, c.Sender())
+		if err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось проверить права сотрудника", "error", err)
+		}
+		if staff == nil {
+			return h.HandleText(c)
+		}
+
+		h.logMessage(ctx, c, false)
+		if !staff.can(permission) {
+			return c.Send("Недостаточно прав. Нужно право " + permission + " в роли CRM.")
+		}
+		return fn(ctx, c, staff)
+	}
+}
+
+// HandleAdmin показывает команды сотрудника, добавляет их в меню этого чата
+// и подписывает на уведомления, если есть право
+func (h *MessageHandler) HandleAdmin(ctx context.Context, c tele.Context, staff *staffUser) error {
+	if staff.can(permTelegramNotifications) {
+		h.subscribeStaff(ctx, c, staff)
+	}
+	h.syncStaffCommands(ctx, c, staff)
+
+	return c.Send(staffHelpText(staff))
+}
+
+// syncStaffCommands показывает команды сотрудника в меню только в его чате
+func (h *MessageHandler) syncStaffCommands(ctx context.Context, c tele.Context, staff *staffUser) {
+	workflows, err := h.queries.GetActiveWorkflowsByBot(ctx, h.botConfig.ID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Ошибка загрузки workflows", "error", err)
+	}
+
+	commands := buildCommandMenu(h.settings.menuCommands(), workflows).forLanguage("")
+	for _, cmd := range adminCommands {
+		if staff.can(cmd.permission) {
+			commands = append(commands, tele.Command{Text: cmd.command, Description: cmd.description})
+		}
+	}
+
+	scope := tele.CommandScope{Type: tele.CommandScopeChat, ChatID: c.Chat().ID}
+	if err := c.Bot().SetCommands(commands, scope); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось обновить меню команд сотрудника", "error", err)
+	}
+}
+
+func staffHelpText(staff *staffUser) string {
+	lines := []string{"Команды сотрудника:"}
+	for _, cmd := range adminCommands {
+		if staff.can(cmd.permission) {
+			lines = append(lines, "/"+cmd.command+" - "+cmd.description)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleStats - /stats: диалоги, сообщения и конверсии профиля за сегодня
+func (h *MessageHandler) HandleStats(ctx context.Context, c tele.Context, staff *staffUser) error {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	stats, err := h.queries.GetTelegramDailyStats(ctx, storage.GetTelegramDailyStatsParams{
+		ProfileID: h.botConfig.ProfileID,
+		Since:     pgtype.Timestamptz{Time: today, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load stats: %w", err)
+	}
+
+	conversion := 0.0
+	if stats.Chats > 0 {
+		conversion = float64(stats.PaidOrders) / float64(stats.Chats) * 100
+	}
+
+	return c.Send(fmt.Sprintf(
+		"📊 Сегодня, %s\n\nДиалогов: %d\nСообщений от клиентов: %d\nОтветов: %d\nНовых клиентов: %d\n"+
+			"Заказов: %d (оплачено: %d)\nЗаписей: %d\nКонверсия диалогов в оплату: %.1f%%",
+		today.Format("02.01.2006"), stats.Chats, stats.Incoming, stats.Outgoing, stats.NewCustomers,
+		stats.Orders, stats.PaidOrders, stats.Appointments, conversion,
+	))
+}
+
+// HandleLeads - /leads: последние новые клиенты
+func (h *MessageHandler) HandleLeads(ctx context.Context, c tele.Context, staff *staffUser) error {
+	customers, err := h.queries.GetRecentCustomers(ctx, storage.GetRecentCustomersParams{
+		ProfileID: h.botConfig.ProfileID,
+		Limit:     recentLeadsLimit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load customers: %w", err)
+	}
+	if len(customers) == 0 {
+		return c.Send("Новых клиентов пока нет.")
+	}
+
+	lines := []string{"👥 Новые клиенты:"}
+	for _, customer := range customers {
+		parts := []string{customer.Name}
+		for _, field := range []pgtype.Text{customer.Phone, customer.Email} {
+			if field.Valid && field.String != "" {
+				parts = append(parts, field.String)
+			}
+		}
+		line := "• " + strings.Join(parts, ", ")
+		if customer.CreatedAt.Valid {
+			line += " - " + customer.CreatedAt.Time.Local().Format("02.01 15:04")
+		}
+		if customer.Source.Valid && customer.Source.String != "" {
+			line += " (" + customer.Source.String + ")"
+		}
+		lines = append(lines, line)
+	}
+	return c.Send(strings.Join(lines, "\n"))
+}
+
+// HandleBroadcast - /broadcast текст: сохраняет черновик и показывает предпросмотр
+// с кнопками отправки. Рассылка уходит задачей очереди (см. handleBroadcast)
+func (h *MessageHandler) HandleBroadcast(ctx context.Context, c tele.Context, staff *staffUser) error {
+	text := strings.TrimSpace(c.Message().Payload)
+	if text == "" {
+		return c.Send("Напишите текст рассылки после команды:\n/broadcast Скидка 20% на все до воскресенья!")
+	}
+
+	chats, err := h.queries.ListBroadcastChats(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients: %w", err)
+	}
+
+	h.updateConversationContext(ctx, c, map[string]interface{}{broadcastDraftKey: text})
+
+	if err := c.Send(fmt.Sprintf("👁 Предпросмотр рассылки. Получателей: %d", len(chats))); err != nil {
+		return err
+	}
+
+	markup := &tele.ReplyMarkup{}
+	markup.InlineKeyboard = [][]tele.InlineButton{{
+		{Text: "📤 Отправить", Data: callbackAdminBroadcastSend},
+		{Text: "✖️ Отменить", Data: callbackAdminBroadcastCancel},
+	}}
+	return c.Send(text, markup)
+}
+
+// handleAdminCallback обрабатывает кнопки предпросмотра рассылки
+func (h *MessageHandler) handleAdminCallback(ctx context.Context, c tele.Context, data string) error {
+	staff, err := h.staffUser(ctx, c.Sender())
+	if err != nil {
+		return err
+	}
+	if staff == nil || !staff.can(permTelegramBroadcast) {
+		return c.Respond(&tele.CallbackResponse{Text: "Недостаточно прав"})
+	}
+
+	// Кнопки убираем, чтобы рассылку нельзя было отправить дважды
+	if msg := c.Callback().Message; msg != nil {
+		if _, err := c.Bot().EditReplyMarkup(msg, nil); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось убрать кнопки", "error", err)
+		}
+	}
+
+	draft := h.broadcastDraft(ctx, c.Chat().ID)
+	h.updateConversationContext(ctx, c, map[string]interface{}{broadcastDraftKey: nil})
+
+	if data != callbackAdminBroadcastSend {
+		return c.Respond(&tele.CallbackResponse{Text: "Рассылка отменена"})
+	}
+	if draft == "" {
+		return c.Respond(&tele.CallbackResponse{Text: "Черновик не найден, отправьте /broadcast еще раз"})
+	}
+
+	task, err := queue.NewBroadcastTask(queue.BroadcastPayload{
+		BotID:        uuid.UUID(h.botConfig.ID.Bytes),
+		AccountID:    uuid.UUID(staff.AccountID.Bytes),
+		ReportChatID: c.Chat().ID,
+		Text:         draft,
+		Carrier:      tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	})
+	if err != nil {
+		return err
+	}
+	if h.tasks == nil {
+		return fmt.Errorf("task queue is not configured")
+	}
+	if _, err := h.tasks.EnqueueContext(ctx, task); err != nil {
+		return fmt.Errorf("failed to enqueue broadcast: %w", err)
+	}
+
+	logger.InfoContext(ctx, "📤 Рассылка поставлена в очередь")
+	return c.Respond(&tele.CallbackResponse{Text: "Рассылка запущена, пришлю отчет"})
+}
+
+// broadcastDraft возвращает черновик рассылки из контекста разговора сотрудника
+func (h *MessageHandler) broadcastDraft(ctx context.Context, chatID int64) string {
+	var draft string
+	h.conversationValue(ctx, chatID, broadcastDraftKey, &draft)
+	return draft
+}
+
+// HandleNotifications - /notifications on|off: уведомления о новых заказах и записях
+func (h *MessageHandler) HandleNotifications(ctx context.Context, c tele.Context, staff *staffUser) error {
+	switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+	case "off", "выкл":
+		if err := h.queries.DeleteAdminSubscription(ctx, storage.DeleteAdminSubscriptionParams{
+			BotID:  h.botConfig.ID,
+			ChatID: c.Chat().ID,
+		}); err != nil {
+			return fmt.Errorf("failed to unsubscribe: %w", err)
+		}
+		return c.Send("🔕 Уведомления выключены")
+	default:
+		if !h.subscribeStaff(ctx, c, staff) {
+			return c.Send("Не удалось включить уведомления, попробуйте позже.")
+		}
+		return c.Send("🔔 Уведомления о новых заказах и записях включены. Выключить: /notifications off")
+	}
+}
+
+func (h *MessageHandler) subscribeStaff(ctx context.Context, c tele.Context, staff *staffUser) bool {
+	if err := h.queries.UpsertAdminSubscription(ctx, storage.UpsertAdminSubscriptionParams{
+		ProfileID: h.botConfig.ProfileID,
+		BotID:     h.botConfig.ID,
+		AccountID: staff.AccountID,
+		ChatID:    c.Chat().ID,
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to subscribe staff", "error", err)
+		return false
+	}
+	return true
+}
+
+// formatAmount форматирует сумму из numeric
+func formatAmount(amount pgtype.Numeric) string {
+	value, err := amount.Float64Value()
+	if err != nil || !value.Valid {
+		return "-"
+	}
+	return strconv.FormatFloat(value.Float64, 'f', 2, 64)
+}
@@ -0,0 +1,173 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// adminEventsInterval - как часто бот проверяет новые заказы и записи
+	adminEventsInterval = 30 * time.Second
+	// adminEventsKeyPrefix - отметка последнего отправленного события в Redis,
+	// чтобы реплика, забравшая бота, не повторила уведомления
+	adminEventsKeyPrefix = "tg:admin-events:"
+	adminEventsKeyTTL    = 7 * 24 * time.Hour
+)
+
+// watchAdminEvents присылает подписанным сотрудникам (/notifications) новые
+// заказы и записи профиля. Работает, пока бот запущен.
+func (b *BotInstance) watchAdminEvents() {
+	ctx := b.logContext()
+	ticker := time.NewTicker(adminEventsInterval)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if since.IsZero() {
+			since = b.loadAdminWatermark(ctx)
+		}
+
+		next, err := b.notifyAdminEvents(ctx, since)
+		if err != nil {
+			if b.ctx.Err() == nil {
+				logger.ErrorContext(ctx, "❌ Ошибка уведомлений сотрудникам", "error", err)
+			}
+			continue
+		}
+		if next.After(since) {
+			since = next
+			b.saveAdminWatermark(ctx, since)
+		}
+	}
+}
+
+// notifyAdminEvents отправляет события, созданные после since, и возвращает
+// время последнего из них
+func (b *BotInstance) notifyAdminEvents(ctx context.Context, since time.Time) (time.Time, error) {
+	subs, err := b.Handler.queries.ListAdminSubscriptions(b.ctx, b.Config.ID)
+	if err != nil {
+		return since, fmt.Errorf("failed to load subscriptions: %w", err)
+	}
+	if len(subs) == 0 {
+		// Без подписчиков события пропускаем, чтобы не прислать старые при подписке
+		return time.Now(), nil
+	}
+
+	sinceParam := pgtype.Timestamptz{Time: since, Valid: true}
+	orders, err := b.Handler.queries.GetNewPaymentOrders(b.ctx, storage.GetNewPaymentOrdersParams{
+		ProfileID: b.Config.ProfileID,
+		Since:     sinceParam,
+	})
+	if err != nil {
+		return since, fmt.Errorf("failed to load orders: %w", err)
+	}
+	appointments, err := b.Handler.queries.GetNewAppointments(b.ctx, storage.GetNewAppointmentsParams{
+		ProfileID: b.Config.ProfileID,
+		Since:     sinceParam,
+	})
+	if err != nil {
+		return since, fmt.Errorf("failed to load appointments: %w", err)
+	}
+
+	latest := since
+	for _, order := range orders {
+		b.sendToSubscribers(ctx, subs, orderNotification(order))
+		if order.CreatedAt.Time.After(latest) {
+			latest = order.CreatedAt.Time
+		}
+	}
+	for _, appointment := range appointments {
+		b.sendToSubscribers(ctx, subs, appointmentNotification(appointment))
+		if appointment.CreatedAt.Time.After(latest) {
+			latest = appointment.CreatedAt.Time
+		}
+	}
+
+	if sent := len(orders) + len(appointments); sent > 0 {
+		logger.InfoContext(ctx, "🔔 Уведомления сотрудникам отправлены", "events", sent, "subscribers", len(subs))
+	}
+	return latest, nil
+}
+
+func (b *BotInstance) sendToSubscribers(ctx context.Context, subs []storage.TelegramAdminSubscription, text string) {
+	for _, sub := range subs {
+		if _, err := b.Bot.Send(tele.ChatID(sub.ChatID), text); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось отправить уведомление сотруднику", "chat_id", sub.ChatID, "error", err)
+		}
+	}
+}
+
+func orderNotification(order storage.GetNewPaymentOrdersRow) string {
+	lines := []string{"🛒 Новый заказ"}
+	if order.Description != "" {
+		lines = append(lines, order.Description)
+	}
+	lines = append(lines, "Сумма: "+formatAmount(order.Amount), "Статус: "+order.Status)
+	for _, contact := range []pgtype.Text{order.PayerPhone, order.PayerEmail} {
+		if contact.Valid && contact.String != "" {
+			lines = append(lines, contact.String)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func appointmentNotification(appointment storage.GetNewAppointmentsRow) string {
+	lines := []string{"📅 Новая запись", appointment.Title}
+	if appointment.CustomerName.Valid && appointment.CustomerName.String != "" {
+		lines = append(lines, "Клиент: "+appointment.CustomerName.String)
+	}
+	if appointment.StartDatetime.Valid {
+		lines = append(lines, "Время: "+appointment.StartDatetime.Time.Local().Format("02.01.2006 15:04"))
+	}
+	if appointment.Price.Valid {
+		lines = append(lines, fmt.Sprintf("Стоимость: %.2f", appointment.Price.Float64))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadAdminWatermark возвращает время последнего отправленного события;
+// при первом запуске - текущее время (старые события не присылаются)
+func (b *BotInstance) loadAdminWatermark(ctx context.Context) time.Time {
+	if b.redis == nil {
+		return time.Now()
+	}
+
+	value, err := b.redis.Get(b.ctx, adminEventsKeyPrefix+b.BotID.String()).Result()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logger.WarnContext(ctx, "⚠️ Не удалось прочитать отметку уведомлений", "error", err)
+		}
+		return time.Now()
+	}
+
+	since, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Now()
+	}
+	return since
+}
+
+func (b *BotInstance) saveAdminWatermark(ctx context.Context, since time.Time) {
+	if b.redis == nil {
+		return
+	}
+
+	key := adminEventsKeyPrefix + b.BotID.String()
+	if err := b.redis.Set(b.ctx, key, since.Format(time.RFC3339Nano), adminEventsKeyTTL).Err(); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось сохранить отметку уведомлений", "error", err)
+	}
+}
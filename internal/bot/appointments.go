@@ -0,0 +1,263 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Напоминания о записях (appointments) и занятиях (education_lesson_enrollments,
+// student_id - клиент CRM) клиентам, связанным с Telegram (telegram_customer_links).
+// Планирование - watchReminders, отправка - handleReminder (см. reminders.go).
+
+// Callback кнопок напоминаний: rm:<вид>:<действие>:<id>
+const (
+	callbackReminderPrefix = "rm:"
+
+	reminderKindAppointment = "a"
+	reminderKindLesson      = "l"
+
+	reminderActionConfirm    = "c"
+	reminderActionCancel     = "x"
+	reminderActionReschedule = "r"
+)
+
+// Статусы, которые ставит клиент кнопками напоминания
+const (
+	appointmentStatusConfirmed           = "confirmed"
+	appointmentStatusCancelled           = "cancelled"
+	appointmentStatusRescheduleRequested = "reschedule_requested"
+
+	enrollmentStatusConfirmed = "confirmed"
+	enrollmentStatusCancelled = "cancelled"
+)
+
+// appointmentReminder - напоминание о записи с кнопками подтверждения, отмены и переноса
+func (h *MessageHandler) appointmentReminder(ctx context.Context, appointmentID pgtype.UUID, startsAt time.Time) (reminder, error) {
+	appointment, err := h.queries.GetAppointmentReminder(ctx, storage.GetAppointmentReminderParams{
+		ID:        appointmentID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if err != nil {
+		return reminder{}, err
+	}
+	if appointment.IsDeleted || appointmentClosed(appointment.Status) || !sameStart(appointment.StartDatetime, startsAt) {
+		return reminder{}, errReminderStale
+	}
+
+	id := uuidString(appointmentID)
+	lines := []string{"⏰ Напоминаем о записи: " + appointment.Title, "📅 " + formatEventTime(appointment.StartDatetime)}
+	markup := &tele.ReplyMarkup{}
+	if appointment.Status == appointmentStatusConfirmed {
+		markup.InlineKeyboard = [][]tele.InlineButton{{
+			{Text: "🔁 Перенести", Data: reminderCallback(reminderKindAppointment, reminderActionReschedule, id)},
+			{Text: "❌ Отменить", Data: reminderCallback(reminderKindAppointment, reminderActionCancel, id)},
+		}}
+	} else {
+		lines = append(lines, "", "Пожалуйста, подтвердите визит.")
+		markup.InlineKeyboard = [][]tele.InlineButton{
+			{
+				{Text: "✅ Подтвердить", Data: reminderCallback(reminderKindAppointment, reminderActionConfirm, id)},
+				{Text: "❌ Отменить", Data: reminderCallback(reminderKindAppointment, reminderActionCancel, id)},
+			},
+			{{Text: "🔁 Перенести", Data: reminderCallback(reminderKindAppointment, reminderActionReschedule, id)}},
+		}
+	}
+	return reminder{ChatID: appointment.TelegramUserID, Text: strings.Join(lines, "\n"), Markup: markup}, nil
+}
+
+// lessonReminder - напоминание о занятии с кнопками "буду" / "не смогу"
+func (h *MessageHandler) lessonReminder(ctx context.Context, enrollmentID pgtype.UUID, startsAt time.Time) (reminder, error) {
+	enrollment, err := h.queries.GetLessonEnrollmentReminder(ctx, storage.GetLessonEnrollmentReminderParams{
+		ID:        enrollmentID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if err != nil {
+		return reminder{}, err
+	}
+	if enrollment.LessonDeleted || appointmentClosed(enrollment.LessonStatus) || appointmentClosed(enrollment.Status) ||
+		!sameStart(enrollment.StartsAt, startsAt) {
+		return reminder{}, errReminderStale
+	}
+
+	id := uuidString(enrollmentID)
+	text := fmt.Sprintf("⏰ Напоминаем о занятии: %s\n📅 %s", lessonTitle(enrollment.SubjectName), formatEventTime(enrollment.StartsAt))
+	markup := &tele.ReplyMarkup{}
+	row := []tele.InlineButton{}
+	if enrollment.Status != enrollmentStatusConfirmed {
+		row = append(row, tele.InlineButton{Text: "✅ Буду", Data: reminderCallback(reminderKindLesson, reminderActionConfirm, id)})
+	}
+	row = append(row, tele.InlineButton{Text: "❌ Не смогу", Data: reminderCallback(reminderKindLesson, reminderActionCancel, id)})
+	markup.InlineKeyboard = [][]tele.InlineButton{row}
+	return reminder{ChatID: enrollment.TelegramUserID, Text: text, Markup: markup}, nil
+}
+
+// handleReminderCallback обрабатывает кнопки напоминаний. Менять можно только
+// свои записи - клиента, связанного с пользователем
+func (h *MessageHandler) handleReminderCallback(ctx context.Context, c tele.Context, data string) error {
+	parts := strings.SplitN(strings.TrimPrefix(data, callbackReminderPrefix), ":", 3)
+	if len(parts) != 3 {
+		return c.Respond()
+	}
+	kind, action := parts[0], parts[1]
+	id, err := parseUUID(parts[2])
+	if err != nil {
+		return c.Respond()
+	}
+
+	link, err := h.queries.GetTelegramCustomerLink(ctx, storage.GetTelegramCustomerLinkParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: c.Sender().ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Запись не найдена", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load customer link: %w", err)
+	}
+
+	var result, staff string
+	switch kind {
+	case reminderKindAppointment:
+		result, staff, err = h.updateAppointmentStatus(ctx, id, link.CustomerID, action)
+	case reminderKindLesson:
+		result, staff, err = h.updateEnrollmentStatus(ctx, id, link.CustomerID, action)
+	default:
+		return c.Respond()
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		h.closeReminder(c, "Запись уже изменена.")
+		return c.Respond(&tele.CallbackResponse{Text: "Запись уже изменена или отменена", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.InfoContext(ctx, "📅 Клиент ответил на напоминание", "kind", kind, "action", action, "entity_id", uuidString(id))
+	h.closeReminder(c, result)
+	h.notifyStaff(ctx, staff)
+	return c.Respond(&tele.CallbackResponse{Text: result})
+}
+
+// updateAppointmentStatus меняет статус записи по кнопке. Возвращает ответ клиенту
+// и уведомление сотрудникам; запись не найдена или уже закрыта - pgx.ErrNoRows
+func (h *MessageHandler) updateAppointmentStatus(ctx context.Context, id, customerID pgtype.UUID, action string) (string, string, error) {
+	var status, result, staff string
+	switch action {
+	case reminderActionConfirm:
+		status, result, staff = appointmentStatusConfirmed, "✅ Запись подтверждена. Ждем вас!", "✅ Клиент подтвердил запись"
+	case reminderActionCancel:
+		status, result, staff = appointmentStatusCancelled, "❌ Запись отменена.", "❌ Клиент отменил запись"
+	case reminderActionReschedule:
+		status, result, staff = appointmentStatusRescheduleRequested,
+			"🔁 Мы свяжемся с вами, чтобы подобрать новое время.", "🔁 Клиент просит перенести запись"
+	default:
+		return "", "", pgx.ErrNoRows
+	}
+
+	updated, err := h.queries.SetCustomerAppointmentStatus(ctx, storage.SetCustomerAppointmentStatusParams{
+		Status:     status,
+		ID:         id,
+		ProfileID:  h.botConfig.ProfileID,
+		CustomerID: customerID,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to update appointment: %w", err)
+	}
+	if updated == 0 {
+		return "", "", pgx.ErrNoRows
+	}
+
+	appointment, err := h.queries.GetAppointmentReminder(ctx, storage.GetAppointmentReminderParams{ID: id, ProfileID: h.botConfig.ProfileID})
+	if err == nil {
+		staff += "\n" + appointment.Title + "\nВремя: " + formatEventTime(appointment.StartDatetime)
+	}
+	return result, staff, nil
+}
+
+// updateEnrollmentStatus подтверждает или отменяет запись на занятие
+func (h *MessageHandler) updateEnrollmentStatus(ctx context.Context, id, studentID pgtype.UUID, action string) (string, string, error) {
+	var result, staff string
+	switch action {
+	case reminderActionConfirm:
+		updated, err := h.queries.SetStudentEnrollmentStatus(ctx, storage.SetStudentEnrollmentStatusParams{
+			Status:    optionalText(enrollmentStatusConfirmed),
+			ID:        id,
+			ProfileID: h.botConfig.ProfileID,
+			StudentID: studentID,
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to update enrollment: %w", err)
+		}
+		if updated == 0 {
+			return "", "", pgx.ErrNoRows
+		}
+		result, staff = "✅ Отлично, ждем на занятии!", "✅ Ученик подтвердил занятие"
+	case reminderActionCancel:
+		if err := h.cancelEnrollment(ctx, id, studentID); err != nil {
+			return "", "", err
+		}
+		result, staff = "❌ Запись на занятие отменена.", "❌ Ученик не придет на занятие"
+	default:
+		return "", "", pgx.ErrNoRows
+	}
+
+	enrollment, err := h.queries.GetLessonEnrollmentReminder(ctx, storage.GetLessonEnrollmentReminderParams{ID: id, ProfileID: h.botConfig.ProfileID})
+	if err == nil {
+		staff += "\n" + lessonTitle(enrollment.SubjectName) + "\nВремя: " + formatEventTime(enrollment.StartsAt)
+	}
+	return result, staff, nil
+}
+
+// closeReminder дописывает к напоминанию итог и убирает кнопки
+func (h *MessageHandler) closeReminder(c tele.Context, result string) {
+	cb := c.Callback()
+	if cb == nil || cb.Message == nil {
+		return
+	}
+	if err := c.Edit(cb.Message.Text + "\n\n" + result); err != nil && !errors.Is(err, tele.ErrSameMessageContent) &&
+		!errors.Is(err, tele.ErrMessageNotModified) {
+		logger.WarnContext(requestContext(c), "⚠️ Не удалось обновить напоминание", "error", err)
+	}
+}
+
+// notifyStaff отправляет сообщение сотрудникам, подписанным на уведомления (/notifications)
+func (h *MessageHandler) notifyStaff(ctx context.Context, text string) {
+	subs, err := h.queries.ListAdminSubscriptions(ctx, h.botConfig.ID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to load admin subscriptions", "error", err)
+		return
+	}
+	for _, sub := range subs {
+		if _, err := h.engine.Bot().Send(tele.ChatID(sub.ChatID), text); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось отправить уведомление сотруднику", "chat_id", sub.ChatID, "error", err)
+		}
+	}
+}
+
+func reminderCallback(kind, action, id string) string {
+	return callbackReminderPrefix + kind + ":" + action + ":" + id
+}
+
+// appointmentClosed - запись или занятие отменены или уже прошли
+func appointmentClosed(status string) bool {
+	switch strings.ToLower(status) {
+	case "cancelled", "canceled", "completed", "no_show", "attended", "absent":
+		return true
+	}
+	return false
+}
+
+func lessonTitle(subject pgtype.Text) string {
+	if subject.String != "" {
+		return subject.String
+	}
+	return "занятие"
+}
@@ -0,0 +1,951 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Бронирование: номера отеля (hotel_rooms, по суткам) или помещения (spaces_rooms,
+// по часам). Даты выбираются календарем из inline кнопок, затем гости (отель) или
+// длительность (помещения); свободные варианты считаются без пересечений с бронями
+// и блокировками (spaces_room_blocks, занятия в помещении). Выбранный вариант
+// бронируется предварительно на booking_hold_minutes: если за это время бронь
+// не оплачена, задача booking:hold снимает ее. Бронь помещения хранится блокировкой
+// spaces_room_blocks (reason = 'telegram_hold', после оплаты - 'booking').
+// Включается booking_enabled в настройках бота.
+
+// Callback кнопок бронирования (лимит data - 64 байта)
+const (
+	callbackBookingPrefix   = "bk:"
+	callbackBookingMonth    = "bk:m:" // bk:m:<yyyymm>
+	callbackBookingDate     = "bk:d:" // bk:d:<yyyymmdd>
+	callbackBookingGuests   = "bk:g:" // bk:g:<guests>
+	callbackBookingDuration = "bk:t:" // bk:t:<hours>
+	callbackBookingRoom     = "bk:r:" // bk:r:<room_id>
+	callbackBookingSlot     = "bk:s:" // bk:s:<room_id>:<hhmm>
+	callbackBookingRestart  = "bk:new"
+	callbackBookingNoop     = "bk:noop"
+)
+
+// Что бронируется (booking_type); это же вид задачи booking:hold
+const (
+	bookingTypeHotel  = "hotel"
+	bookingTypeSpaces = "spaces"
+)
+
+const (
+	// payment_orders.entity_type брони номера и помещения
+	entityHotelBooking = "hotel_booking"
+	entitySpaceBooking = "spaces_room_block"
+	// bookingDraftKey - выбор дат, гостей и длительности в контексте разговора
+	bookingDraftKey = "booking_draft"
+	// bookingMaxNights - сколько суток можно забронировать за раз
+	bookingMaxNights = 30
+	// bookingMaxGuests - кнопки выбора числа гостей
+	bookingMaxGuests = 6
+	// bookingMaxOptions - сколько вариантов (номеров или времени) показывать
+	bookingMaxOptions = 20
+	// Часы работы помещения, если расписания spaces_room_schedules на этот день нет
+	spaceDefaultOpen  = 9 * time.Hour
+	spaceDefaultClose = 21 * time.Hour
+	// Статусы брони
+	hotelBookingConfirmed = "confirmed"
+	spaceBlockHold        = "telegram_hold"
+	spaceBlockBooking     = "booking"
+
+	bookingDateLayout = "20060102"
+)
+
+// bookingDurations - кнопки длительности аренды помещения, в часах
+var bookingDurations = []int{1, 2, 3, 4, 6, 8}
+
+// bookingCommands - команды бронирования в меню
+var bookingCommands = []menuCommand{
+	{Command: "book", Description: "Забронировать", Descriptions: map[string]string{"en": "Book"}},
+}
+
+var monthNames = [...]string{"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь",
+	"Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь"}
+
+// bookingDraft - бронирование в процессе. Для помещения CheckIn - дата аренды
+type bookingDraft struct {
+	CheckIn  string `json:"check_in,omitempty"`
+	CheckOut string `json:"check_out,omitempty"`
+	Guests   int    `json:"guests,omitempty"`
+	Hours    int    `json:"hours,omitempty"`
+}
+
+// bookingOffer - выбранный вариант брони
+type bookingOffer struct {
+	RoomID   pgtype.UUID
+	Title    string
+	Start    time.Time
+	End      time.Time
+	Units    int // ночей или часов
+	Price    float64
+	Guests   int
+	UnitName string
+}
+
+func (h *MessageHandler) loadBookingDraft(ctx context.Context, chatID int64) bookingDraft {
+	var draft bookingDraft
+	h.conversationValue(ctx, chatID, bookingDraftKey, &draft)
+	return draft
+}
+
+func (h *MessageHandler) saveBookingDraft(ctx context.Context, c tele.Context, draft bookingDraft) {
+	var value interface{} = draft
+	if draft == (bookingDraft{}) {
+		value = nil
+	}
+	h.updateConversationContext(ctx, c, map[string]interface{}{bookingDraftKey: value})
+}
+
+// featureBooking - раздел бронирования в payment_methods
+const featureBooking = "booking"
+
+// bookingEnabled - бронирование включено в настройках (для featureOnly)
+func bookingEnabled(s BotSettings) bool { return s.BookingEnabled }
+
+// HandleBook - /book: начало бронирования с выбора даты
+func (h *MessageHandler) HandleBook(ctx context.Context, c tele.Context) error {
+	h.saveBookingDraft(ctx, c, bookingDraft{})
+	return h.showBookingCalendar(c, bookingDraft{}, time.Time{})
+}
+
+// handleBookingCallback обрабатывает кнопки бронирования
+func (h *MessageHandler) handleBookingCallback(ctx context.Context, c tele.Context, data string) error {
+	if !h.settings.BookingEnabled {
+		return c.Respond(&tele.CallbackResponse{Text: "Бронирование недоступно"})
+	}
+
+	draft := h.loadBookingDraft(ctx, c.Chat().ID)
+	var err error
+	switch {
+	case data == callbackBookingNoop:
+		return c.Respond()
+	case data == callbackBookingRestart:
+		draft = bookingDraft{}
+		h.saveBookingDraft(ctx, c, draft)
+		err = h.showBookingCalendar(c, draft, time.Time{})
+	case strings.HasPrefix(data, callbackBookingMonth):
+		month, parseErr := time.ParseInLocation("200601", strings.TrimPrefix(data, callbackBookingMonth), time.Local)
+		if parseErr != nil {
+			return c.Respond()
+		}
+		err = h.showBookingCalendar(c, draft, month)
+	case strings.HasPrefix(data, callbackBookingDate):
+		date, parseErr := time.ParseInLocation(bookingDateLayout, strings.TrimPrefix(data, callbackBookingDate), time.Local)
+		if parseErr != nil {
+			return c.Respond()
+		}
+		return h.selectBookingDate(ctx, c, draft, date)
+	case strings.HasPrefix(data, callbackBookingGuests):
+		guests, _ := strconv.Atoi(strings.TrimPrefix(data, callbackBookingGuests))
+		if draft.CheckOut == "" || guests < 1 || guests > bookingMaxGuests {
+			return c.Respond(&tele.CallbackResponse{Text: "Выберите даты заново"})
+		}
+		draft.Guests = guests
+		h.saveBookingDraft(ctx, c, draft)
+		err = h.showHotelRooms(ctx, c, draft)
+	case strings.HasPrefix(data, callbackBookingDuration):
+		hours, _ := strconv.Atoi(strings.TrimPrefix(data, callbackBookingDuration))
+		if draft.CheckIn == "" || hours < 1 || hours > 24 {
+			return c.Respond(&tele.CallbackResponse{Text: "Выберите дату заново"})
+		}
+		draft.Hours = hours
+		h.saveBookingDraft(ctx, c, draft)
+		err = h.showSpaceSlots(ctx, c, draft)
+	case strings.HasPrefix(data, callbackBookingRoom):
+		id, parseErr := parseUUID(strings.TrimPrefix(data, callbackBookingRoom))
+		if parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Номер не найден"})
+		}
+		return h.bookHotelRoom(ctx, c, draft, id)
+	case strings.HasPrefix(data, callbackBookingSlot):
+		room, at, ok := strings.Cut(strings.TrimPrefix(data, callbackBookingSlot), ":")
+		id, parseErr := parseUUID(room)
+		if !ok || parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Помещение не найдено"})
+		}
+		return h.bookSpace(ctx, c, draft, id, at)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// selectBookingDate запоминает выбранную в календаре дату и переходит к следующему шагу
+func (h *MessageHandler) selectBookingDate(ctx context.Context, c tele.Context, draft bookingDraft, date time.Time) error {
+	minDate, maxDate := h.bookingDateRange(draft)
+	if date.Before(minDate) || date.After(maxDate) {
+		return c.Respond(&tele.CallbackResponse{Text: "Эта дата недоступна"})
+	}
+
+	var err error
+	switch {
+	case h.settings.BookingType == bookingTypeSpaces:
+		draft = bookingDraft{CheckIn: date.Format(time.DateOnly)}
+		err = h.showCatalogScreen(c, "📅 "+date.Format(dateLayout)+"\n\nНа сколько часов нужно помещение?", bookingDurationMarkup())
+	case draft.CheckIn == "" || draft.CheckOut != "":
+		draft = bookingDraft{CheckIn: date.Format(time.DateOnly)}
+		err = h.showBookingCalendar(c, draft, date)
+	default:
+		draft.CheckOut = date.Format(time.DateOnly)
+		checkIn, _ := time.ParseInLocation(time.DateOnly, draft.CheckIn, time.Local)
+		text := fmt.Sprintf("📅 %s - %s\n\nСколько будет гостей?", checkIn.Format(dateLayout), date.Format(dateLayout))
+		err = h.showCatalogScreen(c, text, bookingGuestsMarkup())
+	}
+	h.saveBookingDraft(ctx, c, draft)
+	if err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// bookingDateRange - какие даты можно выбрать на текущем шаге
+func (h *MessageHandler) bookingDateRange(draft bookingDraft) (time.Time, time.Time) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	maxDate := today.AddDate(0, 0, h.settings.BookingDaysAhead)
+	if h.settings.BookingType == bookingTypeHotel && draft.CheckIn != "" && draft.CheckOut == "" {
+		// Выбор даты выезда: после заезда, не дольше bookingMaxNights
+		checkIn, err := time.ParseInLocation(time.DateOnly, draft.CheckIn, time.Local)
+		if err == nil {
+			return checkIn.AddDate(0, 0, 1), checkIn.AddDate(0, 0, bookingMaxNights)
+		}
+	}
+	return today, maxDate
+}
+
+// showBookingCalendar показывает календарь на месяц month (по умолчанию - месяц
+// первой доступной даты) с подсказкой текущего шага
+func (h *MessageHandler) showBookingCalendar(c tele.Context, draft bookingDraft, month time.Time) error {
+	minDate, maxDate := h.bookingDateRange(draft)
+	if month.IsZero() || month.Before(monthStart(minDate)) {
+		month = minDate
+	}
+	if month.After(maxDate) {
+		month = maxDate
+	}
+
+	text := "📅 Выберите дату"
+	switch {
+	case h.settings.BookingType == bookingTypeHotel && draft.CheckIn != "" && draft.CheckOut == "":
+		text = "📅 Заезд: " + minDate.AddDate(0, 0, -1).Format(dateLayout) + "\n\nВыберите дату выезда"
+	case h.settings.BookingType == bookingTypeHotel:
+		text = "📅 Выберите дату заезда"
+	}
+
+	markup := bookingCalendar(monthStart(month), minDate, maxDate)
+	if draft.CheckIn != "" {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "🔄 Начать заново", Data: callbackBookingRestart}})
+	}
+	return h.showCatalogScreen(c, text, markup)
+}
+
+// bookingCalendar - месяц кнопками, неделя с понедельника. Недоступные даты - "·"
+func bookingCalendar(month, minDate, maxDate time.Time) *tele.ReplyMarkup {
+	noop := func(text string) tele.InlineButton {
+		return tele.InlineButton{Text: text, Data: callbackBookingNoop}
+	}
+
+	header := []tele.InlineButton{noop(" ")}
+	if month.After(monthStart(minDate)) {
+		header[0] = tele.InlineButton{Text: "◀️", Data: callbackBookingMonth + month.AddDate(0, -1, 0).Format("200601")}
+	}
+	header = append(header, noop(fmt.Sprintf("%s %d", monthNames[month.Month()-1], month.Year())))
+	if month.Before(monthStart(maxDate)) {
+		header = append(header, tele.InlineButton{Text: "▶️", Data: callbackBookingMonth + month.AddDate(0, 1, 0).Format("200601")})
+	} else {
+		header = append(header, noop(" "))
+	}
+
+	markup := &tele.ReplyMarkup{}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, header)
+	weekdays := []tele.InlineButton{}
+	for _, day := range []string{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"} {
+		weekdays = append(weekdays, noop(day))
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, weekdays)
+
+	// Пустые клетки до первого числа: time.Weekday начинается с воскресенья
+	offset := (int(month.Weekday()) + 6) % 7
+	row := []tele.InlineButton{}
+	for i := 0; i < offset; i++ {
+		row = append(row, noop(" "))
+	}
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		if day.Before(minDate) || day.After(maxDate) {
+			row = append(row, noop("·"))
+		} else {
+			row = append(row, tele.InlineButton{Text: strconv.Itoa(day.Day()), Data: callbackBookingDate + day.Format(bookingDateLayout)})
+		}
+		if len(row) == 7 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = []tele.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		for len(row) < 7 {
+			row = append(row, noop(" "))
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	return markup
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+}
+
+func bookingGuestsMarkup() *tele.ReplyMarkup {
+	row := []tele.InlineButton{}
+	for guests := 1; guests <= bookingMaxGuests; guests++ {
+		row = append(row, tele.InlineButton{Text: strconv.Itoa(guests), Data: callbackBookingGuests + strconv.Itoa(guests)})
+	}
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{row, {{Text: "🔄 Начать заново", Data: callbackBookingRestart}}}}
+}
+
+func bookingDurationMarkup() *tele.ReplyMarkup {
+	row := []tele.InlineButton{}
+	for _, hours := range bookingDurations {
+		row = append(row, tele.InlineButton{Text: fmt.Sprintf("%d ч", hours), Data: callbackBookingDuration + strconv.Itoa(hours)})
+	}
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{row, {{Text: "🔄 Начать заново", Data: callbackBookingRestart}}}}
+}
+
+// hotelDates - даты заезда и выезда из черновика
+func hotelDates(draft bookingDraft) (time.Time, time.Time, error) {
+	checkIn, err := time.ParseInLocation(time.DateOnly, draft.CheckIn, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	checkOut, err := time.ParseInLocation(time.DateOnly, draft.CheckOut, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return checkIn, checkOut, nil
+}
+
+// nightsBetween - число ночей; по календарным датам, чтобы переход на летнее
+// время не менял результат
+func nightsBetween(checkIn, checkOut time.Time) int {
+	in := time.Date(checkIn.Year(), checkIn.Month(), checkIn.Day(), 0, 0, 0, 0, time.UTC)
+	out := time.Date(checkOut.Year(), checkOut.Month(), checkOut.Day(), 0, 0, 0, 0, time.UTC)
+	return int(out.Sub(in).Hours() / 24)
+}
+
+// showHotelRooms показывает свободные на выбранные даты номера с ценой за весь срок
+func (h *MessageHandler) showHotelRooms(ctx context.Context, c tele.Context, draft bookingDraft) error {
+	checkIn, checkOut, err := hotelDates(draft)
+	if err != nil {
+		return h.showBookingCalendar(c, bookingDraft{}, time.Time{})
+	}
+	nights := nightsBetween(checkIn, checkOut)
+
+	rooms, err := h.queries.ListAvailableHotelRooms(ctx, storage.ListAvailableHotelRoomsParams{
+		ProfileID: h.botConfig.ProfileID,
+		Guests:    int32(draft.Guests),
+		CheckOut:  pgtype.Date{Time: checkOut, Valid: true},
+		CheckIn:   pgtype.Date{Time: checkIn, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load rooms: %w", err)
+	}
+
+	summary := fmt.Sprintf("📅 %s - %s, ночей: %d, гостей: %d", checkIn.Format(dateLayout), checkOut.Format(dateLayout), nights, draft.Guests)
+	restart := []tele.InlineButton{{Text: "🔄 Другие даты", Data: callbackBookingRestart}}
+	if len(rooms) == 0 {
+		return h.showCatalogScreen(c, summary+"\n\nСвободных номеров на эти даты нет.",
+			&tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{restart}})
+	}
+
+	lines := []string{summary, ""}
+	markup := &tele.ReplyMarkup{}
+	for i, room := range rooms {
+		if i == bookingMaxOptions {
+			break
+		}
+		perNight, _ := numericToFloat(room.PricePerDay)
+		title := hotelRoomTitle(room.RoomNumber, room.RoomType)
+		total := h.formatPrice(perNight*float64(nights), "")
+		line := fmt.Sprintf("• %s, до %d гостей - %s", title, room.Capacity, total)
+		if room.Description.String != "" {
+			line += "\n  " + truncateRunes(room.Description.String, 120)
+		}
+		lines = append(lines, line)
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: truncateRunes(title, 40) + " - " + total,
+			Data: callbackBookingRoom + uuidString(room.ID),
+		}})
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, restart)
+	return h.showCatalogScreen(c, strings.Join(lines, "\n"), markup)
+}
+
+func hotelRoomTitle(number, roomType string) string {
+	title := "Номер " + number
+	if roomType != "" {
+		title += " (" + roomType + ")"
+	}
+	return title
+}
+
+// spaceSlot - свободное время помещения
+type spaceSlot struct {
+	Room  storage.ListBookableSpacesRow
+	Start time.Time
+}
+
+// spaceSlots считает свободное время помещений на дату черновика: начало - каждый
+// час в часы работы помещения, без пересечений с блокировками и занятиями
+func (h *MessageHandler) spaceSlots(ctx context.Context, draft bookingDraft) ([]spaceSlot, error) {
+	date, err := time.ParseInLocation(time.DateOnly, draft.CheckIn, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Duration(draft.Hours) * time.Hour
+
+	rooms, err := h.queries.ListBookableSpaces(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spaces: %w", err)
+	}
+	schedules, err := h.queries.ListSpaceSchedules(ctx, storage.ListSpaceSchedulesParams{
+		ProfileID: h.botConfig.ProfileID,
+		DayOfWeek: int32(date.Weekday()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load space schedules: %w", err)
+	}
+	busy, err := h.queries.ListSpaceBusy(ctx, storage.ListSpaceBusyParams{
+		ProfileID: h.botConfig.ProfileID,
+		Until:     pgtype.Timestamptz{Time: date.AddDate(0, 0, 1), Valid: true},
+		Since:     pgtype.Timestamptz{Time: date, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load space bookings: %w", err)
+	}
+
+	type window struct{ open, close time.Duration }
+	windows := map[[16]byte][]window{}
+	for _, s := range schedules {
+		windows[s.RoomID.Bytes] = append(windows[s.RoomID.Bytes], window{
+			open:  time.Duration(s.StartTime.Microseconds) * time.Microsecond,
+			close: time.Duration(s.EndTime.Microseconds) * time.Microsecond,
+		})
+	}
+
+	now := time.Now()
+	var slots []spaceSlot
+	for _, room := range rooms {
+		minutes := int32(draft.Hours * 60)
+		if (room.MinBookingDuration.Valid && minutes < room.MinBookingDuration.Int32) ||
+			(room.MaxBookingDuration.Valid && room.MaxBookingDuration.Int32 > 0 && minutes > room.MaxBookingDuration.Int32) {
+			continue
+		}
+		roomWindows, ok := windows[room.ID.Bytes]
+		if !ok {
+			roomWindows = []window{{open: spaceDefaultOpen, close: spaceDefaultClose}}
+		}
+		for _, w := range roomWindows {
+			for offset := w.open; offset+duration <= w.close; offset += time.Hour {
+				start := date.Add(offset)
+				if !start.After(now) || spaceBusy(busy, room.ID, start, start.Add(duration)) {
+					continue
+				}
+				slots = append(slots, spaceSlot{Room: room, Start: start})
+			}
+		}
+	}
+	return slots, nil
+}
+
+func spaceBusy(busy []storage.ListSpaceBusyRow, roomID pgtype.UUID, start, end time.Time) bool {
+	for _, b := range busy {
+		if b.RoomID == roomID && b.StartsAt.Time.Before(end) && b.EndsAt.Time.After(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// showSpaceSlots показывает свободное время помещений на выбранную дату
+func (h *MessageHandler) showSpaceSlots(ctx context.Context, c tele.Context, draft bookingDraft) error {
+	slots, err := h.spaceSlots(ctx, draft)
+	if err != nil {
+		return err
+	}
+
+	date, _ := time.ParseInLocation(time.DateOnly, draft.CheckIn, time.Local)
+	summary := fmt.Sprintf("📅 %s, %d ч", date.Format(dateLayout), draft.Hours)
+	if price := h.settings.BookingHourPrice; price > 0 {
+		summary += " - " + h.formatPrice(price*float64(draft.Hours), "")
+	}
+	restart := []tele.InlineButton{{Text: "🔄 Другая дата", Data: callbackBookingRestart}}
+	if len(slots) == 0 {
+		return h.showCatalogScreen(c, summary+"\n\nСвободного времени на эту дату нет.",
+			&tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{restart}})
+	}
+
+	markup := &tele.ReplyMarkup{}
+	row := []tele.InlineButton{}
+	for i, slot := range slots {
+		if i == bookingMaxOptions {
+			break
+		}
+		row = append(row, tele.InlineButton{
+			Text: slot.Start.Format("15:04") + " " + truncateRunes(spaceTitle(slot.Room), 24),
+			Data: callbackBookingSlot + uuidString(slot.Room.ID) + ":" + slot.Start.Format("1504"),
+		})
+		if len(row) == 2 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = []tele.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, restart)
+	return h.showCatalogScreen(c, summary+"\n\nВыберите помещение и время начала:", markup)
+}
+
+func spaceTitle(room storage.ListBookableSpacesRow) string {
+	if room.LocationName != "" {
+		return room.Name + ", " + room.LocationName
+	}
+	return room.Name
+}
+
+// bookHotelRoom предварительно бронирует номер на даты черновика
+func (h *MessageHandler) bookHotelRoom(ctx context.Context, c tele.Context, draft bookingDraft, roomID pgtype.UUID) error {
+	checkIn, checkOut, err := hotelDates(draft)
+	if err != nil || draft.Guests == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "Выберите даты заново", ShowAlert: true})
+	}
+	room, err := h.queries.GetHotelRoom(ctx, storage.GetHotelRoomParams{ID: roomID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Номер больше недоступен", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load room: %w", err)
+	}
+	if room.Capacity < int32(draft.Guests) {
+		return c.Respond(&tele.CallbackResponse{Text: "Номер не вмещает столько гостей", ShowAlert: true})
+	}
+
+	nights := nightsBetween(checkIn, checkOut)
+	perNight, _ := numericToFloat(room.PricePerDay)
+	return h.placeBookingHold(ctx, c, bookingTypeHotel, bookingOffer{
+		RoomID:   room.ID,
+		Title:    hotelRoomTitle(room.RoomNumber, room.RoomType),
+		Start:    checkIn,
+		End:      checkOut,
+		Units:    nights,
+		Price:    perNight,
+		Guests:   draft.Guests,
+		UnitName: "ночей",
+	})
+}
+
+// bookSpace предварительно бронирует помещение на выбранное время
+func (h *MessageHandler) bookSpace(ctx context.Context, c tele.Context, draft bookingDraft, roomID pgtype.UUID, at string) error {
+	if draft.CheckIn == "" || draft.Hours == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "Выберите дату заново", ShowAlert: true})
+	}
+	start, err := time.ParseInLocation(time.DateOnly+"1504", draft.CheckIn+at, time.Local)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "Выберите время заново", ShowAlert: true})
+	}
+
+	// Помещение и время должны быть среди свободных: кнопка могла устареть
+	slots, err := h.spaceSlots(ctx, draft)
+	if err != nil {
+		return err
+	}
+	for _, slot := range slots {
+		if slot.Room.ID == roomID && slot.Start.Equal(start) {
+			return h.placeBookingHold(ctx, c, bookingTypeSpaces, bookingOffer{
+				RoomID:   roomID,
+				Title:    spaceTitle(slot.Room),
+				Start:    start,
+				End:      start.Add(time.Duration(draft.Hours) * time.Hour),
+				Units:    draft.Hours,
+				Price:    h.settings.BookingHourPrice,
+				UnitName: "ч",
+			})
+		}
+	}
+	if err := h.showSpaceSlots(ctx, c, draft); err != nil {
+		return err
+	}
+	return c.Respond(&tele.CallbackResponse{Text: "Это время уже занято", ShowAlert: true})
+}
+
+// placeBookingHold создает предварительную бронь и заказ на оплату. Бесплатная
+// бронь подтверждается сразу
+func (h *MessageHandler) placeBookingHold(ctx context.Context, c tele.Context, kind string, offer bookingOffer) error {
+	payer := customerFromUser(c.Sender())
+	h.conversationValue(ctx, c.Chat().ID, "phone", &payer.Phone)
+	h.conversationValue(ctx, c.Chat().ID, "email", &payer.Email)
+	customerID, err := h.linkedCustomer(ctx, payer)
+	if err != nil {
+		return err
+	}
+
+	total := offer.Price * float64(offer.Units)
+	free := minorUnits(total, h.settings.PaymentsCurrency) == 0
+	holdUntil := time.Now().Add(h.settings.bookingHold())
+
+	var bookingID pgtype.UUID
+	err = pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		if err := q.LockBookingResource(ctx, offer.RoomID); err != nil {
+			return fmt.Errorf("failed to lock room: %w", err)
+		}
+		var err error
+		if kind == bookingTypeHotel {
+			bookingID, err = q.CreateHotelBookingHold(ctx, storage.CreateHotelBookingHoldParams{
+				ProfileID:    h.botConfig.ProfileID,
+				RoomID:       offer.RoomID,
+				OwnerID:      customerID,
+				CheckInDate:  pgtype.Date{Time: offer.Start, Valid: true},
+				CheckOutDate: pgtype.Date{Time: offer.End, Valid: true},
+				TotalPrice:   numericFromFloat(total),
+				Notes:        optionalText(fmt.Sprintf("Telegram, гостей: %d", offer.Guests)),
+				Guests:       int32(offer.Guests),
+				HoldUntil:    pgtype.Timestamptz{Time: holdUntil, Valid: true},
+			})
+			if err == nil && free {
+				_, err = q.ConfirmHotelBooking(ctx, bookingID)
+			}
+			return err
+		}
+		reason, until := spaceBlockHold, pgtype.Timestamptz{Time: holdUntil, Valid: true}
+		if free {
+			reason, until = spaceBlockBooking, pgtype.Timestamptz{}
+		}
+		bookingID, err = q.CreateSpaceBooking(ctx, storage.CreateSpaceBookingParams{
+			ProfileID:     h.botConfig.ProfileID,
+			RoomID:        offer.RoomID,
+			StartDatetime: pgtype.Timestamptz{Time: offer.Start, Valid: true},
+			EndDatetime:   pgtype.Timestamptz{Time: offer.End, Valid: true},
+			Reason:        reason,
+			Notes:         optionalText("Telegram: " + payerName(payer)),
+			HoldUntil:     until,
+		})
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "К сожалению, этот вариант только что заняли", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create booking: %w", err)
+	}
+	ctx = utils.WithLogAttrs(ctx, "booking_kind", kind, "booking_id", uuidString(bookingID))
+	logger.InfoContext(ctx, "🏨 Бронь создана", "room_id", uuidString(offer.RoomID), "free", free)
+	h.saveBookingDraft(ctx, c, bookingDraft{})
+
+	summary := bookingSummary(kind, offer.Title, offer.Start, offer.End)
+	if free {
+		if err := h.showCatalogScreen(c, "✅ Бронирование подтверждено\n\n"+summary, &tele.ReplyMarkup{}); err != nil {
+			return err
+		}
+		return c.Respond()
+	}
+
+	order, paymentData, err := h.createPaymentOrder(ctx, payer, c.Chat().ID, paymentRequest{
+		Amount:      total,
+		Description: "Бронирование: " + offer.Title,
+		EntityType:  bookingEntity(kind),
+		EntityID:    bookingID,
+		Items:       []paymentItem{{Name: offer.Title, Quantity: offer.Units, Price: offer.Price}},
+		Method:      h.settings.paymentMethod(featureBooking),
+		Expiry:      h.settings.bookingHold(),
+	})
+	if err != nil {
+		h.releaseBooking(ctx, kind, bookingID)
+		logger.ErrorContext(ctx, "❌ Не удалось создать заказ на оплату брони", "error", err)
+		return c.Respond(&tele.CallbackResponse{Text: "Не удалось оформить бронь, попробуйте позже", ShowAlert: true})
+	}
+	h.scheduleBookingRelease(ctx, kind, bookingID, order.ID, holdUntil)
+
+	text := fmt.Sprintf("🕒 Бронь удерживается до %s - оплатите ее, иначе она будет снята.\n\n%s\n%d %s - %s",
+		holdUntil.Local().Format("15:04"), summary, offer.Units, offer.UnitName, h.formatPrice(total, ""))
+	if err := h.showCatalogScreen(c, text, &tele.ReplyMarkup{}); err != nil {
+		return err
+	}
+	if err := h.offerPayment(ctx, c, order, paymentData, ""); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+func payerName(payer telegramCustomer) string {
+	name := strings.TrimSpace(payer.FirstName + " " + payer.LastName)
+	if payer.Username != "" {
+		name = strings.TrimSpace(name + " @" + payer.Username)
+	}
+	if payer.Phone != "" {
+		name += ", " + payer.Phone
+	}
+	return name
+}
+
+func bookingEntity(kind string) string {
+	if kind == bookingTypeSpaces {
+		return entitySpaceBooking
+	}
+	return entityHotelBooking
+}
+
+func bookingSummary(kind, title string, start, end time.Time) string {
+	if kind == bookingTypeSpaces {
+		return fmt.Sprintf("%s\n📅 %s, %s - %s", title, start.Local().Format(dateLayout), start.Local().Format("15:04"), end.Local().Format("15:04"))
+	}
+	return fmt.Sprintf("%s\n📅 %s - %s", title, start.Format(dateLayout), end.Format(dateLayout))
+}
+
+// scheduleBookingRelease ставит снятие неоплаченной брони на конец удержания.
+// Без очереди бронь все равно перестает занимать номер или помещение после
+// удержания (см. ListAvailableHotelRooms, ListSpaceBusy), но остается в CRM
+func (h *MessageHandler) scheduleBookingRelease(ctx context.Context, kind string, bookingID, orderID pgtype.UUID, at time.Time) {
+	if h.tasks == nil {
+		logger.WarnContext(ctx, "⚠️ Очередь задач не настроена, снятие брони не поставлено")
+		return
+	}
+	task, err := queue.NewBookingHoldTask(queue.BookingHoldPayload{
+		BotID:          h.botConfig.ID.Bytes,
+		Kind:           kind,
+		EntityID:       bookingID.Bytes,
+		PaymentOrderID: orderID.Bytes,
+		Carrier:        tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	}, at)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create booking hold task", "error", err)
+		return
+	}
+	if _, err := h.tasks.EnqueueContext(ctx, task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		logger.ErrorContext(ctx, "❌ Не удалось поставить снятие брони", "error", err)
+	}
+}
+
+// releaseBooking снимает неоплаченную бронь. Возвращает true, если бронь была снята
+func (h *MessageHandler) releaseBooking(ctx context.Context, kind string, bookingID pgtype.UUID) bool {
+	var (
+		released int64
+		err      error
+	)
+	if kind == bookingTypeSpaces {
+		released, err = h.queries.ReleaseSpaceHold(ctx, bookingID)
+	} else {
+		released, err = h.queries.ReleaseHotelBooking(ctx, bookingID)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "❌ Не удалось снять бронь", "booking_id", uuidString(bookingID), "error", err)
+		return false
+	}
+	return released > 0
+}
+
+// handleBookingHold снимает бронь, не оплаченную за время удержания, и сообщает
+// об этом клиенту. Если оплата успела прийти, ничего не делает
+func (m *Manager) handleBookingHold(ctx context.Context, t *asynq.Task) error {
+	var p queue.BookingHoldPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"booking_kind", p.Kind, "booking_id", p.EntityID.String())
+	h := instance.Handler
+
+	status, err := h.expirePaymentOrder(ctx, p.PaymentOrderID.String())
+	if err != nil {
+		return err
+	}
+	if status == paymentStatusPaid {
+		return nil
+	}
+	bookingID := pgtype.UUID{Bytes: p.EntityID, Valid: true}
+	if !h.releaseBooking(ctx, p.Kind, bookingID) {
+		return nil
+	}
+	logger.InfoContext(ctx, "⌛ Неоплаченная бронь снята")
+
+	order, err := h.queries.GetPaymentOrder(ctx, pgtype.UUID{Bytes: p.PaymentOrderID, Valid: true})
+	if err != nil {
+		return nil
+	}
+	chatID := parsePaymentOrderData(order).ChatID
+	if chatID == 0 {
+		return nil
+	}
+	text := "⌛ Время на оплату брони истекло, бронь снята. Забронировать снова: /book"
+	if _, err := instance.Bot.Send(tele.ChatID(chatID), text); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось сообщить о снятии брони", "error", err)
+		return nil
+	}
+	h.logBotMessage(ctx, chatID, chatID, text, map[string]interface{}{"booking_id": p.EntityID.String()})
+	return nil
+}
+
+// confirmBooking подтверждает оплаченную бронь и сообщает об этом в чат. Повторный
+// вызов ничего не делает. Занятость проверяется еще раз под блокировкой номера
+// или помещения: если бронь успели снять или время заняли, бронь не подтверждается,
+// а сотрудники получают просьбу вернуть оплату
+func (h *MessageHandler) confirmBooking(ctx context.Context, kind string, bookingID pgtype.UUID, chatID int64) error {
+	var (
+		roomID         pgtype.UUID
+		place, summary string
+	)
+	if kind == bookingTypeSpaces {
+		booking, err := h.queries.GetSpaceBooking(ctx, bookingID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return h.bookingLost(ctx, kind, bookingID, "помещения "+uuidString(bookingID), chatID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load space booking: %w", err)
+		}
+		if booking.Reason == spaceBlockBooking {
+			return nil
+		}
+		roomID, place = booking.RoomID, "помещения "+booking.RoomName
+		summary = bookingSummary(kind, booking.RoomName, booking.StartDatetime.Time, booking.EndDatetime.Time)
+	} else {
+		booking, err := h.queries.GetHotelBookingHold(ctx, bookingID)
+		if err != nil {
+			return fmt.Errorf("failed to load hotel booking: %w", err)
+		}
+		if booking.Status == hotelBookingConfirmed {
+			return nil
+		}
+		roomID, place = booking.RoomID, "номера "+booking.RoomNumber
+		summary = bookingSummary(kind, hotelRoomTitle(booking.RoomNumber, ""), booking.CheckInDate.Time, booking.CheckOutDate.Time)
+	}
+
+	var confirmed int64
+	err := pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		if err := q.LockBookingResource(ctx, roomID); err != nil {
+			return fmt.Errorf("failed to lock room: %w", err)
+		}
+		var err error
+		if kind == bookingTypeSpaces {
+			confirmed, err = q.ConfirmSpaceBooking(ctx, bookingID)
+		} else {
+			confirmed, err = q.ConfirmHotelBooking(ctx, bookingID)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to confirm booking: %w", err)
+	}
+	if confirmed == 0 {
+		// Бронь могли подтвердить параллельно (та же оплата пришла вебхуком и NOTIFY)
+		done, err := h.bookingConfirmed(ctx, kind, bookingID)
+		if err != nil || done {
+			return err
+		}
+		return h.bookingLost(ctx, kind, bookingID, place, chatID)
+	}
+	logger.InfoContext(ctx, "🏨 Бронь оплачена и подтверждена", "booking_id", uuidString(bookingID))
+
+	if chatID == 0 {
+		return nil
+	}
+	text := "✅ Бронирование подтверждено\n\n" + summary
+	if _, err := h.engine.Bot().Send(tele.ChatID(chatID), text); err != nil {
+		return fmt.Errorf("failed to send booking confirmation: %w", err)
+	}
+	h.logBotMessage(ctx, chatID, chatID, text, map[string]interface{}{"booking_id": uuidString(bookingID)})
+	return nil
+}
+
+// bookingConfirmed - подтверждена ли бронь
+func (h *MessageHandler) bookingConfirmed(ctx context.Context, kind string, bookingID pgtype.UUID) (bool, error) {
+	if kind == bookingTypeSpaces {
+		booking, err := h.queries.GetSpaceBooking(ctx, bookingID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to load space booking: %w", err)
+		}
+		return booking.Reason == spaceBlockBooking, nil
+	}
+	booking, err := h.queries.GetHotelBookingHold(ctx, bookingID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load hotel booking: %w", err)
+	}
+	return booking.Status == hotelBookingConfirmed, nil
+}
+
+// bookingLost - оплата пришла за бронь, которую уже нельзя подтвердить: удержание
+// истекло и бронь сняли или время успели занять. Бронь снимается, сотрудники
+// получают просьбу вернуть оплату, клиент - сообщение об этом
+func (h *MessageHandler) bookingLost(ctx context.Context, kind string, bookingID pgtype.UUID, place string, chatID int64) error {
+	h.releaseBooking(ctx, kind, bookingID)
+	logger.WarnContext(ctx, "⚠️ Оплаченную бронь не удалось подтвердить", "booking_id", uuidString(bookingID))
+	h.notifyStaff(ctx, "⚠️ Оплата пришла за бронь "+place+", которую уже нельзя подтвердить: удержание истекло или время занято. Верните оплату клиенту")
+
+	if chatID == 0 {
+		return nil
+	}
+	text := "😔 Оплата пришла после окончания удержания брони, и этот вариант уже недоступен. Бронь не подтверждена, деньги вернем - с вами свяжутся."
+	if _, err := h.engine.Bot().Send(tele.ChatID(chatID), text); err != nil {
+		return fmt.Errorf("failed to send booking notice: %w", err)
+	}
+	h.logBotMessage(ctx, chatID, chatID, text, map[string]interface{}{"booking_id": uuidString(bookingID)})
+	return nil
+}
+
+// validateBookingCheckout перед списанием денег проверяет, что бронь еще удерживается
+func (h *MessageHandler) validateBookingCheckout(ctx context.Context, kind string, bookingID pgtype.UUID) error {
+	if kind == bookingTypeSpaces {
+		booking, err := h.queries.GetSpaceBooking(ctx, bookingID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errCheckout(checkoutErrUnavailable)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load space booking: %w", err)
+		}
+		if booking.ProfileID != h.botConfig.ProfileID || booking.Reason != spaceBlockHold ||
+			!booking.HoldUntil.Time.After(time.Now()) {
+			return errCheckout(checkoutErrUnavailable)
+		}
+		return nil
+	}
+
+	booking, err := h.queries.GetHotelBookingHold(ctx, bookingID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load hotel booking: %w", err)
+	}
+	if booking.ProfileID != h.botConfig.ProfileID || booking.Status != "pending" ||
+		(booking.HoldUntil.Valid && time.Now().After(booking.HoldUntil.Time)) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	return nil
+}
@@ -0,0 +1,416 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Callback кнопок корзины (префикс каталога, см. catalog.go)
+const (
+	callbackCartPrefix   = "cat:cart"
+	callbackCartShow     = "cat:cart"
+	callbackCartInc      = "cat:cart:inc:" // cat:cart:inc:<index>
+	callbackCartDec      = "cat:cart:dec:" // cat:cart:dec:<index>
+	callbackCartClear    = "cat:cart:clear"
+	callbackCartCheckout = "cat:cart:checkout"
+)
+
+const (
+	// cartKey - корзина в контексте разговора
+	cartKey = "cart"
+	// cartMaxItems - сколько разных позиций можно положить в корзину
+	cartMaxItems = 20
+	// cartMaxQuantity - сколько штук одной позиции
+	cartMaxQuantity = 99
+)
+
+// cart - корзина чата. Позиции хранятся с ценой на момент добавления;
+// при оформлении цены и остатки проверяются заново
+type cart struct {
+	Items     []paymentItem `json:"items"`
+	PromoCode string        `json:"promo_code,omitempty"`
+}
+
+func (c cart) subtotal() float64 {
+	var total float64
+	for _, item := range c.Items {
+		total += item.Price * float64(item.Quantity)
+	}
+	return total
+}
+
+func (c cart) count() int {
+	n := 0
+	for _, item := range c.Items {
+		n += item.Quantity
+	}
+	return n
+}
+
+func (h *MessageHandler) loadCart(ctx context.Context, chatID int64) cart {
+	var current cart
+	h.conversationValue(ctx, chatID, cartKey, &current)
+	return current
+}
+
+func (h *MessageHandler) saveCart(ctx context.Context, c tele.Context, current cart) {
+	var value interface{} = current
+	if len(current.Items) == 0 && current.PromoCode == "" {
+		value = nil
+	}
+	h.updateConversationContext(ctx, c, map[string]interface{}{cartKey: value})
+}
+
+func (h *MessageHandler) cartButtonText(ctx context.Context, chatID int64) string {
+	if n := h.loadCart(ctx, chatID).count(); n > 0 {
+		return fmt.Sprintf("🛒 Корзина (%d)", n)
+	}
+	return "🛒 Корзина"
+}
+
+// HandleCart - /cart: содержимое корзины
+func (h *MessageHandler) HandleCart(ctx context.Context, c tele.Context) error {
+	return h.showCart(ctx, c, "")
+}
+
+// promoEnabled - промокоды действуют на корзину и на билеты (для featureOnly)
+func promoEnabled(s BotSettings) bool { return s.CatalogEnabled || s.EventsEnabled }
+
+// HandlePromo - /promo КОД: применяет промокод к билетам, если пользователь
+// выбирает их количество, иначе к корзине
+func (h *MessageHandler) HandlePromo(ctx context.Context, c tele.Context) error {
+	code := strings.TrimSpace(c.Message().Payload)
+	if code == "" {
+		return c.Send("Напишите промокод после команды:\n/promo SALE10")
+	}
+
+	if draft := h.loadTicketDraft(ctx, c.Chat().ID); h.settings.EventsEnabled && draft.TicketTypeID != "" {
+		return h.applyTicketPromo(ctx, c, draft, code)
+	}
+	if !h.settings.CatalogEnabled {
+		return c.Send("Сначала выберите билет в /events, затем отправьте промокод.")
+	}
+
+	current := h.loadCart(ctx, c.Chat().ID)
+	if len(current.Items) == 0 {
+		return c.Send("Корзина пуста - сначала выберите товары в /catalog.")
+	}
+
+	promo, err := h.applyPromo(ctx, code, current.subtotal(), promoScope{}, pgtype.UUID{})
+	var reason errPromo
+	if errors.As(err, &reason) {
+		return c.Send(string(reason))
+	}
+	if err != nil {
+		return err
+	}
+
+	current.PromoCode = promo.Code
+	h.saveCart(ctx, c, current)
+	logger.InfoContext(ctx, "🏷️ Промокод применен к корзине", "code", promo.Code)
+	return h.showCart(ctx, c, "")
+}
+
+// addToCart добавляет одну штуку товара (варианта) в корзину, проверяя остаток
+func (h *MessageHandler) addToCart(ctx context.Context, c tele.Context, productID, variantID pgtype.UUID) error {
+	offer, err := h.productOffer(ctx, productID, variantID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Товар больше не продается"})
+	}
+	if err != nil {
+		return err
+	}
+
+	current := h.loadCart(ctx, c.Chat().ID)
+	index := -1
+	for i, item := range current.Items {
+		if item.ProductID == uuidString(productID) && item.VariantID == uuidString(variantID) {
+			index = i
+			break
+		}
+	}
+
+	quantity := 1
+	if index >= 0 {
+		quantity = current.Items[index].Quantity + 1
+	}
+	switch {
+	case index < 0 && len(current.Items) >= cartMaxItems:
+		return c.Respond(&tele.CallbackResponse{Text: "В корзине слишком много позиций"})
+	case quantity > cartMaxQuantity:
+		return c.Respond(&tele.CallbackResponse{Text: "Больше добавить нельзя"})
+	case !offer.inStock(quantity):
+		return c.Respond(&tele.CallbackResponse{Text: stockMessage(offer)})
+	}
+
+	if index >= 0 {
+		current.Items[index].Quantity = quantity
+		current.Items[index].Price = offer.Price
+	} else {
+		current.Items = append(current.Items, paymentItem{
+			ProductID: uuidString(productID),
+			VariantID: uuidString(variantID),
+			Name:      offer.Name,
+			Quantity:  1,
+			Price:     offer.Price,
+		})
+	}
+	h.saveCart(ctx, c, current)
+
+	return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("✅ %s добавлен в корзину (%d шт.)", offer.Name, quantity)})
+}
+
+// handleCartCallback обрабатывает кнопки корзины
+func (h *MessageHandler) handleCartCallback(ctx context.Context, c tele.Context, data string) error {
+	current := h.loadCart(ctx, c.Chat().ID)
+
+	switch {
+	case data == callbackCartShow:
+		if err := h.showCart(ctx, c, ""); err != nil {
+			return err
+		}
+		return c.Respond()
+
+	case data == callbackCartClear:
+		h.saveCart(ctx, c, cart{})
+		if err := h.showCart(ctx, c, ""); err != nil {
+			return err
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "Корзина очищена"})
+
+	case data == callbackCartCheckout:
+		if err := h.checkoutCart(ctx, c, current); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось оформить заказ", "error", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Не удалось оформить заказ, попробуйте позже", ShowAlert: true})
+		}
+		return nil
+
+	case strings.HasPrefix(data, callbackCartInc), strings.HasPrefix(data, callbackCartDec):
+		inc := strings.HasPrefix(data, callbackCartInc)
+		index, err := strconv.Atoi(data[strings.LastIndex(data, ":")+1:])
+		if err != nil || index < 0 || index >= len(current.Items) {
+			// Кнопка от старого состояния корзины
+			if err := h.showCart(ctx, c, ""); err != nil {
+				return err
+			}
+			return c.Respond()
+		}
+
+		item := &current.Items[index]
+		if inc {
+			productID, variantID := itemIDs(*item)
+			offer, err := h.productOffer(ctx, productID, variantID)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return c.Respond(&tele.CallbackResponse{Text: "Товар больше не продается"})
+			}
+			if err != nil {
+				return err
+			}
+			if item.Quantity+1 > cartMaxQuantity || !offer.inStock(item.Quantity+1) {
+				return c.Respond(&tele.CallbackResponse{Text: stockMessage(offer)})
+			}
+			item.Quantity++
+		} else {
+			item.Quantity--
+			if item.Quantity <= 0 {
+				current.Items = append(current.Items[:index], current.Items[index+1:]...)
+			}
+		}
+
+		h.saveCart(ctx, c, current)
+		if err := h.showCart(ctx, c, ""); err != nil {
+			return err
+		}
+		return c.Respond()
+	}
+	return c.Respond()
+}
+
+// showCart показывает корзину с итогом, скидкой по промокоду и кнопками
+func (h *MessageHandler) showCart(ctx context.Context, c tele.Context, notice string) error {
+	current := h.loadCart(ctx, c.Chat().ID)
+	markup := &tele.ReplyMarkup{}
+
+	if len(current.Items) == 0 {
+		markup.InlineKeyboard = [][]tele.InlineButton{{{Text: "🛍 Каталог", Data: callbackCatalogCategory + catalogRoot + ":0"}}}
+		return h.showCatalogScreen(c, strings.TrimSpace(notice+"\n\n🛒 Корзина пуста."), markup)
+	}
+
+	lines := []string{"🛒 Корзина", ""}
+	for i, item := range current.Items {
+		lines = append(lines, fmt.Sprintf("%d. %s × %d = %s", i+1, item.Name, item.Quantity,
+			h.formatPrice(item.Price*float64(item.Quantity), "")))
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{
+			{Text: "➖", Data: callbackCartDec + strconv.Itoa(i)},
+			{Text: truncateRunes(item.Name, 24), Data: callbackCatalogNoop},
+			{Text: "➕", Data: callbackCartInc + strconv.Itoa(i)},
+		})
+	}
+
+	subtotal := current.subtotal()
+	total := subtotal
+	lines = append(lines, "")
+	if current.PromoCode != "" {
+		promo, err := h.applyPromo(ctx, current.PromoCode, subtotal, promoScope{}, pgtype.UUID{})
+		var reason errPromo
+		switch {
+		case err == nil:
+			total -= promo.Amount
+			lines = append(lines, fmt.Sprintf("Промокод %s: −%s", promo.Code, h.formatPrice(promo.Amount, "")))
+		case errors.As(err, &reason):
+			lines = append(lines, fmt.Sprintf("Промокод %s не применен: %s", current.PromoCode, string(reason)))
+		default:
+			logger.ErrorContext(ctx, "Failed to check promo code", "error", err)
+		}
+	} else {
+		lines = append(lines, "Есть промокод? Отправьте /promo КОД")
+	}
+	lines = append(lines, "Итого: "+h.formatPrice(total, ""))
+
+	markup.InlineKeyboard = append(markup.InlineKeyboard,
+		[]tele.InlineButton{{Text: "✅ Оформить заказ", Data: callbackCartCheckout}},
+		[]tele.InlineButton{
+			{Text: "🛍 Каталог", Data: callbackCatalogCategory + catalogRoot + ":0"},
+			{Text: "🗑 Очистить", Data: callbackCartClear},
+		},
+	)
+
+	text := strings.Join(lines, "\n")
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+	return h.showCatalogScreen(c, text, markup)
+}
+
+// checkoutCart проверяет цены и остатки, применяет промокод и создает заказ на
+// оплату. Оплата - счетом Telegram или ссылкой (payment_methods.catalog / тип шлюза)
+func (h *MessageHandler) checkoutCart(ctx context.Context, c tele.Context, current cart) error {
+	if len(current.Items) == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "Корзина пуста"})
+	}
+
+	// Цены могли измениться с момента добавления - берем текущие
+	var changed []string
+	for i := range current.Items {
+		item := &current.Items[i]
+		productID, variantID := itemIDs(*item)
+		offer, err := h.productOffer(ctx, productID, variantID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			changed = append(changed, item.Name+" больше не продается")
+			item.Quantity = 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !offer.inStock(item.Quantity) {
+			changed = append(changed, stockMessage(offer))
+			item.Quantity = int(offer.Available)
+		}
+		if minorUnits(offer.Price, h.settings.PaymentsCurrency) != minorUnits(item.Price, h.settings.PaymentsCurrency) {
+			changed = append(changed, fmt.Sprintf("Цена %s изменилась: %s", item.Name, h.formatPrice(offer.Price, "")))
+			item.Price = offer.Price
+		}
+	}
+	if len(changed) > 0 {
+		items := current.Items[:0]
+		for _, item := range current.Items {
+			if item.Quantity > 0 {
+				items = append(items, item)
+			}
+		}
+		current.Items = items
+		h.saveCart(ctx, c, current)
+		if err := h.showCart(ctx, c, "⚠️ "+strings.Join(changed, "\n⚠️ ")+"\n\nПроверьте корзину и оформите заказ еще раз."); err != nil {
+			return err
+		}
+		return c.Respond()
+	}
+
+	payer := customerFromUser(c.Sender())
+	h.conversationValue(ctx, c.Chat().ID, "phone", &payer.Phone)
+	customerID, err := h.linkedCustomer(ctx, payer)
+	if err != nil {
+		return err
+	}
+
+	subtotal := current.subtotal()
+	req := paymentRequest{
+		Amount:      subtotal,
+		Description: fmt.Sprintf("Заказ в Telegram: %d шт.", current.count()),
+		EntityType:  "cart",
+		Items:       current.Items,
+		Method:      h.settings.paymentMethod(featureCatalog),
+	}
+	if len(current.Items) == 1 {
+		req.Description = fmt.Sprintf("%s × %d", current.Items[0].Name, current.Items[0].Quantity)
+	}
+	if current.PromoCode != "" {
+		promo, err := h.applyPromo(ctx, current.PromoCode, subtotal, promoScope{}, customerID)
+		var reason errPromo
+		if errors.As(err, &reason) {
+			current.PromoCode = ""
+			h.saveCart(ctx, c, current)
+			if err := h.showCart(ctx, c, "⚠️ "+string(reason)); err != nil {
+				return err
+			}
+			return c.Respond()
+		}
+		if err != nil {
+			return err
+		}
+		req.Promo = promo
+		req.Amount -= promo.Amount
+	}
+	// Промокод на 100% или фиксированная скидка не меньше суммы - оплачивать нечего
+	free := minorUnits(req.Amount, h.settings.PaymentsCurrency) == 0
+	if free {
+		req.Amount = 0
+	}
+
+	order, data, err := h.createPaymentOrder(ctx, payer, c.Chat().ID, req)
+	if err != nil {
+		return err
+	}
+	ctx = utils.WithLogAttrs(ctx, "payment_order_id", uuidString(order.ID))
+
+	if free {
+		if err := h.closeFreeOrder(ctx, order); err != nil {
+			return err
+		}
+		if err := c.Send("✅ Заказ оформлен. Оплата не нужна: промокод покрыл всю сумму."); err != nil {
+			return err
+		}
+	} else if err := h.offerPayment(ctx, c, order, data, ""); err != nil {
+		return err
+	}
+
+	// Корзина превратилась в заказ
+	h.saveCart(ctx, c, cart{})
+	logger.InfoContext(ctx, "🛒 Заказ из корзины оформлен", "items", len(data.Items), "amount", formatAmount(order.Amount))
+	return c.Respond()
+}
+
+func itemIDs(item paymentItem) (pgtype.UUID, pgtype.UUID) {
+	var productID, variantID pgtype.UUID
+	productID.Scan(item.ProductID)
+	if item.VariantID != "" {
+		variantID.Scan(item.VariantID)
+	}
+	return productID, variantID
+}
+
+func stockMessage(offer productOffer) string {
+	if offer.Available <= 0 {
+		return offer.Name + " закончился"
+	}
+	return fmt.Sprintf("%s: в наличии %d шт.", offer.Name, int(offer.Available))
+}
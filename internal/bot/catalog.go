@@ -0,0 +1,376 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Каталог товаров: категории (products_categories) и товары (products,
+// products_variants) с навигацией inline кнопками. Включается catalog_enabled
+// в настройках бота; корзина и оформление заказа - в cart.go.
+
+// Callback кнопок каталога. Telegram ограничивает data 64 байтами,
+// поэтому в данных только короткий префикс и id
+const (
+	callbackCatalogPrefix   = "cat:"
+	callbackCatalogCategory = "cat:c:" // cat:c:<category_id|->:<page>
+	callbackCatalogProduct  = "cat:p:" // cat:p:<product_id>
+	callbackCatalogAdd      = "cat:a:" // cat:a:<product_id>
+	callbackCatalogVariant  = "cat:v:" // cat:v:<variant_id>
+	callbackCatalogNoop     = "cat:noop"
+
+	// catalogRoot - корень каталога в callback категории
+	catalogRoot = "-"
+)
+
+// catalogCommands - команды каталога в меню
+var catalogCommands = []menuCommand{
+	{Command: "catalog", Description: "Каталог", Descriptions: map[string]string{"en": "Catalog"}},
+	{Command: "cart", Description: "Корзина", Descriptions: map[string]string{"en": "Cart"}},
+}
+
+// featureCatalog - раздел каталога в payment_methods
+const featureCatalog = "catalog"
+
+// catalogEnabled - каталог включен в настройках (для featureOnly)
+func catalogEnabled(s BotSettings) bool { return s.CatalogEnabled }
+
+// HandleCatalog - /catalog: корень каталога
+func (h *MessageHandler) HandleCatalog(ctx context.Context, c tele.Context) error {
+	return h.showCategory(ctx, c, pgtype.UUID{}, 0)
+}
+
+// handleCatalogCallback обрабатывает кнопки каталога и корзины
+func (h *MessageHandler) handleCatalogCallback(ctx context.Context, c tele.Context, data string) error {
+	if !h.settings.CatalogEnabled {
+		return c.Respond(&tele.CallbackResponse{Text: "Каталог недоступен"})
+	}
+
+	switch {
+	case data == callbackCatalogNoop:
+		return c.Respond()
+	case strings.HasPrefix(data, callbackCatalogCategory):
+		categoryID, page := parseCategoryCallback(strings.TrimPrefix(data, callbackCatalogCategory))
+		if err := h.showCategory(ctx, c, categoryID, page); err != nil {
+			return err
+		}
+		return c.Respond()
+	case strings.HasPrefix(data, callbackCatalogProduct):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackCatalogProduct))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Товар не найден"})
+		}
+		if err := h.showProduct(ctx, c, id); err != nil {
+			return err
+		}
+		return c.Respond()
+	case strings.HasPrefix(data, callbackCatalogAdd):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackCatalogAdd))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Товар не найден"})
+		}
+		return h.addToCart(ctx, c, id, pgtype.UUID{})
+	case strings.HasPrefix(data, callbackCatalogVariant):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackCatalogVariant))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Товар не найден"})
+		}
+		variant, err := h.queries.GetProductVariant(ctx, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return c.Respond(&tele.CallbackResponse{Text: "Товар не найден"})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load variant: %w", err)
+		}
+		return h.addToCart(ctx, c, variant.ProductID, variant.ID)
+	case strings.HasPrefix(data, callbackCartPrefix):
+		return h.handleCartCallback(ctx, c, data)
+	}
+	return c.Respond()
+}
+
+// showCategory показывает подкатегории и страницу товаров категории
+// (pgtype.UUID{} - корень каталога)
+func (h *MessageHandler) showCategory(ctx context.Context, c tele.Context, categoryID pgtype.UUID, page int) error {
+	title := "🛍 Каталог"
+	parent := catalogRoot
+	if categoryID.Valid {
+		category, err := h.queries.GetCatalogCategory(ctx, storage.GetCatalogCategoryParams{
+			ID:        categoryID,
+			ProfileID: h.botConfig.ProfileID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return h.showCategory(ctx, c, pgtype.UUID{}, 0)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load category: %w", err)
+		}
+		title = category.Name
+		if category.Description.String != "" {
+			title += "\n\n" + category.Description.String
+		}
+		if category.ParentID.Valid {
+			parent = uuidString(category.ParentID)
+		}
+	}
+
+	categories, err := h.queries.ListCatalogCategories(ctx, storage.ListCatalogCategoriesParams{
+		ProfileID: h.botConfig.ProfileID,
+		ParentID:  categoryID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load categories: %w", err)
+	}
+
+	total, err := h.queries.CountCatalogProducts(ctx, storage.CountCatalogProductsParams{
+		ProfileID:  h.botConfig.ProfileID,
+		CategoryID: categoryID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to count products: %w", err)
+	}
+
+	pageSize := h.settings.CatalogPageSize
+	pages := int(math.Ceil(float64(total) / float64(pageSize)))
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	products, err := h.queries.ListCatalogProducts(ctx, storage.ListCatalogProductsParams{
+		ProfileID:  h.botConfig.ProfileID,
+		CategoryID: categoryID,
+		Limit:      int32(pageSize),
+		Offset:     int32(page * pageSize),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load products: %w", err)
+	}
+
+	markup := &tele.ReplyMarkup{}
+	for _, category := range categories {
+		text := category.Name
+		if category.Icon.String != "" {
+			text = category.Icon.String + " " + text
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: text,
+			Data: callbackCatalogCategory + uuidString(category.ID) + ":0",
+		}})
+	}
+	for _, product := range products {
+		price, _ := numericToFloat(product.Price)
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: fmt.Sprintf("%s - %s", product.Name, h.formatPrice(price, product.Currency.String)),
+			Data: callbackCatalogProduct + uuidString(product.ID),
+		}})
+	}
+
+	current := catalogRoot
+	if categoryID.Valid {
+		current = uuidString(categoryID)
+	}
+	if pages > 1 {
+		row := []tele.InlineButton{}
+		if page > 0 {
+			row = append(row, tele.InlineButton{Text: "◀️", Data: fmt.Sprintf("%s%s:%d", callbackCatalogCategory, current, page-1)})
+		}
+		row = append(row, tele.InlineButton{Text: fmt.Sprintf("%d / %d", page+1, pages), Data: callbackCatalogNoop})
+		if page < pages-1 {
+			row = append(row, tele.InlineButton{Text: "▶️", Data: fmt.Sprintf("%s%s:%d", callbackCatalogCategory, current, page+1)})
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+
+	nav := []tele.InlineButton{}
+	if categoryID.Valid {
+		nav = append(nav, tele.InlineButton{Text: "⬆️ Назад", Data: callbackCatalogCategory + parent + ":0"})
+	}
+	nav = append(nav, tele.InlineButton{Text: h.cartButtonText(ctx, c.Chat().ID), Data: callbackCartShow})
+	markup.InlineKeyboard = append(markup.InlineKeyboard, nav)
+
+	if len(categories) == 0 && len(products) == 0 {
+		title += "\n\nЗдесь пока пусто."
+	}
+	return h.showCatalogScreen(c, title, markup)
+}
+
+// showProduct отправляет карточку товара: фото, описание, цена и кнопки вариантов
+func (h *MessageHandler) showProduct(ctx context.Context, c tele.Context, productID pgtype.UUID) error {
+	product, err := h.queries.GetProduct(ctx, storage.GetProductParams{ID: productID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && product.Status.String != "active") {
+		return c.Send("Товар больше не продается.")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load product: %w", err)
+	}
+
+	variants, err := h.queries.ListProductVariants(ctx, productID)
+	if err != nil {
+		return fmt.Errorf("failed to load variants: %w", err)
+	}
+
+	price, _ := numericToFloat(product.Price)
+	lines := []string{"<b>" + html.EscapeString(product.Name) + "</b>"}
+	description := product.ShortDescription.String
+	if description == "" {
+		description = product.Description.String
+	}
+	if description != "" {
+		lines = append(lines, "", html.EscapeString(truncateRunes(description, 800)))
+	}
+	lines = append(lines, "", "Цена: "+h.formatPrice(price, product.Currency.String))
+
+	markup := &tele.ReplyMarkup{}
+	if len(variants) == 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: "➕ В корзину",
+			Data: callbackCatalogAdd + uuidString(productID),
+		}})
+	}
+	for _, variant := range variants {
+		modifier, _ := numericToFloat(variant.PriceModifier)
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: fmt.Sprintf("➕ %s - %s", variant.Name, h.formatPrice(price+modifier, product.Currency.String)),
+			Data: callbackCatalogVariant + uuidString(variant.ID),
+		}})
+	}
+
+	back := callbackCatalogCategory + catalogRoot + ":0"
+	if product.CategoryID.Valid {
+		back = callbackCatalogCategory + uuidString(product.CategoryID) + ":0"
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{
+		{Text: "⬅️ К списку", Data: back},
+		{Text: h.cartButtonText(ctx, c.Chat().ID), Data: callbackCartShow},
+	})
+
+	caption := strings.Join(lines, "\n")
+	if product.ImageUrl.String != "" {
+		photo := &tele.Photo{File: tele.FromURL(product.ImageUrl.String), Caption: caption}
+		err := c.Send(photo, markup, tele.ModeHTML)
+		if err == nil {
+			return nil
+		}
+		// Битая ссылка на фото не должна ломать каталог
+		logger.WarnContext(ctx, "⚠️ Не удалось отправить фото товара", "error", err)
+	}
+	return c.Send(caption, markup, tele.ModeHTML)
+}
+
+// showCatalogScreen заменяет текст сообщения с кнопками; карточку товара с фото
+// отредактировать в текст нельзя - тогда отправляется новое сообщение
+func (h *MessageHandler) showCatalogScreen(c tele.Context, text string, markup *tele.ReplyMarkup) error {
+	if cb := c.Callback(); cb != nil && cb.Message != nil && cb.Message.Photo == nil {
+		err := c.Edit(text, markup)
+		if err == nil || errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+			return nil
+		}
+	}
+	return c.Send(text, markup)
+}
+
+// productOffer - товар (или его вариант) с текущей ценой и остатком
+type productOffer struct {
+	Product storage.GetProductRow
+	Name    string
+	Price   float64
+	// Available - доступный остаток; Limited = false - остаток не учитывается
+	Available float64
+	Limited   bool
+}
+
+// productOffer загружает товар и вариант (variantID может быть пустым).
+// Остаток: у варианта - его stock_quantity, у товара - сумма warehouse_stock
+// (за вычетом резерва), а если товар не лежит на складах - stock_quantity
+// карточки при включенном track_inventory. Нет товара - pgx.ErrNoRows
+func (h *MessageHandler) productOffer(ctx context.Context, productID, variantID pgtype.UUID) (productOffer, error) {
+	product, err := h.queries.GetProduct(ctx, storage.GetProductParams{ID: productID, ProfileID: h.botConfig.ProfileID})
+	if err != nil {
+		return productOffer{}, err
+	}
+	if product.Status.Valid && product.Status.String != "active" {
+		return productOffer{}, pgx.ErrNoRows
+	}
+
+	offer := productOffer{Product: product, Name: product.Name}
+	offer.Price, _ = numericToFloat(product.Price)
+
+	if variantID.Valid {
+		variant, err := h.queries.GetProductVariant(ctx, variantID)
+		if err != nil {
+			return productOffer{}, err
+		}
+		if variant.ProductID != productID {
+			return productOffer{}, pgx.ErrNoRows
+		}
+		modifier, _ := numericToFloat(variant.PriceModifier)
+		offer.Price += modifier
+		offer.Name = product.Name + " (" + variant.Name + ")"
+		if stock, ok := numericToFloat(variant.StockQuantity); ok {
+			offer.Available, offer.Limited = stock, true
+			return offer, nil
+		}
+	}
+
+	stock, err := h.queries.GetProductStock(ctx, productID)
+	if err != nil {
+		return productOffer{}, fmt.Errorf("failed to load stock: %w", err)
+	}
+	switch {
+	case stock.Warehouses > 0:
+		offer.Available, _ = numericToFloat(stock.Available)
+		offer.Limited = true
+	case product.TrackInventory.Bool:
+		offer.Available, _ = numericToFloat(product.StockQuantity)
+		offer.Limited = true
+	}
+	return offer, nil
+}
+
+// inStock - хватает ли остатка на quantity штук
+func (o productOffer) inStock(quantity int) bool {
+	return !o.Limited || o.Available >= float64(quantity)
+}
+
+// formatPrice - цена с символом валюты ("1490.00 ₽")
+func (h *MessageHandler) formatPrice(amount float64, currency string) string {
+	if currency == "" {
+		currency = h.settings.PaymentsCurrency
+	}
+	exp := currencyExponent(currency)
+	symbols := map[string]string{"RUB": "₽", "USD": "$", "EUR": "€", "KZT": "₸", "UAH": "₴", "BYN": "Br"}
+	if symbol, ok := symbols[strings.ToUpper(currency)]; ok {
+		currency = symbol
+	}
+	return fmt.Sprintf("%.*f %s", exp, amount, currency)
+}
+
+func parseCategoryCallback(data string) (pgtype.UUID, int) {
+	id, pageText, _ := strings.Cut(data, ":")
+	page, _ := strconv.Atoi(pageText)
+
+	var categoryID pgtype.UUID
+	if id != catalogRoot {
+		categoryID.Scan(id)
+	}
+	return categoryID, page
+}
+
+func parseUUID(s string) (pgtype.UUID, error) {
+	var id pgtype.UUID
+	err := id.Scan(s)
+	return id, err
+}
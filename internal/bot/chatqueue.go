@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"sync"
+)
+
+// chatQueue выполняет задачи одного чата строго по очереди,
+// а задачи разных чатов - параллельно. Для каждого чата с задачами
+// живет одна горутина, которая завершается, когда очередь пуста.
+type chatQueue struct {
+	mu      sync.Mutex
+	workers map[int64]*chatWorker
+	wg      sync.WaitGroup
+}
+
+type chatWorker struct {
+	jobs []func()
+}
+
+func newChatQueue() *chatQueue {
+	return &chatQueue{
+		workers: make(map[int64]*chatWorker),
+	}
+}
+
+// Dispatch ставит задачу в очередь чата. Порядок выполнения совпадает с порядком вызовов.
+func (q *chatQueue) Dispatch(chatID int64, job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if w, ok := q.workers[chatID]; ok {
+		w.jobs = append(w.jobs, job)
+		return
+	}
+
+	w := &chatWorker{jobs: []func(){job}}
+	q.workers[chatID] = w
+	q.wg.Add(1)
+	go q.run(chatID, w)
+}
+
+func (q *chatQueue) run(chatID int64, w *chatWorker) {
+	defer q.wg.Done()
+
+	for {
+		q.mu.Lock()
+		if len(w.jobs) == 0 {
+			delete(q.workers, chatID)
+			q.mu.Unlock()
+			return
+		}
+		job := w.jobs[0]
+		w.jobs = w.jobs[1:]
+		q.mu.Unlock()
+
+		job()
+	}
+}
+
+// Go выполняет задачу вне очередей чатов, но учитывает ее в Wait
+func (q *chatQueue) Go(job func()) {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		job()
+	}()
+}
+
+// Wait ждет выполнения всех поставленных задач
+func (q *chatQueue) Wait() {
+	q.wg.Wait()
+}
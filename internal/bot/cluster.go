@@ -0,0 +1,479 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/redis/go-redis/v9"
+)
+
+// Распределение ботов между репликами сервиса.
+//
+// Каждая реплика раз в reconcileInterval отмечается в ZSET tg:replicas и
+// пересчитывает, какие боты должны работать у нее: владелец бота выбирается
+// rendezvous-хэшированием по живым репликам. Чтобы опрашивать бота, реплика
+// должна держать lease tg:bot-lease:<bot_id> - так один бот никогда не поллится
+// двумя репликами одновременно (иначе Telegram отвечает 409 Conflict).
+//
+// Если реплика умерла, ее leases истекают через leaseTTL, она пропадает из
+// списка живых, и боты забирают новые владельцы. Если реплика добавилась,
+// текущие владельцы отдают ей "ее" ботов: останавливают поллер и освобождают lease.
+//
+// Задачи Asynq бота (таймауты, рассылки, напоминания...) может выполнить только
+// реплика, на которой он запущен. Задачи ставятся в общую очередь; реплика, взявшая
+// задачу чужого бота, пересылает ее в очередь владельца bot:<replica_id> (см. taskBot).
+// Очереди остановленных реплик разбирает adoptOrphanQueues.
+const (
+	replicasKey       = "tg:replicas"
+	leaseKeyPrefix    = "tg:bot-lease:"
+	adoptKeyPrefix    = "tg:queue-adopt:"
+	leaseTTL          = 30 * time.Second
+	replicaTTL        = 30 * time.Second
+	reconcileInterval = 10 * time.Second
+	// leaseRenewInterval - leases продлеваются отдельно от распределения,
+	// чтобы медленный проход reconcile не стоил реплике ее ботов
+	leaseRenewInterval = leaseTTL / 3
+)
+
+// newReplicaID возвращает идентификатор реплики (REPLICA_ID или hostname + случайный суффикс)
+func newReplicaID(id string) string {
+	if id != "" {
+		return id
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+}
+
+// RunCluster запускает ботов этой реплики и поддерживает распределение, пока не отменен ctx.
+// Без Redis все активные боты запускаются локально.
+func (m *Manager) RunCluster(ctx context.Context) error {
+	if m.redis == nil {
+		return m.LoadAndStartBots(ctx)
+	}
+
+	logger.Info("🌐 Реплика запущена", "replica_id", m.replicaID)
+
+	// Первый проход синхронно, чтобы к старту сервиса боты уже были запущены
+	m.reconcile(ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runEvery(ctx, reconcileInterval, m.reconcile)
+	}()
+	go func() {
+		defer wg.Done()
+		runEvery(ctx, leaseRenewInterval, m.renewLeases)
+	}()
+
+	m.clusterDone = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(m.clusterDone)
+	}()
+
+	return nil
+}
+
+// runEvery вызывает fn раз в interval, пока не отменен ctx
+func runEvery(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fn(ctx)
+		}
+	}
+}
+
+// reconcile приводит набор запущенных ботов к распределению по живым репликам
+func (m *Manager) reconcile(ctx context.Context) {
+	replicas, err := m.heartbeat(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось обновить список реплик", "error", err)
+		return
+	}
+	m.adoptOrphanQueues(ctx, replicas)
+
+	bots, err := m.queries.GetAllActiveBots(ctx)
+	if err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось загрузить ботов", "error", err)
+		return
+	}
+
+	active := make(map[uuid.UUID]bool, len(bots))
+	owned := make(map[uuid.UUID]bool, len(bots))
+	for _, botConfig := range bots {
+		botID := uuid.UUID(botConfig.ID.Bytes)
+		active[botID] = true
+
+		owner := pickOwner(replicas, botID)
+		owned[botID] = owner == m.replicaID
+		_, running := m.supervised(botID)
+
+		switch {
+		case running && owner != m.replicaID:
+			logger.InfoContext(configLogContext(ctx, botConfig), "↪️ Передаем бота другой реплике", "replica_id", owner)
+			m.releaseBot(botID)
+		case !running && owner == m.replicaID:
+			m.claimBot(ctx, botID, botConfig)
+		}
+	}
+
+	// Боты, выключенные в CRM
+	for _, botID := range m.runningBotIDs() {
+		if !active[botID] {
+			logger.InfoContext(ctx, "⏹️ Бот больше не активен", "bot_id", botID)
+			m.releaseBot(botID)
+		}
+	}
+
+	m.pruneFailed(active, owned)
+}
+
+// pruneFailed забывает выключенных ботов, которых снова включили на другой реплике.
+// Выключенные (неактивные) боты остаются в /bots с причиной ошибки.
+func (m *Manager) pruneFailed(active, owned map[uuid.UUID]bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for botID := range m.failed {
+		if active[botID] && !owned[botID] {
+			delete(m.failed, botID)
+		}
+	}
+}
+
+// heartbeat отмечает реплику живой и возвращает список живых реплик
+func (m *Manager) heartbeat(ctx context.Context) ([]string, error) {
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-replicaTTL).UnixMilli(), 10)
+
+	pipe := m.redis.TxPipeline()
+	pipe.ZAdd(ctx, replicasKey, redis.Z{Score: float64(now.UnixMilli()), Member: m.replicaID})
+	pipe.ZRemRangeByScore(ctx, replicasKey, "-inf", "("+cutoff)
+	live := pipe.ZRange(ctx, replicasKey, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return live.Val(), nil
+}
+
+// liveReplicas возвращает список живых реплик, не отмечая текущую
+func (m *Manager) liveReplicas(ctx context.Context) ([]string, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-replicaTTL).UnixMilli(), 10)
+	return m.redis.ZRangeByScore(ctx, replicasKey, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+}
+
+// taskBot возвращает запущенного на этой реплике бота для задачи Asynq.
+// Если бот принадлежит другой реплике, задача пересылается в ее очередь и
+// возвращается nil без ошибки - обработчик должен просто завершиться.
+// Если владелец - эта реплика, но бот еще не запущен, возвращается ошибка,
+// и задача повторяется по обычным правилам.
+func (m *Manager) taskBot(ctx context.Context, t *asynq.Task, botID uuid.UUID) (*BotInstance, error) {
+	if instance, ok := m.GetBot(botID); ok {
+		return instance, nil
+	}
+	if m.redis == nil {
+		return nil, fmt.Errorf("bot %s is not running", botID)
+	}
+
+	replicas, err := m.liveReplicas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load replicas: %w", err)
+	}
+	owner := pickOwner(replicas, botID)
+	if owner == "" || owner == m.replicaID {
+		return nil, fmt.Errorf("bot %s is not running", botID)
+	}
+
+	if err := m.forwardTask(ctx, t, owner); err != nil {
+		return nil, fmt.Errorf("failed to forward task to replica %s: %w", owner, err)
+	}
+	logger.DebugContext(ctx, "Задача передана реплике-владельцу бота", "bot_id", botID, "replica_id", owner)
+	return nil, nil
+}
+
+// forwardTask ставит копию выполняемой задачи в очередь реплики с теми же
+// TaskID, числом повторов и таймаутом
+func (m *Manager) forwardTask(ctx context.Context, t *asynq.Task, replicaID string) error {
+	opts := []asynq.Option{asynq.Queue(queue.ReplicaQueue(replicaID))}
+	if id, ok := asynq.GetTaskID(ctx); ok {
+		opts = append(opts, asynq.TaskID(id))
+	}
+	if maxRetry, ok := asynq.GetMaxRetry(ctx); ok {
+		opts = append(opts, asynq.MaxRetry(maxRetry))
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, asynq.Timeout(time.Until(deadline)))
+	}
+
+	_, err := m.tasks.EnqueueContext(ctx, asynq.NewTask(t.Type(), t.Payload(), opts...))
+	if errors.Is(err, asynq.ErrTaskIDConflict) {
+		// Задача уже переслана
+		return nil
+	}
+	return err
+}
+
+// adoptOrphanQueues возвращает в общую очередь задачи из очередей реплик,
+// которых больше нет среди живых (например, после перезапуска с новым REPLICA_ID).
+// Очередь разбирает одна реплика - та, что заняла lease на нее
+func (m *Manager) adoptOrphanQueues(ctx context.Context, replicas []string) {
+	if m.inspector == nil {
+		return
+	}
+
+	queues, err := m.inspector.Queues()
+	if err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось загрузить очереди задач", "error", err)
+		return
+	}
+
+	for _, name := range queues {
+		replicaID, ok := queue.QueueReplica(name)
+		if !ok || slices.Contains(replicas, replicaID) {
+			continue
+		}
+
+		key := adoptKeyPrefix + name
+		acquired, err := utils.TryAcquireLease(ctx, m.redis, key, m.replicaID, leaseTTL)
+		if err != nil || !acquired {
+			continue
+		}
+		m.adoptQueue(ctx, name)
+		utils.ReleaseLease(ctx, m.redis, key, m.replicaID)
+	}
+}
+
+// adoptQueue переносит ожидающие и повторяемые задачи очереди в общую очередь
+// и удаляет очередь, если она опустела
+func (m *Manager) adoptQueue(ctx context.Context, name string) {
+	var tasks []*asynq.TaskInfo
+	for _, list := range []func(string, ...asynq.ListOption) ([]*asynq.TaskInfo, error){
+		m.inspector.ListPendingTasks,
+		m.inspector.ListRetryTasks,
+	} {
+		infos, err := list(name, asynq.PageSize(1000))
+		if err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось загрузить задачи очереди", "queue", name, "error", err)
+			return
+		}
+		tasks = append(tasks, infos...)
+	}
+
+	for _, info := range tasks {
+		task := asynq.NewTask(info.Type, info.Payload,
+			asynq.Queue(queue.DefaultQueue), asynq.MaxRetry(info.MaxRetry), asynq.Timeout(info.Timeout))
+		if _, err := m.tasks.EnqueueContext(ctx, task); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось перенести задачу", "queue", name, "task_id", info.ID, "error", err)
+			continue
+		}
+		if err := m.inspector.DeleteTask(name, info.ID); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось удалить перенесенную задачу", "queue", name, "task_id", info.ID, "error", err)
+		}
+	}
+
+	if len(tasks) > 0 {
+		logger.InfoContext(ctx, "📦 Задачи остановленной реплики перенесены", "queue", name, "tasks", len(tasks))
+	}
+	// Очередь с активными (еще выполняемыми) задачами не удаляется - дойдем до нее в следующий раз
+	if err := m.inspector.DeleteQueue(name, false); err != nil && !errors.Is(err, asynq.ErrQueueNotEmpty) {
+		logger.DebugContext(ctx, "Очередь остановленной реплики не удалена", "queue", name, "error", err)
+	}
+}
+
+// claimBot занимает lease бота и запускает его
+func (m *Manager) claimBot(ctx context.Context, botID uuid.UUID, botConfig storage.TelegramBot) {
+	if m.isStopping(botID) {
+		// Прежний поллер бота еще работает - запустим на следующем проходе
+		return
+	}
+
+	key := leaseKeyPrefix + botID.String()
+
+	ok, err := utils.TryAcquireLease(ctx, m.redis, key, m.replicaID, leaseTTL)
+	if err == nil && !ok {
+		// Lease мог остаться от нас же (перезапуск с тем же REPLICA_ID)
+		ok, err = utils.RenewLease(ctx, m.redis, key, m.replicaID, leaseTTL)
+	}
+	if err != nil {
+		logger.WarnContext(configLogContext(ctx, botConfig), "⚠️ Не удалось занять бота", "error", err)
+		return
+	}
+	if !ok {
+		// Предыдущий владелец еще не отпустил бота - попробуем на следующем проходе
+		return
+	}
+
+	if err := m.StartBot(ctx, botConfig); err != nil {
+		logger.ErrorContext(configLogContext(ctx, botConfig), "❌ Не удалось запустить бота", "username", botConfig.BotUsername.String, "error", err)
+		utils.ReleaseLease(ctx, m.redis, key, m.replicaID)
+		return
+	}
+
+	if s, ok := m.supervised(botID); ok {
+		s.leaseRenewedAt = time.Now()
+	}
+}
+
+// releaseBot останавливает бота в фоне и только после остановки поллера освобождает lease
+func (m *Manager) releaseBot(botID uuid.UUID) {
+	m.stopAsync(botID, true)
+}
+
+// stopAsync убирает бота из запущенных и останавливает его в отдельной горутине:
+// ожидание обработчиков (до SHUTDOWN_TIMEOUT) не должно задерживать распределение.
+// Если release - lease продлевается до остановки поллера, а затем освобождается.
+func (m *Manager) stopAsync(botID uuid.UUID, release bool) {
+	m.mu.Lock()
+	s, exists := m.bots[botID]
+	delete(m.bots, botID)
+	delete(m.failed, botID)
+	if exists {
+		m.stopping[botID] = release
+	}
+	m.mu.Unlock()
+
+	if !exists {
+		return
+	}
+
+	m.stops.Add(1)
+	go func() {
+		defer m.stops.Done()
+
+		stopCtx, cancel := context.WithTimeout(context.Background(), m.cfg.Shutdown.Timeout)
+		s.stop(stopCtx)
+		cancel()
+
+		if release {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := utils.ReleaseLease(ctx, m.redis, leaseKeyPrefix+botID.String(), m.replicaID); err != nil {
+				logger.WarnContext(s.logCtx, "⚠️ Не удалось освободить бота", "error", err)
+			}
+			cancel()
+		}
+
+		m.mu.Lock()
+		delete(m.stopping, botID)
+		m.mu.Unlock()
+	}()
+}
+
+// isStopping проверяет, что бот еще останавливается в фоне
+func (m *Manager) isStopping(botID uuid.UUID) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.stopping[botID]
+	return ok
+}
+
+// renewLeases продлевает владение запущенными ботами.
+// Бот останавливается, если lease занят другой репликой или его не удавалось
+// продлить дольше leaseTTL (значит, другая реплика уже могла его забрать).
+func (m *Manager) renewLeases(ctx context.Context) {
+	for _, botID := range m.runningBotIDs() {
+		s, ok := m.supervised(botID)
+		if !ok {
+			continue
+		}
+
+		renewed, err := utils.RenewLease(ctx, m.redis, leaseKeyPrefix+botID.String(), m.replicaID, leaseTTL)
+		switch {
+		case err != nil && time.Since(s.leaseRenewedAt) > leaseTTL:
+			logger.WarnContext(s.logCtx, "⚠️ Владение ботом не подтверждено, останавливаем", "error", err)
+			m.stopAsync(botID, false)
+		case err != nil:
+			logger.WarnContext(s.logCtx, "⚠️ Не удалось продлить владение ботом", "error", err)
+		case !renewed:
+			logger.WarnContext(s.logCtx, "⚠️ Бот занят другой репликой, останавливаем")
+			m.stopAsync(botID, false)
+		default:
+			s.leaseRenewedAt = time.Now()
+		}
+	}
+
+	// Боты, которые мы отдаем: поллер еще работает, lease нужен до его остановки
+	for _, botID := range m.releasingBotIDs() {
+		if _, err := utils.RenewLease(ctx, m.redis, leaseKeyPrefix+botID.String(), m.replicaID, leaseTTL); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось продлить владение останавливаемым ботом", "bot_id", botID, "error", err)
+		}
+	}
+}
+
+// leaveCluster освобождает все leases реплики и убирает ее из списка живых
+func (m *Manager) leaveCluster(botIDs []uuid.UUID) {
+	if m.redis == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, botID := range botIDs {
+		utils.ReleaseLease(ctx, m.redis, leaseKeyPrefix+botID.String(), m.replicaID)
+	}
+	m.redis.ZRem(ctx, replicasKey, m.replicaID)
+}
+
+func (m *Manager) runningBotIDs() []uuid.UUID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]uuid.UUID, 0, len(m.bots))
+	for id := range m.bots {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// releasingBotIDs возвращает ботов, которые останавливаются в фоне, но еще держат lease
+func (m *Manager) releasingBotIDs() []uuid.UUID {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ids []uuid.UUID
+	for id, release := range m.stopping {
+		if release {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// pickOwner выбирает реплику-владельца бота (rendezvous hashing):
+// при изменении состава реплик переезжает только часть ботов
+func pickOwner(replicas []string, botID uuid.UUID) string {
+	var (
+		owner string
+		best  uint64
+	)
+	for _, replica := range replicas {
+		h := fnv.New64a()
+		h.Write([]byte(replica))
+		h.Write(botID[:])
+		if score := h.Sum64(); owner == "" || score > best {
+			owner, best = replica, score
+		}
+	}
+	return owner
+}
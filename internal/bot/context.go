@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"context"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	tele "gopkg.in/telebot.v3"
+)
+
+var logger = utils.Module("bot")
+
+// requestContextKey - ключ контекста запроса в tele.Context
+const requestContextKey = "request_context"
+
+// withRequestContext - middleware: контекст обработки апдейта с полями для логов
+// и спаном трейса на весь апдейт
+func (b *BotInstance) withRequestContext(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		attrs := []attribute.KeyValue{
+			attribute.String("telegram.bot_id", b.BotID.String()),
+			attribute.String("telegram.profile_id", b.ProfileID.String()),
+			attribute.Int("telegram.update_id", c.Update().ID),
+		}
+
+		// Контекст обработчика наследует контекст бота: при остановке бота
+		// по истечении SHUTDOWN_TIMEOUT обработка прерывается
+		ctx := utils.WithLogAttrs(botLogContext(b.ctx, b.BotID.String(), b.ProfileID.String()), "update_id", c.Update().ID)
+		if chat := c.Chat(); chat != nil {
+			ctx = utils.WithLogAttrs(ctx, "chat_id", chat.ID)
+			attrs = append(attrs, attribute.Int64("telegram.chat_id", chat.ID))
+		}
+
+		ctx, span := tracing.Start(ctx, "telegram.update", attrs...)
+		if traceID := tracing.TraceID(ctx); traceID != "" {
+			ctx = utils.WithLogAttrs(ctx, "trace_id", traceID)
+		}
+
+		c.Set(requestContextKey, ctx)
+		err := next(c)
+		tracing.End(span, err)
+		return err
+	}
+}
+
+// logContext - контекст с полями бота для логов вне обработки апдейта
+func (b *BotInstance) logContext() context.Context {
+	return botLogContext(context.Background(), b.BotID.String(), b.ProfileID.String())
+}
+
+func botLogContext(ctx context.Context, botID, profileID string) context.Context {
+	return utils.WithLogAttrs(ctx, "bot_id", botID, "profile_id", profileID)
+}
+
+// configLogContext - поля бота для логов по его конфигурации
+func configLogContext(ctx context.Context, config storage.TelegramBot) context.Context {
+	return botLogContext(ctx, uuid.UUID(config.ID.Bytes).String(), uuid.UUID(config.ProfileID.Bytes).String())
+}
+
+// requestContext возвращает контекст обработки апдейта
+func requestContext(c tele.Context) context.Context {
+	if ctx, ok := c.Get(requestContextKey).(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// telegramCustomer - пользователь Telegram, для которого нужен клиент CRM
+type telegramCustomer struct {
+	UserID    int64
+	Username  string
+	FirstName string
+	LastName  string
+	Phone     string
+	Email     string
+}
+
+// customerFromExecution берет данные пользователя из переменных workflow
+// (триггер заполняет username/first_name/last_name, вопросы - phone/email)
+func customerFromExecution(exec *workflow.Execution) telegramCustomer {
+	str := func(name string) string {
+		value, _ := exec.Lookup(name)
+		s, _ := value.(string)
+		return strings.TrimSpace(s)
+	}
+	return telegramCustomer{
+		UserID:    exec.UserID,
+		Username:  str("username"),
+		FirstName: str("first_name"),
+		LastName:  str("last_name"),
+		Phone:     str("phone"),
+		Email:     str("email"),
+	}
+}
+
+// customerFromUser - данные пользователя из апдейта (вне workflow)
+func customerFromUser(user *tele.User) telegramCustomer {
+	return telegramCustomer{
+		UserID:    user.ID,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+}
+
+// linkedCustomer возвращает клиента CRM, связанного с пользователем через
+// telegram_customer_links. Если связи нет - создает клиента (source = telegram) и связь
+func (h *MessageHandler) linkedCustomer(ctx context.Context, user telegramCustomer) (pgtype.UUID, error) {
+	link, err := h.queries.GetTelegramCustomerLink(ctx, storage.GetTelegramCustomerLinkParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: user.UserID,
+	})
+	if err == nil {
+		return link.CustomerID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return pgtype.UUID{}, fmt.Errorf("failed to load customer link: %w", err)
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" && user.Username != "" {
+		name = "@" + user.Username
+	}
+	if name == "" {
+		name = fmt.Sprintf("Telegram %d", user.UserID)
+	}
+
+	customerID, err := h.queries.CreateCustomer(ctx, storage.CreateCustomerParams{
+		ProfileID: h.botConfig.ProfileID,
+		Name:      name,
+		Phone:     optionalText(user.Phone),
+		Email:     optionalText(user.Email),
+	})
+	if err != nil {
+		return pgtype.UUID{}, fmt.Errorf("failed to create customer: %w", err)
+	}
+
+	if err := h.queries.CreateTelegramCustomerLink(ctx, storage.CreateTelegramCustomerLinkParams{
+		ProfileID:        h.botConfig.ProfileID,
+		CustomerID:       customerID,
+		TelegramUserID:   user.UserID,
+		TelegramUsername: optionalText(user.Username),
+		FirstName:        optionalText(user.FirstName),
+		LastName:         optionalText(user.LastName),
+	}); err != nil {
+		return pgtype.UUID{}, fmt.Errorf("failed to link customer: %w", err)
+	}
+
+	logger.InfoContext(ctx, "👤 Создан клиент CRM", "customer_id", uuidString(customerID))
+	return customerID, nil
+}
+
+// optionalText - пустая строка превращается в NULL
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
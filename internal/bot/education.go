@@ -0,0 +1,669 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Обучение: баланс занятий ученика, ближайшие занятия его групп, запись и отмена
+// с проверкой баланса и покупка абонемента (education_packages) через заказ на
+// оплату. Ученик - клиент CRM, связанный с пользователем (telegram_customer_links);
+// родитель пользуется аккаунтом, привязанным к карточке ребенка.
+// Включается education_enabled в настройках бота.
+
+// Callback кнопок обучения (лимит data - 64 байта)
+const (
+	callbackEducationPrefix   = "edu:"
+	callbackEducationLessons  = "edu:l"
+	callbackEducationLesson   = "edu:s:" // edu:s:<lesson_id>
+	callbackEducationEnroll   = "edu:e:" // edu:e:<lesson_id>
+	callbackEducationCancel   = "edu:x:" // edu:x:<lesson_id>
+	callbackEducationPackages = "edu:p"
+	callbackEducationBuy      = "edu:b:" // edu:b:<package_id>
+	callbackEducationBalance  = "edu:bal"
+)
+
+const (
+	// entityPackagePurchase - payment_orders.entity_type покупки абонемента
+	entityPackagePurchase = "education_package_purchase"
+	// educationLessonsLimit - сколько ближайших занятий показывать
+	educationLessonsLimit = 10
+
+	// Типы операций по балансу (education_balance_transactions.transaction_type)
+	balanceTxPackage = "package_purchase"
+	balanceTxRefund  = "refund"
+)
+
+// educationCommands - команды обучения в меню
+var educationCommands = []menuCommand{
+	{Command: "lessons", Description: "Расписание занятий", Descriptions: map[string]string{"en": "Lesson schedule"}},
+	{Command: "balance", Description: "Баланс занятий", Descriptions: map[string]string{"en": "Lesson balance"}},
+	{Command: "packages", Description: "Купить абонемент", Descriptions: map[string]string{"en": "Buy a package"}},
+}
+
+// errNoLessonBalance - на балансе ученика нет свободного занятия для записи на платное
+var errNoLessonBalance = errors.New("no free lessons on balance")
+
+// notStudentText - ответ пользователю, не связанному с карточкой ученика
+const notStudentText = "Мы не нашли вашу карточку ученика. Попросите администратора привязать ваш Telegram."
+
+// balanceChange - изменение баланса ученика с записью в education_balance_transactions
+type balanceChange struct {
+	Amount       int32
+	Type         string
+	LessonID     pgtype.UUID
+	PackageID    pgtype.UUID
+	EnrollmentID pgtype.UUID
+	Description  string
+}
+
+// featureEducation - раздел обучения в payment_methods
+const featureEducation = "education"
+
+// educationEnabled - обучение включено в настройках (для featureOnly)
+func educationEnabled(s BotSettings) bool { return s.EducationEnabled }
+
+// HandleLessons - /lessons: ближайшие занятия ученика
+func (h *MessageHandler) HandleLessons(ctx context.Context, c tele.Context) error {
+	return h.showLessons(ctx, c)
+}
+
+// HandleBalance - /balance: баланс занятий и действующие абонементы
+func (h *MessageHandler) HandleBalance(ctx context.Context, c tele.Context) error {
+	return h.showBalance(ctx, c)
+}
+
+// HandlePackages - /packages: абонементы, которые можно купить
+func (h *MessageHandler) HandlePackages(ctx context.Context, c tele.Context) error {
+	return h.showPackages(ctx, c)
+}
+
+// handleEducationCallback обрабатывает кнопки обучения
+func (h *MessageHandler) handleEducationCallback(ctx context.Context, c tele.Context, data string) error {
+	if !h.settings.EducationEnabled {
+		return c.Respond(&tele.CallbackResponse{Text: "Запись на занятия недоступна"})
+	}
+
+	var err error
+	switch {
+	case data == callbackEducationLessons:
+		err = h.showLessons(ctx, c)
+	case data == callbackEducationBalance:
+		err = h.showBalance(ctx, c)
+	case data == callbackEducationPackages:
+		err = h.showPackages(ctx, c)
+	case strings.HasPrefix(data, callbackEducationLesson):
+		id, parseErr := parseUUID(strings.TrimPrefix(data, callbackEducationLesson))
+		if parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Занятие не найдено"})
+		}
+		err = h.showLesson(ctx, c, id, "")
+	case strings.HasPrefix(data, callbackEducationEnroll):
+		id, parseErr := parseUUID(strings.TrimPrefix(data, callbackEducationEnroll))
+		if parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Занятие не найдено"})
+		}
+		return h.enrollLesson(ctx, c, id)
+	case strings.HasPrefix(data, callbackEducationCancel):
+		id, parseErr := parseUUID(strings.TrimPrefix(data, callbackEducationCancel))
+		if parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Занятие не найдено"})
+		}
+		return h.cancelLesson(ctx, c, id)
+	case strings.HasPrefix(data, callbackEducationBuy):
+		id, parseErr := parseUUID(strings.TrimPrefix(data, callbackEducationBuy))
+		if parseErr != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Абонемент не найден"})
+		}
+		if err := h.buyPackage(ctx, c, id); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось оформить абонемент", "error", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Не удалось оформить заказ, попробуйте позже", ShowAlert: true})
+		}
+		return c.Respond()
+	}
+	if err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// studentID - клиент CRM, связанный с пользователем; нет связи - pgx.ErrNoRows
+func (h *MessageHandler) studentID(ctx context.Context, c tele.Context) (pgtype.UUID, error) {
+	link, err := h.queries.GetTelegramCustomerLink(ctx, storage.GetTelegramCustomerLinkParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: c.Sender().ID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pgtype.UUID{}, err
+	}
+	if err != nil {
+		return pgtype.UUID{}, fmt.Errorf("failed to load customer link: %w", err)
+	}
+	return link.CustomerID, nil
+}
+
+// showBalance показывает баланс занятий, резерв под записи и действующие абонементы
+func (h *MessageHandler) showBalance(ctx context.Context, c tele.Context) error {
+	studentID, err := h.studentID(ctx, c)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h.showCatalogScreen(c, notStudentText, educationMenu())
+	}
+	if err != nil {
+		return err
+	}
+
+	balance, err := h.queries.GetStudentBalance(ctx, storage.GetStudentBalanceParams{
+		ProfileID: h.botConfig.ProfileID,
+		StudentID: studentID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load balance: %w", err)
+	}
+	packages, err := h.queries.ListStudentPackages(ctx, storage.ListStudentPackagesParams{
+		ProfileID: h.botConfig.ProfileID,
+		StudentID: studentID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load packages: %w", err)
+	}
+
+	lines := []string{fmt.Sprintf("💰 На балансе занятий: %d", balance.Balance)}
+	if balance.Reserved > 0 {
+		lines = append(lines,
+			fmt.Sprintf("Зарезервировано записями: %d", balance.Reserved),
+			fmt.Sprintf("Доступно для записи: %d", max(balance.Balance-balance.Reserved, 0)))
+	}
+	if len(packages) > 0 {
+		lines = append(lines, "", "Абонементы:")
+		for _, p := range packages {
+			line := fmt.Sprintf("• %s - использовано %d из %d", p.Name, p.LessonsUsed, p.LessonsGranted)
+			if p.ExpiresAt.Valid {
+				line += ", до " + p.ExpiresAt.Time.Local().Format(dateLayout)
+			}
+			lines = append(lines, line)
+		}
+	}
+	return h.showCatalogScreen(c, strings.Join(lines, "\n"), educationMenu())
+}
+
+// showLessons показывает ближайшие занятия ученика; ✅ - ученик записан
+func (h *MessageHandler) showLessons(ctx context.Context, c tele.Context) error {
+	studentID, err := h.studentID(ctx, c)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h.showCatalogScreen(c, notStudentText, &tele.ReplyMarkup{})
+	}
+	if err != nil {
+		return err
+	}
+
+	lessons, err := h.queries.ListStudentLessons(ctx, storage.ListStudentLessonsParams{
+		StudentID:  studentID,
+		ProfileID:  h.botConfig.ProfileID,
+		Until:      pgtype.Timestamptz{Time: time.Now().AddDate(0, 0, h.settings.EducationLessonsDays), Valid: true},
+		LimitCount: educationLessonsLimit,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load lessons: %w", err)
+	}
+	if len(lessons) == 0 {
+		return h.showCatalogScreen(c, "📚 Ближайших занятий пока нет.", educationMenu())
+	}
+
+	markup := &tele.ReplyMarkup{}
+	for _, lesson := range lessons {
+		text := formatEventTime(lesson.StartsAt) + " - " + lessonTitle(lesson.SubjectName)
+		if lesson.GroupName.String != "" {
+			text += " (" + lesson.GroupName.String + ")"
+		}
+		if lesson.EnrollmentID.Valid && !enrollmentCancelled(lesson.EnrollmentStatus) {
+			text = "✅ " + text
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: text,
+			Data: callbackEducationLesson + uuidString(lesson.ID),
+		}})
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, educationMenu().InlineKeyboard...)
+	return h.showCatalogScreen(c, "📚 Ближайшие занятия", markup)
+}
+
+// showLesson показывает карточку занятия с кнопкой записи или отмены
+func (h *MessageHandler) showLesson(ctx context.Context, c tele.Context, lessonID pgtype.UUID, notice string) error {
+	studentID, err := h.studentID(ctx, c)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h.showCatalogScreen(c, notStudentText, &tele.ReplyMarkup{})
+	}
+	if err != nil {
+		return err
+	}
+	lesson, err := h.studentLesson(ctx, studentID, lessonID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h.showCatalogScreen(c, "Занятие больше недоступно.", educationMenu())
+	}
+	if err != nil {
+		return err
+	}
+
+	enrolled := lesson.EnrollmentID.Valid && !enrollmentCancelled(lesson.EnrollmentStatus)
+	lines := []string{"<b>" + html.EscapeString(lessonTitle(lesson.SubjectName)) + "</b>"}
+	if lesson.GroupName.String != "" {
+		lines = append(lines, "👥 "+html.EscapeString(lesson.GroupName.String))
+	}
+	when := "📅 " + formatEventTime(lesson.StartsAt)
+	if lesson.EndTime.Valid {
+		end := time.Duration(lesson.EndTime.Microseconds) * time.Microsecond
+		when += fmt.Sprintf(" - %02d:%02d", int(end.Hours()), int(end.Minutes())%60)
+	}
+	lines = append(lines, when)
+	if lesson.MaxStudents.Valid && !enrolled {
+		lines = append(lines, fmt.Sprintf("Свободных мест: %d", max(lesson.MaxStudents.Int32-lesson.Enrolled, 0)))
+	}
+	if enrolled {
+		lines = append(lines, "", "✅ Вы записаны")
+	}
+	if notice != "" {
+		lines = append(lines, "", notice)
+	}
+
+	markup := &tele.ReplyMarkup{}
+	id := uuidString(lessonID)
+	switch {
+	case enrolled && h.canCancelLesson(lesson.StartsAt):
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "❌ Отменить запись", Data: callbackEducationCancel + id}})
+	case !enrolled:
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "📝 Записаться", Data: callbackEducationEnroll + id}})
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "⬅️ К расписанию", Data: callbackEducationLessons}})
+
+	if cb := c.Callback(); cb != nil && cb.Message != nil {
+		err := c.Edit(strings.Join(lines, "\n"), markup, tele.ModeHTML)
+		if err == nil || errors.Is(err, tele.ErrSameMessageContent) || errors.Is(err, tele.ErrMessageNotModified) {
+			return nil
+		}
+	}
+	return c.Send(strings.Join(lines, "\n"), markup, tele.ModeHTML)
+}
+
+// studentLesson загружает предстоящее занятие, доступное ученику; иначе pgx.ErrNoRows
+func (h *MessageHandler) studentLesson(ctx context.Context, studentID, lessonID pgtype.UUID) (storage.GetStudentLessonRow, error) {
+	lesson, err := h.queries.GetStudentLesson(ctx, storage.GetStudentLessonParams{
+		StudentID: studentID,
+		ID:        lessonID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return lesson, fmt.Errorf("failed to load lesson: %w", err)
+	}
+	return lesson, err
+}
+
+// enrollLesson записывает ученика на занятие. На платное занятие нужно свободное
+// занятие на балансе: баланс минус уже зарезервированные записями
+func (h *MessageHandler) enrollLesson(ctx context.Context, c tele.Context, lessonID pgtype.UUID) error {
+	studentID, err := h.studentID(ctx, c)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: notStudentText, ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	lesson, err := h.studentLesson(ctx, studentID, lessonID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Занятие больше недоступно", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	if lesson.EnrollmentID.Valid && !enrollmentCancelled(lesson.EnrollmentStatus) {
+		return c.Respond(&tele.CallbackResponse{Text: "Вы уже записаны"})
+	}
+
+	// Баланс ученика и места на занятии проверяются под блокировками в той же
+	// транзакции, что и запись: параллельные записи не превысят ни то, ни другое
+	enrollmentID := lesson.EnrollmentID
+	err = pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		if err := q.LockStudentBalance(ctx, studentID); err != nil {
+			return fmt.Errorf("failed to lock balance: %w", err)
+		}
+		if err := q.LockLesson(ctx, lessonID); err != nil {
+			return fmt.Errorf("failed to lock lesson: %w", err)
+		}
+
+		if lesson.PaymentRequired {
+			balance, err := q.GetStudentBalance(ctx, storage.GetStudentBalanceParams{
+				ProfileID: h.botConfig.ProfileID,
+				StudentID: studentID,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to load balance: %w", err)
+			}
+			if balance.Balance-balance.Reserved < 1 {
+				return errNoLessonBalance
+			}
+		}
+
+		if !enrollmentID.Valid {
+			var err error
+			enrollmentID, err = q.CreateLessonEnrollment(ctx, storage.CreateLessonEnrollmentParams{
+				ProfileID: h.botConfig.ProfileID,
+				StudentID: studentID,
+				LessonID:  lessonID,
+			})
+			return err
+		}
+		restored, err := q.RestoreLessonEnrollment(ctx, storage.RestoreLessonEnrollmentParams{
+			ID:        enrollmentID,
+			ProfileID: h.botConfig.ProfileID,
+			StudentID: studentID,
+		})
+		if err == nil && restored == 0 {
+			err = pgx.ErrNoRows
+		}
+		return err
+	})
+	if errors.Is(err, errNoLessonBalance) {
+		if err := h.showPackages(ctx, c); err != nil {
+			return err
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "На балансе нет свободных занятий. Купите абонемент, чтобы записаться.", ShowAlert: true})
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Свободных мест нет", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enroll: %w", err)
+	}
+	logger.InfoContext(ctx, "📚 Запись на занятие", "lesson_id", uuidString(lessonID), "enrollment_id", uuidString(enrollmentID))
+
+	if h.settings.RemindersEnabled {
+		h.scheduleReminder(ctx, reminderLessonEnrollment, enrollmentID, lesson.StartsAt.Time, h.settings.reminderBefore())
+	}
+	if err := h.showLesson(ctx, c, lessonID, ""); err != nil {
+		return err
+	}
+	return c.Respond(&tele.CallbackResponse{Text: "Вы записаны"})
+}
+
+// cancelLesson отменяет запись ученика, если до занятия осталось не меньше
+// education_cancel_hours
+func (h *MessageHandler) cancelLesson(ctx context.Context, c tele.Context, lessonID pgtype.UUID) error {
+	studentID, err := h.studentID(ctx, c)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: notStudentText, ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	lesson, err := h.studentLesson(ctx, studentID, lessonID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Занятие больше недоступно", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	if !lesson.EnrollmentID.Valid || enrollmentCancelled(lesson.EnrollmentStatus) {
+		return c.Respond(&tele.CallbackResponse{Text: "Вы не записаны на это занятие"})
+	}
+	if !h.canCancelLesson(lesson.StartsAt) {
+		return c.Respond(&tele.CallbackResponse{
+			Text:      fmt.Sprintf("Отменить запись можно не позднее чем за %d ч. до начала", h.settings.EducationCancelHours),
+			ShowAlert: true,
+		})
+	}
+
+	err = h.cancelEnrollment(ctx, lesson.EnrollmentID, studentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Запись уже изменена", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "📚 Запись на занятие отменена", "lesson_id", uuidString(lessonID), "enrollment_id", uuidString(lesson.EnrollmentID))
+
+	if err := h.showLesson(ctx, c, lessonID, "Запись отменена."); err != nil {
+		return err
+	}
+	return c.Respond(&tele.CallbackResponse{Text: "Запись отменена"})
+}
+
+// cancelEnrollment отменяет запись ученика на занятие; списанное занятие
+// возвращается на баланс. Уже закрытая запись - pgx.ErrNoRows
+func (h *MessageHandler) cancelEnrollment(ctx context.Context, id, studentID pgtype.UUID) error {
+	return pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		enrollment, err := q.CancelStudentEnrollment(ctx, storage.CancelStudentEnrollmentParams{
+			ID:        id,
+			ProfileID: h.botConfig.ProfileID,
+			StudentID: studentID,
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("failed to cancel enrollment: %w", err)
+		}
+		if !enrollment.WasCharged {
+			return nil
+		}
+		return h.changeBalance(ctx, q, studentID, balanceChange{
+			Amount:       1,
+			Type:         balanceTxRefund,
+			LessonID:     enrollment.LessonID,
+			EnrollmentID: enrollment.ID,
+			Description:  "Отмена записи на занятие в Telegram",
+		})
+	})
+}
+
+// canCancelLesson - можно ли еще отменить запись на занятие
+func (h *MessageHandler) canCancelLesson(startsAt pgtype.Timestamptz) bool {
+	deadline := startsAt.Time.Add(-time.Duration(h.settings.EducationCancelHours) * time.Hour)
+	return time.Now().Before(deadline)
+}
+
+// changeBalance меняет баланс ученика и записывает операцию. Вызывается в транзакции
+func (h *MessageHandler) changeBalance(ctx context.Context, q *storage.Queries, studentID pgtype.UUID, change balanceChange) error {
+	after, err := q.AddEducationBalance(ctx, storage.AddEducationBalanceParams{
+		Amount:    change.Amount,
+		ProfileID: h.botConfig.ProfileID,
+		StudentID: studentID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		after, err = q.CreateEducationBalanceAccount(ctx, storage.CreateEducationBalanceAccountParams{
+			ProfileID: h.botConfig.ProfileID,
+			StudentID: studentID,
+			Balance:   pgtype.Int4{Int32: change.Amount, Valid: true},
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update balance: %w", err)
+	}
+
+	if err := q.CreateEducationBalanceTransaction(ctx, storage.CreateEducationBalanceTransactionParams{
+		ProfileID:           h.botConfig.ProfileID,
+		StudentID:           studentID,
+		TransactionType:     change.Type,
+		Amount:              change.Amount,
+		BalanceBefore:       after - change.Amount,
+		BalanceAfter:        after,
+		RelatedLessonID:     change.LessonID,
+		RelatedPackageID:    change.PackageID,
+		RelatedEnrollmentID: change.EnrollmentID,
+		Description:         optionalText(change.Description),
+	}); err != nil {
+		return fmt.Errorf("failed to record balance transaction: %w", err)
+	}
+	return nil
+}
+
+// showPackages показывает абонементы, которые можно купить
+func (h *MessageHandler) showPackages(ctx context.Context, c tele.Context) error {
+	packages, err := h.queries.ListEducationPackages(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to load packages: %w", err)
+	}
+	if len(packages) == 0 {
+		return h.showCatalogScreen(c, "Абонементов для покупки пока нет.", educationMenu())
+	}
+
+	lines := []string{"🛒 Абонементы", ""}
+	markup := &tele.ReplyMarkup{}
+	for _, p := range packages {
+		price, _ := numericToFloat(p.Price)
+		line := fmt.Sprintf("• %s - %d занятий, %s", p.Name, p.LessonsCount, h.formatPrice(price, ""))
+		if p.ValidityDays.Valid && p.ValidityDays.Int32 > 0 {
+			line += fmt.Sprintf(", действует %d дн.", p.ValidityDays.Int32)
+		}
+		lines = append(lines, line)
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: fmt.Sprintf("%s - %s", p.Name, h.formatPrice(price, "")),
+			Data: callbackEducationBuy + uuidString(p.ID),
+		}})
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "💰 Баланс", Data: callbackEducationBalance}})
+	return h.showCatalogScreen(c, strings.Join(lines, "\n"), markup)
+}
+
+// buyPackage создает покупку абонемента и заказ на оплату. Занятия начисляются
+// на баланс после оплаты (см. activatePackage)
+func (h *MessageHandler) buyPackage(ctx context.Context, c tele.Context, packageID pgtype.UUID) error {
+	pkg, err := h.queries.GetEducationPackage(ctx, storage.GetEducationPackageParams{
+		ID:        packageID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Этот абонемент больше не продается", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load package: %w", err)
+	}
+
+	payer := customerFromUser(c.Sender())
+	h.conversationValue(ctx, c.Chat().ID, "phone", &payer.Phone)
+	h.conversationValue(ctx, c.Chat().ID, "email", &payer.Email)
+	studentID, err := h.linkedCustomer(ctx, payer)
+	if err != nil {
+		return err
+	}
+
+	price, _ := numericToFloat(pkg.Price)
+	purchaseID, err := h.queries.CreatePackagePurchase(ctx, storage.CreatePackagePurchaseParams{
+		ProfileID:      h.botConfig.ProfileID,
+		StudentID:      studentID,
+		PackageID:      packageID,
+		LessonsGranted: pkg.LessonsCount,
+		PricePaid:      numericFromFloat(price),
+		Notes:          optionalText("Telegram"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create package purchase: %w", err)
+	}
+	ctx = utils.WithLogAttrs(ctx, "package_purchase_id", uuidString(purchaseID))
+	logger.InfoContext(ctx, "🛒 Покупка абонемента создана", "package", pkg.Name)
+
+	if minorUnits(price, h.settings.PaymentsCurrency) == 0 {
+		return h.activatePackage(ctx, purchaseID, c.Chat().ID)
+	}
+
+	order, paymentData, err := h.createPaymentOrder(ctx, payer, c.Chat().ID, paymentRequest{
+		Amount:      price,
+		Description: "Абонемент: " + pkg.Name,
+		EntityType:  entityPackagePurchase,
+		EntityID:    purchaseID,
+		Items:       []paymentItem{{Name: pkg.Name, Quantity: 1, Price: price}},
+		Method:      h.settings.paymentMethod(featureEducation),
+	})
+	if err != nil {
+		return err
+	}
+	return h.offerPayment(ctx, c, order, paymentData, "")
+}
+
+// activatePackage активирует оплаченный абонемент, начисляет занятия на баланс
+// и сообщает об этом в чат. Повторный вызов для той же покупки ничего не делает
+func (h *MessageHandler) activatePackage(ctx context.Context, purchaseID pgtype.UUID, chatID int64) error {
+	purchase, err := h.queries.GetPackagePurchase(ctx, purchaseID)
+	if err != nil {
+		return fmt.Errorf("failed to load package purchase: %w", err)
+	}
+	if purchase.ProfileID != h.botConfig.ProfileID {
+		return fmt.Errorf("package purchase %s belongs to another profile", uuidString(purchaseID))
+	}
+
+	activated := false
+	err = pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		updated, err := q.ActivatePackagePurchase(ctx, storage.ActivatePackagePurchaseParams{
+			ValidityDays: purchase.ValidityDays,
+			ID:           purchase.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to activate package purchase: %w", err)
+		}
+		if updated == 0 {
+			return nil
+		}
+		activated = true
+		return h.changeBalance(ctx, q, purchase.StudentID, balanceChange{
+			Amount:      purchase.LessonsGranted,
+			Type:        balanceTxPackage,
+			PackageID:   purchase.PackageID,
+			Description: "Абонемент «" + purchase.PackageName + "» (Telegram)",
+		})
+	})
+	if err != nil || !activated {
+		return err
+	}
+	logger.InfoContext(ctx, "🛒 Абонемент активирован", "package_purchase_id", uuidString(purchase.ID), "lessons", purchase.LessonsGranted)
+
+	if chatID == 0 {
+		return nil
+	}
+	text := fmt.Sprintf("✅ Абонемент «%s» активирован: на баланс начислено занятий - %d.\n\nЗаписаться на занятие: /lessons",
+		purchase.PackageName, purchase.LessonsGranted)
+	if _, err := h.engine.Bot().Send(tele.ChatID(chatID), text); err != nil {
+		return fmt.Errorf("failed to send package confirmation: %w", err)
+	}
+	h.logBotMessage(ctx, chatID, chatID, text, map[string]interface{}{"package_purchase_id": uuidString(purchase.ID)})
+	return nil
+}
+
+// validatePackageCheckout перед списанием денег проверяет, что покупка абонемента
+// еще ждет оплаты и абонемент продается
+func (h *MessageHandler) validatePackageCheckout(ctx context.Context, purchaseID pgtype.UUID) error {
+	purchase, err := h.queries.GetPackagePurchase(ctx, purchaseID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load package purchase: %w", err)
+	}
+	if purchase.ProfileID != h.botConfig.ProfileID || purchase.PaymentStatus != paymentStatusPending || !purchase.PackageActive {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	return nil
+}
+
+// educationMenu - кнопки разделов обучения
+func educationMenu() *tele.ReplyMarkup {
+	return &tele.ReplyMarkup{InlineKeyboard: [][]tele.InlineButton{{
+		{Text: "📚 Расписание", Data: callbackEducationLessons},
+		{Text: "💰 Баланс", Data: callbackEducationBalance},
+		{Text: "🛒 Абонементы", Data: callbackEducationPackages},
+	}}}
+}
+
+func enrollmentCancelled(status string) bool {
+	return status == enrollmentStatusCancelled
+}
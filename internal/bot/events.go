@@ -0,0 +1,447 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/skip2/go-qrcode"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Мероприятия: список ближайших опубликованных мероприятий (events), бесплатная
+// регистрация (event_registrations) и покупка билетов на сеансы (см. tickets.go).
+// Включается events_enabled в настройках бота.
+
+// Callback кнопок мероприятий (лимит data - 64 байта)
+const (
+	callbackEventsPrefix  = "ev:"
+	callbackEventsList    = "ev:l:" // ev:l:<page>
+	callbackEventShow     = "ev:e:" // ev:e:<event_id>
+	callbackEventRegister = "ev:r:" // ev:r:<event_id>
+	callbackEventSession  = "ev:s:" // ev:s:<session_id>
+	callbackEventType     = "ev:t:" // ev:t:<ticket_type_id>
+	callbackEventQuantity = "ev:q:" // ev:q:<quantity>
+	callbackEventNoop     = "ev:noop"
+)
+
+const (
+	// eventsPageSize - сколько мероприятий на странице списка
+	eventsPageSize = 6
+	// eventTimeLayout - дата и время мероприятия в сообщениях
+	eventTimeLayout = "02.01.2006 15:04"
+	// qrCodeSize - размер картинки QR-кода в пикселях
+	qrCodeSize = 512
+	// Статус опубликованного мероприятия и отмененной регистрации
+	eventStatusPublished     = "published"
+	registrationStatusCancel = "cancelled"
+)
+
+// eventsCommands - команды мероприятий в меню
+var eventsCommands = []menuCommand{
+	{Command: "events", Description: "Мероприятия", Descriptions: map[string]string{"en": "Events"}},
+}
+
+// botOrigin - откуда пришла запись (custom_fields регистрации и заказа билетов):
+// по chat_id бот находит чат для билетов и напоминаний
+type botOrigin struct {
+	Source         string `json:"source"`
+	BotID          string `json:"bot_id"`
+	ChatID         int64  `json:"chat_id"`
+	TelegramUserID int64  `json:"telegram_user_id"`
+}
+
+func (h *MessageHandler) botOrigin(c tele.Context) botOrigin {
+	return botOrigin{
+		Source:         "telegram",
+		BotID:          uuidString(h.botConfig.ID),
+		ChatID:         c.Chat().ID,
+		TelegramUserID: c.Sender().ID,
+	}
+}
+
+// featureEvents - раздел мероприятий в payment_methods
+const featureEvents = "events"
+
+// eventsEnabled - мероприятия включены в настройках (для featureOnly)
+func eventsEnabled(s BotSettings) bool { return s.EventsEnabled }
+
+// HandleEvents - /events: ближайшие мероприятия
+func (h *MessageHandler) HandleEvents(ctx context.Context, c tele.Context) error {
+	return h.showEvents(ctx, c, 0)
+}
+
+// handleEventsCallback обрабатывает кнопки мероприятий и покупки билетов
+func (h *MessageHandler) handleEventsCallback(ctx context.Context, c tele.Context, data string) error {
+	if !h.settings.EventsEnabled {
+		return c.Respond(&tele.CallbackResponse{Text: "Мероприятия недоступны"})
+	}
+
+	switch {
+	case data == callbackEventNoop:
+		return c.Respond()
+	case strings.HasPrefix(data, callbackEventsList):
+		page, _ := strconv.Atoi(strings.TrimPrefix(data, callbackEventsList))
+		if err := h.showEvents(ctx, c, page); err != nil {
+			return err
+		}
+		return c.Respond()
+	case strings.HasPrefix(data, callbackEventShow):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackEventShow))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Мероприятие не найдено"})
+		}
+		if err := h.showEvent(ctx, c, id); err != nil {
+			return err
+		}
+		return c.Respond()
+	case strings.HasPrefix(data, callbackEventRegister):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackEventRegister))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Мероприятие не найдено"})
+		}
+		return h.registerForEvent(ctx, c, id)
+	case strings.HasPrefix(data, callbackEventSession):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackEventSession))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Сеанс не найден"})
+		}
+		return h.showTicketTypes(ctx, c, id)
+	case strings.HasPrefix(data, callbackEventType):
+		id, err := parseUUID(strings.TrimPrefix(data, callbackEventType))
+		if err != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Билет не найден"})
+		}
+		return h.showTicketQuantity(ctx, c, id)
+	case strings.HasPrefix(data, callbackEventQuantity):
+		quantity, err := strconv.Atoi(strings.TrimPrefix(data, callbackEventQuantity))
+		if err != nil || quantity <= 0 {
+			return c.Respond()
+		}
+		if err := h.orderTickets(ctx, c, quantity); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось оформить билеты", "error", err)
+			return c.Respond(&tele.CallbackResponse{Text: "Не удалось оформить заказ, попробуйте позже", ShowAlert: true})
+		}
+		return nil
+	}
+	return c.Respond()
+}
+
+// showEvents показывает страницу ближайших мероприятий
+func (h *MessageHandler) showEvents(ctx context.Context, c tele.Context, page int) error {
+	total, err := h.queries.CountUpcomingEvents(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to count events: %w", err)
+	}
+	if total == 0 {
+		return h.showCatalogScreen(c, "📅 Ближайших мероприятий пока нет.", &tele.ReplyMarkup{})
+	}
+
+	pages := int(math.Ceil(float64(total) / float64(eventsPageSize)))
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	events, err := h.queries.ListUpcomingEvents(ctx, storage.ListUpcomingEventsParams{
+		ProfileID: h.botConfig.ProfileID,
+		Limit:     eventsPageSize,
+		Offset:    int32(page * eventsPageSize),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load events: %w", err)
+	}
+
+	markup := &tele.ReplyMarkup{}
+	for _, event := range events {
+		text := formatEventTime(event.StartDate) + " - " + event.Title
+		if event.City.String != "" {
+			text += " (" + event.City.String + ")"
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: text,
+			Data: callbackEventShow + uuidString(event.ID),
+		}})
+	}
+	if pages > 1 {
+		row := []tele.InlineButton{}
+		if page > 0 {
+			row = append(row, tele.InlineButton{Text: "◀️", Data: callbackEventsList + strconv.Itoa(page-1)})
+		}
+		row = append(row, tele.InlineButton{Text: fmt.Sprintf("%d / %d", page+1, pages), Data: callbackEventNoop})
+		if page < pages-1 {
+			row = append(row, tele.InlineButton{Text: "▶️", Data: callbackEventsList + strconv.Itoa(page+1)})
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+
+	return h.showCatalogScreen(c, "📅 Ближайшие мероприятия", markup)
+}
+
+// showEvent отправляет карточку мероприятия: сеансы с билетами или кнопку регистрации
+func (h *MessageHandler) showEvent(ctx context.Context, c tele.Context, eventID pgtype.UUID) error {
+	event, err := h.upcomingEvent(ctx, eventID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Мероприятие больше недоступно.")
+	}
+	if err != nil {
+		return err
+	}
+
+	sessions, err := h.queries.ListEventSessions(ctx, storage.ListEventSessionsParams{
+		EventID:   eventID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load ticket sessions: %w", err)
+	}
+
+	lines := []string{"<b>" + html.EscapeString(event.Title) + "</b>", "", "📅 " + formatEventTime(event.StartDate)}
+	if place := eventPlace(event.IsOnline.Bool, event.Address.String, event.City.String); place != "" {
+		lines = append(lines, html.EscapeString(place))
+	}
+	description := event.ShortDescription.String
+	if description == "" {
+		description = event.Description.String
+	}
+	if description != "" {
+		lines = append(lines, "", html.EscapeString(truncateRunes(description, 800)))
+	}
+
+	markup := &tele.ReplyMarkup{}
+	if len(sessions) > 0 {
+		// Билеты продаются на сеансы; выбранное мероприятие запоминаем для следующих шагов
+		h.saveTicketDraft(ctx, c, ticketDraft{EventID: uuidString(eventID)})
+		for _, session := range sessions {
+			text := "🎟 " + formatEventTime(session.StartTime)
+			if session.Title.String != "" {
+				text += " - " + session.Title.String
+			}
+			data := callbackEventSession + uuidString(session.ID)
+			if left, limited := sessionSeatsLeft(session.Capacity, session.SoldCount); limited {
+				if left <= 0 {
+					text, data = text+" (мест нет)", callbackEventNoop
+				} else {
+					text += fmt.Sprintf(" (осталось %d)", left)
+				}
+			}
+			markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: text, Data: data}})
+		}
+	} else {
+		if event.MaxParticipants.Valid {
+			left := int64(event.MaxParticipants.Int32) - event.Registered
+			lines = append(lines, "", fmt.Sprintf("👥 Свободных мест: %d", max(left, 0)))
+		}
+		if reason := registrationClosed(event); reason != "" {
+			lines = append(lines, "", reason)
+		} else {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+				Text: "📝 Зарегистрироваться",
+				Data: callbackEventRegister + uuidString(eventID),
+			}})
+		}
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "⬅️ К списку", Data: callbackEventsList + "0"}})
+
+	caption := strings.Join(lines, "\n")
+	if event.CoverImage.String != "" {
+		photo := &tele.Photo{File: tele.FromURL(event.CoverImage.String), Caption: caption}
+		err := c.Send(photo, markup, tele.ModeHTML)
+		if err == nil {
+			return nil
+		}
+		logger.WarnContext(ctx, "⚠️ Не удалось отправить обложку мероприятия", "error", err)
+	}
+	return c.Send(caption, markup, tele.ModeHTML)
+}
+
+// upcomingEvent загружает опубликованное мероприятие, которое еще не началось.
+// Иначе - pgx.ErrNoRows
+func (h *MessageHandler) upcomingEvent(ctx context.Context, eventID pgtype.UUID) (storage.GetEventRow, error) {
+	event, err := h.queries.GetEvent(ctx, storage.GetEventParams{ID: eventID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return event, err
+	}
+	if err != nil {
+		return event, fmt.Errorf("failed to load event: %w", err)
+	}
+	if event.Status != eventStatusPublished || !event.StartDate.Valid || event.StartDate.Time.Before(time.Now()) {
+		return event, pgx.ErrNoRows
+	}
+	return event, nil
+}
+
+// registrationClosed - почему регистрация на мероприятие недоступна; "" - открыта
+func registrationClosed(event storage.GetEventRow) string {
+	now := time.Now()
+	switch {
+	case event.RegistrationStart.Valid && now.Before(event.RegistrationStart.Time):
+		return "Регистрация откроется " + formatEventTime(event.RegistrationStart) + "."
+	case event.RegistrationEnd.Valid && now.After(event.RegistrationEnd.Time):
+		return "Регистрация закрыта."
+	case event.MaxParticipants.Valid && event.Registered >= int64(event.MaxParticipants.Int32):
+		return "Свободных мест нет."
+	}
+	return ""
+}
+
+// registerForEvent регистрирует пользователя на мероприятие и присылает QR-код.
+// Повторная регистрация не создается - пользователь получает свой QR-код еще раз
+func (h *MessageHandler) registerForEvent(ctx context.Context, c tele.Context, eventID pgtype.UUID) error {
+	event, err := h.upcomingEvent(ctx, eventID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Мероприятие больше недоступно", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+
+	user := customerFromUser(c.Sender())
+	h.conversationValue(ctx, c.Chat().ID, "phone", &user.Phone)
+	h.conversationValue(ctx, c.Chat().ID, "email", &user.Email)
+	customerID, err := h.linkedCustomer(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	caption := fmt.Sprintf("✅ Вы зарегистрированы на «%s»\n📅 %s", event.Title, formatEventTime(event.StartDate))
+	if place := eventPlace(event.IsOnline.Bool, event.Address.String, event.City.String); place != "" {
+		caption += "\n" + place
+	}
+	caption += "\n\nПокажите QR-код на входе."
+
+	existing, err := h.queries.GetCustomerEventRegistration(ctx, storage.GetCustomerEventRegistrationParams{
+		EventID:    eventID,
+		CustomerID: customerID,
+	})
+	switch {
+	case err == nil:
+		if err := h.sendQRCode(ctx, c.Chat().ID, c.Sender().ID, existing.QrCode.String, caption); err != nil {
+			return err
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "Вы уже зарегистрированы"})
+	case !errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("failed to load registration: %w", err)
+	}
+
+	if reason := registrationClosed(event); reason != "" {
+		return c.Respond(&tele.CallbackResponse{Text: reason, ShowAlert: true})
+	}
+
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = "@" + user.Username
+	}
+	code := uuid.NewString()
+	customFields, _ := json.Marshal(h.botOrigin(c))
+
+	// Лимит мест проверяется в самой вставке под блокировкой мероприятия:
+	// одновременные регистрации не превысят его
+	var registrationID pgtype.UUID
+	err = pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+		if err := q.LockEvent(ctx, eventID); err != nil {
+			return fmt.Errorf("failed to lock event: %w", err)
+		}
+		registrationID, err = q.CreateEventRegistration(ctx, storage.CreateEventRegistrationParams{
+			ProfileID:       h.botConfig.ProfileID,
+			EventID:         eventID,
+			CustomerID:      customerID,
+			Name:            name,
+			Email:           optionalText(user.Email),
+			Phone:           optionalText(user.Phone),
+			QrCode:          optionalText(code),
+			CustomFields:    customFields,
+			MaxParticipants: event.MaxParticipants,
+		})
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Свободных мест нет", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create registration: %w", err)
+	}
+	logger.InfoContext(ctx, "📝 Регистрация на мероприятие", "event_id", uuidString(eventID), "registration_id", uuidString(registrationID))
+
+	if err := h.sendQRCode(ctx, c.Chat().ID, c.Sender().ID, code, caption); err != nil {
+		return err
+	}
+	h.scheduleReminder(ctx, reminderEventRegistration, registrationID, event.StartDate.Time, h.settings.eventReminderBefore())
+	return c.Respond(&tele.CallbackResponse{Text: "Вы зарегистрированы"})
+}
+
+// eventRegistrationReminder - текст напоминания о мероприятии для регистрации
+func (h *MessageHandler) eventRegistrationReminder(ctx context.Context, registrationID pgtype.UUID, startsAt time.Time) (reminder, error) {
+	registration, err := h.queries.GetEventRegistration(ctx, registrationID)
+	if err != nil {
+		return reminder{}, err
+	}
+	if registration.Status == registrationStatusCancel || registration.EventStatus != eventStatusPublished ||
+		!sameStart(registration.StartDate, startsAt) {
+		return reminder{}, errReminderStale
+	}
+
+	var origin botOrigin
+	json.Unmarshal(registration.CustomFields, &origin)
+
+	text := fmt.Sprintf("⏰ Напоминаем: «%s» начнется %s.", registration.Title, formatEventTime(registration.StartDate))
+	if registration.IsOnline.Bool && registration.OnlineUrl.String != "" {
+		text += "\n🌐 " + registration.OnlineUrl.String
+	} else if place := eventPlace(false, registration.Address.String, registration.City.String); place != "" {
+		text += "\n" + place
+	}
+	return reminder{ChatID: origin.ChatID, Text: text}, nil
+}
+
+// sendQRCode отправляет QR-код картинкой
+func (h *MessageHandler) sendQRCode(ctx context.Context, chatID, userID int64, code, caption string) error {
+	png, err := qrcode.Encode(code, qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return fmt.Errorf("failed to encode qr code: %w", err)
+	}
+
+	photo := &tele.Photo{File: tele.FromReader(bytes.NewReader(png)), Caption: caption}
+	if _, err := h.engine.Bot().Send(tele.ChatID(chatID), photo); err != nil {
+		return fmt.Errorf("failed to send qr code: %w", err)
+	}
+	h.logBotMessage(ctx, chatID, userID, caption, map[string]interface{}{"type": "qr_code"})
+	return nil
+}
+
+// eventPlace - "🌐 Онлайн" или "📍 адрес, город"
+func eventPlace(online bool, address, city string) string {
+	if online {
+		return "🌐 Онлайн"
+	}
+	parts := []string{}
+	for _, part := range []string{address, city} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "📍 " + strings.Join(parts, ", ")
+}
+
+func formatEventTime(t pgtype.Timestamptz) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Local().Format(eventTimeLayout)
+}
@@ -1,45 +1,88 @@
 package bot
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/botjoker/sambacrm-business-tg/internal/ai"
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/metrics"
 	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	tele "gopkg.in/telebot.v3"
 )
 
+// maxVoiceFileSize - максимальный размер голосового сообщения для распознавания
+// (ограничение getFile в Bot API - 20 МБ)
+const maxVoiceFileSize = 20 * 1024 * 1024
+
+// errVoiceTooLarge - голосовое сообщение больше maxVoiceFileSize
+var errVoiceTooLarge = errors.New("voice file too large")
+
 type MessageHandler struct {
-	pool      *pgxpool.Pool
-	queries   *storage.Queries
-	botConfig storage.TelegramBot
-	aiClient  ai.Provider
+	pool        *pgxpool.Pool
+	queries     *storage.Queries
+	botConfig   storage.TelegramBot
+	settings    BotSettings
+	aiClient    ai.Provider
+	fallbackAI  ai.Provider
+	quota       *ai.Quota
+	transcriber ai.Transcriber
+	engine      *workflow.Engine
+	tasks       *asynq.Client
+	payments    config.Payments
 }
 
-func NewMessageHandler(pool *pgxpool.Pool, queries *storage.Queries, config storage.TelegramBot) *MessageHandler {
+func NewMessageHandler(pool *pgxpool.Pool, queries *storage.Queries, config storage.TelegramBot, aiConfig config.AI, payments config.Payments, bot *tele.Bot, tasks *asynq.Client) *MessageHandler {
 	h := &MessageHandler{
 		pool:      pool,
 		queries:   queries,
 		botConfig: config,
+		settings:  parseBotSettings(config, aiConfig),
+		engine:    workflow.NewEngine(queries, bot, config, tasks),
+		tasks:     tasks,
+		payments:  payments,
 	}
 
 	// Инициализируем AI клиент если включен
 	if config.AiEnabled {
-		h.aiClient = ai.NewProvider(config)
+		h.aiClient = ai.NewProvider(aiConfig, config)
+		h.quota = ai.NewQuota(queries, ai.QuotaLimitsFromConfig(aiConfig))
+
+		// Более дешевая модель для ответов сверх лимита тарифа
+		fallbackConfig := config
+		fallbackConfig.AiModel = pgtype.Text{String: h.settings.AIFallbackModel, Valid: true}
+		h.fallbackAI = ai.NewProvider(aiConfig, fallbackConfig)
 	}
 
+	// Распознавание речи нужно и для AI, и для workflows
+	h.transcriber = ai.NewTranscriber(aiConfig)
+
+	// Узел передачи диалога оператору (см. handoff.go)
+	h.engine.Register("handoff", &handoffNode{h: h})
+	// Узел оплаты заказа (см. payment.go)
+	h.engine.Register("payment", &paymentNode{h: h})
+
 	return h
 }
 
 // HandleStart обрабатывает команду /start
 func (h *MessageHandler) HandleStart(c tele.Context) error {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	
-	log.Printf("📨 /start от пользователя %d", c.Sender().ID)
+	logger.InfoContext(ctx, "📨 /start", "user_id", c.Sender().ID)
 	
 	// Логируем сообщение
 	h.logMessage(ctx, c, false)
@@ -51,7 +94,7 @@ func (h *MessageHandler) HandleStart(c tele.Context) error {
 	}
 
 	if err := c.Send(msg); err != nil {
-		log.Printf("❌ Ошибка отправки: %v", err)
+		logger.ErrorContext(ctx, "❌ Ошибка отправки", "error", err)
 		return err
 	}
 
@@ -66,10 +109,24 @@ func (h *MessageHandler) HandleStart(c tele.Context) error {
 
 // HandleHelp обрабатывает команду /help
 func (h *MessageHandler) HandleHelp(c tele.Context) error {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	h.logMessage(ctx, c, false)
 
-	helpText := "Доступные команды:\n/start - Начать\n/help - Помощь"
+	// Тот же список, что в меню команд Telegram (см. profile.go)
+	workflows, err := h.queries.GetActiveWorkflowsByBot(ctx, h.botConfig.ID)
+	if err != nil {
+		logger.ErrorContext(ctx, "Ошибка загрузки workflows", "error", err)
+	}
+
+	helpText := buildCommandMenu(h.settings.menuCommands(), workflows).helpText(c.Sender().LanguageCode)
+
+	// Сотрудник видит и свои команды (см. admin.go)
+	if c.Chat().Type == tele.ChatPrivate {
+		if staff, err := h.staffUser(ctx, c.Sender()); err == nil && staff != nil {
+			helpText += "\n\n" + staffHelpText(staff)
+		}
+	}
+
 	if err := c.Send(helpText); err != nil {
 		return err
 	}
@@ -80,42 +137,357 @@ func (h *MessageHandler) HandleHelp(c tele.Context) error {
 
 // HandleText обрабатывает любое текстовое сообщение
 func (h *MessageHandler) HandleText(c tele.Context) error {
-	ctx := context.Background()
+	ctx := requestContext(c)
 	
-	log.Printf("📨 Текст от %d: %s", c.Sender().ID, c.Text())
+	logger.InfoContext(ctx, "📨 Текст", "user_id", c.Sender().ID, "text", c.Text())
 	
 	h.logMessage(ctx, c, false)
 
-	userMessage := c.Text()
+	// Команды без отдельного обработчика запускают workflows с триггером command
+	if command, ok := commandFromText(c.Text()); ok {
+		if h.executeWorkflowsForCommand(ctx, c, command) {
+			return nil
+		}
+	}
+
+	return h.processText(ctx, c, c.Text())
+}
+
+// featureOnly - команда раздела бота (каталог, мероприятия...); если раздел
+// выключен в настройках, команда обрабатывается как текст
+func (h *MessageHandler) featureOnly(enabled func(BotSettings) bool, fn func(ctx context.Context, c tele.Context) error) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !enabled(h.settings) {
+			return h.HandleText(c)
+		}
+		ctx := requestContext(c)
+		h.logMessage(ctx, c, false)
+		return fn(ctx, c)
+	}
+}
+
+// HandleContact обрабатывает отправленный контакт (кнопка request_contact)
+func (h *MessageHandler) HandleContact(c tele.Context) error {
+	ctx := requestContext(c)
+	contact := c.Message().Contact
 
-	// 1. Проверяем есть ли workflow с триггером на сообщения
-	go h.executeWorkflowsForMessage(ctx, c, userMessage)
+	h.logMessageText(ctx, c, contact.PhoneNumber, false, map[string]interface{}{"media_type": "contact"})
+
+	handled, err := h.engine.HandleInput(ctx, c.Chat().ID, workflow.Input{Contact: contact})
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to resume workflow on contact", "error", err)
+	}
+	if handled {
+		return nil
+	}
+
+	// Свой номер сохраняем в контекст разговора
+	if contact.UserID == c.Sender().ID {
+		h.updateConversationContext(ctx, c, map[string]interface{}{
+			"phone": contact.PhoneNumber,
+		})
+	}
+
+	return nil
+}
+
+// commandFromText выделяет команду из текста ("/catalog@shop_bot arg" -> "/catalog")
+func commandFromText(text string) (string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", false
+	}
+	command := strings.Fields(text)[0]
+	if i := strings.Index(command, "@"); i != -1 {
+		command = command[:i]
+	}
+	return command, true
+}
+
+// HandleVoice обрабатывает голосовые сообщения и видео-кружки:
+// распознает речь и дальше работает с ней как с обычным текстом
+func (h *MessageHandler) HandleVoice(c tele.Context) error {
+	ctx := requestContext(c)
+
+	var (
+		file      tele.File
+		filename  string
+		mediaType string
+		duration  int
+	)
+	switch msg := c.Message(); {
+	case msg.Voice != nil:
+		file, filename, mediaType, duration = msg.Voice.File, "voice.ogg", "voice", msg.Voice.Duration
+	case msg.VideoNote != nil:
+		file, filename, mediaType, duration = msg.VideoNote.File, "video_note.mp4", "video_note", msg.VideoNote.Duration
+	default:
+		return nil
+	}
+
+	logger.InfoContext(ctx, "🎤 Голосовое сообщение", "media_type", mediaType, "user_id", c.Sender().ID, "duration", duration)
+
+	if h.transcriber == nil {
+		h.logMessageText(ctx, c, "", false, map[string]interface{}{"media_type": mediaType})
+		return c.Send("Извините, я пока не умею слушать голосовые сообщения. Напишите, пожалуйста, текстом.")
+	}
+
+	if file.FileSize > maxVoiceFileSize {
+		h.logMessageText(ctx, c, "", false, map[string]interface{}{"media_type": mediaType})
+		return c.Send("Сообщение слишком длинное. Пожалуйста, запишите покороче или напишите текстом.")
+	}
+
+	text, err := h.transcribe(ctx, c, file, filename)
+	if errors.Is(err, errVoiceTooLarge) {
+		h.logMessageText(ctx, c, "", false, map[string]interface{}{"media_type": mediaType})
+		return c.Send("Сообщение слишком длинное. Пожалуйста, запишите покороче или напишите текстом.")
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "❌ Ошибка распознавания речи", "error", err)
+		h.logMessageText(ctx, c, "", false, map[string]interface{}{"media_type": mediaType})
+		return c.Send("Не удалось распознать сообщение. Пожалуйста, напишите текстом.")
+	}
+
+	h.logMessageText(ctx, c, text, false, map[string]interface{}{
+		"media_type":  mediaType,
+		"transcribed": true,
+		"duration":    duration,
+	})
+
+	if text == "" {
+		return c.Send("Не удалось разобрать слова в сообщении. Попробуйте еще раз.")
+	}
+
+	return h.processText(ctx, c, text)
+}
+
+// HandlePhoto обрабатывает фото и изображения, отправленные документом.
+// Если для бота включен vision - изображение передается AI вместе с подписью
+func (h *MessageHandler) HandlePhoto(c tele.Context) error {
+	ctx := requestContext(c)
+	msg := c.Message()
+	caption := msg.Caption
+
+	var (
+		file     tele.File
+		mimeType string
+	)
+	switch {
+	case msg.Photo != nil:
+		file, mimeType = msg.Photo.File, "image/jpeg"
+	case msg.Document != nil && strings.HasPrefix(msg.Document.MIME, "image/"):
+		file, mimeType = msg.Document.File, msg.Document.MIME
+	default:
+		// Прочие документы обрабатываем только по подписи
+		h.logMessageText(ctx, c, caption, false, map[string]interface{}{"media_type": "document"})
+		if caption == "" {
+			return nil
+		}
+		return h.processText(ctx, c, caption)
+	}
+
+	logger.InfoContext(ctx, "🖼️ Изображение", "user_id", c.Sender().ID)
+
+	h.logMessageText(ctx, c, caption, false, map[string]interface{}{
+		"media_type": "image",
+		"file_id":    file.FileID,
+	})
+
+	if !h.settings.AIVisionEnabled || !h.botConfig.AiEnabled || h.aiClient == nil {
+		if caption == "" {
+			return nil
+		}
+		return h.processText(ctx, c, caption)
+	}
+
+	// Подпись может быть ответом workflow или запросом оператора - тогда vision не нужен
+	if caption != "" {
+		if handled, err := h.routeInput(ctx, c, caption); handled {
+			return err
+		}
+	}
+
+	if file.FileSize > h.settings.visionMaxImageBytes() {
+		return c.Send(fmt.Sprintf("Изображение слишком большое. Максимальный размер - %d МБ.", h.settings.AIVisionMaxImageMB))
+	}
+
+	image, err := h.downloadImage(c, file, mimeType)
+	if err != nil {
+		logger.ErrorContext(ctx, "❌ Ошибка загрузки изображения", "error", err)
+		return c.Send("Не удалось загрузить изображение. Попробуйте отправить еще раз.")
+	}
+
+	if caption != "" {
+		h.executeWorkflowsForMessage(ctx, c, caption)
+	}
+
+	response, err := h.generateAIResponse(ctx, c, caption, image)
+	if errors.Is(err, errAIQuotaExceeded) {
+		return nil
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "AI error", "error", err)
+		return c.Send("Извините, произошла ошибка при обработке запроса.")
+	}
+
+	if h.aiRequestsHandoff(response.Text) {
+		return h.startHandoff(ctx, c.Chat().ID, c.Sender(), handoffReasonAI, caption)
+	}
+
+	if err := c.Send(response.Text); err != nil {
+		return err
+	}
+
+	h.logMessageText(ctx, c, response.Text, true, aiResponseMeta(response))
+	return nil
+}
+
+// downloadImage скачивает изображение из Telegram с учетом лимита размера
+func (h *MessageHandler) downloadImage(c tele.Context, file tele.File, mimeType string) (ai.Image, error) {
+	reader, err := c.Bot().File(&file)
+	if err != nil {
+		return ai.Image{}, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer reader.Close()
+
+	// FileSize может отсутствовать, поэтому ограничиваем чтение
+	limit := h.settings.visionMaxImageBytes()
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return ai.Image{}, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return ai.Image{}, fmt.Errorf("image exceeds %d bytes", limit)
+	}
+
+	return ai.Image{Data: data, MIMEType: mimeType}, nil
+}
+
+// transcribe скачивает файл из Telegram и отправляет его в STT провайдера
+func (h *MessageHandler) transcribe(ctx context.Context, c tele.Context, file tele.File, filename string) (string, error) {
+	reader, err := c.Bot().File(&file)
+	if err != nil {
+		return "", fmt.Errorf("failed to download file: %w", err)
+	}
+	defer reader.Close()
+
+	// FileSize может отсутствовать или не совпадать с файлом, поэтому ограничиваем чтение
+	data, err := io.ReadAll(io.LimitReader(reader, maxVoiceFileSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > maxVoiceFileSize {
+		return "", errVoiceTooLarge
+	}
+
+	return h.transcriber.Transcribe(ctx, bytes.NewReader(data), filename)
+}
+
+// processText запускает workflows и AI для текста пользователя
+// (набранного вручную или распознанного из голоса)
+func (h *MessageHandler) processText(ctx context.Context, c tele.Context, userMessage string) error {
+	// 0. Ответ ожидающему workflow или запрос оператора
+	if handled, err := h.routeInput(ctx, c, userMessage); handled {
+		return err
+	}
+
+	// 1. Проверяем есть ли workflow с триггером на сообщения.
+	// Выполняется синхронно: апдейты чата и так обрабатываются по очереди (serializeChat)
+	h.executeWorkflowsForMessage(ctx, c, userMessage)
 
 	// 2. Если AI включен - генерируем ответ
 	if h.botConfig.AiEnabled && h.aiClient != nil {
 		response, err := h.generateAIResponse(ctx, c, userMessage)
+		if errors.Is(err, errAIQuotaExceeded) {
+			return nil
+		}
 		if err != nil {
-			log.Printf("AI error: %v", err)
+			logger.ErrorContext(ctx, "AI error", "error", err)
 			return c.Send("Извините, произошла ошибка при обработке запроса.")
 		}
 
-		if err := c.Send(response); err != nil {
+		if h.aiRequestsHandoff(response.Text) {
+			return h.startHandoff(ctx, c.Chat().ID, c.Sender(), handoffReasonAI, userMessage)
+		}
+
+		if err := c.Send(response.Text); err != nil {
 			return err
 		}
 
-		h.logMessage(ctx, c, true)
+		h.logMessageText(ctx, c, response.Text, true, aiResponseMeta(response))
 	}
 
 	return nil
 }
 
+// routeInput передает текст ожидающему ответа workflow или переводит чат на оператора,
+// если клиент об этом просит. Возвращает true, если текст обработан
+func (h *MessageHandler) routeInput(ctx context.Context, c tele.Context, userMessage string) (bool, error) {
+	// Если workflow в этом чате ждет ответа - это ответ ему
+	handled, err := h.engine.HandleInput(ctx, c.Chat().ID, workflow.Input{Text: userMessage})
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to resume workflow on message", "error", err)
+	}
+	if handled {
+		return true, nil
+	}
+
+	// Клиент просит живого человека
+	if h.isHandoffRequest(userMessage) {
+		return true, h.startHandoff(ctx, c.Chat().ID, c.Sender(), handoffReasonKeyword, userMessage)
+	}
+
+	return false, nil
+}
+
 // HandleCallback обрабатывает нажатия на inline кнопки
 func (h *MessageHandler) HandleCallback(c tele.Context) error {
-	ctx := context.Background()
+	ctx := requestContext(c)
 
 	// Получаем данные callback
 	data := c.Callback().Data
 
+	// Кнопки рассылки сотрудника (см. admin.go)
+	if strings.HasPrefix(data, callbackAdminPrefix) {
+		return h.handleAdminCallback(ctx, c, data)
+	}
+
+	// Каталог и корзина (см. catalog.go)
+	if strings.HasPrefix(data, callbackCatalogPrefix) {
+		return h.handleCatalogCallback(ctx, c, data)
+	}
+
+	// Мероприятия и билеты (см. events.go)
+	if strings.HasPrefix(data, callbackEventsPrefix) {
+		return h.handleEventsCallback(ctx, c, data)
+	}
+
+	// Обучение: запись на занятия и абонементы (см. education.go)
+	if strings.HasPrefix(data, callbackEducationPrefix) {
+		return h.handleEducationCallback(ctx, c, data)
+	}
+
+	// Бронирование номеров и помещений (см. booking.go)
+	if strings.HasPrefix(data, callbackBookingPrefix) {
+		return h.handleBookingCallback(ctx, c, data)
+	}
+
+	// Ответы на напоминания о записях и занятиях (см. appointments.go)
+	if strings.HasPrefix(data, callbackReminderPrefix) {
+		return h.handleReminderCallback(ctx, c, data)
+	}
+
+	// Если в чате есть workflow, ожидающий нажатия - продолжаем его
+	input := workflow.Input{CallbackData: data}
+	if c.Callback().Message != nil {
+		input.MessageID = c.Callback().Message.ID
+	}
+	handled, err := h.engine.HandleInput(ctx, c.Chat().ID, input)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to resume workflow on callback", "error", err)
+	}
+	if handled {
+		return c.Respond()
+	}
+
 	// Обновляем контекст разговора
 	h.updateConversationContext(ctx, c, map[string]interface{}{
 		"last_callback": data,
@@ -127,15 +499,17 @@ func (h *MessageHandler) HandleCallback(c tele.Context) error {
 	})
 }
 
-// executeWorkflowsForCommand выполняет workflow с триггером на команду
-func (h *MessageHandler) executeWorkflowsForCommand(ctx context.Context, c tele.Context, command string) {
+// executeWorkflowsForCommand выполняет workflow с триггером на команду.
+// Возвращает true, если сработал хотя бы один workflow
+func (h *MessageHandler) executeWorkflowsForCommand(ctx context.Context, c tele.Context, command string) bool {
 	// Загружаем workflows привязанные к этому боту
 	workflows, err := h.queries.GetActiveWorkflowsByBot(ctx, h.botConfig.ID)
 	if err != nil {
-		log.Printf("Ошибка загрузки workflows: %v", err)
-		return
+		logger.ErrorContext(ctx, "Ошибка загрузки workflows", "error", err)
+		return false
 	}
 
+	matched := false
 	for _, wf := range workflows {
 		if wf.TriggerType == "command" {
 			// Проверяем конфигурацию триггера
@@ -143,48 +517,21 @@ func (h *MessageHandler) executeWorkflowsForCommand(ctx context.Context, c tele.
 			if wf.TriggerConfig != nil {
 				if err := json.Unmarshal(wf.TriggerConfig, &triggerConfig); err == nil {
 					if cmd, ok := triggerConfig["command"].(string); ok && cmd == command {
-						log.Printf("▶️ Workflow '%s' сработал на %s", wf.WorkflowName, command)
-						
-						// Загружаем узлы и связи
-						nodes, _ := h.queries.GetWorkflowNodes(ctx, wf.ID)
-						edges, _ := h.queries.GetWorkflowEdges(ctx, wf.ID)
-						
-						// Формируем текстовое описание цепочки
-						chainText := h.buildWorkflowChainText(&wf, nodes, edges)
-						c.Send(chainText)
+						logger.InfoContext(ctx, "▶️ Workflow сработал на команду", "workflow", wf.WorkflowName, "command", command)
+						matched = true
+
+						trigger := h.workflowTrigger(c, "command")
+						trigger.Variables["command"] = command
+						if err := h.engine.Start(ctx, wf.ID, trigger); err != nil {
+							logger.ErrorContext(ctx, "❌ Workflow завершился с ошибкой", "workflow", wf.WorkflowName, "error", err)
+						}
 					}
 				}
 			}
 		}
 	}
-}
 
-// buildWorkflowChainText строит текстовое представление цепочки workflow
-func (h *MessageHandler) buildWorkflowChainText(wf *storage.GetActiveWorkflowsByBotRow, nodes []storage.GetWorkflowNodesRow, edges []storage.TelegramWorkflowEdge) string {
-	result := fmt.Sprintf("📋 Workflow: %s\n", wf.WorkflowName)
-	if wf.Description.Valid {
-		result += fmt.Sprintf("%s\n", wf.Description.String)
-	}
-	result += "\n🔗 Цепочка выполнения:\n\n"
-	
-	if len(nodes) == 0 {
-		return result + "⚠️ Узлы не настроены"
-	}
-	
-	// Простой список узлов
-	for i, node := range nodes {
-		nodeLabel := node.NodeType
-		if node.NodeLabel.Valid {
-			nodeLabel = node.NodeLabel.String
-		}
-		result += fmt.Sprintf("%d. [%s] %s\n", i+1, node.NodeType, nodeLabel)
-	}
-	
-	if len(edges) > 0 {
-		result += fmt.Sprintf("\n🔗 Связей: %d\n", len(edges))
-	}
-	
-	return result
+	return matched
 }
 
 // executeWorkflowsForMessage выполняет workflow с триггером на сообщения
@@ -192,25 +539,88 @@ func (h *MessageHandler) executeWorkflowsForMessage(ctx context.Context, c tele.
 	// Загружаем workflows привязанные к этому боту
 	workflows, err := h.queries.GetActiveWorkflowsByBot(ctx, h.botConfig.ID)
 	if err != nil {
-		log.Printf("Failed to load workflows for bot: %v", err)
+		logger.ErrorContext(ctx, "Failed to load workflows for bot", "error", err)
 		return
 	}
 
 	for _, wf := range workflows {
 		if wf.TriggerType == "message" {
-			// TODO: проверить pattern regex из trigger_config
-			log.Printf("▶️  Workflow %s сработал на сообщение", wf.WorkflowName)
-			// Пока просто логируем, обработку добавим позже
+			// Если в trigger_config задан pattern - сообщение должно ему соответствовать
+			var triggerConfig struct {
+				Pattern string `json:"pattern"`
+			}
+			if wf.TriggerConfig != nil {
+				json.Unmarshal(wf.TriggerConfig, &triggerConfig)
+			}
+			if triggerConfig.Pattern != "" {
+				re, err := regexp.Compile(triggerConfig.Pattern)
+				if err != nil {
+					logger.WarnContext(ctx, "⚠️ Некорректный pattern в workflow", "workflow", wf.WorkflowName, "error", err)
+					continue
+				}
+				if !re.MatchString(message) {
+					continue
+				}
+			}
+
+			logger.InfoContext(ctx, "▶️ Workflow сработал на сообщение", "workflow", wf.WorkflowName)
+
+			trigger := h.workflowTrigger(c, "message")
+			trigger.Variables["text"] = message
+			if err := h.engine.Start(ctx, wf.ID, trigger); err != nil {
+				logger.ErrorContext(ctx, "❌ Workflow завершился с ошибкой", "workflow", wf.WorkflowName, "error", err)
+			}
 		}
 	}
 }
 
-// generateAIResponse генерирует ответ через AI
-func (h *MessageHandler) generateAIResponse(ctx context.Context, c tele.Context, userMessage string) (string, error) {
+// workflowTrigger собирает начальные переменные workflow из апдейта
+func (h *MessageHandler) workflowTrigger(c tele.Context, triggerType string) workflow.Trigger {
+	sender := c.Sender()
+	return workflow.Trigger{
+		Type:   triggerType,
+		ChatID: c.Chat().ID,
+		UserID: sender.ID,
+		Variables: map[string]interface{}{
+			"user_id":    sender.ID,
+			"chat_id":    c.Chat().ID,
+			"username":   sender.Username,
+			"first_name": sender.FirstName,
+			"last_name":  sender.LastName,
+			"language":   sender.LanguageCode,
+		},
+	}
+}
+
+// errAIQuotaExceeded - лимит токенов исчерпан, а бот настроен не отвечать через AI
+var errAIQuotaExceeded = errors.New("ai quota exceeded")
+
+// generateAIResponse генерирует ответ через AI с учетом лимита токенов тарифа
+func (h *MessageHandler) generateAIResponse(ctx context.Context, c tele.Context, userMessage string, images ...ai.Image) (*ai.Response, error) {
+	provider := h.aiClient
+
+	exceeded, err := h.quota.Exceeded(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		// Не оставляем клиента без ответа из-за сбоя учета
+		logger.WarnContext(ctx, "⚠️ Не удалось проверить лимит AI", "error", err)
+	}
+	if exceeded {
+		logger.WarnContext(ctx, "💸 Лимит AI исчерпан", "action", h.settings.AIQuotaAction)
+
+		switch h.settings.AIQuotaAction {
+		case quotaActionFallback:
+			provider = h.fallbackAI
+		case quotaActionDisable:
+			return nil, errAIQuotaExceeded
+		default:
+			return &ai.Response{Text: h.settings.AIQuotaMessage}, nil
+		}
+	}
+
 	// Получаем контекст разговора
 	conv, err := h.getOrCreateConversation(ctx, c)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Формируем промпт
@@ -218,36 +628,57 @@ func (h *MessageHandler) generateAIResponse(ctx context.Context, c tele.Context,
 	if h.botConfig.AiSystemPrompt.Valid {
 		systemPrompt = h.botConfig.AiSystemPrompt.String
 	}
+	if h.settings.HandoffEnabled && h.settings.HandoffAI {
+		systemPrompt += aiHandoffPrompt
+	}
 
 	// Если включен RAG - добавляем контекст
 	var ragContext string
 	// TODO: реализовать RAG поиск
 
 	// Генерируем ответ
-	response, err := h.aiClient.GenerateResponse(ctx, systemPrompt, userMessage, ragContext)
+	botID, profileID := uuid.UUID(h.botConfig.ID.Bytes).String(), uuid.UUID(h.botConfig.ProfileID.Bytes).String()
+	model := provider.Model()
+
+	started := time.Now()
+	response, err := provider.GenerateResponse(ctx, systemPrompt, userMessage, ragContext, images...)
+	metrics.AIRequestDuration.WithLabelValues(botID, profileID, model).Observe(time.Since(started).Seconds())
+	metrics.AIRequests.WithLabelValues(botID, profileID, model, metrics.Status(err)).Inc()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Обновляем контекст разговора
-	var contextData map[string]interface{}
-	if err := json.Unmarshal(conv.Context, &contextData); err != nil {
-		contextData = make(map[string]interface{})
+	metrics.AITokens.WithLabelValues(botID, profileID, model, "prompt").Add(float64(response.Usage.PromptTokens))
+	metrics.AITokens.WithLabelValues(botID, profileID, model, "completion").Add(float64(response.Usage.CompletionTokens))
+
+	if err := h.quota.Record(ctx, h.botConfig.ID, h.botConfig.ProfileID, response); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось записать расход AI", "error", err)
 	}
-	contextData["last_user_message"] = userMessage
-	contextData["last_ai_response"] = response
 
-	newContext, _ := json.Marshal(contextData)
-	if err := h.queries.UpdateConversation(ctx, storage.UpdateConversationParams{
-		ID:      conv.ID,
-		Context: newContext,
+	// Обновляем контекст разговора
+	if err := h.mergeConversationContext(ctx, conv.ID, map[string]interface{}{
+		"last_user_message": userMessage,
+		"last_ai_response":  response.Text,
 	}); err != nil {
-		log.Printf("Failed to update conversation: %v", err)
+		logger.ErrorContext(ctx, "Failed to update conversation", "error", err)
 	}
 
 	return response, nil
 }
 
+// aiResponseMeta - метаданные AI ответа для лога сообщений
+func aiResponseMeta(response *ai.Response) map[string]interface{} {
+	if response.Model == "" {
+		// Ответ-заглушка при исчерпанном лимите
+		return map[string]interface{}{"ai_quota_exceeded": true}
+	}
+	return map[string]interface{}{
+		"ai_model":          response.Model,
+		"prompt_tokens":     response.Usage.PromptTokens,
+		"completion_tokens": response.Usage.CompletionTokens,
+	}
+}
+
 // getOrCreateConversation получает или создает разговор
 func (h *MessageHandler) getOrCreateConversation(ctx context.Context, c tele.Context) (storage.TelegramConversation, error) {
 	chatID := c.Chat().ID
@@ -281,20 +712,47 @@ func (h *MessageHandler) updateConversationContext(ctx context.Context, c tele.C
 		return
 	}
 
-	var contextData map[string]interface{}
-	if err := json.Unmarshal(conv.Context, &contextData); err != nil {
-		contextData = make(map[string]interface{})
+	if err := h.mergeConversationContext(ctx, conv.ID, updates); err != nil {
+		logger.ErrorContext(ctx, "Failed to update conversation", "error", err)
+	}
+}
+
+// conversationValue читает поле контекста разговора в dst.
+// Возвращает false, если разговора или поля нет
+func (h *MessageHandler) conversationValue(ctx context.Context, chatID int64, key string, dst interface{}) bool {
+	conv, err := h.queries.GetConversation(ctx, storage.GetConversationParams{
+		ProfileID: h.botConfig.ProfileID,
+		ChatID:    chatID,
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.ErrorContext(ctx, "Failed to load conversation", "error", err)
+		}
+		return false
+	}
+
+	var convContext map[string]json.RawMessage
+	if err := json.Unmarshal(conv.Context, &convContext); err != nil {
+		return false
 	}
+	value, ok := convContext[key]
+	if !ok || string(value) == "null" {
+		return false
+	}
+	return json.Unmarshal(value, dst) == nil
+}
 
-	// Обновляем поля
-	for k, v := range updates {
-		contextData[k] = v
+// mergeConversationContext атомарно дописывает поля в контекст разговора (JSONB ||),
+// не перезаписывая то, что параллельно изменили другие обработчики
+func (h *MessageHandler) mergeConversationContext(ctx context.Context, convID pgtype.UUID, updates map[string]interface{}) error {
+	data, err := json.Marshal(updates)
+	if err != nil {
+		return err
 	}
 
-	newContext, _ := json.Marshal(contextData)
-	h.queries.UpdateConversation(ctx, storage.UpdateConversationParams{
-		ID:      conv.ID,
-		Context: newContext,
+	return h.queries.MergeConversationContext(ctx, storage.MergeConversationContextParams{
+		ID:      convID,
+		Updates: data,
 	})
 }
 
@@ -308,10 +766,19 @@ func (h *MessageHandler) logMessage(ctx context.Context, c tele.Context, isFromB
 		text = c.Text()
 	}
 
-	metadata, _ := json.Marshal(map[string]interface{}{
+	h.logMessageText(ctx, c, text, isFromBot, nil)
+}
+
+// logMessageText логирует сообщение с явно заданным текстом и дополнительными метаданными
+func (h *MessageHandler) logMessageText(ctx context.Context, c tele.Context, text string, isFromBot bool, extra map[string]interface{}) {
+	meta := map[string]interface{}{
 		"username": c.Sender().Username,
 		"first_name": c.Sender().FirstName,
-	})
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+	metadata, _ := json.Marshal(meta)
 
 	// Конвертируем string в pgtype.Text
 	var messageText pgtype.Text
@@ -0,0 +1,536 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Передача диалога оператору (handoff).
+//
+// Диалог передается по ключевому слову клиента, по решению AI или узлом workflow
+// "handoff". Пока передача открыта, AI и workflows в чате не работают: сообщения
+// клиента пересылаются в группу сотрудников (handoff_chat_id), а ответ оператора
+// на пересланное сообщение уходит клиенту. Бизнес получает уведомление в CRM;
+// из CRM ответить и закрыть диалог можно задачами handoff:reply / handoff:close.
+// После закрытия бот снова отвечает сам.
+
+// Причины передачи оператору
+const (
+	handoffReasonKeyword  = "keyword"
+	handoffReasonAI       = "ai"
+	handoffReasonWorkflow = "workflow"
+)
+
+// notificationHandoff - тип уведомления в CRM о новом диалоге для оператора
+const notificationHandoff = "telegram_handoff"
+
+// aiHandoffMarker - ответ AI, означающий, что клиенту нужен человек
+const aiHandoffMarker = "[HANDOFF]"
+
+const aiHandoffPrompt = "\n\nЕсли ты не можешь помочь клиенту или он просит связаться с человеком, " +
+	"ответь только " + aiHandoffMarker + " без других слов."
+
+// Callback кнопок в группе сотрудников: "handoff:take:<id>", "handoff:close:<id>"
+const (
+	callbackHandoffTake  = "handoff:take:"
+	callbackHandoffClose = "handoff:close:"
+)
+
+var handoffReasonTitles = map[string]string{
+	handoffReasonKeyword:  "клиент попросил оператора",
+	handoffReasonAI:       "AI не смог помочь",
+	handoffReasonWorkflow: "сценарий",
+}
+
+// errOperatorNotLinked - Telegram сотрудника не привязан к аккаунту CRM
+var errOperatorNotLinked = errors.New("operator telegram is not linked to an account")
+
+// handoffGate - middleware: в чате с открытой передачей сообщения клиента уходят
+// оператору, апдейты из группы сотрудников обрабатываются как действия операторов
+func (b *BotInstance) handoffGate(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		h := b.Handler
+		if h.isStaffChat(c) {
+			return h.handleStaff(c)
+		}
+		if c.Chat() == nil || (c.Message() == nil && c.Callback() == nil) {
+			return next(c)
+		}
+		if c.Message() != nil && c.Message().Payment != nil {
+			// Оплату фиксируем всегда, даже если клиент сейчас говорит с оператором
+			return next(c)
+		}
+
+		ctx := requestContext(c)
+		handoff, ok, err := h.openHandoff(ctx, c.Chat().ID)
+		if err != nil {
+			// Без проверки лучше ответить автоматически, чем промолчать
+			logger.ErrorContext(ctx, "❌ Не удалось проверить передачу оператору", "error", err)
+		}
+		if !ok {
+			return next(c)
+		}
+
+		if c.Callback() != nil {
+			return c.Respond(&tele.CallbackResponse{Text: "Оператор скоро ответит"})
+		}
+		return h.relayToStaff(ctx, c, handoff)
+	}
+}
+
+// isStaffChat - апдейт из группы сотрудников
+func (h *MessageHandler) isStaffChat(c tele.Context) bool {
+	return h.settings.HandoffChatID != 0 && c.Chat() != nil && c.Chat().ID == h.settings.HandoffChatID
+}
+
+func (h *MessageHandler) openHandoff(ctx context.Context, chatID int64) (storage.TelegramHandoff, bool, error) {
+	handoff, err := h.queries.GetOpenHandoff(ctx, storage.GetOpenHandoffParams{
+		BotID:  h.botConfig.ID,
+		ChatID: chatID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.TelegramHandoff{}, false, nil
+	}
+	if err != nil {
+		return storage.TelegramHandoff{}, false, err
+	}
+	return handoff, true, nil
+}
+
+// isHandoffRequest - клиент просит оператора ключевым словом
+func (h *MessageHandler) isHandoffRequest(text string) bool {
+	if !h.settings.HandoffEnabled {
+		return false
+	}
+	text = strings.ToLower(text)
+	for _, keyword := range h.settings.HandoffKeywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// aiRequestsHandoff - AI ответил, что клиенту нужен человек
+func (h *MessageHandler) aiRequestsHandoff(response string) bool {
+	return h.settings.HandoffEnabled && h.settings.HandoffAI && strings.Contains(response, aiHandoffMarker)
+}
+
+// startHandoff передает чат оператору: ожидающие workflows отменяются, клиент
+// получает handoff_message, бизнес - уведомление в CRM и карточку в группе сотрудников.
+// Если передача в чате уже открыта, новая не создается.
+func (h *MessageHandler) startHandoff(ctx context.Context, chatID int64, user *tele.User, reason, lastMessage string) error {
+	if _, ok, err := h.openHandoff(ctx, chatID); err != nil || ok {
+		return err
+	}
+
+	if err := h.queries.CancelWaitingExecutions(ctx, storage.CancelWaitingExecutionsParams{
+		BotID:  h.botConfig.ID,
+		ChatID: chatID,
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to cancel waiting executions", "error", err)
+	}
+
+	handoff, err := h.queries.CreateHandoff(ctx, storage.CreateHandoffParams{
+		ProfileID:      h.botConfig.ProfileID,
+		BotID:          h.botConfig.ID,
+		ChatID:         chatID,
+		TelegramUserID: user.ID,
+		Reason:         reason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create handoff: %w", err)
+	}
+
+	logger.InfoContext(ctx, "🙋 Диалог передан оператору", "handoff_id", uuidString(handoff.ID), "reason", reason)
+
+	bot := h.engine.Bot()
+	if _, err := bot.Send(tele.ChatID(chatID), h.settings.HandoffMessage); err != nil {
+		logger.ErrorContext(ctx, "❌ Ошибка отправки", "error", err)
+	} else {
+		h.logBotMessage(ctx, chatID, user.ID, h.settings.HandoffMessage, map[string]interface{}{"handoff_id": uuidString(handoff.ID)})
+	}
+
+	if err := h.notifyHandoff(ctx, handoff, user, lastMessage); err != nil {
+		logger.ErrorContext(ctx, "❌ Не удалось уведомить о передаче оператору", "error", err)
+	}
+
+	if h.settings.HandoffChatID != 0 {
+		card, err := bot.Send(tele.ChatID(h.settings.HandoffChatID), handoffCardText(handoff, user, lastMessage), handoffCardMarkup(handoff))
+		if err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось отправить диалог в группу сотрудников", "error", err)
+		} else {
+			h.rememberStaffMessage(ctx, handoff, card)
+		}
+	}
+
+	return nil
+}
+
+func (h *MessageHandler) notifyHandoff(ctx context.Context, handoff storage.TelegramHandoff, user *tele.User, lastMessage string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"handoff_id":       uuidString(handoff.ID),
+		"bot_id":           uuidString(h.botConfig.ID),
+		"chat_id":          handoff.ChatID,
+		"telegram_user_id": user.ID,
+		"username":         user.Username,
+		"name":             telegramUserName(user),
+		"reason":           handoff.Reason,
+		"message":          lastMessage,
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.queries.CreateNotification(ctx, storage.CreateNotificationParams{
+		ProfileID: h.botConfig.ProfileID,
+		Type:      notificationHandoff,
+		Title:     fmt.Sprintf("Клиент %s ждет оператора в Telegram", telegramUserName(user)),
+		Message:   pgtype.Text{String: lastMessage, Valid: lastMessage != ""},
+		Payload:   payload,
+	})
+}
+
+func handoffCardText(handoff storage.TelegramHandoff, user *tele.User, lastMessage string) string {
+	var sb strings.Builder
+	sb.WriteString("🙋 Клиент ждет оператора\n")
+	sb.WriteString(telegramUserName(user))
+	if user.Username != "" {
+		sb.WriteString(" (@" + user.Username + ")")
+	}
+	sb.WriteString("\nПричина: " + handoffReasonTitles[handoff.Reason])
+	if lastMessage != "" {
+		sb.WriteString("\n\n" + lastMessage)
+	}
+	sb.WriteString("\n\nОтветьте на это сообщение или на сообщение клиента - ответ уйдет в чат. /close в ответе закрывает диалог.")
+	return sb.String()
+}
+
+func handoffCardMarkup(handoff storage.TelegramHandoff) *tele.ReplyMarkup {
+	markup := &tele.ReplyMarkup{}
+	id := uuidString(handoff.ID)
+	markup.InlineKeyboard = [][]tele.InlineButton{{
+		{Text: "👤 Взять", Data: callbackHandoffTake + id},
+		{Text: "✅ Закрыть", Data: callbackHandoffClose + id},
+	}}
+	return markup
+}
+
+// rememberStaffMessage связывает сообщение в группе сотрудников с передачей,
+// чтобы ответ на него ушел клиенту
+func (h *MessageHandler) rememberStaffMessage(ctx context.Context, handoff storage.TelegramHandoff, msg *tele.Message) {
+	if err := h.queries.CreateHandoffMessage(ctx, storage.CreateHandoffMessageParams{
+		HandoffID:      handoff.ID,
+		StaffChatID:    msg.Chat.ID,
+		StaffMessageID: int64(msg.ID),
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to save handoff message", "error", err)
+	}
+}
+
+// relayToStaff пересылает сообщение клиента в группу сотрудников
+func (h *MessageHandler) relayToStaff(ctx context.Context, c tele.Context, handoff storage.TelegramHandoff) error {
+	msg := c.Message()
+	h.logMessageText(ctx, c, messageText(msg), false, map[string]interface{}{"handoff_id": uuidString(handoff.ID)})
+
+	if h.settings.HandoffChatID == 0 {
+		return nil
+	}
+
+	forwarded, err := c.Bot().Forward(tele.ChatID(h.settings.HandoffChatID), msg)
+	if err != nil {
+		return fmt.Errorf("failed to forward message to staff chat: %w", err)
+	}
+	h.rememberStaffMessage(ctx, handoff, forwarded)
+	return nil
+}
+
+// handleStaff обрабатывает действия операторов в группе сотрудников
+func (h *MessageHandler) handleStaff(c tele.Context) error {
+	ctx := requestContext(c)
+
+	if cb := c.Callback(); cb != nil {
+		return h.handleStaffCallback(ctx, c, cb.Data)
+	}
+
+	msg := c.Message()
+	if msg == nil || msg.ReplyTo == nil {
+		// Обычная переписка сотрудников
+		return nil
+	}
+
+	handoff, err := h.queries.GetHandoffByStaffMessage(ctx, storage.GetHandoffByStaffMessageParams{
+		BotID:          h.botConfig.ID,
+		StaffChatID:    msg.Chat.ID,
+		StaffMessageID: int64(msg.ReplyTo.ID),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load handoff: %w", err)
+	}
+	ctx = withHandoffLogAttrs(ctx, handoff)
+
+	if handoff.Status != "open" {
+		return c.Reply("Диалог уже закрыт.")
+	}
+
+	account, err := h.operatorAccount(ctx, c.Sender())
+	if errors.Is(err, errOperatorNotLinked) {
+		return c.Reply(fmt.Sprintf("Ваш Telegram не привязан к аккаунту CRM - укажите в профиле сотрудника ваш Telegram ID: %d", c.Sender().ID))
+	}
+	if err != nil {
+		return err
+	}
+
+	if command, ok := commandFromText(msg.Text); ok && command == "/close" {
+		return h.closeHandoff(ctx, handoff, account.ID)
+	}
+
+	if _, err := c.Bot().Copy(tele.ChatID(handoff.ChatID), msg); err != nil {
+		logger.ErrorContext(ctx, "❌ Не удалось отправить ответ оператора", "error", err)
+		return c.Reply("Не удалось отправить ответ клиенту: " + err.Error())
+	}
+
+	h.assignHandoff(ctx, handoff, account.ID)
+	h.logOperatorMessage(ctx, handoff, account.ID, messageText(msg), map[string]interface{}{
+		"operator_telegram_id": c.Sender().ID,
+	})
+	return nil
+}
+
+func (h *MessageHandler) handleStaffCallback(ctx context.Context, c tele.Context, data string) error {
+	var action, id string
+	switch {
+	case strings.HasPrefix(data, callbackHandoffTake):
+		action, id = callbackHandoffTake, strings.TrimPrefix(data, callbackHandoffTake)
+	case strings.HasPrefix(data, callbackHandoffClose):
+		action, id = callbackHandoffClose, strings.TrimPrefix(data, callbackHandoffClose)
+	default:
+		return c.Respond()
+	}
+
+	handoffID, err := uuid.Parse(id)
+	if err != nil {
+		return c.Respond()
+	}
+	handoff, err := h.queries.GetHandoff(ctx, pgtype.UUID{Bytes: handoffID, Valid: true})
+	if err != nil || handoff.BotID != h.botConfig.ID {
+		return c.Respond(&tele.CallbackResponse{Text: "Диалог не найден"})
+	}
+	ctx = withHandoffLogAttrs(ctx, handoff)
+
+	if handoff.Status != "open" {
+		return c.Respond(&tele.CallbackResponse{Text: "Диалог уже закрыт"})
+	}
+
+	account, err := h.operatorAccount(ctx, c.Sender())
+	if errors.Is(err, errOperatorNotLinked) {
+		return c.Respond(&tele.CallbackResponse{Text: "Ваш Telegram не привязан к аккаунту CRM", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+
+	if action == callbackHandoffClose {
+		if err := h.closeHandoff(ctx, handoff, account.ID); err != nil {
+			return err
+		}
+		return c.Respond(&tele.CallbackResponse{Text: "Диалог закрыт"})
+	}
+
+	if handoff.AccountID.Valid && handoff.AccountID != account.ID {
+		return c.Respond(&tele.CallbackResponse{Text: "Диалог уже взял другой оператор"})
+	}
+	h.assignHandoff(ctx, handoff, account.ID)
+	if _, err := c.Bot().Send(c.Chat(), "👤 "+accountName(account)+" ведет диалог", &tele.SendOptions{ReplyTo: c.Callback().Message}); err != nil {
+		logger.WarnContext(ctx, "⚠️ Ошибка отправки", "error", err)
+	}
+	return c.Respond(&tele.CallbackResponse{Text: "Диалог ваш"})
+}
+
+// operatorAccount находит аккаунт CRM сотрудника по его Telegram ID (core_accounts.tg)
+func (h *MessageHandler) operatorAccount(ctx context.Context, user *tele.User) (storage.GetAccountByTelegramRow, error) {
+	account, err := h.queries.GetAccountByTelegram(ctx, storage.GetAccountByTelegramParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: strconv.FormatInt(user.ID, 10),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return account, errOperatorNotLinked
+	}
+	if err != nil {
+		return account, fmt.Errorf("failed to load operator account: %w", err)
+	}
+	return account, nil
+}
+
+func (h *MessageHandler) assignHandoff(ctx context.Context, handoff storage.TelegramHandoff, accountID pgtype.UUID) {
+	if handoff.AccountID.Valid {
+		return
+	}
+	if err := h.queries.AssignHandoff(ctx, storage.AssignHandoffParams{ID: handoff.ID, AccountID: accountID}); err != nil {
+		logger.ErrorContext(ctx, "Failed to assign handoff", "error", err)
+	}
+}
+
+// sendOperatorReply отправляет клиенту ответ оператора из CRM
+func (h *MessageHandler) sendOperatorReply(ctx context.Context, handoff storage.TelegramHandoff, accountID pgtype.UUID, text string) error {
+	if _, err := h.engine.Bot().Send(tele.ChatID(handoff.ChatID), text); err != nil {
+		return err
+	}
+
+	h.assignHandoff(ctx, handoff, accountID)
+	h.logOperatorMessage(ctx, handoff, accountID, text, map[string]interface{}{"source": "crm"})
+
+	if h.settings.HandoffChatID != 0 {
+		// Чтобы в группе сотрудников была видна вся переписка
+		if _, err := h.engine.Bot().Send(tele.ChatID(h.settings.HandoffChatID), "💬 Ответ из CRM:\n"+text); err != nil {
+			logger.WarnContext(ctx, "⚠️ Ошибка отправки", "error", err)
+		}
+	}
+	return nil
+}
+
+// closeHandoff закрывает передачу: клиент получает handoff_closed_message,
+// дальше бот отвечает сам
+func (h *MessageHandler) closeHandoff(ctx context.Context, handoff storage.TelegramHandoff, accountID pgtype.UUID) error {
+	closed, err := h.queries.CloseHandoff(ctx, storage.CloseHandoffParams{ID: handoff.ID, ClosedBy: accountID})
+	if err != nil {
+		return fmt.Errorf("failed to close handoff: %w", err)
+	}
+	if closed == 0 {
+		return nil
+	}
+
+	logger.InfoContext(ctx, "✅ Диалог с оператором закрыт")
+
+	bot := h.engine.Bot()
+	if _, err := bot.Send(tele.ChatID(handoff.ChatID), h.settings.HandoffClosedMessage); err != nil {
+		logger.ErrorContext(ctx, "❌ Ошибка отправки", "error", err)
+	} else {
+		h.logBotMessage(ctx, handoff.ChatID, handoff.TelegramUserID, h.settings.HandoffClosedMessage,
+			map[string]interface{}{"handoff_id": uuidString(handoff.ID)})
+	}
+
+	if h.settings.HandoffChatID != 0 {
+		if _, err := bot.Send(tele.ChatID(h.settings.HandoffChatID), "✅ Диалог закрыт"); err != nil {
+			logger.WarnContext(ctx, "⚠️ Ошибка отправки", "error", err)
+		}
+	}
+	return nil
+}
+
+// logOperatorMessage записывает ответ оператора как сообщение бота с аккаунтом оператора
+func (h *MessageHandler) logOperatorMessage(ctx context.Context, handoff storage.TelegramHandoff, accountID pgtype.UUID, text string, extra map[string]interface{}) {
+	meta := map[string]interface{}{
+		"handoff_id": uuidString(handoff.ID),
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+	metadata, _ := json.Marshal(meta)
+
+	if err := h.queries.LogOperatorMessage(ctx, storage.LogOperatorMessageParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: handoff.TelegramUserID,
+		ChatID:         handoff.ChatID,
+		MessageText:    pgtype.Text{String: text, Valid: true},
+		Metadata:       metadata,
+		AccountID:      accountID,
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to log operator message", "error", err)
+	}
+}
+
+// logBotMessage логирует сообщение бота, отправленное вне обработки апдейта
+func (h *MessageHandler) logBotMessage(ctx context.Context, chatID, userID int64, text string, extra map[string]interface{}) {
+	metadata, _ := json.Marshal(extra)
+	h.queries.LogMessage(ctx, storage.LogMessageParams{
+		ProfileID:      h.botConfig.ProfileID,
+		TelegramUserID: userID,
+		ChatID:         chatID,
+		MessageText:    pgtype.Text{String: text, Valid: true},
+		IsFromBot:      true,
+		Metadata:       metadata,
+	})
+}
+
+// handoffNode - узел workflow "handoff": передает чат оператору.
+//
+//	{"reason": "Вопрос по возврату"}
+//
+// Выполнение идет дальше по графу; обычно узел последний в сценарии.
+type handoffNode struct {
+	h *MessageHandler
+}
+
+func (n *handoffNode) Execute(ctx context.Context, exec *workflow.Execution, node workflow.Node) (workflow.Result, error) {
+	var cfg struct {
+		Reason string `json:"reason"`
+	}
+	if len(node.Config) > 0 {
+		if err := json.Unmarshal(node.Config, &cfg); err != nil {
+			return workflow.Result{}, fmt.Errorf("invalid handoff config: %w", err)
+		}
+	}
+
+	user := &tele.User{ID: exec.UserID}
+	if v, ok := exec.Lookup("first_name"); ok {
+		user.FirstName, _ = v.(string)
+	}
+	if v, ok := exec.Lookup("last_name"); ok {
+		user.LastName, _ = v.(string)
+	}
+	if v, ok := exec.Lookup("username"); ok {
+		user.Username, _ = v.(string)
+	}
+
+	return workflow.Result{}, n.h.startHandoff(ctx, exec.ChatID, user, handoffReasonWorkflow, cfg.Reason)
+}
+
+func withHandoffLogAttrs(ctx context.Context, handoff storage.TelegramHandoff) context.Context {
+	return utils.WithLogAttrs(ctx, "handoff_id", uuidString(handoff.ID), "chat_id", handoff.ChatID)
+}
+
+// messageText - текст или подпись сообщения
+func messageText(msg *tele.Message) string {
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
+
+func telegramUserName(user *tele.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = strconv.FormatInt(user.ID, 10)
+	}
+	return name
+}
+
+func accountName(account storage.GetAccountByTelegramRow) string {
+	if account.Name.Valid && account.Name.String != "" {
+		return account.Name.String
+	}
+	return strings.TrimSpace(account.FirstName.String + " " + account.LastName.String)
+}
+
+func uuidString(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return uuid.UUID(id.Bytes).String()
+}
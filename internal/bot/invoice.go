@@ -0,0 +1,356 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// gatewayTypeTelegram - шлюз профиля, оплата через который идет счетом Telegram
+	gatewayTypeTelegram = "telegram"
+	// credentialTypeTelegramPayments - credentials с токеном платежного провайдера
+	credentialTypeTelegramPayments = "telegram_payments"
+	// paymentMethodTelegram - payments.payment_method платежа, пришедшего через Telegram
+	paymentMethodTelegram = "telegram"
+
+	// Лимиты sendInvoice
+	invoiceTitleLimit       = 32
+	invoiceDescriptionLimit = 255
+)
+
+// Ответы на pre_checkout_query; Telegram показывает их пользователю
+const (
+	checkoutErrUnavailable = "Заказ больше недоступен для оплаты. Оформите его заново."
+	checkoutErrPrice       = "Цена изменилась. Оформите заказ заново."
+	checkoutErrStock       = "К сожалению, товар закончился."
+	checkoutErrInternal    = "Не удалось проверить заказ, попробуйте еще раз."
+)
+
+// errCheckout - заказ нельзя оплатить; текст ошибки уходит пользователю
+type errCheckout string
+
+func (e errCheckout) Error() string { return string(e) }
+
+// providerToken возвращает токен платежного провайдера из credentials бота
+func (h *MessageHandler) providerToken(ctx context.Context) (string, error) {
+	if h.settings.PaymentsCredentialsID == "" {
+		return "", fmt.Errorf("payments_credentials_id is not set in bot settings")
+	}
+
+	var id pgtype.UUID
+	if err := id.Scan(h.settings.PaymentsCredentialsID); err != nil {
+		return "", fmt.Errorf("invalid payments_credentials_id: %w", err)
+	}
+
+	cred, err := h.queries.GetCredential(ctx, storage.GetCredentialParams{ID: id, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("payments credentials not found or inactive")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load payments credentials: %w", err)
+	}
+	if cred.CredentialType != credentialTypeTelegramPayments || cred.Key1.String == "" {
+		return "", fmt.Errorf("credentials %s is not a telegram_payments provider token", cred.Name)
+	}
+	return cred.Key1.String, nil
+}
+
+// sendInvoice отправляет счет Telegram на заказ. Payload счета - id заказа,
+// по нему pre_checkout_query и successful_payment находят заказ
+func (h *MessageHandler) sendInvoice(ctx context.Context, chatID, userID int64, order storage.PaymentOrder, data paymentOrderData, photoURL string) (*tele.Message, error) {
+	token, err := h.providerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	amount, _ := numericToFloat(order.Amount)
+	invoice := tele.Invoice{
+		Title:       truncateRunes(order.Description, invoiceTitleLimit),
+		Description: truncateRunes(invoiceDescription(order, data), invoiceDescriptionLimit),
+		Payload:     uuidString(order.ID),
+		Currency:    data.Currency,
+		Prices:      invoicePrices(order.Description, amount, data.Currency, data.Items),
+		Token:       token,
+	}
+	if photoURL != "" {
+		invoice.Photo = &tele.Photo{File: tele.FromURL(photoURL)}
+	}
+
+	msg, err := h.engine.Bot().Send(tele.ChatID(chatID), &invoice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send invoice: %w", err)
+	}
+
+	h.logBotMessage(ctx, chatID, userID, invoice.Title, map[string]interface{}{
+		"type":             "invoice",
+		"payment_order_id": uuidString(order.ID),
+		"amount":           formatAmount(order.Amount),
+		"currency":         data.Currency,
+	})
+	return msg, nil
+}
+
+func invoiceDescription(order storage.PaymentOrder, data paymentOrderData) string {
+	if len(data.Items) == 0 {
+		return order.Description
+	}
+	lines := make([]string, 0, len(data.Items))
+	for _, item := range data.Items {
+		lines = append(lines, fmt.Sprintf("%s × %d", item.Name, item.Quantity))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// invoicePrices - строки счета по позициям заказа. Если сумма позиций не сходится
+// с суммой заказа (скидка, доставка), счет выставляется одной строкой
+func invoicePrices(label string, amount float64, currency string, items []paymentItem) []tele.Price {
+	total := minorUnits(amount, currency)
+
+	prices := make([]tele.Price, 0, len(items))
+	sum := 0
+	for _, item := range items {
+		price := tele.Price{
+			Label:  truncateRunes(fmt.Sprintf("%s × %d", item.Name, item.Quantity), invoiceTitleLimit),
+			Amount: minorUnits(item.Price*float64(item.Quantity), currency),
+		}
+		prices = append(prices, price)
+		sum += price.Amount
+	}
+	if len(prices) > 0 && sum == total {
+		return prices
+	}
+	return []tele.Price{{Label: truncateRunes(label, invoiceTitleLimit), Amount: total}}
+}
+
+// currencyExponents - валюты, у которых в минимальной единице не 2 знака после
+// запятой (ISO 4217, как в currencies.json Bot API). Остальные - 2 знака
+var currencyExponents = map[string]int{
+	"CLP": 0, "ISK": 0, "JPY": 0, "KRW": 0, "PYG": 0, "UGX": 0, "VND": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// currencyExponent возвращает число знаков после запятой в валюте
+func currencyExponent(currency string) int {
+	if exp, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exp
+	}
+	return 2
+}
+
+// minorUnits переводит сумму в минимальные единицы валюты (копейки, центы; для JPY -
+// целые иены) - так суммы передаются в Bot API
+func minorUnits(amount float64, currency string) int {
+	return int(math.Round(amount * math.Pow10(currencyExponent(currency))))
+}
+
+// fromMinorUnits - обратное к minorUnits: сумма из Bot API в единицах валюты
+func fromMinorUnits(total int, currency string) float64 {
+	return float64(total) / math.Pow10(currencyExponent(currency))
+}
+
+// HandleCheckout отвечает на pre_checkout_query: перед списанием денег проверяет,
+// что заказ еще ждет оплаты, а цены, остатки и места не изменились
+func (h *MessageHandler) HandleCheckout(c tele.Context) error {
+	ctx := requestContext(c)
+	query := c.PreCheckoutQuery()
+	ctx = utils.WithLogAttrs(ctx, "payment_order_id", query.Payload, "user_id", query.Sender.ID)
+
+	err := h.validateCheckout(ctx, query)
+	var reason errCheckout
+	switch {
+	case err == nil:
+		logger.InfoContext(ctx, "💳 Заказ подтвержден к оплате")
+		return c.Accept()
+	case errors.As(err, &reason):
+		logger.WarnContext(ctx, "⚠️ Оплата отклонена", "reason", string(reason))
+		return c.Accept(string(reason))
+	default:
+		logger.ErrorContext(ctx, "❌ Не удалось проверить заказ перед оплатой", "error", err)
+		return c.Accept(checkoutErrInternal)
+	}
+}
+
+func (h *MessageHandler) validateCheckout(ctx context.Context, query *tele.PreCheckoutQuery) error {
+	order, err := h.invoiceOrder(ctx, query.Payload)
+	if err != nil {
+		return err
+	}
+	if order.Status != paymentStatusPending || (order.ExpiresAt.Valid && order.ExpiresAt.Time.Before(time.Now())) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+
+	data := parsePaymentOrderData(order)
+	amount, _ := numericToFloat(order.Amount)
+	if minorUnits(amount, data.Currency) != query.Total || !strings.EqualFold(data.Currency, query.Currency) {
+		return errCheckout(checkoutErrPrice)
+	}
+
+	switch order.EntityType.String {
+	case entityTicketOrder:
+		return h.validateTicketCheckout(ctx, order.EntityID)
+	case entityPackagePurchase:
+		return h.validatePackageCheckout(ctx, order.EntityID)
+	case entityHotelBooking:
+		return h.validateBookingCheckout(ctx, bookingTypeHotel, order.EntityID)
+	case entitySpaceBooking:
+		return h.validateBookingCheckout(ctx, bookingTypeSpaces, order.EntityID)
+	}
+	for _, item := range data.Items {
+		if err := h.validateCheckoutItem(ctx, item, data.Currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCheckoutItem сверяет позицию с текущей ценой и остатком товара (см. productOffer)
+func (h *MessageHandler) validateCheckoutItem(ctx context.Context, item paymentItem, currency string) error {
+	productID, variantID := itemIDs(item)
+	offer, err := h.productOffer(ctx, productID, variantID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return err
+	}
+	if minorUnits(offer.Price, currency) != minorUnits(item.Price, currency) {
+		return errCheckout(checkoutErrPrice)
+	}
+	if !offer.inStock(item.Quantity) {
+		return errCheckout(checkoutErrStock)
+	}
+	return nil
+}
+
+// HandlePayment обрабатывает successful_payment: записывает платеж, отмечает заказ
+// оплаченным и продолжает workflow, который ждал оплату
+func (h *MessageHandler) HandlePayment(c tele.Context) error {
+	ctx := requestContext(c)
+	payment := c.Message().Payment
+	ctx = utils.WithLogAttrs(ctx, "payment_order_id", payment.Payload)
+
+	order, err := h.invoiceOrder(ctx, payment.Payload)
+	if err != nil {
+		return err
+	}
+
+	paid, err := h.markInvoicePaid(ctx, order, payment)
+	if err != nil {
+		return err
+	}
+	if !paid {
+		// Повторный апдейт или заказ уже закрыт другим способом
+		logger.WarnContext(ctx, "⚠️ Оплата пришла по уже закрытому заказу", "status", order.Status)
+		return nil
+	}
+	logger.InfoContext(ctx, "✅ Оплата получена", "amount", formatAmount(order.Amount), "currency", payment.Currency)
+	h.orderPaid(ctx, order)
+
+	resumed := false
+	data := parsePaymentOrderData(order)
+	if data.ExecutionID != "" {
+		var execID pgtype.UUID
+		if err := execID.Scan(data.ExecutionID); err == nil {
+			resumed, err = h.engine.Resume(ctx, execID, workflow.Input{
+				PaymentOrderID: uuidString(order.ID),
+				PaymentStatus:  paymentStatusPaid,
+			})
+			if err != nil {
+				logger.ErrorContext(ctx, "❌ Не удалось продолжить workflow после оплаты", "error", err)
+			}
+		}
+	}
+	if resumed {
+		return nil
+	}
+
+	const text = "✅ Оплата получена, спасибо!"
+	if err := c.Send(text); err != nil {
+		return err
+	}
+	h.logBotMessage(ctx, c.Chat().ID, c.Sender().ID, text, nil)
+	return nil
+}
+
+// markInvoicePaid в одной транзакции создает платеж и отмечает заказ оплаченным.
+// Возвращает false, если заказ уже не ждал оплаты
+func (h *MessageHandler) markInvoicePaid(ctx context.Context, order storage.PaymentOrder, payment *tele.Payment) (bool, error) {
+	externalData, _ := json.Marshal(map[string]interface{}{
+		"telegram_payment_charge_id": payment.TelegramChargeID,
+		"provider_payment_charge_id": payment.ProviderChargeID,
+		"currency":                   payment.Currency,
+		"total_amount":               payment.Total,
+	})
+
+	paid := false
+	err := pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+
+		paymentID, err := q.CreatePayment(ctx, storage.CreatePaymentParams{
+			ProfileID:     order.ProfileID,
+			CustomerID:    order.CustomerID,
+			EntityType:    order.EntityType,
+			EntityID:      order.EntityID,
+			Amount:        numericFromFloat(fromMinorUnits(payment.Total, payment.Currency)),
+			PaymentMethod: paymentMethodTelegram,
+			Status:        "completed",
+			ExternalID:    optionalText(payment.ProviderChargeID),
+			ExternalData:  externalData,
+			Description:   optionalText(order.Description),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+
+		updated, err := q.MarkPaymentOrderPaid(ctx, storage.MarkPaymentOrderPaidParams{
+			ID:           order.ID,
+			PaymentID:    paymentID,
+			ExternalID:   optionalText(payment.TelegramChargeID),
+			ExternalData: externalData,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to mark payment order paid: %w", err)
+		}
+		if updated == 0 {
+			// Откатываем платеж: заказ уже закрыт
+			return errPaymentOrderClosed
+		}
+		paid = true
+		return nil
+	})
+	if errors.Is(err, errPaymentOrderClosed) {
+		return false, nil
+	}
+	return paid, err
+}
+
+var errPaymentOrderClosed = errors.New("payment order is not pending")
+
+// invoiceOrder загружает заказ профиля по payload счета
+func (h *MessageHandler) invoiceOrder(ctx context.Context, payload string) (storage.PaymentOrder, error) {
+	var id pgtype.UUID
+	if err := id.Scan(payload); err != nil {
+		return storage.PaymentOrder{}, errCheckout(checkoutErrUnavailable)
+	}
+
+	order, err := h.queries.GetPaymentOrder(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && order.ProfileID != h.botConfig.ProfileID) {
+		return storage.PaymentOrder{}, errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return storage.PaymentOrder{}, fmt.Errorf("failed to load payment order: %w", err)
+	}
+	return order, nil
+}
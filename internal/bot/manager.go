@@ -3,21 +3,39 @@ package bot
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
 
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
 	"github.com/botjoker/sambacrm-business-tg/internal/storage"
 	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
 	tele "gopkg.in/telebot.v3"
 )
 
 // Manager управляет множеством ботов
 type Manager struct {
+	cfg     *config.Config
 	pool    *pgxpool.Pool
 	queries *storage.Queries
-	bots    map[uuid.UUID]*BotInstance // key = bot_id
-	mu      sync.RWMutex
+	tasks   *asynq.Client
+	redis   *redis.Client
+	bots    map[uuid.UUID]*supervisor // key = bot_id
+	// failed - боты, выключенные из-за фатальной ошибки (например, отозван токен)
+	failed map[uuid.UUID]*botStatus
+	mu     sync.RWMutex
+
+	// replicaID - идентификатор этой реплики при распределении ботов (см. cluster.go)
+	replicaID string
+	// clusterDone закрывается, когда цикл распределения ботов и продление leases завершены
+	clusterDone chan struct{}
+	// stopping - боты, которые останавливаются в фоне (см. stopAsync);
+	// true - lease еще наш и освобождается после остановки поллера
+	stopping map[uuid.UUID]bool
+	stops    sync.WaitGroup
+	// inspector переносит задачи из очередей остановленных реплик (см. cluster.go)
+	inspector *asynq.Inspector
 }
 
 // BotInstance - один запущенный бот
@@ -27,18 +45,38 @@ type BotInstance struct {
 	Bot       *tele.Bot
 	Config    storage.TelegramBot
 	Handler   *MessageHandler
+	ctx       context.Context
 	cancel    context.CancelFunc
+	redis     *redis.Client
+	chats     *chatQueue
+	// poller получает апдейты в updates; его запускает supervisor
+	poller  *statusPoller
+	updates chan tele.Update
+	// done закрывается, когда все полученные апдейты переданы обработчикам
+	done chan struct{}
+	// status - состояние для админки (см. status.go)
+	status *botStatus
 }
 
-func NewManager(pool *pgxpool.Pool, queries *storage.Queries) *Manager {
+func NewManager(cfg *config.Config, pool *pgxpool.Pool, queries *storage.Queries, tasks *asynq.Client, inspector *asynq.Inspector, redisClient *redis.Client) *Manager {
 	return &Manager{
+		cfg:     cfg,
 		pool:    pool,
 		queries: queries,
-		bots:    make(map[uuid.UUID]*BotInstance),
+		tasks:   tasks,
+		redis:   redisClient,
+		bots:    make(map[uuid.UUID]*supervisor),
+		failed:  make(map[uuid.UUID]*botStatus),
+
+		stopping: make(map[uuid.UUID]bool),
+
+		replicaID: newReplicaID(cfg.Cluster.ReplicaID),
+		inspector: inspector,
 	}
 }
 
-// LoadAndStartBots загружает всех активных ботов из БД и запускает их
+// LoadAndStartBots загружает всех активных ботов из БД и запускает их.
+// Боты, которые не удалось запустить сразу, перезапускает их supervisor.
 func (m *Manager) LoadAndStartBots(ctx context.Context) error {
 	bots, err := m.queries.GetAllActiveBots(ctx)
 	if err != nil {
@@ -47,59 +85,80 @@ func (m *Manager) LoadAndStartBots(ctx context.Context) error {
 
 	for _, botConfig := range bots {
 		if err := m.StartBot(ctx, botConfig); err != nil {
-			username := ""
-			if botConfig.BotUsername.Valid {
-				username = botConfig.BotUsername.String
-			}
-			log.Printf("❌ Не удалось запустить бота (@%s): %v", username, err)
-			continue
+			logger.ErrorContext(configLogContext(ctx, botConfig), "❌ Не удалось запустить бота", "username", botConfig.BotUsername.String, "error", err)
 		}
-		username := ""
-		if botConfig.BotUsername.Valid {
-			username = botConfig.BotUsername.String
-		}
-		log.Printf("✅ Запущен бот @%s", username)
 	}
 
 	return nil
 }
 
-// StartBot запускает одного бота
+// StartBot запускает supervisor бота: он подключается к Telegram и держит бота
+// запущенным (см. supervisor.go). Ошибки подключения не возвращаются - они
+// повторяются с паузой и видны в статусе бота.
 func (m *Manager) StartBot(parentCtx context.Context, config storage.TelegramBot) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Конвертируем UUID из pgtype.UUID в uuid.UUID
-	var botID uuid.UUID
-	copy(botID[:], config.ID.Bytes[:])
+	botID := uuid.UUID(config.ID.Bytes)
 
 	// Проверяем что бот еще не запущен
 	if _, exists := m.bots[botID]; exists {
 		return fmt.Errorf("bot %s already running", botID)
 	}
+	delete(m.failed, botID)
+
+	s := newSupervisor(m, parentCtx, config)
+	m.bots[botID] = s
+	go s.run()
 
-	// Создаем Telegram бота
+	return nil
+}
+
+// newInstance создает бота: проверяет токен (getMe) и регистрирует обработчики.
+// Апдейты начинают поступать, когда supervisor запустит поллер.
+func (m *Manager) newInstance(parentCtx context.Context, config storage.TelegramBot, status *botStatus) (*BotInstance, error) {
+	botID := uuid.UUID(config.ID.Bytes)
+	profileID := uuid.UUID(config.ProfileID.Bytes)
+	logCtx := configLogContext(context.Background(), config)
+
+	client := newBotHTTPClient(botID.String(), profileID.String())
+	poller := &statusPoller{status: status, client: client, logCtx: logCtx}
+
+	// Создаем Telegram бота.
+	// Synchronous: апдейты разбираются по порядку, а параллельность
+	// между чатами обеспечивает middleware serializeChat
 	pref := tele.Settings{
-		Token: config.BotToken,
-		Poller: &tele.LongPoller{
-			Timeout: 10,
+		Token:       config.BotToken,
+		Poller:      poller,
+		Client:      client,
+		Synchronous: true,
+		OnError: func(err error, c tele.Context) {
+			status.setError(err)
+
+			ctx := logCtx
+			if c != nil {
+				ctx = requestContext(c)
+			}
+			logger.ErrorContext(ctx, "❌ Ошибка бота", "error", err)
 		},
 	}
 
 	bot, err := tele.NewBot(pref)
 	if err != nil {
-		return fmt.Errorf("failed to create bot: %w", err)
+		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
 
-	// Создаем контекст для этого бота
-	ctx, cancel := context.WithCancel(parentCtx)
+	// Username и имя - из getMe (сохраняются в БД при синхронизации профиля, см. profile.go)
+	config = applyTelegramProfile(config, bot.Me)
+	status.setUsername(config.BotUsername.String)
 
-	// Конвертируем ProfileID из pgtype.UUID в uuid.UUID
-	var profileID uuid.UUID
-	copy(profileID[:], config.ProfileID.Bytes[:])
+	// Контекст бота - родитель контекстов обработчиков. Отмена parentCtx (остановка
+	// цикла распределения) его не отменяет: бот останавливается через StopBot/Shutdown,
+	// которые сначала дожидаются обработчиков
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parentCtx))
 
 	// Создаем handler для сообщений
-	handler := NewMessageHandler(m.pool, m.queries, config)
+	handler := NewMessageHandler(m.pool, m.queries, config, m.cfg.AI, m.cfg.Payments, bot, m.tasks)
 
 	instance := &BotInstance{
 		BotID:     botID,
@@ -107,60 +166,159 @@ func (m *Manager) StartBot(parentCtx context.Context, config storage.TelegramBot
 		Bot:       bot,
 		Config:    config,
 		Handler:   handler,
+		ctx:       ctx,
 		cancel:    cancel,
+		redis:     m.redis,
+		chats:     newChatQueue(),
+		poller:    poller,
+		updates:   make(chan tele.Update, cap(bot.Updates)),
+		done:      make(chan struct{}),
+		status:    status,
 	}
 
 	// Регистрируем обработчики
 	instance.registerHandlers()
 
-	// Запускаем в отдельной горутине
-	go func() {
-		bot.Start()
-		<-ctx.Done()
-		bot.Stop()
-	}()
-
-	m.bots[botID] = instance
-
-	return nil
+	return instance, nil
 }
 
 // registerHandlers регистрирует обработчики сообщений
 func (b *BotInstance) registerHandlers() {
+	// Проверка перед списанием (см. invoice.go) регистрируется до общих middleware:
+	// Telegram ждет ответа не дольше 10 секунд, поэтому она не встает в очередь чата.
+	// HandleCheckout только читает заказ, порядок с другими апдейтами ему не нужен
+	b.Bot.Handle(tele.OnCheckout, b.Handler.HandleCheckout, b.unordered, b.withRequestContext, b.observeHandler)
+
+	// Должен быть подключен до регистрации остальных обработчиков
+	b.Bot.Use(b.serializeChat, b.withRequestContext, b.observeHandler, b.handoffGate)
+
 	// Команды
 	b.Bot.Handle("/start", b.Handler.HandleStart)
 	b.Bot.Handle("/help", b.Handler.HandleHelp)
 
+	// Команды сотрудников (см. admin.go); для клиентов это обычный текст
+	b.Bot.Handle("/admin", b.Handler.staffOnly("", b.Handler.HandleAdmin))
+	b.Bot.Handle("/stats", b.Handler.staffOnly(permTelegramStats, b.Handler.HandleStats))
+	b.Bot.Handle("/leads", b.Handler.staffOnly(permTelegramLeads, b.Handler.HandleLeads))
+	b.Bot.Handle("/broadcast", b.Handler.staffOnly(permTelegramBroadcast, b.Handler.HandleBroadcast))
+	b.Bot.Handle("/notifications", b.Handler.staffOnly(permTelegramNotifications, b.Handler.HandleNotifications))
+
+	// Каталог и корзина (см. catalog.go); без catalog_enabled - обычный текст.
+	// /promo работает и для билетов (см. tickets.go)
+	b.Bot.Handle("/catalog", b.Handler.featureOnly(catalogEnabled, b.Handler.HandleCatalog))
+	b.Bot.Handle("/cart", b.Handler.featureOnly(catalogEnabled, b.Handler.HandleCart))
+	b.Bot.Handle("/promo", b.Handler.featureOnly(promoEnabled, b.Handler.HandlePromo))
+
+	// Мероприятия и билеты (см. events.go); без events_enabled - обычный текст
+	b.Bot.Handle("/events", b.Handler.featureOnly(eventsEnabled, b.Handler.HandleEvents))
+
+	// Обучение: занятия, баланс, абонементы (см. education.go); без education_enabled - обычный текст
+	b.Bot.Handle("/lessons", b.Handler.featureOnly(educationEnabled, b.Handler.HandleLessons))
+	b.Bot.Handle("/balance", b.Handler.featureOnly(educationEnabled, b.Handler.HandleBalance))
+	b.Bot.Handle("/packages", b.Handler.featureOnly(educationEnabled, b.Handler.HandlePackages))
+
+	// Бронирование номеров и помещений (см. booking.go); без booking_enabled - обычный текст
+	b.Bot.Handle("/book", b.Handler.featureOnly(bookingEnabled, b.Handler.HandleBook))
+
 	// Любой текст
 	b.Bot.Handle(tele.OnText, b.Handler.HandleText)
 
+	// Голосовые сообщения и видео-кружки
+	b.Bot.Handle(tele.OnVoice, b.Handler.HandleVoice)
+	b.Bot.Handle(tele.OnVideoNote, b.Handler.HandleVoice)
+
+	// Фото и документы (изображения уходят в AI при включенном vision)
+	b.Bot.Handle(tele.OnPhoto, b.Handler.HandlePhoto)
+	b.Bot.Handle(tele.OnDocument, b.Handler.HandlePhoto)
+
+	// Контакт (кнопка "Отправить номер")
+	b.Bot.Handle(tele.OnContact, b.Handler.HandleContact)
+
 	// Callback от inline кнопок
 	b.Bot.Handle(tele.OnCallback, b.Handler.HandleCallback)
+
+	// Успешная оплата счета Telegram (см. invoice.go)
+	b.Bot.Handle(tele.OnPayment, b.Handler.HandlePayment)
+}
+
+// run передает обработчикам апдейты от поллера, пока supervisor не закроет канал.
+// Используется вместо bot.Start(): bot.Stop() обрывает все текущие запросы к Bot API,
+// в том числе ответы обработчиков, которые при остановке нужно дождаться.
+func (b *BotInstance) run() {
+	defer close(b.done)
+
+	for update := range b.updates {
+		b.Bot.ProcessUpdate(update)
+	}
+}
+
+// drain ждет обработку уже полученных апдейтов, включая запросы к AI.
+// Поллер к этому моменту должен быть остановлен. Если ctx истек раньше,
+// контекст бота отменяется, и оставшиеся обработчики прерываются.
+func (b *BotInstance) drain(ctx context.Context) {
+	<-b.done
+
+	drained := make(chan struct{})
+	go func() {
+		b.chats.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		logger.WarnContext(b.logContext(), "⚠️ Обработчики не завершились вовремя, прерываем")
+		b.cancel()
+		<-drained
+	}
+
+	b.cancel()
 }
 
-// StopBot останавливает конкретного бота
+// StopBot останавливает конкретного бота, дожидаясь обработки полученных апдейтов
+// (не дольше SHUTDOWN_TIMEOUT)
 func (m *Manager) StopBot(botID uuid.UUID) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	s, exists := m.bots[botID]
+	delete(m.bots, botID)
+	delete(m.failed, botID)
+	m.mu.Unlock()
 
-	if instance, exists := m.bots[botID]; exists {
-		instance.cancel()
-		delete(m.bots, botID)
-		log.Printf("🛑 Остановлен бот %s", botID)
+	if exists {
+		ctx, cancel := context.WithTimeout(context.Background(), m.cfg.Shutdown.Timeout)
+		defer cancel()
+		s.stop(ctx)
 	}
 }
 
-// StopAll останавливает всех ботов
-func (m *Manager) StopAll() {
+// Shutdown останавливает всех ботов и освобождает их для других реплик.
+// Контекст, переданный в RunCluster, должен быть уже отменен. Боты
+// останавливаются параллельно; ctx ограничивает ожидание обработчиков.
+func (m *Manager) Shutdown(ctx context.Context) {
+	if m.clusterDone != nil {
+		<-m.clusterDone
+	}
+
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	bots := m.bots
+	m.bots = make(map[uuid.UUID]*supervisor)
+	m.mu.Unlock()
 
-	for botID, instance := range m.bots {
-		instance.cancel()
-		log.Printf("🛑 Остановлен бот %s", botID)
+	var wg sync.WaitGroup
+	ids := make([]uuid.UUID, 0, len(bots))
+	for botID, s := range bots {
+		ids = append(ids, botID)
+		wg.Add(1)
+		go func(s *supervisor) {
+			defer wg.Done()
+			s.stop(ctx)
+		}(s)
 	}
+	wg.Wait()
+	// Боты, которые уже останавливались в фоне, сами освобождают свои leases
+	m.stops.Wait()
 
-	m.bots = make(map[uuid.UUID]*BotInstance)
+	m.leaveCluster(ids)
 }
 
 // ActiveBotsCount возвращает количество активных ботов
@@ -170,10 +328,28 @@ func (m *Manager) ActiveBotsCount() int {
 	return len(m.bots)
 }
 
-// GetBot возвращает инстанс бота по bot_id
+// ReplicaID возвращает идентификатор этой реплики
+func (m *Manager) ReplicaID() string {
+	return m.replicaID
+}
+
+// GetBot возвращает подключенный инстанс бота по bot_id
 func (m *Manager) GetBot(botID uuid.UUID) (*BotInstance, bool) {
+	m.mu.RLock()
+	s, exists := m.bots[botID]
+	m.mu.RUnlock()
+	if !exists {
+		return nil, false
+	}
+
+	instance := s.current()
+	return instance, instance != nil
+}
+
+// supervised проверяет, что бот запущен на этой реплике (возможно, еще подключается)
+func (m *Manager) supervised(botID uuid.UUID) (*supervisor, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	instance, exists := m.bots[botID]
-	return instance, exists
+	s, exists := m.bots[botID]
+	return s, exists
 }
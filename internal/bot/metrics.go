@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/metrics"
+	tele "gopkg.in/telebot.v3"
+)
+
+// botHTTPTimeout - таймаут запросов к Bot API (как у клиента telebot по умолчанию)
+const botHTTPTimeout = time.Minute
+
+// newBotHTTPClient возвращает HTTP клиент бота, который считает отправленные сообщения.
+// Все отправки (обработчики, workflow, очередь) проходят через него.
+func newBotHTTPClient(botID, profileID string) *http.Client {
+	return &http.Client{
+		Timeout: botHTTPTimeout,
+		Transport: &sendCounter{
+			next:      http.DefaultTransport,
+			botID:     botID,
+			profileID: profileID,
+		},
+	}
+}
+
+// sendCounter - RoundTripper, считающий вызовы send*/copyMessage/forwardMessage
+type sendCounter struct {
+	next      http.RoundTripper
+	botID     string
+	profileID string
+}
+
+func (t *sendCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	if !isSendMethod(method) {
+		return t.next.RoundTrip(req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		metrics.MessagesFailed.WithLabelValues(t.botID, t.profileID, method).Inc()
+	} else {
+		metrics.MessagesSent.WithLabelValues(t.botID, t.profileID, method).Inc()
+	}
+	return resp, err
+}
+
+func isSendMethod(method string) bool {
+	return strings.HasPrefix(method, "send") && method != "sendChatAction" ||
+		method == "copyMessage" || method == "forwardMessage"
+}
+
+// observeHandler - middleware: время обработки апдейта.
+// Подключается после serializeChat, поэтому ожидание в очереди чата не учитывается.
+func (b *BotInstance) observeHandler(next tele.HandlerFunc) tele.HandlerFunc {
+	botID, profileID := b.BotID.String(), b.ProfileID.String()
+
+	return func(c tele.Context) error {
+		started := time.Now()
+		err := next(c)
+		metrics.HandlerDuration.WithLabelValues(botID, profileID).Observe(time.Since(started).Seconds())
+		return err
+	}
+}
+
+// RegisterMetrics обновляет tg_bot_up перед каждой выдачей /metrics
+func (m *Manager) RegisterMetrics(registry *metrics.Registry) {
+	registry.OnScrape(func() {
+		err := metrics.Refill(metrics.BotUp, func(set func(float64, ...string) error) error {
+			for _, status := range m.BotStatuses() {
+				up := 1.0
+				if status.State == BotStateFailed {
+					up = 0
+				}
+				if err := set(up, status.BotID.String(), status.ProfileID.String(), status.Username); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("❌ Не удалось обновить tg_bot_up", "error", err)
+		}
+	})
+}
@@ -0,0 +1,750 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Статусы заказа на оплату (payment_orders.status)
+const (
+	paymentStatusPending = "pending"
+	paymentStatusPaid    = "paid"
+	paymentStatusFailed  = "failed"
+	paymentStatusExpired = "expired"
+)
+
+// Исходы узла payment; оплаченный заказ идет по обычному пути
+const (
+	outcomePaymentFailed  = "failed"
+	outcomePaymentExpired = "expired"
+)
+
+const (
+	// defaultPaymentExpiry - сколько ждать оплату, если expires_minutes не задан
+	defaultPaymentExpiry = time.Hour
+	// defaultPaymentVariable - переменная с данными заказа
+	defaultPaymentVariable = "payment"
+)
+
+// Способы оплаты: ссылка на страницу оплаты или счет Telegram (см. invoice.go)
+const (
+	paymentMethodLink    = "link"
+	paymentMethodInvoice = "invoice"
+)
+
+// paymentConfig - конфигурация узла payment: создать заказ на оплату и дождаться ее.
+//
+//	{
+//	  "product_id": "2f6c...",
+//	  "quantity": 1,
+//	  "amount_variable": "total",
+//	  "description": "Заказ для {{first_name}}",
+//	  "text": "К оплате {{payment.amount}} ₽",
+//	  "button_text": "💳 Оплатить",
+//	  "expires_minutes": 60
+//	}
+//
+// Сумма - amount, переменная amount_variable или цена товара product_id, умноженная
+// на quantity. Заказ создается для клиента CRM, связанного с пользователем
+// (telegram_customer_links), в активном шлюзе профиля (или gateway_id). Кнопка
+// ведет на страницу оплаты (PAYMENT_PAGE_URL); method "invoice" или шлюз с типом
+// "telegram" - вместо ссылки счет Telegram. После оплаты выполнение идет дальше,
+// при ошибке оплаты - по исходу "failed", если заказ не оплачен за expires_minutes -
+// по исходу "expired". Данные заказа доступны как {{payment.order_id}},
+// {{payment.amount}}, {{payment.url}}, {{payment.status}}.
+type paymentConfig struct {
+	Amount         float64 `json:"amount"`
+	AmountVariable string  `json:"amount_variable"`
+	ProductID      string  `json:"product_id"`
+	Quantity       int     `json:"quantity"`
+	Description    string  `json:"description"`
+	GatewayID      string  `json:"gateway_id"`
+	Method         string  `json:"method"`
+	ExpiresMinutes int     `json:"expires_minutes"`
+
+	Text        string `json:"text"`
+	ParseMode   string `json:"parse_mode"`
+	ButtonText  string `json:"button_text"`
+	PaidText    string `json:"paid_text"`
+	FailedText  string `json:"failed_text"`
+	ExpiredText string `json:"expired_text"`
+	Variable    string `json:"variable"`
+}
+
+func parsePaymentConfig(node workflow.Node) (paymentConfig, error) {
+	var cfg paymentConfig
+	if err := json.Unmarshal(node.Config, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid payment config: %w", err)
+	}
+	if cfg.Text == "" {
+		cfg.Text = "К оплате: {{payment.amount}} ₽"
+	}
+	if cfg.ButtonText == "" {
+		cfg.ButtonText = "💳 Оплатить"
+	}
+	if cfg.PaidText == "" {
+		cfg.PaidText = "✅ Оплата получена, спасибо!"
+	}
+	if cfg.FailedText == "" {
+		cfg.FailedText = "Оплата не прошла. Попробуйте еще раз или выберите другой способ оплаты."
+	}
+	if cfg.ExpiredText == "" {
+		cfg.ExpiredText = "Время на оплату истекло."
+	}
+	if cfg.Variable == "" {
+		cfg.Variable = defaultPaymentVariable
+	}
+	if cfg.Quantity <= 0 {
+		cfg.Quantity = 1
+	}
+	return cfg, nil
+}
+
+// paymentItem - позиция заказа; перед оплатой счета Telegram по позициям
+// проверяются текущие цены и остатки
+type paymentItem struct {
+	ProductID string  `json:"product_id"`
+	VariantID string  `json:"variant_id,omitempty"`
+	Name      string  `json:"name"`
+	Quantity  int     `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+// paymentOrderData - payment_orders.form_data заказа, созданного ботом
+type paymentOrderData struct {
+	Source      string        `json:"source"`
+	BotID       string        `json:"bot_id"`
+	ChatID      int64         `json:"chat_id"`
+	ExecutionID string        `json:"execution_id,omitempty"`
+	Method      string        `json:"method"`
+	Currency    string        `json:"currency"`
+	Items       []paymentItem `json:"items,omitempty"`
+	// Примененный промокод; использование учитывается после оплаты (см. orderPaid)
+	PromoCodeID string  `json:"promo_code_id,omitempty"`
+	PromoCode   string  `json:"promo_code,omitempty"`
+	Discount    float64 `json:"discount,omitempty"`
+}
+
+func parsePaymentOrderData(order storage.PaymentOrder) paymentOrderData {
+	var data paymentOrderData
+	if len(order.FormData) > 0 {
+		json.Unmarshal(order.FormData, &data)
+	}
+	return data
+}
+
+// paymentRequest - что и как оплачивается
+type paymentRequest struct {
+	Amount      float64
+	Description string
+	// EntityType / EntityID - что оплачивается (товар, билет, бронь)
+	EntityType  string
+	EntityID    pgtype.UUID
+	Items       []paymentItem
+	Currency    string
+	PhotoURL    string
+	GatewayID   pgtype.UUID
+	Method      string
+	Expiry      time.Duration
+	ExecutionID pgtype.UUID
+	Promo       *promoDiscount
+}
+
+// paymentNode - узел workflow "payment"
+type paymentNode struct {
+	h *MessageHandler
+}
+
+func (n *paymentNode) Execute(ctx context.Context, exec *workflow.Execution, node workflow.Node) (workflow.Result, error) {
+	cfg, err := parsePaymentConfig(node)
+	if err != nil {
+		return workflow.Result{}, err
+	}
+
+	req, err := n.h.paymentRequest(ctx, exec, cfg)
+	if err != nil {
+		return workflow.Result{}, err
+	}
+
+	order, data, err := n.h.createPaymentOrder(ctx, customerFromExecution(exec), exec.ChatID, req)
+	if err != nil {
+		return workflow.Result{}, err
+	}
+	ctx = utils.WithLogAttrs(ctx, "payment_order_id", uuidString(order.ID))
+
+	url := ""
+	if data.Method == paymentMethodLink {
+		if url, err = n.h.setPaymentURL(ctx, order); err != nil {
+			return workflow.Result{}, err
+		}
+	}
+	setPaymentVariables(exec, cfg.Variable, order, url)
+
+	var msg *tele.Message
+	if data.Method == paymentMethodInvoice {
+		msg, err = n.h.sendInvoice(ctx, exec.ChatID, exec.UserID, order, data, req.PhotoURL)
+	} else {
+		msg, err = n.h.engine.SendMessage(ctx, exec, workflow.SendMessageConfig{
+			Text:           cfg.Text,
+			ParseMode:      cfg.ParseMode,
+			InlineKeyboard: [][]workflow.ButtonConfig{{{Text: cfg.ButtonText, URL: url}}},
+		})
+	}
+	if err != nil {
+		return workflow.Result{}, err
+	}
+
+	wait := &workflow.Wait{Kind: "payment", PaymentOrderID: uuidString(order.ID), Token: uuid.NewString()}
+	if msg != nil {
+		wait.MessageID = msg.ID
+	}
+
+	if err := n.h.engine.ScheduleTimeout(ctx, exec, wait.Token, time.Until(order.ExpiresAt.Time)); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось запланировать истечение оплаты", "node", node.NodeKey, "error", err)
+	}
+
+	logger.InfoContext(ctx, "💳 Создан заказ на оплату", "amount", formatAmount(order.Amount), "method", data.Method)
+	return workflow.Result{Wait: wait}, nil
+}
+
+func (n *paymentNode) HandleInput(ctx context.Context, exec *workflow.Execution, node workflow.Node, input workflow.Input) (workflow.Result, bool, error) {
+	if exec.Wait == nil || exec.Wait.PaymentOrderID == "" {
+		return workflow.Result{}, false, nil
+	}
+
+	cfg, err := parsePaymentConfig(node)
+	if err != nil {
+		return workflow.Result{}, true, err
+	}
+
+	status := input.PaymentStatus
+	switch {
+	case input.TimeoutToken != "":
+		if input.TimeoutToken != exec.Wait.Token {
+			return workflow.Result{}, false, nil
+		}
+		if status, err = n.h.expirePaymentOrder(ctx, exec.Wait.PaymentOrderID); err != nil {
+			return workflow.Result{}, true, err
+		}
+	case input.PaymentOrderID != "":
+		if input.PaymentOrderID != exec.Wait.PaymentOrderID {
+			return workflow.Result{}, false, nil
+		}
+	default:
+		// Сообщения пользователя, пока он платит, обрабатываются как обычно
+		return workflow.Result{}, false, nil
+	}
+
+	return n.h.paymentResult(ctx, exec, cfg, status), true, nil
+}
+
+// paymentResult сообщает пользователю итог оплаты и выбирает исход узла
+func (h *MessageHandler) paymentResult(ctx context.Context, exec *workflow.Execution, cfg paymentConfig, status string) workflow.Result {
+	var text, outcome string
+	switch normalizePaymentStatus(status) {
+	case paymentStatusPaid:
+		text = cfg.PaidText
+	case paymentStatusFailed:
+		text, outcome = cfg.FailedText, outcomePaymentFailed
+	case paymentStatusExpired:
+		text, outcome = cfg.ExpiredText, outcomePaymentExpired
+	default:
+		// Промежуточный статус (например, ожидает подтверждения) - ждем дальше
+		return workflow.Result{Wait: exec.Wait}
+	}
+
+	if vars, ok := exec.Variables[cfg.Variable].(map[string]interface{}); ok {
+		vars["status"] = normalizePaymentStatus(status)
+	}
+	if _, err := h.engine.SendMessage(ctx, exec, workflow.SendMessageConfig{Text: text, ParseMode: cfg.ParseMode}); err != nil {
+		logger.ErrorContext(ctx, "Failed to send payment result", "error", err)
+	}
+
+	logger.InfoContext(ctx, "💳 Оплата завершена", "payment_order_id", exec.Wait.PaymentOrderID, "status", status)
+	return workflow.Result{Outcome: outcome}
+}
+
+// normalizePaymentStatus сводит статусы шлюзов к paid / failed / expired / pending
+func normalizePaymentStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "paid", "succeeded", "success", "completed":
+		return paymentStatusPaid
+	case "failed", "cancelled", "canceled", "rejected", "declined":
+		return paymentStatusFailed
+	case "expired":
+		return paymentStatusExpired
+	default:
+		return paymentStatusPending
+	}
+}
+
+// paymentRequest собирает сумму и позиции заказа из конфигурации узла
+func (h *MessageHandler) paymentRequest(ctx context.Context, exec *workflow.Execution, cfg paymentConfig) (paymentRequest, error) {
+	req := paymentRequest{Method: cfg.Method, ExecutionID: exec.ID}
+	if cfg.ExpiresMinutes > 0 {
+		req.Expiry = time.Duration(cfg.ExpiresMinutes) * time.Minute
+	}
+	if cfg.GatewayID != "" {
+		if err := req.GatewayID.Scan(cfg.GatewayID); err != nil {
+			return req, fmt.Errorf("invalid gateway_id %q: %w", cfg.GatewayID, err)
+		}
+	}
+
+	switch {
+	case cfg.ProductID != "":
+		var productID pgtype.UUID
+		if err := productID.Scan(workflow.Render(cfg.ProductID, exec.Variables, "")); err != nil {
+			return req, fmt.Errorf("invalid product_id %q: %w", cfg.ProductID, err)
+		}
+		product, err := h.queries.GetProduct(ctx, storage.GetProductParams{ID: productID, ProfileID: h.botConfig.ProfileID})
+		if err != nil {
+			return req, fmt.Errorf("failed to load product: %w", err)
+		}
+		price, ok := numericToFloat(product.Price)
+		if !ok {
+			return req, fmt.Errorf("product %s has no price", product.Name)
+		}
+
+		req.Amount = price * float64(cfg.Quantity)
+		req.Description = product.Name
+		if cfg.Quantity > 1 {
+			req.Description = fmt.Sprintf("%s × %d", product.Name, cfg.Quantity)
+		}
+		req.EntityType, req.EntityID = "product", productID
+		req.Currency = product.Currency.String
+		req.PhotoURL = product.ImageUrl.String
+		req.Items = []paymentItem{{
+			ProductID: uuidString(productID),
+			Name:      product.Name,
+			Quantity:  cfg.Quantity,
+			Price:     price,
+		}}
+
+	case cfg.AmountVariable != "":
+		value, ok := exec.Lookup(cfg.AmountVariable)
+		if !ok {
+			return req, fmt.Errorf("variable %q is not set", cfg.AmountVariable)
+		}
+		amount, err := toFloat(value)
+		if err != nil {
+			return req, fmt.Errorf("variable %q: %w", cfg.AmountVariable, err)
+		}
+		req.Amount = amount
+
+	default:
+		req.Amount = cfg.Amount
+	}
+
+	if cfg.Description != "" {
+		req.Description = workflow.Render(cfg.Description, exec.Variables, "")
+	}
+	return req, nil
+}
+
+// createPaymentOrder создает заказ на оплату для клиента, связанного с пользователем.
+// Способ оплаты: из запроса, иначе счет Telegram для шлюза "telegram" и ссылка для остальных
+func (h *MessageHandler) createPaymentOrder(ctx context.Context, payer telegramCustomer, chatID int64, req paymentRequest) (storage.PaymentOrder, paymentOrderData, error) {
+	// Нулевая сумма - только у заказа, который целиком покрыт промокодом (см. closeFreeOrder)
+	if req.Amount < 0 || (req.Amount == 0 && req.Promo == nil) {
+		return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("payment amount must be positive, got %v", req.Amount)
+	}
+	if req.Description == "" {
+		req.Description = "Оплата заказа"
+	}
+	if req.Expiry <= 0 {
+		req.Expiry = defaultPaymentExpiry
+	}
+	if req.Currency == "" {
+		req.Currency = h.settings.PaymentsCurrency
+	}
+
+	gateway, err := h.queries.GetActivePaymentGateway(ctx, storage.GetActivePaymentGatewayParams{
+		ProfileID: h.botConfig.ProfileID,
+		GatewayID: req.GatewayID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("profile has no active payment gateway")
+	}
+	if err != nil {
+		return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("failed to load payment gateway: %w", err)
+	}
+
+	method := req.Method
+	if method == "" {
+		method = paymentMethodLink
+		if gateway.GatewayType.String == gatewayTypeTelegram {
+			method = paymentMethodInvoice
+		}
+	}
+	switch method {
+	case paymentMethodLink:
+		if h.payments.PageURL == "" {
+			return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("PAYMENT_PAGE_URL is not configured")
+		}
+	case paymentMethodInvoice:
+	default:
+		return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("unknown payment method %q", method)
+	}
+
+	customerID, err := h.linkedCustomer(ctx, payer)
+	if err != nil {
+		return storage.PaymentOrder{}, paymentOrderData{}, err
+	}
+
+	data := paymentOrderData{
+		Source:      "telegram",
+		BotID:       uuidString(h.botConfig.ID),
+		ChatID:      chatID,
+		ExecutionID: uuidString(req.ExecutionID),
+		Method:      method,
+		Currency:    strings.ToUpper(req.Currency),
+		Items:       req.Items,
+	}
+	if req.Promo != nil {
+		data.PromoCodeID = uuidString(req.Promo.ID)
+		data.PromoCode = req.Promo.Code
+		data.Discount = req.Promo.Amount
+	}
+	formData, _ := json.Marshal(data)
+
+	order, err := h.queries.CreatePaymentOrder(ctx, storage.CreatePaymentOrderParams{
+		ProfileID:        h.botConfig.ProfileID,
+		CustomerID:       customerID,
+		EntityType:       optionalText(req.EntityType),
+		EntityID:         req.EntityID,
+		PaymentGatewayID: gateway.ID,
+		PayerEmail:       optionalText(payer.Email),
+		PayerPhone:       optionalText(payer.Phone),
+		Amount:           numericFromFloat(req.Amount),
+		Description:      req.Description,
+		FormData:         formData,
+		ExpiresAt:        pgtype.Timestamptz{Time: time.Now().Add(req.Expiry), Valid: true},
+	})
+	if err != nil {
+		return storage.PaymentOrder{}, paymentOrderData{}, fmt.Errorf("failed to create payment order: %w", err)
+	}
+	return order, data, nil
+}
+
+// setPaymentURL сохраняет в заказе ссылку на страницу оплаты
+func (h *MessageHandler) setPaymentURL(ctx context.Context, order storage.PaymentOrder) (string, error) {
+	url := strings.ReplaceAll(h.payments.PageURL, "{id}", uuidString(order.ID))
+	if err := h.queries.SetPaymentOrderURL(ctx, storage.SetPaymentOrderURLParams{
+		ID:         order.ID,
+		PaymentUrl: optionalText(url),
+	}); err != nil {
+		return "", fmt.Errorf("failed to save payment url: %w", err)
+	}
+	return url, nil
+}
+
+// offerPayment отправляет пользователю счет Telegram или кнопку оплаты по ссылке
+func (h *MessageHandler) offerPayment(ctx context.Context, c tele.Context, order storage.PaymentOrder, data paymentOrderData, photoURL string) error {
+	if data.Method == paymentMethodInvoice {
+		_, err := h.sendInvoice(ctx, c.Chat().ID, c.Sender().ID, order, data, photoURL)
+		return err
+	}
+
+	url, err := h.setPaymentURL(ctx, order)
+	if err != nil {
+		return err
+	}
+	amount, _ := numericToFloat(order.Amount)
+	text := fmt.Sprintf("Заказ оформлен. К оплате: %s", h.formatPrice(amount, data.Currency))
+	markup := &tele.ReplyMarkup{}
+	markup.InlineKeyboard = [][]tele.InlineButton{{{Text: "💳 Оплатить", URL: url}}}
+	if err := c.Send(text, markup); err != nil {
+		return err
+	}
+	h.logBotMessage(ctx, c.Chat().ID, c.Sender().ID, text, map[string]interface{}{"payment_order_id": uuidString(order.ID)})
+	return nil
+}
+
+// expirePaymentOrder отмечает неоплаченный заказ истекшим. Если статус успел
+// смениться (оплата пришла одновременно с таймаутом), возвращает текущий
+func (h *MessageHandler) expirePaymentOrder(ctx context.Context, orderID string) (string, error) {
+	var id pgtype.UUID
+	if err := id.Scan(orderID); err != nil {
+		return "", fmt.Errorf("invalid payment order id %q: %w", orderID, err)
+	}
+
+	expired, err := h.queries.ExpirePaymentOrder(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to expire payment order: %w", err)
+	}
+	if expired > 0 {
+		return paymentStatusExpired, nil
+	}
+
+	order, err := h.queries.GetPaymentOrder(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to load payment order: %w", err)
+	}
+	return order.Status, nil
+}
+
+func setPaymentVariables(exec *workflow.Execution, variable string, order storage.PaymentOrder, url string) {
+	exec.Set(variable, map[string]interface{}{
+		"order_id": uuidString(order.ID),
+		"amount":   formatAmount(order.Amount),
+		"url":      url,
+		"status":   order.Status,
+	})
+}
+
+// NotifyPaymentStatus ставит задачу смены статуса заказа (см. handlePaymentStatus).
+// Повтор того же события, пока задача в очереди, игнорируется
+func (m *Manager) NotifyPaymentStatus(ctx context.Context, orderID uuid.UUID, status string) error {
+	task, err := queue.NewPaymentStatusTask(queue.PaymentStatusPayload{
+		PaymentOrderID: orderID,
+		Status:         status,
+		Carrier:        tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.tasks.EnqueueContext(ctx, task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to enqueue payment status: %w", err)
+	}
+	return nil
+}
+
+// handlePaymentStatus продолжает workflow, который ждет оплату заказа.
+// Статус берется из БД: в событии он может быть устаревшим
+func (m *Manager) handlePaymentStatus(ctx context.Context, t *asynq.Task) error {
+	var p queue.PaymentStatusPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	order, err := m.queries.GetPaymentOrder(ctx, pgtype.UUID{Bytes: p.PaymentOrderID, Valid: true})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("payment order %s not found: %w", p.PaymentOrderID, asynq.SkipRetry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load payment order: %w", err)
+	}
+
+	// Бот - по ожидающему выполнению workflow или из form_data заказа (корзина)
+	var execution *storage.GetPaymentExecutionRow
+	row, err := m.queries.GetPaymentExecution(ctx, p.PaymentOrderID.String())
+	switch {
+	case err == nil:
+		execution = &row
+	case !errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("failed to load payment execution: %w", err)
+	}
+
+	botID := parsePaymentOrderData(order).BotID
+	if execution != nil {
+		botID = uuidString(execution.BotID)
+	}
+	id, err := uuid.Parse(botID)
+	if err != nil {
+		// Заказ создан не ботом
+		return nil
+	}
+	instance, err := m.taskBot(ctx, t, id)
+	if instance == nil {
+		return err
+	}
+
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"payment_order_id", p.PaymentOrderID.String())
+
+	if normalizePaymentStatus(order.Status) == paymentStatusPaid {
+		instance.Handler.orderPaid(ctx, order)
+	}
+	if execution == nil {
+		// Workflow уже не ждет оплату
+		return nil
+	}
+
+	ctx = utils.WithLogAttrs(ctx, "chat_id", execution.ChatID)
+	return instance.withChatLock(ctx, execution.ChatID, func() error {
+		_, err := instance.Handler.engine.Resume(ctx, execution.ID, workflow.Input{
+			PaymentOrderID: p.PaymentOrderID.String(),
+			PaymentStatus:  order.Status,
+		})
+		return err
+	})
+}
+
+// orderPaid - действия после оплаты заказа: учет промокода, выдача билетов,
+// активация абонемента, подтверждение брони.
+// Может вызываться повторно для того же заказа (вебхук и NOTIFY), поэтому
+// должен быть идемпотентным
+func (h *MessageHandler) orderPaid(ctx context.Context, order storage.PaymentOrder) {
+	h.countPromoUse(ctx, order)
+
+	switch order.EntityType.String {
+	case entityTicketOrder:
+		if err := h.issueTickets(ctx, order.EntityID, uuidString(order.ID)); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось выдать билеты", "error", err)
+		}
+	case entityPackagePurchase:
+		if err := h.activatePackage(ctx, order.EntityID, parsePaymentOrderData(order).ChatID); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось активировать абонемент", "error", err)
+		}
+	case entityHotelBooking, entitySpaceBooking:
+		kind := bookingTypeHotel
+		if order.EntityType.String == entitySpaceBooking {
+			kind = bookingTypeSpaces
+		}
+		if err := h.confirmBooking(ctx, kind, order.EntityID, parsePaymentOrderData(order).ChatID); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось подтвердить бронь", "error", err)
+		}
+	}
+}
+
+// closeFreeOrder отмечает оплаченным заказ с нулевой суммой (промокод покрыл всю
+// сумму) и выполняет действия после оплаты - как если бы оплата пришла от шлюза
+func (h *MessageHandler) closeFreeOrder(ctx context.Context, order storage.PaymentOrder) error {
+	externalData, _ := json.Marshal(map[string]interface{}{"free": true})
+	updated, err := h.queries.MarkPaymentOrderPaid(ctx, storage.MarkPaymentOrderPaidParams{
+		ID:           order.ID,
+		ExternalData: externalData,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark free order paid: %w", err)
+	}
+	if updated > 0 {
+		logger.InfoContext(ctx, "🎁 Бесплатный заказ отмечен оплаченным")
+		h.orderPaid(ctx, order)
+	}
+	return nil
+}
+
+// ListenPaymentEvents слушает PostgreSQL NOTIFY о смене статуса заказов
+// (канал PAYMENT_NOTIFY_CHANNEL), пока ctx не отменен. Payload - id заказа
+// или JSON {"id": "...", "status": "paid"}. Соединение переподключается с паузой.
+func (m *Manager) ListenPaymentEvents(ctx context.Context) {
+	channel := m.cfg.Payments.NotifyChannel
+	if channel == "" {
+		return
+	}
+
+	delay := restartMinDelay
+	for {
+		startedAt := time.Now()
+		err := m.listenPaymentEvents(ctx, channel)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(startedAt) > restartResetAfter {
+			delay = restartMinDelay
+		}
+		logger.WarnContext(ctx, "⚠️ Прервано ожидание статусов оплаты, переподключение", "error", err, "retry_in", delay.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = nextRestartDelay(delay)
+	}
+}
+
+func (m *Manager) listenPaymentEvents(ctx context.Context, channel string) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	defer func() {
+		// Соединение вернется в пул - подписку нужно снять
+		unlistenCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn.Exec(unlistenCtx, "UNLISTEN *")
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "👂 Ожидание статусов оплаты", "channel", channel)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		orderID, status, err := parsePaymentNotification(notification.Payload)
+		if err != nil {
+			logger.WarnContext(ctx, "⚠️ Некорректное уведомление об оплате", "payload", notification.Payload, "error", err)
+			continue
+		}
+		if err := m.NotifyPaymentStatus(ctx, orderID, status); err != nil {
+			logger.ErrorContext(ctx, "❌ Не удалось передать статус оплаты", "payment_order_id", orderID.String(), "error", err)
+		}
+	}
+}
+
+func parsePaymentNotification(payload string) (uuid.UUID, string, error) {
+	payload = strings.TrimSpace(payload)
+	if !strings.HasPrefix(payload, "{") {
+		id, err := uuid.Parse(payload)
+		return id, "", err
+	}
+
+	var body struct {
+		ID     uuid.UUID `json:"id"`
+		Status string    `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(payload), &body); err != nil {
+		return uuid.Nil, "", err
+	}
+	return body.ID, body.Status, nil
+}
+
+// numericFromFloat переводит сумму в numeric с точностью до копеек
+func numericFromFloat(value float64) pgtype.Numeric {
+	var n pgtype.Numeric
+	n.Scan(strconv.FormatFloat(math.Round(value*100)/100, 'f', 2, 64))
+	return n
+}
+
+func numericToFloat(n pgtype.Numeric) (float64, bool) {
+	value, err := n.Float64Value()
+	if err != nil || !value.Valid {
+		return 0, false
+	}
+	return value.Float64, true
+}
+
+// toFloat приводит значение переменной workflow к числу
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(v), ",", "."), 64)
+	default:
+		return 0, fmt.Errorf("not a number: %v", value)
+	}
+}
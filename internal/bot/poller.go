@@ -0,0 +1,140 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// pollTimeout - long polling таймаут getUpdates
+	pollTimeout = 10 * time.Second
+	// pollAckTimeout - сколько ждать подтверждения последних апдейтов при остановке
+	pollAckTimeout = 5 * time.Second
+)
+
+// statusPoller - long poller, который отчитывается о своем состоянии.
+// В отличие от tele.LongPoller он не глотает ошибки getUpdates: poll возвращает
+// первую ошибку, а supervisor решает, перезапустить поллер с паузой или выключить
+// бота (отозванный токен).
+//
+// getUpdates выполняется собственным запросом с контекстом: остановка прерывает
+// long polling сразу и не трогает остальные запросы бота (bot.Stop() отменил бы
+// и отправку ответов обработчиками, которые мы дожидаемся при остановке).
+type statusPoller struct {
+	status       *botStatus
+	client       *http.Client
+	logCtx       context.Context
+	lastUpdateID int
+}
+
+// Poll реализует tele.Poller
+func (p *statusPoller) Poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) {
+	if err := p.poll(b, dest, stop); err != nil {
+		logger.ErrorContext(p.logCtx, "❌ Ошибка getUpdates", "username", b.Me.Username, "error", err)
+	}
+}
+
+// poll получает апдейты до остановки (возвращает nil) или первой ошибки getUpdates
+func (p *statusPoller) poll(b *tele.Bot, dest chan tele.Update, stop chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			p.ack(b)
+			return nil
+		default:
+		}
+
+		updates, err := p.getUpdates(ctx, b, p.lastUpdateID+1, pollTimeout, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return err
+		}
+
+		p.status.polled(len(updates))
+
+		for _, update := range updates {
+			p.lastUpdateID = update.ID
+			dest <- update
+		}
+	}
+}
+
+// ack подтверждает Telegram уже полученные апдейты, чтобы реплика, которая
+// заберет бота, не обработала их повторно
+func (p *statusPoller) ack(b *tele.Bot) {
+	if p.lastUpdateID == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pollAckTimeout)
+	defer cancel()
+
+	// Апдейт, который вернется в ответе, не подтверждается и достанется следующему поллеру
+	if _, err := p.getUpdates(ctx, b, p.lastUpdateID+1, 0, 1); err != nil {
+		logger.WarnContext(p.logCtx, "⚠️ Не удалось подтвердить апдейты", "error", err)
+	}
+}
+
+func (p *statusPoller) getUpdates(ctx context.Context, b *tele.Bot, offset int, timeout time.Duration, limit int) ([]tele.Update, error) {
+	params := map[string]int{
+		"offset":  offset,
+		"timeout": int(timeout / time.Second),
+	}
+	if limit > 0 {
+		params["limit"] = limit
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.URL+"/bot"+b.Token+"/getUpdates", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Ok          bool          `json:"ok"`
+		Code        int           `json:"error_code"`
+		Description string        `json:"description"`
+		Result      []tele.Update `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("telegram: bad getUpdates response (%s): %w", resp.Status, err)
+	}
+	if !result.Ok {
+		// Известные ошибки (например, tele.ErrUnauthorized) - как у клиента telebot
+		if err := tele.Err(result.Description); err != nil {
+			return nil, err
+		}
+		return nil, tele.NewError(result.Code, result.Description)
+	}
+
+	return result.Result, nil
+}
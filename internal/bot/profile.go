@@ -0,0 +1,301 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Меню команд собирается из workflows с триггером command:
+//
+//	{"command": "/catalog", "description": "Каталог", "descriptions": {"en": "Catalog"}, "hidden": false}
+//
+// /start и /help есть в меню всегда. Для каждого языка из descriptions меню
+// отправляется отдельно (setMyCommands с language_code), остальные пользователи
+// видят меню по умолчанию. /help показывает тот же список.
+
+const (
+	// profileSyncTimeout - таймаут запросов к БД при синхронизации профиля
+	profileSyncTimeout = 10 * time.Second
+	// maxMenuCommands - ограничение Bot API на число команд в меню
+	maxMenuCommands = 100
+	// maxCommandDescription - ограничение Bot API на длину описания команды
+	maxCommandDescription = 256
+)
+
+// commandNamePattern - допустимое имя команды в меню Telegram
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// commandTrigger - trigger_config workflow с триггером command
+type commandTrigger struct {
+	Command      string            `json:"command"`
+	Description  string            `json:"description"`
+	Descriptions map[string]string `json:"descriptions"`
+	// Hidden - команда работает, но не показывается в меню и /help
+	Hidden bool `json:"hidden"`
+}
+
+// menuCommand - команда меню (без "/") с описаниями по языкам
+type menuCommand struct {
+	Command      string
+	Description  string
+	Descriptions map[string]string
+}
+
+// builtinCommands - команды с собственными обработчиками
+var builtinCommands = []menuCommand{
+	{Command: "start", Description: "Начать", Descriptions: map[string]string{"en": "Start"}},
+	{Command: "help", Description: "Помощь", Descriptions: map[string]string{"en": "Help"}},
+}
+
+// helpHeaders - заголовок /help по языкам ("" - по умолчанию)
+var helpHeaders = map[string]string{
+	"":   "Доступные команды:",
+	"en": "Available commands:",
+}
+
+// commandMenu - меню команд бота
+type commandMenu struct {
+	commands []menuCommand
+	// languages - языки, для которых в workflows заданы переводы
+	languages []string
+	// invalid - команды, которые нельзя показать в меню Telegram
+	invalid []string
+}
+
+// menuCommands - встроенные команды с учетом включенных в настройках режимов
+func (s BotSettings) menuCommands() []menuCommand {
+	commands := append([]menuCommand{}, builtinCommands...)
+	if s.CatalogEnabled {
+		commands = append(commands, catalogCommands...)
+	}
+	if s.EventsEnabled {
+		commands = append(commands, eventsCommands...)
+	}
+	if s.EducationEnabled {
+		commands = append(commands, educationCommands...)
+	}
+	if s.BookingEnabled {
+		commands = append(commands, bookingCommands...)
+	}
+	return commands
+}
+
+// buildCommandMenu собирает меню из встроенных команд и активных workflows бота
+func buildCommandMenu(builtins []menuCommand, workflows []storage.GetActiveWorkflowsByBotRow) *commandMenu {
+	menu := &commandMenu{}
+	index := make(map[string]int)
+	languages := make(map[string]bool)
+
+	for _, cmd := range builtins {
+		index[cmd.Command] = len(menu.commands)
+		menu.commands = append(menu.commands, cmd)
+	}
+
+	for _, wf := range workflows {
+		if wf.TriggerType != "command" || wf.TriggerConfig == nil {
+			continue
+		}
+
+		var trigger commandTrigger
+		if err := json.Unmarshal(wf.TriggerConfig, &trigger); err != nil || trigger.Hidden {
+			continue
+		}
+
+		name := strings.TrimPrefix(trigger.Command, "/")
+		if !commandNamePattern.MatchString(name) {
+			menu.invalid = append(menu.invalid, trigger.Command)
+			continue
+		}
+
+		description := trigger.Description
+		if description == "" {
+			description = wf.WorkflowName
+		}
+		descriptions := make(map[string]string, len(trigger.Descriptions))
+		for lang, text := range trigger.Descriptions {
+			if lang = normalizeLanguage(lang); lang != "" && text != "" {
+				descriptions[lang] = text
+				languages[lang] = true
+			}
+		}
+
+		// Описание из workflow заменяет описание встроенной команды (/start)
+		if i, exists := index[name]; exists {
+			if trigger.Description != "" || len(descriptions) > 0 {
+				menu.commands[i] = menuCommand{Command: name, Description: description, Descriptions: descriptions}
+			}
+			continue
+		}
+
+		if len(menu.commands) >= maxMenuCommands {
+			menu.invalid = append(menu.invalid, trigger.Command)
+			continue
+		}
+		index[name] = len(menu.commands)
+		menu.commands = append(menu.commands, menuCommand{
+			Command:      name,
+			Description:  description,
+			Descriptions: descriptions,
+		})
+	}
+
+	for lang := range languages {
+		menu.languages = append(menu.languages, lang)
+	}
+	sort.Strings(menu.languages)
+
+	return menu
+}
+
+// forLanguage возвращает команды с описаниями на языке lang ("" - по умолчанию)
+func (menu *commandMenu) forLanguage(lang string) []tele.Command {
+	commands := make([]tele.Command, 0, len(menu.commands))
+	for _, cmd := range menu.commands {
+		description := cmd.Description
+		if text, ok := cmd.Descriptions[lang]; ok {
+			description = text
+		}
+		commands = append(commands, tele.Command{
+			Text:        cmd.Command,
+			Description: truncateRunes(description, maxCommandDescription),
+		})
+	}
+	return commands
+}
+
+// helpText - текст /help на языке пользователя
+func (menu *commandMenu) helpText(lang string) string {
+	lang = normalizeLanguage(lang)
+
+	header, ok := helpHeaders[lang]
+	if !ok {
+		header = helpHeaders[""]
+	}
+
+	lines := []string{header}
+	for _, cmd := range menu.forLanguage(lang) {
+		lines = append(lines, "/"+cmd.Text+" - "+cmd.Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeLanguage приводит language_code к виду Bot API ("en-US" -> "en")
+func normalizeLanguage(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i != -1 {
+		code = code[:i]
+	}
+	return code
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit])
+}
+
+// applyTelegramProfile подставляет username и имя из getMe: в БД может быть
+// устаревшее значение, введенное в CRM
+func applyTelegramProfile(config storage.TelegramBot, me *tele.User) storage.TelegramBot {
+	if me == nil || me.Username == "" {
+		return config
+	}
+	config.BotUsername = pgtype.Text{String: me.Username, Valid: true}
+	config.BotName = pgtype.Text{String: me.FirstName, Valid: me.FirstName != ""}
+	return config
+}
+
+// syncProfile синхронизирует профиль бота с Telegram: сохраняет в БД username и
+// имя из getMe, отправляет меню команд и описания. Ошибки не мешают работе бота.
+func (m *Manager) syncProfile(b *BotInstance, stored storage.TelegramBot) {
+	ctx := b.logContext()
+
+	if stored.BotUsername != b.Config.BotUsername || stored.BotName != b.Config.BotName {
+		dbCtx, cancel := context.WithTimeout(b.ctx, profileSyncTimeout)
+		err := m.queries.UpdateBotProfile(dbCtx, storage.UpdateBotProfileParams{
+			ID:          b.Config.ID,
+			BotUsername: b.Config.BotUsername,
+			BotName:     b.Config.BotName,
+		})
+		cancel()
+		if err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось сохранить профиль бота", "error", err)
+		} else {
+			logger.InfoContext(ctx, "✅ Профиль бота обновлен из Telegram",
+				"username", b.Config.BotUsername.String, "previous_username", stored.BotUsername.String)
+		}
+	}
+
+	m.syncCommands(ctx, b)
+	syncDescriptions(ctx, b.Bot, b.Handler.settings)
+}
+
+// syncCommands отправляет меню команд: по умолчанию и для каждого языка из workflows
+func (m *Manager) syncCommands(ctx context.Context, b *BotInstance) {
+	dbCtx, cancel := context.WithTimeout(b.ctx, profileSyncTimeout)
+	workflows, err := m.queries.GetActiveWorkflowsByBot(dbCtx, b.Config.ID)
+	cancel()
+	if err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось загрузить workflows для меню команд", "error", err)
+		return
+	}
+
+	menu := buildCommandMenu(b.Handler.settings.menuCommands(), workflows)
+	if len(menu.invalid) > 0 {
+		logger.WarnContext(ctx, "⚠️ Команды не попали в меню: допустимы a-z, 0-9 и _, до 32 символов, не больше 100 команд",
+			"commands", menu.invalid)
+	}
+
+	if err := b.Bot.SetCommands(menu.forLanguage("")); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось обновить меню команд", "error", err)
+		return
+	}
+	for _, lang := range menu.languages {
+		if err := b.Bot.SetCommands(menu.forLanguage(lang), lang); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось обновить меню команд", "language", lang, "error", err)
+		}
+	}
+
+	logger.InfoContext(ctx, "✅ Меню команд обновлено", "commands", len(menu.commands), "languages", menu.languages)
+}
+
+// syncDescriptions отправляет описание и короткое описание бота, если они заданы в настройках
+func syncDescriptions(ctx context.Context, bot *tele.Bot, settings BotSettings) {
+	texts := map[string]BotProfileText{
+		"": {Description: settings.Description, ShortDescription: settings.ShortDescription},
+	}
+	for lang, text := range settings.ProfileTranslations {
+		texts[normalizeLanguage(lang)] = text
+	}
+
+	for lang, text := range texts {
+		if text.Description != "" {
+			if _, err := bot.Raw("setMyDescription", profileParams("description", text.Description, lang)); err != nil {
+				logger.WarnContext(ctx, "⚠️ Не удалось обновить описание бота", "language", lang, "error", err)
+			}
+		}
+		if text.ShortDescription != "" {
+			if _, err := bot.Raw("setMyShortDescription", profileParams("short_description", text.ShortDescription, lang)); err != nil {
+				logger.WarnContext(ctx, "⚠️ Не удалось обновить короткое описание бота", "language", lang, "error", err)
+			}
+		}
+	}
+}
+
+func profileParams(field, value, lang string) map[string]string {
+	params := map[string]string{field: value}
+	if lang != "" {
+		params["language_code"] = lang
+	}
+	return params
+}
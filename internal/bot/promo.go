@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Типы скидки промокода (promo_codes.discount_type)
+const (
+	discountTypePercent = "percent"
+	discountTypeFixed   = "fixed"
+)
+
+// errPromo - промокод нельзя применить; текст ошибки уходит пользователю
+type errPromo string
+
+func (e errPromo) Error() string { return string(e) }
+
+// promoDiscount - примененный промокод
+type promoDiscount struct {
+	ID     pgtype.UUID
+	Code   string
+	Amount float64
+}
+
+// promoScope - к чему применяется промокод: билетам (ticket_type_ids) или товарам
+type promoScope struct {
+	TicketTypeID pgtype.UUID
+}
+
+// applyPromo проверяет правила промокода (активность, срок действия, лимит
+// использований, минимальная сумма заказа) и считает скидку для суммы subtotal.
+// Лимит на клиента проверяется, если customerID известен
+func (h *MessageHandler) applyPromo(ctx context.Context, code string, subtotal float64, scope promoScope, customerID pgtype.UUID) (*promoDiscount, error) {
+	promo, err := h.queries.GetPromoCode(ctx, storage.GetPromoCodeParams{
+		ProfileID: h.botConfig.ProfileID,
+		Code:      strings.TrimSpace(code),
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errPromo("Промокод не найден.")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load promo code: %w", err)
+	}
+
+	if promo.IsActive.Valid && !promo.IsActive.Bool {
+		return nil, errPromo("Промокод не найден.")
+	}
+	now := time.Now()
+	if promo.ValidFrom.Valid && now.Before(promo.ValidFrom.Time) {
+		return nil, errPromo("Промокод начнет действовать " + promo.ValidFrom.Time.Local().Format("02.01.2006") + ".")
+	}
+	if promo.ValidUntil.Valid && now.After(promo.ValidUntil.Time) {
+		return nil, errPromo("Срок действия промокода истек.")
+	}
+	if promo.MaxUses.Valid && promo.MaxUses.Int32 > 0 && promo.UsedCount.Int32 >= promo.MaxUses.Int32 {
+		return nil, errPromo("Промокод уже использован максимальное число раз.")
+	}
+	if len(promo.TicketTypeIds) > 0 && !containsUUID(promo.TicketTypeIds, scope.TicketTypeID) {
+		return nil, errPromo("Промокод не действует на этот заказ.")
+	}
+	if minimum, ok := numericToFloat(promo.MinOrderAmount); ok && subtotal < minimum {
+		return nil, errPromo(fmt.Sprintf("Промокод действует для заказа от %.2f.", minimum))
+	}
+
+	if customerID.Valid && promo.MaxUsesPerCustomer.Valid && promo.MaxUsesPerCustomer.Int32 > 0 {
+		used, err := h.queries.CountCustomerPromoUses(ctx, storage.CountCustomerPromoUsesParams{
+			CustomerID:  customerID,
+			PromoCodeID: uuidString(promo.ID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count promo uses: %w", err)
+		}
+		if used >= int64(promo.MaxUsesPerCustomer.Int32) {
+			return nil, errPromo("Вы уже использовали этот промокод.")
+		}
+	}
+
+	value, _ := numericToFloat(promo.DiscountValue)
+	var amount float64
+	switch strings.ToLower(promo.DiscountType) {
+	case discountTypePercent, "percentage":
+		amount = subtotal * value / 100
+	case discountTypeFixed, "amount":
+		amount = value
+	default:
+		return nil, fmt.Errorf("unknown discount type %q", promo.DiscountType)
+	}
+	amount = math.Min(math.Round(amount*100)/100, subtotal)
+
+	return &promoDiscount{ID: promo.ID, Code: promo.Code, Amount: amount}, nil
+}
+
+// countPromoUse учитывает использование промокода оплаченного заказа.
+// Повторный вызов для того же заказа ничего не меняет
+func (h *MessageHandler) countPromoUse(ctx context.Context, order storage.PaymentOrder) {
+	counted, err := h.queries.CountPromoCodeUse(ctx, order.ID)
+	if err != nil {
+		logger.ErrorContext(ctx, "❌ Не удалось учесть использование промокода", "error", err)
+		return
+	}
+	if counted > 0 {
+		logger.InfoContext(ctx, "🏷️ Промокод использован", "payment_order_id", uuidString(order.ID))
+	}
+}
+
+func containsUUID(ids []pgtype.UUID, id pgtype.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Виды напоминаний (queue.ReminderPayload.Kind)
+const (
+	reminderEventRegistration = "event_registration"
+	reminderTicketOrder       = "ticket_order"
+	reminderAppointment       = "appointment"
+	reminderLessonEnrollment  = "lesson_enrollment"
+)
+
+// reminderScanInterval - как часто бот ищет записи и занятия, которым пора
+// поставить напоминание. Ищутся начинающиеся в ближайшие reminder_hours плюс
+// два интервала, поэтому напоминание ставится заранее и не опаздывает
+const reminderScanInterval = 5 * time.Minute
+
+// errReminderStale - напоминание больше не актуально: запись отменена или время
+// начала изменилось
+var errReminderStale = errors.New("reminder is stale")
+
+// reminder - сообщение напоминания
+type reminder struct {
+	ChatID int64
+	Text   string
+	Markup *tele.ReplyMarkup
+}
+
+// scheduleReminder ставит напоминание за before до startsAt. Если это время уже
+// прошло, напоминание не ставится
+func (h *MessageHandler) scheduleReminder(ctx context.Context, kind string, entityID pgtype.UUID, startsAt time.Time, before time.Duration) {
+	at := startsAt.Add(-before)
+	if before <= 0 || !at.After(time.Now()) {
+		return
+	}
+	if h.tasks == nil {
+		logger.WarnContext(ctx, "⚠️ Очередь задач не настроена, напоминание не поставлено", "kind", kind)
+		return
+	}
+
+	task, err := queue.NewReminderTask(queue.ReminderPayload{
+		BotID:    h.botConfig.ID.Bytes,
+		Kind:     kind,
+		EntityID: entityID.Bytes,
+		StartsAt: startsAt,
+		Carrier:  tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	}, at)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to create reminder task", "error", err)
+		return
+	}
+	_, err = h.tasks.EnqueueContext(ctx, task)
+	switch {
+	case errors.Is(err, asynq.ErrTaskIDConflict):
+		// Уже запланировано
+	case err != nil:
+		logger.ErrorContext(ctx, "❌ Не удалось поставить напоминание", "kind", kind, "error", err)
+	default:
+		logger.InfoContext(ctx, "⏰ Напоминание запланировано", "kind", kind, "entity_id", uuidString(entityID), "at", at.Format(time.RFC3339))
+	}
+}
+
+// watchReminders ставит напоминания о предстоящих записях и занятиях клиентов,
+// связанных с Telegram. Если время записи изменили, ставится новое напоминание,
+// а старое при срабатывании будет пропущено (см. handleReminder)
+func (b *BotInstance) watchReminders() {
+	ctx := b.logContext()
+	ticker := time.NewTicker(reminderScanInterval)
+	defer ticker.Stop()
+
+	for {
+		if b.Handler.settings.RemindersEnabled {
+			if err := b.Handler.scheduleUpcomingReminders(b.ctx); err != nil && b.ctx.Err() == nil {
+				logger.ErrorContext(ctx, "❌ Ошибка планирования напоминаний", "error", err)
+			}
+		}
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *MessageHandler) scheduleUpcomingReminders(ctx context.Context) error {
+	before := h.settings.reminderBefore()
+	until := pgtype.Timestamptz{Time: time.Now().Add(before + 2*reminderScanInterval), Valid: true}
+
+	appointments, err := h.queries.ListAppointmentReminders(ctx, storage.ListAppointmentRemindersParams{
+		ProfileID: h.botConfig.ProfileID,
+		Until:     until,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load appointments: %w", err)
+	}
+	for _, appointment := range appointments {
+		h.scheduleReminder(ctx, reminderAppointment, appointment.ID, appointment.StartDatetime.Time, before)
+	}
+
+	lessons, err := h.queries.ListLessonReminders(ctx, storage.ListLessonRemindersParams{
+		ProfileID: h.botConfig.ProfileID,
+		Until:     until,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load lessons: %w", err)
+	}
+	for _, lesson := range lessons {
+		h.scheduleReminder(ctx, reminderLessonEnrollment, lesson.ID, lesson.StartsAt.Time, before)
+	}
+	return nil
+}
+
+// handleReminder отправляет клиенту напоминание. Запись и время начала
+// перечитываются из БД: устаревшее напоминание пропускается
+func (m *Manager) handleReminder(ctx context.Context, t *asynq.Task) error {
+	var p queue.ReminderPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"kind", p.Kind, "entity_id", p.EntityID.String())
+
+	entityID := pgtype.UUID{Bytes: p.EntityID, Valid: true}
+	h := instance.Handler
+	var msg reminder
+	switch p.Kind {
+	case reminderEventRegistration:
+		msg, err = h.eventRegistrationReminder(ctx, entityID, p.StartsAt)
+	case reminderTicketOrder:
+		msg, err = h.ticketOrderReminder(ctx, entityID, p.StartsAt)
+	case reminderAppointment:
+		msg, err = h.appointmentReminder(ctx, entityID, p.StartsAt)
+	case reminderLessonEnrollment:
+		msg, err = h.lessonReminder(ctx, entityID, p.StartsAt)
+	default:
+		return fmt.Errorf("unknown reminder kind %q: %w", p.Kind, asynq.SkipRetry)
+	}
+	if errors.Is(err, errReminderStale) || errors.Is(err, pgx.ErrNoRows) {
+		logger.InfoContext(ctx, "⏰ Напоминание устарело и пропущено")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if msg.ChatID == 0 {
+		logger.WarnContext(ctx, "⚠️ Не найден чат для напоминания")
+		return nil
+	}
+
+	opts := []interface{}{}
+	if msg.Markup != nil {
+		opts = append(opts, msg.Markup)
+	}
+	if _, err := instance.Bot.Send(tele.ChatID(msg.ChatID), msg.Text, opts...); err != nil {
+		return fmt.Errorf("failed to send reminder: %w", err)
+	}
+	h.logBotMessage(ctx, msg.ChatID, msg.ChatID, msg.Text, map[string]interface{}{
+		"reminder":  p.Kind,
+		"entity_id": p.EntityID.String(),
+	})
+	logger.InfoContext(ctx, "⏰ Напоминание отправлено", "chat_id", msg.ChatID)
+	return nil
+}
+
+// sameStart - совпадает ли время начала с тем, под которое ставилось напоминание
+func sameStart(start pgtype.Timestamptz, startsAt time.Time) bool {
+	return start.Valid && start.Time.Unix() == startsAt.Unix()
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// chatLockTTL - время жизни блокировки чата, если процесс упал, не освободив ее
+	chatLockTTL = 30 * time.Second
+	// chatLockWait - сколько ждать блокировку чата, прежде чем обработать апдейт без нее
+	chatLockWait = time.Minute
+)
+
+// serializeChat - middleware: апдейты одного чата обрабатываются строго по очереди.
+// Внутри процесса порядок держит chatQueue, между репликами (например, отложенные
+// задачи Asynq на другом инстансе) - блокировка чата в Redis.
+func (b *BotInstance) serializeChat(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		chatID, ok := chatKey(c)
+		if !ok {
+			b.chats.Go(func() {
+				if err := next(c); err != nil {
+					b.Bot.OnError(err, c)
+				}
+			})
+			return nil
+		}
+
+		b.chats.Dispatch(chatID, func() {
+			ctx := botLogContext(b.ctx, b.BotID.String(), b.ProfileID.String())
+			err := b.withChatLock(ctx, chatID, func() error {
+				return next(c)
+			})
+			if err != nil {
+				b.Bot.OnError(err, c)
+			}
+		})
+		return nil
+	}
+}
+
+// unordered - middleware: апдейт обрабатывается сразу, вне очереди чата и без
+// блокировки чата (для ответов, которые Telegram ждет ограниченное время)
+func (b *BotInstance) unordered(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		b.chats.Go(func() {
+			if err := next(c); err != nil {
+				b.Bot.OnError(err, c)
+			}
+		})
+		return nil
+	}
+}
+
+// withChatLock выполняет fn под распределенной блокировкой чата
+func (b *BotInstance) withChatLock(ctx context.Context, chatID int64, fn func() error) error {
+	if b.redis == nil {
+		return fn()
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, chatLockWait)
+	defer cancel()
+
+	key := fmt.Sprintf("tg:chat-lock:%s:%d", b.BotID, chatID)
+	lock, err := utils.AcquireLock(lockCtx, b.redis, key, chatLockTTL)
+	if err != nil {
+		// Лучше ответить без блокировки, чем не ответить совсем
+		logger.WarnContext(ctx, "⚠️ Не удалось получить блокировку чата", "chat_id", chatID, "error", err)
+		return fn()
+	}
+	defer lock.Release()
+
+	return fn()
+}
+
+// chatKey возвращает чат апдейта (для callback без чата - отправителя)
+func chatKey(c tele.Context) (int64, bool) {
+	if chat := c.Chat(); chat != nil {
+		return chat.ID, true
+	}
+	if sender := c.Sender(); sender != nil {
+		return sender.ID, true
+	}
+	return 0, false
+}
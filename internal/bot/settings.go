@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/google/uuid"
+)
+
+// BotSettings - дополнительные настройки бота из telegram_bots.settings
+type BotSettings struct {
+	// Распознавание изображений (фото и картинки-документы) в AI ответах
+	AIVisionEnabled bool `json:"ai_vision_enabled"`
+	// Максимальный размер изображения в мегабайтах
+	AIVisionMaxImageMB int `json:"ai_vision_max_image_mb"`
+
+	// Что делать, когда исчерпан месячный лимит токенов тарифа:
+	// "message" - отвечать AIQuotaMessage, "fallback" - отвечать дешевой моделью,
+	// "disable" - не отвечать через AI (workflows продолжают работать)
+	AIQuotaAction  string `json:"ai_quota_action"`
+	AIQuotaMessage string `json:"ai_quota_message"`
+	// Модель для режима "fallback" (по умолчанию AI_FALLBACK_MODEL из настроек сервиса)
+	AIFallbackModel string `json:"ai_fallback_model"`
+
+	// Описание бота (текст в пустом чате до /start) и короткое описание (профиль бота).
+	// Отправляются в Telegram при запуске бота; пустые значения не меняют то, что задано в @BotFather
+	Description      string `json:"description"`
+	ShortDescription string `json:"short_description"`
+	// Переводы описаний по коду языка ("en", "uk", ...)
+	ProfileTranslations map[string]BotProfileText `json:"profile_translations"`
+
+	// Передача диалога оператору (см. handoff.go): по ключевым словам клиента
+	// и, если включено HandoffAI, по решению AI. Узел workflow "handoff" работает всегда
+	HandoffEnabled  bool     `json:"handoff_enabled"`
+	HandoffKeywords []string `json:"handoff_keywords"`
+	HandoffAI       bool     `json:"handoff_ai"`
+	// Группа сотрудников, куда пересылаются сообщения клиента; 0 - только уведомление в CRM
+	HandoffChatID int64 `json:"handoff_chat_id"`
+	// Ответ клиенту при передаче оператору и при закрытии диалога
+	HandoffMessage       string `json:"handoff_message"`
+	HandoffClosedMessage string `json:"handoff_closed_message"`
+
+	// Счета Telegram (см. invoice.go): запись credentials с типом "telegram_payments",
+	// key1 - токен платежного провайдера из @BotFather
+	PaymentsCredentialsID string `json:"payments_credentials_id"`
+	// Валюта счетов, если у товара она не задана
+	PaymentsCurrency string `json:"payments_currency"`
+	// Способ оплаты заказов бота: "link" или "invoice"; пусто - по типу шлюза
+	PaymentMethod string `json:"payment_method"`
+	// Способ оплаты отдельного раздела (ключ - раздел бота, например "catalog"),
+	// если он отличается от PaymentMethod
+	PaymentMethods map[string]string `json:"payment_methods"`
+
+	// Каталог товаров (см. catalog.go): команды /catalog, /cart, /promo
+	CatalogEnabled bool `json:"catalog_enabled"`
+	// Сколько товаров показывать на странице категории
+	CatalogPageSize int `json:"catalog_page_size"`
+
+	// Мероприятия и билеты (см. events.go): команда /events
+	EventsEnabled bool `json:"events_enabled"`
+	// За сколько часов до начала напомнить о регистрации или билетах
+	EventReminderHours int `json:"event_reminder_hours"`
+
+	// Напоминания о записях (appointments) и занятиях клиентам, связанным с Telegram
+	// (см. appointments.go), с кнопками подтверждения, отмены и переноса
+	RemindersEnabled bool `json:"reminders_enabled"`
+	// За сколько часов до начала записи или занятия напомнить
+	ReminderHours int `json:"reminder_hours"`
+
+	// Обучение (см. education.go): команды /lessons, /balance, /packages
+	EducationEnabled bool `json:"education_enabled"`
+	// На сколько дней вперед показывать расписание занятий
+	EducationLessonsDays int `json:"education_lessons_days"`
+	// Не позднее чем за сколько часов до начала можно отменить запись; 0 - до начала
+	EducationCancelHours int `json:"education_cancel_hours"`
+
+	// Бронирование номеров отеля или помещений (см. booking.go): команда /book
+	BookingEnabled bool `json:"booking_enabled"`
+	// Что бронируется: "hotel" (hotel_rooms, по суткам) или "spaces" (spaces_rooms, по часам)
+	BookingType string `json:"booking_type"`
+	// На сколько дней вперед можно выбрать дату
+	BookingDaysAhead int `json:"booking_days_ahead"`
+	// Сколько минут бронь держится до оплаты
+	BookingHoldMinutes int `json:"booking_hold_minutes"`
+	// Стоимость часа аренды помещения (в spaces_rooms цены нет); 0 - бесплатно
+	BookingHourPrice float64 `json:"booking_hour_price"`
+}
+
+// BotProfileText - описания бота на одном языке
+type BotProfileText struct {
+	Description      string `json:"description"`
+	ShortDescription string `json:"short_description"`
+}
+
+// Действия при превышении лимита AI
+const (
+	quotaActionMessage  = "message"
+	quotaActionFallback = "fallback"
+	quotaActionDisable  = "disable"
+)
+
+const (
+	// defaultVisionMaxImageMB - лимит размера изображения по умолчанию
+	defaultVisionMaxImageMB = 5
+	defaultAIQuotaMessage   = "Извините, сейчас я не могу ответить автоматически. Мы свяжемся с вами в ближайшее время."
+
+	defaultHandoffMessage       = "Передаю ваш вопрос оператору, он ответит в этом чате."
+	defaultHandoffClosedMessage = "Оператор завершил диалог. Если появятся вопросы - пишите!"
+
+	defaultPaymentsCurrency = "RUB"
+	defaultCatalogPageSize  = 6
+
+	defaultEventReminderHours   = 24
+	defaultReminderHours        = 24
+	defaultEducationLessonsDays = 14
+
+	defaultBookingDaysAhead   = 90
+	defaultBookingHoldMinutes = 30
+)
+
+// defaultHandoffKeywords - слова, по которым клиент попадает к оператору
+var defaultHandoffKeywords = []string{"оператор", "менеджер", "живой человек"}
+
+// parseBotSettings разбирает настройки бота, подставляя значения по умолчанию
+func parseBotSettings(config storage.TelegramBot, aiConfig config.AI) BotSettings {
+	settings := BotSettings{
+		AIVisionMaxImageMB: defaultVisionMaxImageMB,
+	}
+
+	if len(config.Settings) > 0 {
+		if err := json.Unmarshal(config.Settings, &settings); err != nil {
+			logger.Warn("⚠️ Некорректные настройки бота", "bot_id", uuid.UUID(config.ID.Bytes), "error", err)
+		}
+	}
+
+	if settings.AIVisionMaxImageMB <= 0 {
+		settings.AIVisionMaxImageMB = defaultVisionMaxImageMB
+	}
+
+	switch settings.AIQuotaAction {
+	case quotaActionMessage, quotaActionFallback, quotaActionDisable:
+	default:
+		settings.AIQuotaAction = quotaActionMessage
+	}
+	if settings.AIQuotaMessage == "" {
+		settings.AIQuotaMessage = defaultAIQuotaMessage
+	}
+	if settings.AIFallbackModel == "" {
+		settings.AIFallbackModel = aiConfig.FallbackModel
+	}
+
+	if settings.HandoffKeywords == nil {
+		settings.HandoffKeywords = defaultHandoffKeywords
+	}
+	if settings.HandoffMessage == "" {
+		settings.HandoffMessage = defaultHandoffMessage
+	}
+	if settings.HandoffClosedMessage == "" {
+		settings.HandoffClosedMessage = defaultHandoffClosedMessage
+	}
+	if settings.PaymentsCurrency == "" {
+		settings.PaymentsCurrency = defaultPaymentsCurrency
+	}
+	if settings.CatalogPageSize <= 0 {
+		settings.CatalogPageSize = defaultCatalogPageSize
+	}
+	if settings.EventReminderHours <= 0 {
+		settings.EventReminderHours = defaultEventReminderHours
+	}
+	if settings.ReminderHours <= 0 {
+		settings.ReminderHours = defaultReminderHours
+	}
+	if settings.EducationLessonsDays <= 0 {
+		settings.EducationLessonsDays = defaultEducationLessonsDays
+	}
+	if settings.EducationCancelHours < 0 {
+		settings.EducationCancelHours = 0
+	}
+	if settings.BookingType != bookingTypeSpaces {
+		settings.BookingType = bookingTypeHotel
+	}
+	if settings.BookingDaysAhead <= 0 {
+		settings.BookingDaysAhead = defaultBookingDaysAhead
+	}
+	if settings.BookingHoldMinutes <= 0 {
+		settings.BookingHoldMinutes = defaultBookingHoldMinutes
+	}
+
+	return settings
+}
+
+// paymentMethod - способ оплаты заказов раздела feature: payment_methods[feature],
+// иначе общий payment_method
+func (s BotSettings) paymentMethod(feature string) string {
+	if method := s.PaymentMethods[feature]; method != "" {
+		return method
+	}
+	return s.PaymentMethod
+}
+
+// eventReminderBefore - за сколько до начала мероприятия отправить напоминание
+func (s BotSettings) eventReminderBefore() time.Duration {
+	return time.Duration(s.EventReminderHours) * time.Hour
+}
+
+// reminderBefore - за сколько до начала записи или занятия отправить напоминание
+func (s BotSettings) reminderBefore() time.Duration {
+	return time.Duration(s.ReminderHours) * time.Hour
+}
+
+// bookingHold - сколько бронь держится до оплаты
+func (s BotSettings) bookingHold() time.Duration {
+	return time.Duration(s.BookingHoldMinutes) * time.Minute
+}
+
+// visionMaxImageBytes возвращает лимит размера изображения в байтах
+func (s BotSettings) visionMaxImageBytes() int64 {
+	return int64(s.AIVisionMaxImageMB) * 1024 * 1024
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/metrics"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+)
+
+// Состояния бота для админки
+const (
+	BotStateStarting = "starting"
+	BotStateRunning  = "running"
+	BotStateFailed   = "failed"
+)
+
+// pollerModeLongPolling - единственный режим получения апдейтов сейчас
+const pollerModeLongPolling = "long_polling"
+
+// BotStatus - снимок состояния бота (отдается в /bots)
+type BotStatus struct {
+	BotID        uuid.UUID  `json:"bot_id"`
+	ProfileID    uuid.UUID  `json:"profile_id"`
+	Username     string     `json:"username"`
+	State        string     `json:"state"`
+	PollerMode   string     `json:"poller_mode"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	LastPollAt   *time.Time `json:"last_poll_at,omitempty"`
+	LastUpdateAt *time.Time `json:"last_update_at,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at,omitempty"`
+}
+
+// botStatus - изменяемое состояние бота, обновляется поллером и обработчиками
+type botStatus struct {
+	mu sync.Mutex
+	s  BotStatus
+}
+
+func newBotStatus(config storage.TelegramBot) *botStatus {
+	return &botStatus{s: BotStatus{
+		BotID:      uuid.UUID(config.ID.Bytes),
+		ProfileID:  uuid.UUID(config.ProfileID.Bytes),
+		Username:   config.BotUsername.String,
+		State:      BotStateStarting,
+		PollerMode: pollerModeLongPolling,
+	}}
+}
+
+func (st *botStatus) snapshot() BotStatus {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.s
+}
+
+func (st *botStatus) setState(state string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.s.State = state
+	if state == BotStateRunning && st.s.StartedAt == nil {
+		now := time.Now()
+		st.s.StartedAt = &now
+	}
+}
+
+func (st *botStatus) setUsername(username string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.s.Username = username
+}
+
+// polled - успешный запрос getUpdates
+func (st *botStatus) polled(updates int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	st.s.LastPollAt = &now
+	if updates > 0 {
+		st.s.LastUpdateAt = &now
+		metrics.UpdatesReceived.WithLabelValues(st.s.BotID.String(), st.s.ProfileID.String()).Add(float64(updates))
+	}
+	if st.s.State == BotStateStarting {
+		st.s.State = BotStateRunning
+		st.s.StartedAt = &now
+	}
+}
+
+func (st *botStatus) setError(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	st.s.LastError = utils.RedactBotToken(err.Error())
+	st.s.LastErrorAt = &now
+	metrics.BotErrors.WithLabelValues(st.s.BotID.String(), st.s.ProfileID.String()).Inc()
+}
+
+// fail отмечает бота неработающим (например, токен отозван)
+func (st *botStatus) fail(err error) {
+	st.setError(err)
+	st.setState(BotStateFailed)
+}
+
+// BotStatuses возвращает состояние всех ботов реплики, включая тех, что не удалось запустить
+func (m *Manager) BotStatuses() []BotStatus {
+	m.mu.RLock()
+	statuses := make([]BotStatus, 0, len(m.bots)+len(m.failed))
+	for _, s := range m.bots {
+		statuses = append(statuses, s.status.snapshot())
+	}
+	for _, status := range m.failed {
+		statuses = append(statuses, status.snapshot())
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Username < statuses[j].Username
+	})
+	return statuses
+}
@@ -0,0 +1,269 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// restartMinDelay / restartMaxDelay - пауза перед повторным подключением или
+	// перезапуском поллера; удваивается после каждой ошибки подряд
+	restartMinDelay = time.Second
+	restartMaxDelay = 2 * time.Minute
+	// restartResetAfter - после стольких минут стабильной работы пауза снова минимальная
+	restartResetAfter = time.Minute
+	// invalidateTimeout - таймаут записи в БД при выключении бота
+	invalidateTimeout = 10 * time.Second
+)
+
+// notificationBotInvalid - тип уведомления в CRM об отключенном боте
+const notificationBotInvalid = "telegram_bot_invalid"
+
+// supervisor держит одного бота запущенным:
+//   - подключается к Telegram (getMe) и повторяет попытки с экспоненциальной паузой;
+//   - перезапускает поллер после временных ошибок (сеть, 5xx, 409) с той же паузой;
+//   - при фатальной ошибке (токен отозван или неверен) выключает бота в БД,
+//     уведомляет бизнес и больше не перезапускает его.
+type supervisor struct {
+	m         *Manager
+	botID     uuid.UUID
+	config    storage.TelegramBot
+	status    *botStatus
+	parentCtx context.Context
+	logCtx    context.Context
+
+	mu       sync.RWMutex
+	instance *BotInstance
+
+	// stopCh закрывается в stop; drainCtx ограничивает ожидание обработчиков
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	drainCtx context.Context
+	done     chan struct{}
+
+	// leaseRenewedAt - последнее успешное продление владения ботом (см. cluster.go)
+	leaseRenewedAt time.Time
+}
+
+func newSupervisor(m *Manager, parentCtx context.Context, config storage.TelegramBot) *supervisor {
+	return &supervisor{
+		m:         m,
+		botID:     uuid.UUID(config.ID.Bytes),
+		config:    config,
+		status:    newBotStatus(config),
+		parentCtx: parentCtx,
+		logCtx:    configLogContext(context.Background(), config),
+		stopCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// current возвращает подключенный инстанс (nil, пока бот подключается)
+func (s *supervisor) current() *BotInstance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instance
+}
+
+// stop останавливает бота и ждет обработку полученных апдейтов не дольше ctx
+func (s *supervisor) stop(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		s.drainCtx = ctx
+		close(s.stopCh)
+	})
+	<-s.done
+	logger.InfoContext(s.logCtx, "🛑 Остановлен бот")
+}
+
+func (s *supervisor) run() {
+	defer close(s.done)
+
+	instance, err := s.connect()
+	if instance != nil {
+		// Меню команд и описания обновляются параллельно с обработкой апдейтов
+		instance.chats.Go(func() { s.m.syncProfile(instance, s.config) })
+		// Уведомления сотрудникам и планировщик напоминаний живут до отмены контекста
+		// бота, поэтому не через chats.Go
+		go instance.watchAdminEvents()
+		go instance.watchReminders()
+		go instance.run()
+		err = s.poll(instance)
+		close(instance.updates)
+
+		drainCtx, cancel := s.drainContext()
+		instance.drain(drainCtx)
+		cancel()
+	}
+
+	if err != nil {
+		s.invalidate(err)
+	}
+}
+
+// connect создает инстанс бота, повторяя временные ошибки.
+// Возвращает nil и ошибку, если ошибка фатальная, или nil, nil при остановке.
+func (s *supervisor) connect() (*BotInstance, error) {
+	delay := restartMinDelay
+	for {
+		instance, err := s.m.newInstance(s.parentCtx, s.config, s.status)
+		if err == nil {
+			s.mu.Lock()
+			s.instance = instance
+			s.mu.Unlock()
+			logger.InfoContext(s.logCtx, "✅ Запущен бот", "username", instance.Bot.Me.Username)
+			return instance, nil
+		}
+		if isFatalBotError(err) {
+			return nil, err
+		}
+
+		s.status.setError(err)
+		logger.WarnContext(s.logCtx, "⚠️ Не удалось подключить бота, повторим", "error", err, "retry_in", delay.String())
+		if !s.wait(delay) {
+			return nil, nil
+		}
+		delay = nextRestartDelay(delay)
+	}
+}
+
+// poll получает апдейты, перезапуская поллер после временных ошибок.
+// Возвращает фатальную ошибку или nil при остановке.
+func (s *supervisor) poll(instance *BotInstance) error {
+	delay := restartMinDelay
+	for {
+		startedAt := time.Now()
+		err := instance.poller.poll(instance.Bot, instance.updates, s.stopCh)
+		if err == nil {
+			return nil
+		}
+		if isFatalBotError(err) {
+			return err
+		}
+
+		if time.Since(startedAt) > restartResetAfter {
+			delay = restartMinDelay
+		}
+		s.status.setError(err)
+		logger.WarnContext(s.logCtx, "⚠️ Ошибка getUpdates, перезапуск поллера", "error", err, "retry_in", delay.String())
+		if !s.wait(delay) {
+			return nil
+		}
+		delay = nextRestartDelay(delay)
+	}
+}
+
+// wait ждет паузу перед перезапуском; false - бот останавливают
+func (s *supervisor) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-s.stopCh:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// drainContext - ограничение ожидания обработчиков: из stop или SHUTDOWN_TIMEOUT,
+// если бот останавливается сам (фатальная ошибка)
+func (s *supervisor) drainContext() (context.Context, context.CancelFunc) {
+	select {
+	case <-s.stopCh:
+		return s.drainCtx, func() {}
+	default:
+		return context.WithTimeout(context.Background(), s.m.cfg.Shutdown.Timeout)
+	}
+}
+
+// invalidate выключает бота с отозванным или неверным токеном:
+// отмечает его в БД (is_active = false, last_error), создает уведомление
+// для бизнеса в CRM и освобождает бота на этой реплике
+func (s *supervisor) invalidate(err error) {
+	s.status.fail(err)
+	logger.ErrorContext(s.logCtx, "❌ Токен бота недействителен, бот выключен", "username", s.config.BotUsername.String, "error", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), invalidateTimeout)
+	defer cancel()
+
+	if dbErr := s.m.queries.DisableBot(ctx, storage.DisableBotParams{
+		ID:        s.config.ID,
+		LastError: pgtype.Text{String: utils.RedactBotToken(err.Error()), Valid: true},
+	}); dbErr != nil {
+		logger.ErrorContext(s.logCtx, "❌ Не удалось выключить бота в БД", "error", dbErr)
+	}
+
+	if notifyErr := s.notifyInvalid(ctx, err); notifyErr != nil {
+		logger.ErrorContext(s.logCtx, "❌ Не удалось уведомить о выключении бота", "error", notifyErr)
+	}
+
+	s.m.retire(s)
+}
+
+func (s *supervisor) notifyInvalid(ctx context.Context, cause error) error {
+	username := s.config.BotUsername.String
+	if username == "" {
+		username = s.botID.String()
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"bot_id":   s.botID.String(),
+		"username": username,
+		"error":    utils.RedactBotToken(cause.Error()),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.m.queries.CreateNotification(ctx, storage.CreateNotificationParams{
+		ProfileID: s.config.ProfileID,
+		Type:      notificationBotInvalid,
+		Title:     fmt.Sprintf("Telegram бот @%s отключен", username),
+		Message: pgtype.Text{
+			String: "Telegram отклонил токен бота (токен отозван или изменен в @BotFather). " +
+				"Укажите новый токен в настройках бота и включите его снова.",
+			Valid: true,
+		},
+		Payload: payload,
+	})
+}
+
+// retire убирает выключенного бота из запущенных; статус остается в /bots
+func (m *Manager) retire(s *supervisor) {
+	m.mu.Lock()
+	if m.bots[s.botID] == s {
+		delete(m.bots, s.botID)
+		m.failed[s.botID] = s.status
+	}
+	m.mu.Unlock()
+
+	if m.redis != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		utils.ReleaseLease(ctx, m.redis, leaseKeyPrefix+s.botID.String(), m.replicaID)
+	}
+}
+
+// isFatalBotError - ошибка, после которой перезапуск бесполезен:
+// 401 - токен отозван, 404 - токен в неверном формате
+func isFatalBotError(err error) bool {
+	return errors.Is(err, tele.ErrUnauthorized) || errors.Is(err, tele.ErrNotFound)
+}
+
+func nextRestartDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > restartMaxDelay {
+		delay = restartMaxDelay
+	}
+	return delay
+}
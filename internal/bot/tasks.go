@@ -0,0 +1,289 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/redis/go-redis/v9"
+	tele "gopkg.in/telebot.v3"
+)
+
+// broadcastInterval - пауза между сообщениями рассылки (лимит Bot API ~30 в секунду)
+const broadcastInterval = 40 * time.Millisecond
+
+// Прогресс рассылки в Redis: tg:broadcast:<task_id>:sent и :failed - чаты, которым
+// сообщение уже отправлено или не доставлено. Повтор задачи их пропускает
+const (
+	broadcastKeyPrefix   = "tg:broadcast:"
+	broadcastProgressTTL = 24 * time.Hour
+)
+
+// RegisterTaskHandlers регистрирует обработчики задач Asynq, которым нужны запущенные боты
+func (m *Manager) RegisterTaskHandlers(mux *asynq.ServeMux) {
+	mux.HandleFunc(queue.TypeWorkflowTimeout, m.handleWorkflowTimeout)
+	mux.HandleFunc(queue.TypeHandoffReply, m.handleHandoffTask)
+	mux.HandleFunc(queue.TypeHandoffClose, m.handleHandoffTask)
+	mux.HandleFunc(queue.TypeBroadcast, m.handleBroadcast)
+	mux.HandleFunc(queue.TypePaymentStatus, m.handlePaymentStatus)
+	mux.HandleFunc(queue.TypeReminder, m.handleReminder)
+	mux.HandleFunc(queue.TypeCRMEvent, m.handleCRMEvent)
+	mux.HandleFunc(queue.TypeTemplateMessage, m.handleTemplateMessage)
+	mux.HandleFunc(queue.TypeBookingHold, m.handleBookingHold)
+	mux.HandleFunc(queue.TypeTicketDelivery, m.handleTicketDelivery)
+}
+
+// handleWorkflowTimeout продолжает workflow, если пользователь не ответил на вопрос вовремя
+func (m *Manager) handleWorkflowTimeout(ctx context.Context, t *asynq.Task) error {
+	var p queue.WorkflowTimeoutPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+
+	executionID := pgtype.UUID{Bytes: p.ExecutionID, Valid: true}
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"chat_id", p.ChatID, "execution_id", p.ExecutionID.String())
+
+	// Таймаут не должен пересечься с ответом пользователя в том же чате
+	var handled bool
+	err = instance.withChatLock(ctx, p.ChatID, func() error {
+		var err error
+		handled, err = instance.Handler.engine.Resume(ctx, executionID, workflow.Input{TimeoutToken: p.Token})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if handled {
+		logger.InfoContext(ctx, "⏱️ Таймаут ожидания ответа")
+	}
+
+	return nil
+}
+
+// handleHandoffTask отправляет клиенту ответ оператора из CRM или закрывает диалог
+func (m *Manager) handleHandoffTask(ctx context.Context, t *asynq.Task) error {
+	var p queue.HandoffPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+
+	handoff, err := m.queries.GetHandoff(ctx, pgtype.UUID{Bytes: p.HandoffID, Valid: true})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && handoff.BotID != instance.Config.ID) {
+		return fmt.Errorf("handoff %s not found: %w", p.HandoffID, asynq.SkipRetry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load handoff: %w", err)
+	}
+
+	ctx = withHandoffLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()), handoff)
+	accountID := pgtype.UUID{Bytes: p.AccountID, Valid: p.AccountID != uuid.Nil}
+
+	return instance.withChatLock(ctx, handoff.ChatID, func() error {
+		if t.Type() == queue.TypeHandoffClose {
+			return instance.Handler.closeHandoff(ctx, handoff, accountID)
+		}
+
+		if handoff.Status != "open" {
+			logger.WarnContext(ctx, "⚠️ Ответ оператора в закрытый диалог не отправлен")
+			return nil
+		}
+		if p.Text == "" {
+			return fmt.Errorf("empty operator reply: %w", asynq.SkipRetry)
+		}
+		return instance.Handler.sendOperatorReply(ctx, handoff, accountID, p.Text)
+	})
+}
+
+// handleBroadcast отправляет рассылку сотрудника всем чатам профиля и присылает
+// ему отчет. Чаты, заблокировавшие бота, пропускаются. На 429 рассылка ждет
+// retry_after, а прочие ошибки отправки прерывают задачу. Прерванная рассылка
+// (ошибка, перезапуск реплики, таймаут) при повторе продолжается с неотправленных
+// чатов; повторно сообщение может получить только чат, на котором ее прервали
+func (m *Manager) handleBroadcast(ctx context.Context, t *asynq.Task) error {
+	var p queue.BroadcastPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"account_id", p.AccountID.String())
+
+	chats, err := m.queries.ListBroadcastChats(ctx, instance.Config.ProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to load recipients: %w", err)
+	}
+
+	progress := m.broadcastProgress(ctx)
+	if err := progress.load(ctx); err != nil {
+		return fmt.Errorf("failed to load broadcast progress: %w", err)
+	}
+
+	logger.InfoContext(ctx, "📤 Рассылка начата", "recipients", len(chats),
+		"already_done", len(progress.sent)+len(progress.failed))
+
+	accountID := pgtype.UUID{Bytes: p.AccountID, Valid: p.AccountID != uuid.Nil}
+	metadata, _ := json.Marshal(map[string]interface{}{"broadcast": true})
+
+	first := true
+	for _, chatID := range chats {
+		if progress.sent[chatID] || progress.failed[chatID] {
+			continue
+		}
+		if !first {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(broadcastInterval):
+			}
+		}
+		first = false
+
+		if err := sendBroadcastMessage(ctx, instance.Bot, chatID, p.Text); err != nil {
+			if !isUnreachableChat(err) {
+				return fmt.Errorf("failed to send broadcast message: %w", err)
+			}
+			logger.DebugContext(ctx, "Сообщение рассылки не доставлено", "chat_id", chatID, "error", err)
+			if err := progress.mark(ctx, chatID, false); err != nil {
+				return fmt.Errorf("failed to save broadcast progress: %w", err)
+			}
+			continue
+		}
+		if err := progress.mark(ctx, chatID, true); err != nil {
+			return fmt.Errorf("failed to save broadcast progress: %w", err)
+		}
+
+		if err := m.queries.LogOperatorMessage(ctx, storage.LogOperatorMessageParams{
+			ProfileID:      instance.Config.ProfileID,
+			TelegramUserID: chatID,
+			ChatID:         chatID,
+			MessageText:    pgtype.Text{String: p.Text, Valid: true},
+			Metadata:       metadata,
+			AccountID:      accountID,
+		}); err != nil {
+			logger.ErrorContext(ctx, "Failed to log broadcast message", "chat_id", chatID, "error", err)
+		}
+	}
+
+	sent, failed := len(progress.sent), len(progress.failed)
+	logger.InfoContext(ctx, "✅ Рассылка завершена", "sent", sent, "failed", failed)
+
+	report := fmt.Sprintf("✅ Рассылка завершена\nДоставлено: %d\nНе доставлено: %d", sent, failed)
+	if _, err := instance.Bot.Send(tele.ChatID(p.ReportChatID), report); err != nil {
+		logger.WarnContext(ctx, "⚠️ Не удалось отправить отчет о рассылке", "error", err)
+	}
+	return nil
+}
+
+// sendBroadcastMessage отправляет сообщение рассылки; при 429 ждет retry_after
+// и повторяет, пока не отменен ctx
+func sendBroadcastMessage(ctx context.Context, bot *tele.Bot, chatID int64, text string) error {
+	for {
+		_, err := bot.Send(tele.ChatID(chatID), text)
+
+		var flood tele.FloodError
+		if !errors.As(err, &flood) {
+			return err
+		}
+
+		logger.WarnContext(ctx, "⏳ Рассылка уперлась в лимит Telegram", "retry_after", flood.RetryAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(flood.RetryAfter) * time.Second):
+		}
+	}
+}
+
+// isUnreachableChat - ошибка, после которой отправка в чат бесполезна:
+// бот заблокирован, чат не найден или пользователь удален
+func isUnreachableChat(err error) bool {
+	return errors.Is(err, tele.ErrBlockedByUser) || errors.Is(err, tele.ErrNotStartedByUser) ||
+		errors.Is(err, tele.ErrUserIsDeactivated) || errors.Is(err, tele.ErrChatNotFound) ||
+		errors.Is(err, tele.ErrKickedFromGroup)
+}
+
+// broadcastProgress - отметки чатов одной рассылки. Без Redis (запуск без кластера)
+// отметки живут только в памяти задачи
+type broadcastProgress struct {
+	redis  *redis.Client
+	key    string
+	sent   map[int64]bool
+	failed map[int64]bool
+}
+
+func (m *Manager) broadcastProgress(ctx context.Context) *broadcastProgress {
+	id, _ := asynq.GetTaskID(ctx)
+	return &broadcastProgress{
+		redis:  m.redis,
+		key:    broadcastKeyPrefix + id,
+		sent:   make(map[int64]bool),
+		failed: make(map[int64]bool),
+	}
+}
+
+// load загружает чаты, обработанные предыдущими попытками
+func (p *broadcastProgress) load(ctx context.Context) error {
+	if p.redis == nil {
+		return nil
+	}
+
+	for suffix, marks := range map[string]map[int64]bool{":sent": p.sent, ":failed": p.failed} {
+		ids, err := p.redis.SMembers(ctx, p.key+suffix).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if chatID, err := strconv.ParseInt(id, 10, 64); err == nil {
+				marks[chatID] = true
+			}
+		}
+	}
+	return nil
+}
+
+// mark отмечает чат отправленным или недоставленным
+func (p *broadcastProgress) mark(ctx context.Context, chatID int64, sent bool) error {
+	suffix := ":failed"
+	if sent {
+		suffix = ":sent"
+		p.sent[chatID] = true
+	} else {
+		p.failed[chatID] = true
+	}
+	if p.redis == nil {
+		return nil
+	}
+
+	pipe := p.redis.TxPipeline()
+	pipe.SAdd(ctx, p.key+suffix, chatID)
+	pipe.Expire(ctx, p.key+suffix, broadcastProgressTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
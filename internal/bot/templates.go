@@ -0,0 +1,362 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/internal/workflow"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Сообщения клиентам по шаблонам (telegram_message_templates) на события CRM.
+// Бэкенд CRM ставит задачу crm:event, handleCRMEvent находит шаблоны с таким
+// trigger_event и ставит по задаче telegram:template с задержкой шаблона,
+// handleTemplateMessage проверяет условия, подставляет поля сущности и отправляет.
+
+// Типы сущностей событий CRM (queue.CRMEventPayload.EntityType)
+const (
+	templateEntityAppointment = "appointment"
+	templateEntityOrder       = "order"
+	templateEntityBooking     = "booking"
+)
+
+// Статусы отправки в telegram_template_sends
+const (
+	templateSendSent   = "sent"
+	templateSendFailed = "failed"
+)
+
+const dateLayout = "02.01.2006"
+
+// templateCondition - условие шаблона на поле сущности, операторы как у ребер
+// workflow (workflow.MatchCondition). conditions шаблона - массив условий, все
+// должны выполняться:
+//
+//	[{"field": "status", "operator": "equals", "value": "confirmed"}, {"field": "price", "operator": "gt", "value": 0}]
+//
+// Объект {"status": "confirmed"} - краткая запись проверок на равенство.
+type templateCondition struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+// handleCRMEvent ставит сообщения по шаблонам, срабатывающим на событие CRM
+func (m *Manager) handleCRMEvent(ctx context.Context, t *asynq.Task) error {
+	var p queue.CRMEventPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	ctx = utils.WithLogAttrs(ctx, "profile_id", p.ProfileID.String(), "event", p.Event,
+		"entity_type", p.EntityType, "entity_id", p.EntityID.String())
+
+	profileID := pgtype.UUID{Bytes: p.ProfileID, Valid: true}
+	templates, err := m.queries.ListTriggeredTemplates(ctx, storage.ListTriggeredTemplatesParams{
+		ProfileID:    profileID,
+		TriggerEvent: pgtype.Text{String: p.Event, Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
+	}
+	if len(templates) == 0 {
+		logger.DebugContext(ctx, "Нет шаблонов на событие")
+		return nil
+	}
+
+	var defaultBot pgtype.UUID
+	for _, tpl := range templates {
+		botID := tpl.BotID
+		if !botID.Valid {
+			if !defaultBot.Valid {
+				bots, err := m.queries.ListProfileBotIDs(ctx, profileID)
+				if err != nil {
+					return fmt.Errorf("failed to load bots: %w", err)
+				}
+				if len(bots) == 0 {
+					logger.WarnContext(ctx, "⚠️ У профиля нет активного бота, сообщения по шаблонам не отправлены")
+					return nil
+				}
+				defaultBot = bots[0]
+			}
+			botID = defaultBot
+		}
+
+		task, err := queue.NewTemplateMessageTask(queue.TemplateMessagePayload{
+			BotID:      botID.Bytes,
+			TemplateID: tpl.ID.Bytes,
+			Event:      p.Event,
+			EntityType: p.EntityType,
+			EntityID:   p.EntityID,
+			EventID:    p.EventID,
+			Data:       p.Data,
+			Carrier:    tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+		}, templateDelay(tpl))
+		if err != nil {
+			return fmt.Errorf("failed to create template task: %w", err)
+		}
+		_, err = m.tasks.EnqueueContext(ctx, task)
+		switch {
+		case errors.Is(err, asynq.ErrTaskIDConflict):
+			logger.DebugContext(ctx, "Сообщение по шаблону уже в очереди", "template", tpl.TemplateKey)
+		case err != nil:
+			return fmt.Errorf("failed to enqueue template message: %w", err)
+		default:
+			logger.InfoContext(ctx, "✉️ Сообщение по шаблону запланировано", "template", tpl.TemplateKey,
+				"delay", templateDelay(tpl).String())
+		}
+	}
+	return nil
+}
+
+// handleTemplateMessage отправляет клиенту сообщение по шаблону и записывает
+// отправку в telegram_template_sends
+func (m *Manager) handleTemplateMessage(ctx context.Context, t *asynq.Task) error {
+	var p queue.TemplateMessagePayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"event", p.Event, "entity_type", p.EntityType, "entity_id", p.EntityID.String())
+
+	h := instance.Handler
+	tpl, err := h.queries.GetMessageTemplate(ctx, storage.GetMessageTemplateParams{
+		ID:        pgtype.UUID{Bytes: p.TemplateID, Valid: true},
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && tpl.IsActive.Valid && !tpl.IsActive.Bool) {
+		logger.InfoContext(ctx, "✉️ Шаблон удален или выключен, сообщение не отправлено")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load template: %w", err)
+	}
+	ctx = utils.WithLogAttrs(ctx, "template", tpl.TemplateKey)
+
+	entityID := pgtype.UUID{Bytes: p.EntityID, Valid: true}
+	vars, chatID, err := h.templateVars(ctx, p.EntityType, entityID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.InfoContext(ctx, "✉️ Сущность не найдена, сообщение не отправлено")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	vars["event_name"] = p.Event
+	if p.Data != nil {
+		vars["event"] = p.Data
+	}
+
+	matched, err := matchTemplateConditions(tpl.Conditions, vars)
+	if err != nil {
+		logger.WarnContext(ctx, "⚠️ Некорректные условия шаблона", "error", err)
+		return nil
+	}
+	if !matched {
+		logger.InfoContext(ctx, "✉️ Условия шаблона не выполнены, сообщение не отправлено")
+		return nil
+	}
+	if chatID == 0 {
+		logger.InfoContext(ctx, "✉️ Клиент не связан с Telegram, сообщение не отправлено")
+		return nil
+	}
+
+	mode := workflow.ParseMode(tpl.ParseMode.String)
+	text := workflow.Render(tpl.MessageText, vars, mode)
+	if strings.TrimSpace(text) == "" {
+		logger.WarnContext(ctx, "⚠️ Пустой текст шаблона")
+		return nil
+	}
+
+	send := storage.CreateTemplateSendParams{
+		ProfileID:    h.botConfig.ProfileID,
+		BotID:        h.botConfig.ID,
+		TemplateID:   tpl.ID,
+		TemplateKey:  tpl.TemplateKey,
+		TriggerEvent: p.Event,
+		EntityType:   p.EntityType,
+		EntityID:     entityID,
+		ChatID:       chatID,
+		MessageText:  text,
+		Status:       templateSendSent,
+	}
+	msg, sendErr := instance.Bot.Send(tele.ChatID(chatID), text, mode)
+	if sendErr != nil {
+		send.Status = templateSendFailed
+		send.ErrorMessage = pgtype.Text{String: sendErr.Error(), Valid: true}
+	} else {
+		send.TelegramMessageID = pgtype.Int8{Int64: int64(msg.ID), Valid: true}
+	}
+	if err := h.queries.CreateTemplateSend(ctx, send); err != nil {
+		logger.ErrorContext(ctx, "Failed to log template send", "error", err)
+	}
+
+	if sendErr != nil {
+		if errors.Is(sendErr, tele.ErrBlockedByUser) || errors.Is(sendErr, tele.ErrNotStartedByUser) ||
+			errors.Is(sendErr, tele.ErrUserIsDeactivated) || errors.Is(sendErr, tele.ErrChatNotFound) {
+			logger.InfoContext(ctx, "✉️ Клиент недоступен, сообщение по шаблону не доставлено", "chat_id", chatID, "error", sendErr)
+			return nil
+		}
+		return fmt.Errorf("failed to send template message: %w", sendErr)
+	}
+
+	h.logBotMessage(ctx, chatID, chatID, text, map[string]interface{}{
+		"template":    tpl.TemplateKey,
+		"event":       p.Event,
+		"entity_type": p.EntityType,
+		"entity_id":   p.EntityID.String(),
+	})
+	logger.InfoContext(ctx, "✉️ Сообщение по шаблону отправлено", "chat_id", chatID)
+	return nil
+}
+
+// templateVars загружает поля сущности для подстановки в шаблон и чат клиента
+// (0, если клиент не связан с Telegram)
+func (h *MessageHandler) templateVars(ctx context.Context, entityType string, id pgtype.UUID) (map[string]interface{}, int64, error) {
+	switch entityType {
+	case templateEntityAppointment:
+		a, err := h.queries.GetAppointmentTemplateData(ctx, storage.GetAppointmentTemplateDataParams{ID: id, ProfileID: h.botConfig.ProfileID})
+		if err != nil {
+			return nil, 0, err
+		}
+		vars := map[string]interface{}{
+			"id":              uuidString(a.ID),
+			"title":           a.Title,
+			"description":     a.Description.String,
+			"status":          a.Status,
+			"start":           formatEventTime(a.StartDatetime),
+			"end":             formatEventTime(a.EndDatetime),
+			"date":            a.StartDatetime.Time.Local().Format(dateLayout),
+			"time":            a.StartDatetime.Time.Local().Format("15:04"),
+			"paid":            a.Paid,
+			"customer_name":   a.CustomerName,
+			"specialist_name": a.SpecialistName,
+		}
+		if a.Price.Valid {
+			vars["price"] = a.Price.Float64
+			vars["price_text"] = h.formatPrice(a.Price.Float64, "")
+		}
+		return vars, a.TelegramUserID, nil
+
+	case templateEntityOrder:
+		o, err := h.queries.GetPaymentOrderTemplateData(ctx, storage.GetPaymentOrderTemplateDataParams{ID: id, ProfileID: h.botConfig.ProfileID})
+		if err != nil {
+			return nil, 0, err
+		}
+		vars := map[string]interface{}{
+			"id":            uuidString(o.ID),
+			"description":   o.Description,
+			"status":        o.Status,
+			"payment_url":   o.PaymentUrl.String,
+			"order_type":    o.EntityType,
+			"customer_name": o.CustomerName,
+		}
+		if amount, ok := numericToFloat(o.Amount); ok {
+			vars["amount"] = amount
+			vars["amount_text"] = h.formatPrice(amount, "")
+		}
+		return vars, o.TelegramUserID, nil
+
+	case templateEntityBooking:
+		b, err := h.queries.GetHotelBookingTemplateData(ctx, storage.GetHotelBookingTemplateDataParams{ID: id, ProfileID: h.botConfig.ProfileID})
+		if err != nil {
+			return nil, 0, err
+		}
+		vars := map[string]interface{}{
+			"id":            uuidString(b.ID),
+			"room_number":   b.RoomNumber,
+			"room_type":     b.RoomType,
+			"status":        b.Status,
+			"check_in":      formatDate(b.CheckInDate),
+			"check_out":     formatDate(b.CheckOutDate),
+			"customer_name": b.CustomerName,
+		}
+		if b.CheckInDate.Valid && b.CheckOutDate.Valid {
+			vars["nights"] = float64(b.CheckOutDate.Time.Sub(b.CheckInDate.Time) / (24 * time.Hour))
+		}
+		if price, ok := numericToFloat(b.TotalPrice); ok {
+			vars["total_price"] = price
+			vars["total_price_text"] = h.formatPrice(price, "")
+		}
+		return vars, b.TelegramUserID, nil
+	}
+	return nil, 0, fmt.Errorf("unknown entity type %q: %w", entityType, asynq.SkipRetry)
+}
+
+// templateDelay - задержка отправки: send_delay_minutes, если шаблон не отправляется сразу
+func templateDelay(tpl storage.TelegramMessageTemplate) time.Duration {
+	if tpl.SendImmediately.Valid && tpl.SendImmediately.Bool {
+		return 0
+	}
+	if !tpl.SendDelayMinutes.Valid || tpl.SendDelayMinutes.Int32 <= 0 {
+		return 0
+	}
+	return time.Duration(tpl.SendDelayMinutes.Int32) * time.Minute
+}
+
+// matchTemplateConditions проверяет условия шаблона; пустые условия выполняются всегда
+func matchTemplateConditions(raw []byte, vars map[string]interface{}) (bool, error) {
+	conditions, err := parseTemplateConditions(raw)
+	if err != nil {
+		return false, err
+	}
+	for _, cond := range conditions {
+		expected := ""
+		if cond.Value != nil {
+			expected = fmt.Sprint(cond.Value)
+		}
+		if !workflow.MatchCondition(vars, cond.Field, cond.Operator, expected) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func parseTemplateConditions(raw []byte) ([]templateCondition, error) {
+	text := strings.TrimSpace(string(raw))
+	if text == "" || text == "null" || text == "{}" || text == "[]" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(text, "{") {
+		var equals map[string]interface{}
+		if err := json.Unmarshal(raw, &equals); err != nil {
+			return nil, err
+		}
+		conditions := make([]templateCondition, 0, len(equals))
+		for field, value := range equals {
+			conditions = append(conditions, templateCondition{Field: field, Value: value})
+		}
+		return conditions, nil
+	}
+
+	var conditions []templateCondition
+	if err := json.Unmarshal(raw, &conditions); err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+func formatDate(d pgtype.Date) string {
+	if !d.Valid {
+		return ""
+	}
+	return d.Time.Format(dateLayout)
+}
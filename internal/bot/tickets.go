@@ -0,0 +1,691 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	tele "gopkg.in/telebot.v3"
+)
+
+const (
+	// entityTicketOrder - payment_orders.entity_type заказа билетов
+	entityTicketOrder = "ticket_order"
+	// ticketDraftKey - выбор мероприятия, сеанса и типа билета в контексте разговора
+	ticketDraftKey = "ticket_draft"
+	// defaultMaxPerOrder - лимит билетов в заказе, если у типа билета он не задан
+	defaultMaxPerOrder = 10
+	// ticketOrderStatusPaid - оплаченный заказ билетов (ticket_orders.status)
+	ticketOrderStatusPaid = "paid"
+)
+
+// checkoutErrSoldOut - ответ на pre_checkout_query, если места закончились
+const checkoutErrSoldOut = "К сожалению, билеты закончились."
+
+// errTicketsSoldOut - при выдаче билетов на сеансе не хватило мест (их успели
+// купить, пока шла оплата)
+var errTicketsSoldOut = errors.New("ticket session is sold out")
+
+// ticketDraft - покупка билетов в процессе: callback несет только один id,
+// остальной выбор хранится здесь
+type ticketDraft struct {
+	EventID      string `json:"event_id"`
+	SessionID    string `json:"session_id,omitempty"`
+	TicketTypeID string `json:"ticket_type_id,omitempty"`
+	// PromoCode - промокод (/promo), проверяется еще раз при заказе
+	PromoCode string `json:"promo_code,omitempty"`
+}
+
+// ticketOrderData - ticket_orders.custom_fields заказа, созданного ботом
+type ticketOrderData struct {
+	botOrigin
+	EventID      string  `json:"event_id"`
+	EventTitle   string  `json:"event_title"`
+	TicketTypeID string  `json:"ticket_type_id"`
+	Quantity     int     `json:"quantity"`
+	Price        float64 `json:"price"`
+}
+
+func (h *MessageHandler) loadTicketDraft(ctx context.Context, chatID int64) ticketDraft {
+	var draft ticketDraft
+	h.conversationValue(ctx, chatID, ticketDraftKey, &draft)
+	return draft
+}
+
+func (h *MessageHandler) saveTicketDraft(ctx context.Context, c tele.Context, draft ticketDraft) {
+	var value interface{} = draft
+	if draft.EventID == "" {
+		value = nil
+	}
+	h.updateConversationContext(ctx, c, map[string]interface{}{ticketDraftKey: value})
+}
+
+// draftSession находит сеанс среди сеансов мероприятия из черновика: так кнопка
+// от старого сообщения не продаст билет на сеанс, который уже не продается
+func (h *MessageHandler) draftSession(ctx context.Context, draft ticketDraft, sessionID pgtype.UUID) (storage.ListEventSessionsRow, error) {
+	eventID, err := parseUUID(draft.EventID)
+	if err != nil {
+		return storage.ListEventSessionsRow{}, pgx.ErrNoRows
+	}
+	sessions, err := h.queries.ListEventSessions(ctx, storage.ListEventSessionsParams{
+		EventID:   eventID,
+		ProfileID: h.botConfig.ProfileID,
+	})
+	if err != nil {
+		return storage.ListEventSessionsRow{}, fmt.Errorf("failed to load ticket sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return session, nil
+		}
+	}
+	return storage.ListEventSessionsRow{}, pgx.ErrNoRows
+}
+
+// showTicketTypes - шаг выбора типа билета на сеанс
+func (h *MessageHandler) showTicketTypes(ctx context.Context, c tele.Context, sessionID pgtype.UUID) error {
+	draft := h.loadTicketDraft(ctx, c.Chat().ID)
+	session, err := h.draftSession(ctx, draft, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Продажа на этот сеанс закрыта", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	if left, limited := sessionSeatsLeft(session.Capacity, session.SoldCount); limited && left <= 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "Мест нет", ShowAlert: true})
+	}
+
+	types, err := h.queries.ListTicketTypes(ctx, h.botConfig.ProfileID)
+	if err != nil {
+		return fmt.Errorf("failed to load ticket types: %w", err)
+	}
+	if len(types) == 0 {
+		return c.Respond(&tele.CallbackResponse{Text: "Билеты пока не продаются", ShowAlert: true})
+	}
+
+	draft.SessionID, draft.TicketTypeID = uuidString(sessionID), ""
+	h.saveTicketDraft(ctx, c, draft)
+
+	lines := []string{"🎟 " + formatEventTime(session.StartTime)}
+	if session.Title.String != "" {
+		lines[0] += " - " + session.Title.String
+	}
+	lines = append(lines, "", "Выберите билет:")
+
+	markup := &tele.ReplyMarkup{}
+	for _, ticketType := range types {
+		price, _ := numericToFloat(ticketType.Price)
+		text := fmt.Sprintf("%s - %s", ticketType.Name, h.formatPrice(price, ""))
+		if price == 0 {
+			text = ticketType.Name + " - бесплатно"
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{
+			Text: text,
+			Data: callbackEventType + uuidString(ticketType.ID),
+		}})
+		if ticketType.Description.String != "" {
+			lines = append(lines, "• "+ticketType.Name+": "+truncateRunes(ticketType.Description.String, 200))
+		}
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "⬅️ К мероприятию", Data: callbackEventShow + draft.EventID}})
+
+	if err := h.showCatalogScreen(c, strings.Join(lines, "\n"), markup); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// showTicketQuantity - шаг выбора количества билетов в пределах min/max_per_order
+// и свободных мест
+func (h *MessageHandler) showTicketQuantity(ctx context.Context, c tele.Context, typeID pgtype.UUID) error {
+	draft := h.loadTicketDraft(ctx, c.Chat().ID)
+	sessionID, _ := parseUUID(draft.SessionID)
+	session, err := h.draftSession(ctx, draft, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Продажа на этот сеанс закрыта", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+
+	ticketType, err := h.queries.GetTicketType(ctx, storage.GetTicketTypeParams{ID: typeID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Этот билет больше не продается", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load ticket type: %w", err)
+	}
+
+	minimum, maximum := ticketQuantityBounds(ticketType, session)
+	if maximum < minimum {
+		return c.Respond(&tele.CallbackResponse{Text: "Недостаточно свободных мест", ShowAlert: true})
+	}
+
+	draft.TicketTypeID = uuidString(typeID)
+	h.saveTicketDraft(ctx, c, draft)
+
+	price, _ := numericToFloat(ticketType.Price)
+	text := fmt.Sprintf("🎟 %s - %s", ticketType.Name, h.formatPrice(price, ""))
+	switch {
+	case draft.PromoCode != "":
+		text += "\n🏷️ Промокод: " + draft.PromoCode
+	case price > 0:
+		text += "\nЕсть промокод? Отправьте /promo КОД"
+	}
+	text += "\n\nСколько билетов?"
+
+	markup := &tele.ReplyMarkup{}
+	row := []tele.InlineButton{}
+	for n := minimum; n <= maximum; n++ {
+		row = append(row, tele.InlineButton{Text: strconv.Itoa(n), Data: callbackEventQuantity + strconv.Itoa(n)})
+		if len(row) == 5 {
+			markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+			row = []tele.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+	markup.InlineKeyboard = append(markup.InlineKeyboard, []tele.InlineButton{{Text: "⬅️ Назад", Data: callbackEventSession + draft.SessionID}})
+
+	if err := h.showCatalogScreen(c, text, markup); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// orderTickets создает заказ билетов и заказ на оплату. Бесплатные билеты
+// выдаются сразу, платные - после оплаты (см. orderPaid)
+func (h *MessageHandler) orderTickets(ctx context.Context, c tele.Context, quantity int) error {
+	draft := h.loadTicketDraft(ctx, c.Chat().ID)
+	eventID, _ := parseUUID(draft.EventID)
+	sessionID, _ := parseUUID(draft.SessionID)
+	typeID, _ := parseUUID(draft.TicketTypeID)
+
+	event, err := h.upcomingEvent(ctx, eventID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Мероприятие больше недоступно", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	session, err := h.draftSession(ctx, draft, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Продажа на этот сеанс закрыта", ShowAlert: true})
+	}
+	if err != nil {
+		return err
+	}
+	ticketType, err := h.queries.GetTicketType(ctx, storage.GetTicketTypeParams{ID: typeID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Respond(&tele.CallbackResponse{Text: "Этот билет больше не продается", ShowAlert: true})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load ticket type: %w", err)
+	}
+
+	minimum, maximum := ticketQuantityBounds(ticketType, session)
+	switch {
+	case maximum < minimum:
+		return c.Respond(&tele.CallbackResponse{Text: "Недостаточно свободных мест", ShowAlert: true})
+	case quantity < minimum || quantity > maximum:
+		return c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("Можно купить от %d до %d билетов", minimum, maximum), ShowAlert: true})
+	}
+
+	payer := customerFromUser(c.Sender())
+	h.conversationValue(ctx, c.Chat().ID, "phone", &payer.Phone)
+	h.conversationValue(ctx, c.Chat().ID, "email", &payer.Email)
+	customerID, err := h.linkedCustomer(ctx, payer)
+	if err != nil {
+		return err
+	}
+
+	price, _ := numericToFloat(ticketType.Price)
+	total := price * float64(quantity)
+
+	var (
+		promo    *promoDiscount
+		discount float64
+	)
+	if draft.PromoCode != "" && minorUnits(total, h.settings.PaymentsCurrency) > 0 {
+		promo, err = h.applyPromo(ctx, draft.PromoCode, total, promoScope{TicketTypeID: typeID}, customerID)
+		var reason errPromo
+		if errors.As(err, &reason) {
+			draft.PromoCode = ""
+			h.saveTicketDraft(ctx, c, draft)
+			return c.Respond(&tele.CallbackResponse{Text: string(reason) + " Промокод снят, выберите количество еще раз.", ShowAlert: true})
+		}
+		if err != nil {
+			return err
+		}
+		discount = promo.Amount
+	}
+	amount := total - discount
+
+	data := ticketOrderData{
+		botOrigin:    h.botOrigin(c),
+		EventID:      draft.EventID,
+		EventTitle:   event.Title,
+		TicketTypeID: draft.TicketTypeID,
+		Quantity:     quantity,
+		Price:        price,
+	}
+	customFields, _ := json.Marshal(data)
+
+	ticketOrder, err := h.queries.CreateTicketOrder(ctx, storage.CreateTicketOrderParams{
+		ProfileID:      h.botConfig.ProfileID,
+		CustomerID:     customerID,
+		SessionID:      sessionID,
+		OrderNumber:    fmt.Sprintf("TG-%s-%s", time.Now().Format("060102"), strings.ToUpper(uuid.NewString()[:6])),
+		BuyerName:      optionalText(strings.TrimSpace(payer.FirstName + " " + payer.LastName)),
+		BuyerEmail:     optionalText(payer.Email),
+		BuyerPhone:     optionalText(payer.Phone),
+		Subtotal:       numericFromFloat(total),
+		DiscountAmount: numericFromFloat(discount),
+		TotalAmount:    numericFromFloat(amount),
+		PaymentMethod:  optionalText(h.settings.paymentMethod(featureEvents)),
+		PromoCodeID:    promoID(promo),
+		CustomFields:   customFields,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ticket order: %w", err)
+	}
+	ctx = utils.WithLogAttrs(ctx, "ticket_order_id", uuidString(ticketOrder.ID))
+	logger.InfoContext(ctx, "🎟 Заказ билетов создан", "order_number", ticketOrder.OrderNumber, "quantity", quantity)
+	h.saveTicketDraft(ctx, c, ticketDraft{})
+
+	free := minorUnits(amount, h.settings.PaymentsCurrency) == 0
+	if free && promo == nil {
+		if err := h.issueTickets(ctx, ticketOrder.ID, ""); err != nil {
+			return err
+		}
+		return c.Respond()
+	}
+	if free {
+		amount = 0
+	}
+
+	// Заказ на оплату создается и для билетов, целиком оплаченных промокодом:
+	// по нему учитывается использование промокода
+	order, paymentData, err := h.createPaymentOrder(ctx, payer, c.Chat().ID, paymentRequest{
+		Amount:      amount,
+		Description: "Билеты: " + event.Title,
+		EntityType:  entityTicketOrder,
+		EntityID:    ticketOrder.ID,
+		Items:       []paymentItem{{Name: ticketType.Name, Quantity: quantity, Price: price}},
+		PhotoURL:    event.CoverImage.String,
+		Method:      h.settings.paymentMethod(featureEvents),
+		Promo:       promo,
+	})
+	if err != nil {
+		return err
+	}
+	if free {
+		if err := h.closeFreeOrder(ctx, order); err != nil {
+			return err
+		}
+		return c.Respond()
+	}
+	if err := h.offerPayment(ctx, c, order, paymentData, event.CoverImage.String); err != nil {
+		return err
+	}
+	return c.Respond()
+}
+
+// applyTicketPromo - /promo при выборе количества билетов: проверяет промокод для
+// выбранного типа билета и сохраняет его в черновике. Минимальная сумма заказа
+// проверяется по наибольшему количеству - точная проверка будет при заказе
+func (h *MessageHandler) applyTicketPromo(ctx context.Context, c tele.Context, draft ticketDraft, code string) error {
+	sessionID, _ := parseUUID(draft.SessionID)
+	typeID, _ := parseUUID(draft.TicketTypeID)
+	session, err := h.draftSession(ctx, draft, sessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Продажа на этот сеанс закрыта.")
+	}
+	if err != nil {
+		return err
+	}
+	ticketType, err := h.queries.GetTicketType(ctx, storage.GetTicketTypeParams{ID: typeID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.Send("Этот билет больше не продается.")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load ticket type: %w", err)
+	}
+
+	price, _ := numericToFloat(ticketType.Price)
+	_, maximum := ticketQuantityBounds(ticketType, session)
+	promo, err := h.applyPromo(ctx, code, price*float64(maximum), promoScope{TicketTypeID: typeID}, pgtype.UUID{})
+	var reason errPromo
+	if errors.As(err, &reason) {
+		return c.Send(string(reason))
+	}
+	if err != nil {
+		return err
+	}
+
+	draft.PromoCode = promo.Code
+	h.saveTicketDraft(ctx, c, draft)
+	logger.InfoContext(ctx, "🏷️ Промокод применен к билетам", "code", promo.Code)
+	return c.Send(fmt.Sprintf("🏷️ Промокод %s применен: скидка будет учтена в заказе. Выберите количество билетов выше.", promo.Code))
+}
+
+// issueTickets отмечает заказ билетов оплаченным, создает билеты с QR-кодами
+// и ставит их отправку в чат. Повторный вызов для того же заказа билеты не
+// создает, а только повторяет отправку (TaskID задачи убирает дубли)
+func (h *MessageHandler) issueTickets(ctx context.Context, ticketOrderID pgtype.UUID, paymentRef string) error {
+	order, err := h.queries.GetTicketOrder(ctx, ticketOrderID)
+	if err != nil {
+		return fmt.Errorf("failed to load ticket order: %w", err)
+	}
+	if order.ProfileID != h.botConfig.ProfileID {
+		return fmt.Errorf("ticket order %s belongs to another profile", uuidString(ticketOrderID))
+	}
+
+	var data ticketOrderData
+	if err := json.Unmarshal(order.CustomFields, &data); err != nil || data.Quantity <= 0 {
+		return fmt.Errorf("ticket order %s has no bot data", uuidString(ticketOrderID))
+	}
+	typeID, _ := parseUUID(data.TicketTypeID)
+
+	issued := false
+	err = pgx.BeginFunc(ctx, h.pool, func(tx pgx.Tx) error {
+		q := h.queries.WithTx(tx)
+
+		updated, err := q.MarkTicketOrderPaid(ctx, storage.MarkTicketOrderPaidParams{
+			ID:        order.ID,
+			PaymentID: optionalText(paymentRef),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to mark ticket order paid: %w", err)
+		}
+		if updated == 0 {
+			return nil
+		}
+
+		// Места занимаются до создания билетов: при нехватке транзакция откатится
+		added, err := q.AddTicketSessionSold(ctx, storage.AddTicketSessionSoldParams{
+			Quantity: int32(data.Quantity),
+			ID:       order.SessionID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update sold count: %w", err)
+		}
+		if added == 0 {
+			return errTicketsSoldOut
+		}
+
+		for i := 0; i < data.Quantity; i++ {
+			if _, err := q.CreateTicket(ctx, storage.CreateTicketParams{
+				ProfileID:     order.ProfileID,
+				OrderID:       order.ID,
+				TicketTypeID:  typeID,
+				SessionID:     order.SessionID,
+				QrCode:        uuid.NewString(),
+				Price:         numericFromFloat(data.Price),
+				AttendeeName:  order.BuyerName,
+				AttendeeEmail: order.BuyerEmail,
+				AttendeePhone: order.BuyerPhone,
+			}); err != nil {
+				return fmt.Errorf("failed to create ticket: %w", err)
+			}
+		}
+		issued = true
+		return nil
+	})
+	if errors.Is(err, errTicketsSoldOut) {
+		return h.ticketsSoldOut(ctx, order, data, paymentRef)
+	}
+	if err != nil {
+		return err
+	}
+	if !issued {
+		// Повторная оплата того же заказа: билеты уже выданы, но могли не дойти
+		order, err = h.queries.GetTicketOrder(ctx, ticketOrderID)
+		if err != nil {
+			return fmt.Errorf("failed to load ticket order: %w", err)
+		}
+		if order.Status == ticketOrderStatusPaid {
+			return h.deliverTickets(ctx, order.ID)
+		}
+		return nil
+	}
+	logger.InfoContext(ctx, "🎟 Билеты выданы", "ticket_order_id", uuidString(order.ID), "quantity", data.Quantity)
+
+	session, err := h.queries.GetTicketSession(ctx, storage.GetTicketSessionParams{ID: order.SessionID, ProfileID: order.ProfileID})
+	if err != nil {
+		return fmt.Errorf("failed to load ticket session: %w", err)
+	}
+	h.scheduleReminder(ctx, reminderTicketOrder, order.ID, session.StartTime.Time, h.settings.eventReminderBefore())
+	return h.deliverTickets(ctx, order.ID)
+}
+
+// deliverTickets ставит отправку билетов в очередь: при сбое Telegram задача
+// повторится. Без очереди билеты отправляются сразу
+func (h *MessageHandler) deliverTickets(ctx context.Context, ticketOrderID pgtype.UUID) error {
+	if h.tasks == nil {
+		logger.WarnContext(ctx, "⚠️ Очередь задач не настроена, билеты отправляются без повтора")
+		return h.sendOrderTickets(ctx, ticketOrderID)
+	}
+	task, err := queue.NewTicketDeliveryTask(queue.TicketDeliveryPayload{
+		BotID:         h.botConfig.ID.Bytes,
+		TicketOrderID: ticketOrderID.Bytes,
+		Carrier:       tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create ticket delivery task: %w", err)
+	}
+	if _, err := h.tasks.EnqueueContext(ctx, task); err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to enqueue ticket delivery: %w", err)
+	}
+	return nil
+}
+
+// handleTicketDelivery отправляет клиенту билеты оплаченного заказа
+func (m *Manager) handleTicketDelivery(ctx context.Context, t *asynq.Task) error {
+	var p queue.TicketDeliveryPayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %w: %w", err, asynq.SkipRetry)
+	}
+
+	instance, err := m.taskBot(ctx, t, p.BotID)
+	if instance == nil {
+		return err
+	}
+	ctx = utils.WithLogAttrs(botLogContext(ctx, instance.BotID.String(), instance.ProfileID.String()),
+		"ticket_order_id", p.TicketOrderID.String())
+
+	err = instance.Handler.sendOrderTickets(ctx, pgtype.UUID{Bytes: p.TicketOrderID, Valid: true})
+	if errors.Is(err, pgx.ErrNoRows) {
+		logger.WarnContext(ctx, "⚠️ Заказ билетов не найден, отправка пропущена")
+		return nil
+	}
+	return err
+}
+
+// sendOrderTickets перечитывает оплаченный заказ и присылает его билеты
+func (h *MessageHandler) sendOrderTickets(ctx context.Context, ticketOrderID pgtype.UUID) error {
+	order, err := h.queries.GetTicketOrder(ctx, ticketOrderID)
+	if err != nil {
+		return fmt.Errorf("failed to load ticket order: %w", err)
+	}
+	if order.ProfileID != h.botConfig.ProfileID || order.Status != ticketOrderStatusPaid {
+		return nil
+	}
+	var data ticketOrderData
+	json.Unmarshal(order.CustomFields, &data)
+
+	session, err := h.queries.GetTicketSession(ctx, storage.GetTicketSessionParams{ID: order.SessionID, ProfileID: order.ProfileID})
+	if err != nil {
+		return fmt.Errorf("failed to load ticket session: %w", err)
+	}
+	if err := h.sendTickets(ctx, order, data, session); err != nil {
+		return err
+	}
+	logger.InfoContext(ctx, "🎟 Билеты отправлены", "ticket_order_id", uuidString(order.ID))
+	return nil
+}
+
+// ticketsSoldOut отменяет заказ, на который не хватило мест. Если он был оплачен,
+// сотрудники получают просьбу вернуть деньги, а клиент - сообщение об этом
+func (h *MessageHandler) ticketsSoldOut(ctx context.Context, order storage.TicketOrder, data ticketOrderData, paymentRef string) error {
+	notes := "Мест не хватило при выдаче билетов"
+	if paymentRef != "" {
+		notes += ", нужен возврат оплаты"
+	}
+	cancelled, err := h.queries.MarkTicketOrderSoldOut(ctx, storage.MarkTicketOrderSoldOutParams{
+		ID:        order.ID,
+		PaymentID: optionalText(paymentRef),
+		Notes:     optionalText(notes),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to cancel sold out ticket order: %w", err)
+	}
+	if cancelled == 0 {
+		return nil
+	}
+	logger.WarnContext(ctx, "⚠️ Мест на сеансе не хватило, заказ билетов отменен", "ticket_order_id", uuidString(order.ID))
+
+	text := "😔 К сожалению, пока шла оплата, билеты закончились. Заказ " + order.OrderNumber + " отменен."
+	if paymentRef != "" {
+		h.notifyStaff(ctx, fmt.Sprintf("⚠️ Билеты по оплаченному заказу %s не выданы: на сеансе не хватило мест. Верните оплату клиенту", order.OrderNumber))
+		text += " Деньги вернем, с вами свяжутся."
+	}
+	if data.ChatID == 0 {
+		return nil
+	}
+	if _, err := h.engine.Bot().Send(tele.ChatID(data.ChatID), text); err != nil {
+		return fmt.Errorf("failed to send sold out notice: %w", err)
+	}
+	h.logBotMessage(ctx, data.ChatID, data.TelegramUserID, text, map[string]interface{}{"ticket_order_id": uuidString(order.ID)})
+	return nil
+}
+
+// sendTickets присылает билеты заказа - по QR-коду на билет
+func (h *MessageHandler) sendTickets(ctx context.Context, order storage.TicketOrder, data ticketOrderData, session storage.GetTicketSessionRow) error {
+	if data.ChatID == 0 {
+		return nil
+	}
+	tickets, err := h.queries.ListOrderTickets(ctx, order.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load tickets: %w", err)
+	}
+
+	header := fmt.Sprintf("«%s»\n📅 %s", data.EventTitle, formatEventTime(session.StartTime))
+	if place := eventPlace(false, session.VenueName.String, session.VenueAddress.String); place != "" {
+		header += "\n" + place
+	}
+	for i, ticket := range tickets {
+		caption := fmt.Sprintf("🎟 Билет %d из %d: %s\n%s\n\nЗаказ %s. Покажите QR-код на входе.",
+			i+1, len(tickets), ticket.TypeName.String, header, order.OrderNumber)
+		if err := h.sendQRCode(ctx, data.ChatID, data.TelegramUserID, ticket.QrCode, caption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTicketCheckout перед списанием денег проверяет, что заказ билетов
+// еще ждет оплаты и на сеансе хватает мест
+func (h *MessageHandler) validateTicketCheckout(ctx context.Context, ticketOrderID pgtype.UUID) error {
+	order, err := h.queries.GetTicketOrder(ctx, ticketOrderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load ticket order: %w", err)
+	}
+	if order.Status != paymentStatusPending {
+		return errCheckout(checkoutErrUnavailable)
+	}
+
+	var data ticketOrderData
+	json.Unmarshal(order.CustomFields, &data)
+
+	session, err := h.queries.GetTicketSession(ctx, storage.GetTicketSessionParams{ID: order.SessionID, ProfileID: h.botConfig.ProfileID})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load ticket session: %w", err)
+	}
+	if session.StartTime.Time.Before(time.Now()) {
+		return errCheckout(checkoutErrUnavailable)
+	}
+	if left, limited := sessionSeatsLeft(session.Capacity, session.SoldCount); limited && left < data.Quantity {
+		return errCheckout(checkoutErrSoldOut)
+	}
+	return nil
+}
+
+// ticketOrderReminder - текст напоминания о сеансе для оплаченного заказа билетов
+func (h *MessageHandler) ticketOrderReminder(ctx context.Context, ticketOrderID pgtype.UUID, startsAt time.Time) (reminder, error) {
+	order, err := h.queries.GetTicketOrder(ctx, ticketOrderID)
+	if err != nil {
+		return reminder{}, err
+	}
+	if order.Status != ticketOrderStatusPaid {
+		return reminder{}, errReminderStale
+	}
+	session, err := h.queries.GetTicketSession(ctx, storage.GetTicketSessionParams{ID: order.SessionID, ProfileID: order.ProfileID})
+	if err != nil {
+		return reminder{}, err
+	}
+	if !sameStart(session.StartTime, startsAt) {
+		return reminder{}, errReminderStale
+	}
+
+	var data ticketOrderData
+	json.Unmarshal(order.CustomFields, &data)
+
+	text := fmt.Sprintf("⏰ Напоминаем: «%s» начнется %s.", data.EventTitle, formatEventTime(session.StartTime))
+	if place := eventPlace(false, session.VenueName.String, session.VenueAddress.String); place != "" {
+		text += "\n" + place
+	}
+	text += fmt.Sprintf("\nБилетов: %d, QR-коды - в сообщениях выше.", data.Quantity)
+	return reminder{ChatID: data.ChatID, Text: text}, nil
+}
+
+// ticketQuantityBounds - сколько билетов можно купить в одном заказе
+func ticketQuantityBounds(ticketType storage.GetTicketTypeRow, session storage.ListEventSessionsRow) (int, int) {
+	minimum, maximum := 1, defaultMaxPerOrder
+	if ticketType.MinPerOrder.Valid && ticketType.MinPerOrder.Int32 > 0 {
+		minimum = int(ticketType.MinPerOrder.Int32)
+	}
+	if ticketType.MaxPerOrder.Valid && ticketType.MaxPerOrder.Int32 > 0 {
+		maximum = int(ticketType.MaxPerOrder.Int32)
+	}
+	if left, limited := sessionSeatsLeft(session.Capacity, session.SoldCount); limited && left < maximum {
+		maximum = left
+	}
+	return minimum, maximum
+}
+
+// sessionSeatsLeft - свободные места сеанса; limited = false - вместимость не задана
+func sessionSeatsLeft(capacity pgtype.Int4, sold int32) (int, bool) {
+	if !capacity.Valid || capacity.Int32 <= 0 {
+		return 0, false
+	}
+	return int(capacity.Int32 - sold), true
+}
+
+// promoID - id примененного промокода для заказа; без промокода - NULL
+func promoID(promo *promoDiscount) pgtype.UUID {
+	if promo == nil {
+		return pgtype.UUID{}
+	}
+	return promo.ID
+}
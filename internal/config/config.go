@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config - настройки сервиса.
+//
+// Порядок загрузки: значения по умолчанию -> YAML файл из CONFIG_FILE (необязательный)
+// -> переменные окружения. Секреты (пароли, ключи, строка подключения к БД) можно
+// передать файлом: переменная с суффиксом _FILE (например, REDIS_PASSWORD_FILE=/run/secrets/redis_password)
+// имеет приоритет над самой переменной. Пробелы и перевод строки по краям файла отбрасываются.
+type Config struct {
+	Database Database `yaml:"database"`
+	Redis    Redis    `yaml:"redis"`
+	Queue    Queue    `yaml:"queue"`
+	AI       AI       `yaml:"ai"`
+	Admin    Admin    `yaml:"admin"`
+	Payments Payments `yaml:"payments"`
+	Cluster  Cluster  `yaml:"cluster"`
+	Log      Log      `yaml:"log"`
+	Shutdown Shutdown `yaml:"shutdown"`
+}
+
+// Database - подключение к PostgreSQL
+type Database struct {
+	URL string `yaml:"url"` // DATABASE_URL (секрет)
+}
+
+// Redis - подключение к Redis (общее для кэша, блокировок и Asynq)
+type Redis struct {
+	Host     string `yaml:"host"`     // REDIS_HOST
+	Port     int    `yaml:"port"`     // REDIS_PORT
+	User     string `yaml:"user"`     // REDIS_USER
+	Password string `yaml:"password"` // REDIS_PASSWORD (секрет)
+	DB       int    `yaml:"db"`       // REDIS_DB
+}
+
+// Addr возвращает адрес Redis в виде host:port
+func (r Redis) Addr() string {
+	return fmt.Sprintf("%s:%d", r.Host, r.Port)
+}
+
+// Queue - обработчик задач Asynq
+type Queue struct {
+	Concurrency int `yaml:"concurrency"` // QUEUE_CONCURRENCY
+}
+
+// AI - доступ к моделям и лимиты
+type AI struct {
+	OpenAIAPIKey string `yaml:"openai_api_key"` // OPENAI_API_KEY (секрет)
+	// FallbackModel - модель по умолчанию для режима "fallback" при исчерпании лимита
+	FallbackModel string `yaml:"fallback_model"` // AI_FALLBACK_MODEL
+	// TokenLimits - месячные лимиты токенов по тарифу профиля, 0 - без ограничений
+	TokenLimits map[string]int64 `yaml:"token_limits"` // AI_TOKEN_LIMITS="free:50000,basic:500000"
+	// DefaultTokenLimit - лимит для тарифов, которых нет в TokenLimits
+	DefaultTokenLimit int64 `yaml:"default_token_limit"` // AI_DEFAULT_TOKEN_LIMIT
+	STT               STT   `yaml:"stt"`
+}
+
+// STT - распознавание речи
+type STT struct {
+	// Provider: openai, local или none; пусто - openai, если задан OPENAI_API_KEY
+	Provider string `yaml:"provider"` // STT_PROVIDER
+	BaseURL  string `yaml:"base_url"` // STT_BASE_URL
+	APIKey   string `yaml:"api_key"`  // STT_API_KEY (секрет)
+	Model    string `yaml:"model"`    // STT_MODEL
+	Language string `yaml:"language"` // STT_LANGUAGE
+}
+
+// Admin - служебный HTTP сервер
+type Admin struct {
+	Addr string `yaml:"addr"` // ADMIN_ADDR
+	// Secret - секрет для GET /bots (заголовок X-Admin-Secret); пусто - эндпоинт всегда отвечает 401
+	Secret string `yaml:"secret"` // ADMIN_SECRET
+}
+
+// Payments - оплата заказов из workflows (узел payment)
+type Payments struct {
+	// PageURL - страница оплаты CRM, {id} заменяется на id заказа. Страница создает
+	// платеж в шлюзе профиля и перенаправляет на него
+	PageURL string `yaml:"page_url"` // PAYMENT_PAGE_URL, например "https://pay.example.com/orders/{id}"
+	// WebhookSecret - секрет вебхука смены статуса заказа (POST /webhooks/payment,
+	// заголовок X-Webhook-Secret); пусто - вебхук выключен
+	WebhookSecret string `yaml:"webhook_secret"` // PAYMENT_WEBHOOK_SECRET (секрет)
+	// NotifyChannel - канал PostgreSQL NOTIFY со сменой статуса заказа; пусто - не слушать
+	NotifyChannel string `yaml:"notify_channel"` // PAYMENT_NOTIFY_CHANNEL
+}
+
+// Cluster - распределение ботов между репликами
+type Cluster struct {
+	// ReplicaID - идентификатор реплики; пусто - hostname + случайный суффикс
+	ReplicaID string `yaml:"replica_id"` // REPLICA_ID
+}
+
+// Log - логирование
+type Log struct {
+	Format string            `yaml:"format"` // LOG_FORMAT: json или text
+	Level  string            `yaml:"level"`  // LOG_LEVEL
+	Levels map[string]string `yaml:"levels"` // LOG_LEVELS="workflow=debug,ai=warn"
+	Redact bool              `yaml:"redact"` // LOG_REDACT
+}
+
+// Shutdown - остановка сервиса
+type Shutdown struct {
+	// Timeout - сколько ждать обработчики апдейтов и активные задачи очереди
+	Timeout time.Duration `yaml:"timeout"` // SHUTDOWN_TIMEOUT, например "20s"
+}
+
+// Default возвращает настройки по умолчанию
+func Default() *Config {
+	return &Config{
+		Redis: Redis{
+			Host: "localhost",
+			Port: 6379,
+		},
+		Queue: Queue{
+			Concurrency: 10,
+		},
+		AI: AI{
+			FallbackModel: "gpt-4o-mini",
+			TokenLimits:   map[string]int64{},
+		},
+		Admin: Admin{
+			Addr: ":8080",
+		},
+		Payments: Payments{
+			NotifyChannel: "payment_order_status",
+		},
+		Log: Log{
+			Format: "json",
+			Level:  "info",
+			Levels: map[string]string{},
+			Redact: true,
+		},
+		Shutdown: Shutdown{
+			Timeout: 20 * time.Second,
+		},
+	}
+}
+
+// Load загружает и проверяет настройки. Ошибка содержит все найденные проблемы сразу.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	var errs []error
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	env := &envReader{}
+	cfg.applyEnv(env)
+	errs = append(errs, env.errs...)
+	errs = append(errs, cfg.Validate()...)
+
+	if len(errs) > 0 {
+		return nil, &Error{Errs: errs}
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("CONFIG_FILE: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("CONFIG_FILE %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет настройки и возвращает все найденные ошибки
+func (c *Config) Validate() []error {
+	var errs []error
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Database.URL == "" {
+		add("DATABASE_URL is required")
+	}
+
+	if c.Redis.Host == "" {
+		add("REDIS_HOST must not be empty")
+	}
+	if c.Redis.Port <= 0 || c.Redis.Port > 65535 {
+		add("REDIS_PORT must be between 1 and 65535, got %d", c.Redis.Port)
+	}
+	if c.Redis.DB < 0 {
+		add("REDIS_DB must not be negative, got %d", c.Redis.DB)
+	}
+
+	if c.Queue.Concurrency <= 0 {
+		add("QUEUE_CONCURRENCY must be positive, got %d", c.Queue.Concurrency)
+	}
+
+	for tariff, limit := range c.AI.TokenLimits {
+		if limit < 0 {
+			add("AI_TOKEN_LIMITS: limit for %q must not be negative", tariff)
+		}
+	}
+	if c.AI.DefaultTokenLimit < 0 {
+		add("AI_DEFAULT_TOKEN_LIMIT must not be negative, got %d", c.AI.DefaultTokenLimit)
+	}
+
+	switch c.AI.STT.Provider {
+	case "", "none", "local":
+	case "openai":
+		if c.AI.OpenAIAPIKey == "" {
+			add("STT_PROVIDER=openai requires OPENAI_API_KEY")
+		}
+	default:
+		add("STT_PROVIDER must be one of openai, local, none, got %q", c.AI.STT.Provider)
+	}
+
+	if c.Admin.Addr == "" {
+		add("ADMIN_ADDR must not be empty")
+	}
+
+	if c.Payments.PageURL != "" && !strings.Contains(c.Payments.PageURL, "{id}") {
+		add("PAYMENT_PAGE_URL must contain {id}, got %q", c.Payments.PageURL)
+	}
+
+	if c.Shutdown.Timeout <= 0 {
+		add("SHUTDOWN_TIMEOUT must be positive, got %s", c.Shutdown.Timeout)
+	}
+
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		add("LOG_FORMAT must be json or text, got %q", c.Log.Format)
+	}
+	if !validLevel(c.Log.Level) {
+		add("LOG_LEVEL: unknown level %q", c.Log.Level)
+	}
+	for module, level := range c.Log.Levels {
+		if !validLevel(level) {
+			add("LOG_LEVELS: unknown level %q for module %q", level, module)
+		}
+	}
+
+	return errs
+}
+
+func validLevel(value string) bool {
+	var level slog.Level
+	return level.UnmarshalText([]byte(strings.TrimSpace(value))) == nil
+}
+
+// Error - ошибки загрузки настроек; выводятся все сразу, чтобы не чинить их по одной
+type Error struct {
+	Errs []error
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, 0, len(e.Errs)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration (%d errors):", len(e.Errs)))
+	for _, err := range e.Errs {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *Error) Unwrap() []error {
+	return e.Errs
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applyEnv переносит переменные окружения поверх значений из файла
+func (c *Config) applyEnv(env *envReader) {
+	// В YAML списки могут быть заданы пустыми (null)
+	if c.AI.TokenLimits == nil {
+		c.AI.TokenLimits = make(map[string]int64)
+	}
+	if c.Log.Levels == nil {
+		c.Log.Levels = make(map[string]string)
+	}
+
+	env.secret("DATABASE_URL", &c.Database.URL)
+
+	env.string("REDIS_HOST", &c.Redis.Host)
+	env.int("REDIS_PORT", &c.Redis.Port)
+	env.string("REDIS_USER", &c.Redis.User)
+	env.secret("REDIS_PASSWORD", &c.Redis.Password)
+	env.int("REDIS_DB", &c.Redis.DB)
+
+	env.int("QUEUE_CONCURRENCY", &c.Queue.Concurrency)
+
+	env.secret("OPENAI_API_KEY", &c.AI.OpenAIAPIKey)
+	env.string("AI_FALLBACK_MODEL", &c.AI.FallbackModel)
+	env.tokenLimits("AI_TOKEN_LIMITS", c.AI.TokenLimits)
+	env.int64("AI_DEFAULT_TOKEN_LIMIT", &c.AI.DefaultTokenLimit)
+
+	env.string("STT_PROVIDER", &c.AI.STT.Provider)
+	env.string("STT_BASE_URL", &c.AI.STT.BaseURL)
+	env.secret("STT_API_KEY", &c.AI.STT.APIKey)
+	env.string("STT_MODEL", &c.AI.STT.Model)
+	env.string("STT_LANGUAGE", &c.AI.STT.Language)
+
+	env.string("ADMIN_ADDR", &c.Admin.Addr)
+	env.secret("ADMIN_SECRET", &c.Admin.Secret)
+	env.string("REPLICA_ID", &c.Cluster.ReplicaID)
+
+	env.string("PAYMENT_PAGE_URL", &c.Payments.PageURL)
+	env.secret("PAYMENT_WEBHOOK_SECRET", &c.Payments.WebhookSecret)
+	env.string("PAYMENT_NOTIFY_CHANNEL", &c.Payments.NotifyChannel)
+
+	env.string("LOG_FORMAT", &c.Log.Format)
+	env.string("LOG_LEVEL", &c.Log.Level)
+	env.pairs("LOG_LEVELS", "=", c.Log.Levels)
+	env.bool("LOG_REDACT", &c.Log.Redact)
+
+	env.duration("SHUTDOWN_TIMEOUT", &c.Shutdown.Timeout)
+}
+
+// envReader читает переменные окружения и копит ошибки разбора
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) fail(name string, err error) {
+	r.errs = append(r.errs, fmt.Errorf("%s: %w", name, err))
+}
+
+func (r *envReader) string(name string, dst *string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*dst = strings.TrimSpace(value)
+	}
+}
+
+// secret читает секрет из файла NAME_FILE (Docker/Kubernetes secrets) или из NAME
+func (r *envReader) secret(name string, dst *string) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			r.fail(name+"_FILE", err)
+			return
+		}
+		*dst = strings.TrimSpace(string(data))
+		return
+	}
+	r.string(name, dst)
+}
+
+func (r *envReader) int(name string, dst *int) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		r.fail(name, fmt.Errorf("not a number: %q", value))
+		return
+	}
+	*dst = n
+}
+
+func (r *envReader) int64(name string, dst *int64) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		r.fail(name, fmt.Errorf("not a number: %q", value))
+		return
+	}
+	*dst = n
+}
+
+func (r *envReader) bool(name string, dst *bool) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		r.fail(name, fmt.Errorf("not a boolean: %q", value))
+		return
+	}
+	*dst = b
+}
+
+func (r *envReader) duration(name string, dst *time.Duration) {
+	value, ok := os.LookupEnv(name)
+	if !ok || strings.TrimSpace(value) == "" {
+		return
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		r.fail(name, fmt.Errorf("not a duration: %q", value))
+		return
+	}
+	*dst = d
+}
+
+// pairs разбирает список вида "key=value,key2=value2"
+func (r *envReader) pairs(name, sep string, dst map[string]string) {
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, sep)
+		if !ok {
+			r.fail(name, fmt.Errorf("expected key%svalue, got %q", sep, pair))
+			continue
+		}
+		dst[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+}
+
+// tokenLimits разбирает лимиты вида "free:50000,basic:500000"
+func (r *envReader) tokenLimits(name string, dst map[string]int64) {
+	values := make(map[string]string)
+	r.pairs(name, ":", values)
+	for tariff, value := range values {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			r.fail(name, fmt.Errorf("limit for %q is not a number: %q", tariff, value))
+			continue
+		}
+		dst[tariff] = limit
+	}
+}
@@ -0,0 +1,94 @@
+package metrics
+
+// Метрики сервиса. Лейблы bot и profile - UUID бота и профиля бизнеса:
+// по ним видно, что сломался бот конкретного клиента.
+var (
+	UpdatesReceived = Default.NewCounterVec(
+		"tg_updates_received_total",
+		"Апдейты, полученные от Telegram",
+		"bot", "profile",
+	)
+
+	MessagesSent = Default.NewCounterVec(
+		"tg_messages_sent_total",
+		"Успешные вызовы send*/copy/forward Bot API",
+		"bot", "profile", "method",
+	)
+
+	MessagesFailed = Default.NewCounterVec(
+		"tg_messages_failed_total",
+		"Неудачные вызовы send*/copy/forward Bot API",
+		"bot", "profile", "method",
+	)
+
+	BotErrors = Default.NewCounterVec(
+		"tg_bot_errors_total",
+		"Ошибки поллера и обработчиков бота",
+		"bot", "profile",
+	)
+
+	BotUp = Default.NewGaugeVec(
+		"tg_bot_up",
+		"1 - бот работает на этой реплике, 0 - бот в состоянии failed",
+		"bot", "profile", "username",
+	)
+
+	HandlerDuration = Default.NewHistogramVec(
+		"tg_handler_duration_seconds",
+		"Время обработки апдейта (без ожидания в очереди чата)",
+		DefaultBuckets,
+		"bot", "profile",
+	)
+
+	WorkflowExecutions = Default.NewCounterVec(
+		"tg_workflow_executions_total",
+		"Переходы выполнений workflow в статус (waiting, completed, failed)",
+		"bot", "profile", "status",
+	)
+
+	WorkflowNodes = Default.NewCounterVec(
+		"tg_workflow_nodes_total",
+		"Выполненные узлы workflow",
+		"node_type", "status",
+	)
+
+	WorkflowNodeDuration = Default.NewHistogramVec(
+		"tg_workflow_node_duration_seconds",
+		"Время выполнения узла workflow",
+		DefaultBuckets,
+		"node_type",
+	)
+
+	QueueSize = Default.NewGaugeVec(
+		"tg_asynq_queue_size",
+		"Задачи в очередях Asynq по состояниям",
+		"queue", "state",
+	)
+
+	AIRequests = Default.NewCounterVec(
+		"tg_ai_requests_total",
+		"Запросы к AI провайдеру",
+		"bot", "profile", "model", "status",
+	)
+
+	AIRequestDuration = Default.NewHistogramVec(
+		"tg_ai_request_duration_seconds",
+		"Время ответа AI провайдера",
+		DefaultBuckets,
+		"bot", "profile", "model",
+	)
+
+	AITokens = Default.NewCounterVec(
+		"tg_ai_tokens_total",
+		"Токены AI (type: prompt, completion)",
+		"bot", "profile", "model", "type",
+	)
+)
+
+// Status - значение лейбла status по ошибке
+func Status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
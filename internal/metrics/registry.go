@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets - границы гистограмм длительности (секунды)
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Registry - метрики сервиса в реестре Prometheus и функции, которые
+// пересчитывают gauge перед выдачей /metrics
+type Registry struct {
+	registry *prometheus.Registry
+
+	mu    sync.Mutex
+	hooks []func()
+	// scrapeMu - одна выдача за раз: хуки пересчитывают gauge, и параллельная
+	// выдача не должна видеть их наполовину обновленными
+	scrapeMu sync.Mutex
+}
+
+// NewRegistry создает реестр со стандартными метриками процесса и Go runtime
+func NewRegistry() *Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		prometheus.NewGoCollector(),
+	)
+	return &Registry{registry: registry}
+}
+
+// Default - реестр, в котором объявлены метрики сервиса
+var Default = NewRegistry()
+
+// OnScrape регистрирует функцию, которая обновляет метрики перед каждой выдачей
+// (например, глубину очередей)
+func (r *Registry) OnScrape(hook func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Handler отдает метрики в текстовом формате Prometheus
+func (r *Registry) Handler() http.Handler {
+	next := promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		hooks := append([]func(){}, r.hooks...)
+		r.mu.Unlock()
+
+		r.scrapeMu.Lock()
+		defer r.scrapeMu.Unlock()
+
+		for _, hook := range hooks {
+			hook()
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// NewCounterVec регистрирует счетчик с лейблами
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	r.registry.MustRegister(c)
+	return c
+}
+
+// NewGaugeVec регистрирует gauge с лейблами
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	r.registry.MustRegister(g)
+	return g
+}
+
+// NewHistogramVec регистрирует гистограмму с лейблами
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	r.registry.MustRegister(h)
+	return h
+}
+
+// Refill пересчитывает gauge целиком: fill задает значения серий через set, и новые
+// серии заменяют старые. Серии, которые fill не задал, удаляются.
+// Если fill вернул ошибку, старые значения остаются
+func Refill(g *prometheus.GaugeVec, fill func(set func(value float64, labelValues ...string) error) error) error {
+	type point struct {
+		value  float64
+		labels []string
+	}
+
+	var fresh []point
+	err := fill(func(value float64, labelValues ...string) error {
+		// Проверяем число лейблов до замены серий
+		if _, err := g.GetMetricWithLabelValues(labelValues...); err != nil {
+			return err
+		}
+		fresh = append(fresh, point{value: value, labels: labelValues})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	g.Reset()
+	for _, p := range fresh {
+		g.WithLabelValues(p.labels...).Set(p.value)
+	}
+	return nil
+}
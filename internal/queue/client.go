@@ -1,75 +1,66 @@
 package queue
 
 import (
-	"fmt"
-	"os"
+	"strings"
 
+	"github.com/botjoker/sambacrm-business-tg/internal/config"
 	"github.com/hibiken/asynq"
 )
 
 // NewAsynqClient создает клиента Asynq для создания задач
-func NewAsynqClient() (*asynq.Client, error) {
-	redisAddr := getRedisAddr()
-	redisPassword := getRedisPassword()
-	redisUser := os.Getenv("REDIS_USER")
-
-	client := asynq.NewClient(asynq.RedisClientOpt{
-		Addr:     redisAddr,
-		Username: redisUser,
-		Password: redisPassword,
-		DB:       0,
-	})
+func NewAsynqClient(cfg config.Redis) (*asynq.Client, error) {
+	client := asynq.NewClient(redisClientOpt(cfg))
 
 	return client, nil
 }
 
-// NewAsynqServer создает сервер Asynq для обработки задач
-func NewAsynqServer() (*asynq.Server, error) {
-	redisAddr := getRedisAddr()
-	redisPassword := getRedisPassword()
-	redisUser := os.Getenv("REDIS_USER")
-
+// NewAsynqServer создает сервер Asynq для обработки задач.
+// Сервер разбирает общую очередь и собственную очередь реплики (задачи ее ботов).
+// При остановке активные задачи доделываются не дольше SHUTDOWN_TIMEOUT.
+func NewAsynqServer(redisCfg config.Redis, queueCfg config.Queue, shutdownCfg config.Shutdown, replicaID string) (*asynq.Server, error) {
 	srv := asynq.NewServer(
-		asynq.RedisClientOpt{
-			Addr:     redisAddr,
-			Username: redisUser,
-			Password: redisPassword,
-			DB:       0,
-		},
+		redisClientOpt(redisCfg),
 		asynq.Config{
-			Concurrency: 10,
+			Concurrency:     queueCfg.Concurrency,
+			ShutdownTimeout: shutdownCfg.Timeout,
+			Queues: map[string]int{
+				ReplicaQueue(replicaID): 2,
+				DefaultQueue:            1,
+			},
 		},
 	)
 
 	return srv, nil
 }
 
-// getRedisAddr возвращает адрес Redis
-func getRedisAddr() string {
-	host := os.Getenv("REDIS_HOST")
-	if host == "" {
-		host = "localhost"
-	}
+// DefaultQueue - общая очередь, в которую задачи ставят бэкенд CRM и сами реплики
+const DefaultQueue = "default"
 
-	port := os.Getenv("REDIS_PORT")
-	if port == "" {
-		port = "6379"
-	}
+// replicaQueuePrefix - префикс очередей реплик: задачи бота выполняет только реплика,
+// на которой он запущен, поэтому задачи чужих ботов пересылаются в очередь владельца
+const replicaQueuePrefix = "bot:"
 
-	return fmt.Sprintf("%s:%s", host, port)
+// ReplicaQueue возвращает имя очереди реплики
+func ReplicaQueue(replicaID string) string {
+	return replicaQueuePrefix + replicaID
 }
 
-// getRedisPassword возвращает пароль Redis (из файла или env)
-func getRedisPassword() string {
-	// Пробуем прочитать из Docker secret
-	passwordFile := os.Getenv("REDIS_PASSWORD_FILE")
-	if passwordFile != "" {
-		data, err := os.ReadFile(passwordFile)
-		if err == nil {
-			return string(data)
-		}
-	}
+// QueueReplica возвращает реплику, которой принадлежит очередь, или false для общих очередей
+func QueueReplica(queue string) (string, bool) {
+	return strings.CutPrefix(queue, replicaQueuePrefix)
+}
 
-	// Берем из env переменной
-	return os.Getenv("REDIS_PASSWORD")
+// NewAsynqInspector создает инспектор очередей (проверка готовности, мониторинг)
+func NewAsynqInspector(cfg config.Redis) *asynq.Inspector {
+	return asynq.NewInspector(redisClientOpt(cfg))
+}
+
+// redisClientOpt - подключение Asynq к Redis
+func redisClientOpt(cfg config.Redis) asynq.RedisClientOpt {
+	return asynq.RedisClientOpt{
+		Addr:     cfg.Addr(),
+		Username: cfg.User,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
 }
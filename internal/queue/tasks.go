@@ -4,18 +4,31 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"time"
 
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 )
 
+var logger = utils.Module("queue")
+
 const (
 	// Типы задач
 	TypeWorkflowDelay    = "workflow:delay"
 	TypeWorkflowSchedule = "workflow:schedule"
 	TypeSendMessage      = "telegram:send"
+	TypeWorkflowTimeout  = "workflow:timeout"
+	TypeHandoffReply     = "handoff:reply"
+	TypeHandoffClose     = "handoff:close"
+	TypeBroadcast        = "telegram:broadcast"
+	TypePaymentStatus    = "payment:status"
+	TypeReminder         = "telegram:reminder"
+	TypeCRMEvent         = "crm:event"
+	TypeTemplateMessage  = "telegram:template"
+	TypeBookingHold      = "booking:hold"
+	TypeTicketDelivery   = "telegram:tickets"
 )
 
 // DelayWorkflowPayload - данные для отложенного выполнения workflow
@@ -25,6 +38,9 @@ type DelayWorkflowPayload struct {
 	ChatID      int64     `json:"chat_id"`
 	UserID      int64     `json:"user_id"`
 	DelaySeconds int      `json:"delay_seconds"`
+
+	// Трейс шага, поставившего задачу
+	tracing.Carrier
 }
 
 // NewDelayWorkflowTask создает задачу для отложенного выполнения
@@ -49,7 +65,7 @@ func HandleDelayWorkflow(ctx context.Context, t *asynq.Task) error {
 		return fmt.Errorf("json.Unmarshal failed: %w", err)
 	}
 
-	log.Printf("⏱️ Выполнение отложенного workflow %s для profile %s", p.WorkflowID, p.ProfileID)
+	logger.InfoContext(ctx, "⏱️ Выполнение отложенного workflow", "workflow_id", p.WorkflowID, "profile_id", p.ProfileID, "chat_id", p.ChatID)
 
 	// TODO: запустить workflow через engine
 	
@@ -80,9 +96,270 @@ func HandleScheduleWorkflow(ctx context.Context, t *asynq.Task) error {
 		return fmt.Errorf("json.Unmarshal failed: %w", err)
 	}
 
-	log.Printf("📅 Выполнение workflow по расписанию %s для profile %s", p.WorkflowID, p.ProfileID)
+	logger.InfoContext(ctx, "📅 Выполнение workflow по расписанию", "workflow_id", p.WorkflowID, "profile_id", p.ProfileID)
 
 	// TODO: запустить workflow через engine
 
 	return nil
 }
+
+// WorkflowTimeoutPayload - данные для таймаута ожидания ввода в workflow
+type WorkflowTimeoutPayload struct {
+	BotID       uuid.UUID `json:"bot_id"`
+	ExecutionID uuid.UUID `json:"execution_id"`
+	ChatID      int64     `json:"chat_id"`
+	// Token связывает задачу с конкретным ожиданием: если пользователь уже ответил
+	// и workflow ждет на другом шаге, таймаут игнорируется
+	Token string `json:"token"`
+
+	// Трейс шага, поставившего задачу
+	tracing.Carrier
+}
+
+// NewWorkflowTimeoutTask создает задачу, которая сработает, если пользователь не ответит за timeout
+func NewWorkflowTimeoutTask(payload WorkflowTimeoutPayload, timeout time.Duration) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypeWorkflowTimeout,
+		data,
+		asynq.ProcessIn(timeout),
+		asynq.MaxRetry(3),
+	), nil
+}
+
+// HandoffPayload - ответ оператора из CRM в диалог, переданный оператору
+// (handoff:reply), или закрытие такого диалога (handoff:close).
+// Задачи ставит бэкенд CRM.
+type HandoffPayload struct {
+	BotID     uuid.UUID `json:"bot_id"`
+	HandoffID uuid.UUID `json:"handoff_id"`
+	// AccountID - оператор (core_accounts), от имени которого ответ попадет в лог
+	AccountID uuid.UUID `json:"account_id"`
+	// Text - текст ответа (для handoff:reply)
+	Text string `json:"text,omitempty"`
+
+	tracing.Carrier
+}
+
+// broadcastTimeout - рассылка идет с паузами между сообщениями (лимит Bot API
+// ~30 сообщений в секунду), поэтому обычного таймаута задачи не хватает
+const broadcastTimeout = 2 * time.Hour
+
+// BroadcastPayload - рассылка сотрудника всем клиентам бота (команда /broadcast)
+type BroadcastPayload struct {
+	BotID uuid.UUID `json:"bot_id"`
+	// AccountID - сотрудник (core_accounts), от имени которого сообщения попадут в лог
+	AccountID uuid.UUID `json:"account_id"`
+	// ReportChatID - чат сотрудника, куда придет отчет о рассылке
+	ReportChatID int64  `json:"report_chat_id"`
+	Text         string `json:"text"`
+
+	tracing.Carrier
+}
+
+// NewBroadcastTask создает задачу рассылки. Обработчик отмечает чаты, которым
+// сообщение уже ушло, поэтому прерванная рассылка при повторе продолжается
+// с оставшихся получателей
+func NewBroadcastTask(payload BroadcastPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypeBroadcast,
+		data,
+		asynq.MaxRetry(5),
+		asynq.Timeout(broadcastTimeout),
+	), nil
+}
+
+// PaymentStatusPayload - сменился статус заказа на оплату (payment_orders.status).
+// Задачу ставят вебхук, слушатель PostgreSQL NOTIFY или бэкенд CRM; обработчик
+// продолжает workflow, который ждет оплату этого заказа (узел payment)
+type PaymentStatusPayload struct {
+	PaymentOrderID uuid.UUID `json:"payment_order_id"`
+	Status         string    `json:"status,omitempty"`
+
+	tracing.Carrier
+}
+
+// NewPaymentStatusTask создает задачу смены статуса. Одно событие может прийти
+// и вебхуком, и через NOTIFY на каждую реплику - TaskID убирает дубли
+func NewPaymentStatusTask(payload PaymentStatusPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypePaymentStatus,
+		data,
+		asynq.TaskID(fmt.Sprintf("payment:%s:%s", payload.PaymentOrderID, payload.Status)),
+		asynq.MaxRetry(5),
+	), nil
+}
+
+// ReminderPayload - напоминание клиенту перед началом мероприятия (записи, занятия).
+// StartsAt - время начала, под которое ставилось напоминание: если его перенесли,
+// задача устарела и обработчик ее пропускает (на новое время ставится новая)
+type ReminderPayload struct {
+	BotID    uuid.UUID `json:"bot_id"`
+	Kind     string    `json:"kind"`
+	EntityID uuid.UUID `json:"entity_id"`
+	StartsAt time.Time `json:"starts_at"`
+
+	tracing.Carrier
+}
+
+// reminderRetention - сколько хранить выполненное напоминание после начала
+const reminderRetention = 24 * time.Hour
+
+// NewReminderTask создает задачу напоминания на время at. TaskID включает время
+// начала, поэтому повторная постановка того же напоминания игнорируется; выполненная
+// задача хранится до начала, чтобы планировщик не отправил напоминание второй раз
+func NewReminderTask(payload ReminderPayload, at time.Time) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypeReminder,
+		data,
+		asynq.TaskID(fmt.Sprintf("reminder:%s:%s:%d", payload.Kind, payload.EntityID, payload.StartsAt.Unix())),
+		asynq.ProcessAt(at),
+		asynq.MaxRetry(3),
+		asynq.Retention(time.Until(payload.StartsAt)+reminderRetention),
+	), nil
+}
+
+// CRMEventPayload - событие CRM (запись создана, заказ оплачен, бронь подтверждена...).
+// Задачу ставит бэкенд CRM; обработчик находит шаблоны telegram_message_templates
+// с trigger_event = Event и ставит по задаче telegram:template на каждый
+type CRMEventPayload struct {
+	ProfileID uuid.UUID `json:"profile_id"`
+	// Event - имя события, например appointment.created или order.paid
+	Event      string    `json:"event"`
+	EntityType string    `json:"entity_type"`
+	EntityID   uuid.UUID `json:"entity_id"`
+	// EventID - идентификатор события у отправителя, убирает дубли при повторной постановке
+	EventID string `json:"event_id,omitempty"`
+	// Data - дополнительные поля события, в шаблоне доступны как {{event.*}}
+	Data map[string]interface{} `json:"data,omitempty"`
+
+	tracing.Carrier
+}
+
+// NewCRMEventTask создает задачу события CRM
+func NewCRMEventTask(payload CRMEventPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []asynq.Option{asynq.MaxRetry(5)}
+	if payload.EventID != "" {
+		opts = append(opts, asynq.TaskID("crm-event:"+payload.EventID))
+	}
+	return asynq.NewTask(TypeCRMEvent, data, opts...), nil
+}
+
+// TemplateMessagePayload - отправка клиенту сообщения по шаблону. Сущность и условия
+// шаблона перечитываются при отправке, поэтому отложенное сообщение учитывает
+// изменения, сделанные за время задержки
+type TemplateMessagePayload struct {
+	BotID      uuid.UUID              `json:"bot_id"`
+	TemplateID uuid.UUID              `json:"template_id"`
+	Event      string                 `json:"event"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   uuid.UUID              `json:"entity_id"`
+	EventID    string                 `json:"event_id,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+
+	tracing.Carrier
+}
+
+// NewTemplateMessageTask создает задачу отправки через delay. Пока задача ждет
+// в очереди, такое же событие по той же сущности не ставит второе сообщение
+func NewTemplateMessageTask(payload TemplateMessagePayload, delay time.Duration) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	event := payload.EventID
+	if event == "" {
+		event = payload.Event
+	}
+	return asynq.NewTask(
+		TypeTemplateMessage,
+		data,
+		asynq.TaskID(fmt.Sprintf("template:%s:%s:%s", payload.TemplateID, payload.EntityID, event)),
+		asynq.ProcessIn(delay),
+		asynq.MaxRetry(3),
+	), nil
+}
+
+// BookingHoldPayload - конец предварительной брони номера или помещения, созданной
+// ботом до оплаты. Если бронь к этому времени не оплачена, она снимается, а заказ
+// на оплату помечается истекшим
+type BookingHoldPayload struct {
+	BotID          uuid.UUID `json:"bot_id"`
+	Kind           string    `json:"kind"`
+	EntityID       uuid.UUID `json:"entity_id"`
+	PaymentOrderID uuid.UUID `json:"payment_order_id"`
+
+	tracing.Carrier
+}
+
+// NewBookingHoldTask создает задачу снятия брони на время at
+func NewBookingHoldTask(payload BookingHoldPayload, at time.Time) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypeBookingHold,
+		data,
+		asynq.TaskID(fmt.Sprintf("booking-hold:%s:%s", payload.Kind, payload.EntityID)),
+		asynq.ProcessAt(at),
+		asynq.MaxRetry(5),
+	), nil
+}
+
+// TicketDeliveryPayload - отправка клиенту билетов оплаченного заказа. Билеты
+// создаются в транзакции оплаты, а отправляются этой задачей, поэтому сбой
+// Telegram не оставляет клиента без билетов: задача повторяется
+type TicketDeliveryPayload struct {
+	BotID         uuid.UUID `json:"bot_id"`
+	TicketOrderID uuid.UUID `json:"ticket_order_id"`
+
+	tracing.Carrier
+}
+
+// ticketDeliveryRetention - сколько хранить выполненную отправку билетов
+const ticketDeliveryRetention = 24 * time.Hour
+
+// NewTicketDeliveryTask создает задачу отправки билетов. Оплата заказа может прийти
+// несколько раз (вебхук и NOTIFY) - TaskID и хранение выполненной задачи не дают
+// отправить билеты повторно
+func NewTicketDeliveryTask(payload TicketDeliveryPayload) (*asynq.Task, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return asynq.NewTask(
+		TypeTicketDelivery,
+		data,
+		asynq.TaskID("tickets:"+payload.TicketOrderID.String()),
+		asynq.MaxRetry(5),
+		asynq.Retention(ticketDeliveryRetention),
+	), nil
+}
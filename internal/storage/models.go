@@ -87,6 +87,17 @@ type CoreModule struct {
 	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
 }
 
+type CoreNotification struct {
+	ID        pgtype.UUID        `json:"id"`
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Type      string             `json:"type"`
+	Title     string             `json:"title"`
+	Message   pgtype.Text        `json:"message"`
+	Payload   []byte             `json:"payload"`
+	IsRead    bool               `json:"is_read"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
 type CorePermission struct {
 	ID          pgtype.UUID        `json:"id"`
 	ProfileID   pgtype.UUID        `json:"profile_id"`
@@ -1018,6 +1029,8 @@ type SpacesRoomBlock struct {
 	Notes         pgtype.Text        `json:"notes"`
 	CreatedAt     pgtype.Timestamptz `json:"created_at"`
 	CreatedBy     pgtype.UUID        `json:"created_by"`
+	// До какого времени держится предварительная бронь из бота (reason = telegram_hold)
+	HoldUntil pgtype.Timestamptz `json:"hold_until"`
 }
 
 type SpacesRoomSchedule struct {
@@ -1258,6 +1271,29 @@ type TeamMember struct {
 	CreatedAt    pgtype.Timestamptz `json:"created_at"`
 }
 
+// Сотрудники, получающие в боте уведомления о новых заказах и записях
+type TelegramAdminSubscription struct {
+	ID        pgtype.UUID        `json:"id"`
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	BotID     pgtype.UUID        `json:"bot_id"`
+	AccountID pgtype.UUID        `json:"account_id"`
+	ChatID    int64              `json:"chat_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type TelegramAiUsage struct {
+	ID               pgtype.UUID        `json:"id"`
+	ProfileID        pgtype.UUID        `json:"profile_id"`
+	BotID            pgtype.UUID        `json:"bot_id"`
+	Day              pgtype.Date        `json:"day"`
+	Model            string             `json:"model"`
+	Requests         int32              `json:"requests"`
+	PromptTokens     int64              `json:"prompt_tokens"`
+	CompletionTokens int64              `json:"completion_tokens"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+}
+
 type TelegramBot struct {
 	ID             pgtype.UUID        `json:"id"`
 	ProfileID      pgtype.UUID        `json:"profile_id"`
@@ -1274,6 +1310,11 @@ type TelegramBot struct {
 	AiMaxTokens    pgtype.Int4        `json:"ai_max_tokens"`
 	CreatedAt      pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt      pgtype.Timestamptz `json:"updated_at"`
+	// Дополнительные настройки бота (JSON)
+	Settings []byte `json:"settings"`
+	// Последняя фатальная ошибка бота (например, отозванный токен)
+	LastError   pgtype.Text        `json:"last_error"`
+	LastErrorAt pgtype.Timestamptz `json:"last_error_at"`
 }
 
 type TelegramConversation struct {
@@ -1310,6 +1351,31 @@ type TelegramExecution struct {
 	FinishedAt     pgtype.Timestamptz `json:"finished_at"`
 }
 
+// Передача диалога оператору: пока status = 'open', AI и workflows в чате не работают
+type TelegramHandoff struct {
+	ID             pgtype.UUID `json:"id"`
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	BotID          pgtype.UUID `json:"bot_id"`
+	ChatID         int64       `json:"chat_id"`
+	TelegramUserID int64       `json:"telegram_user_id"`
+	Status         string      `json:"status"`
+	Reason         string      `json:"reason"`
+	// Оператор, взявший диалог (core_accounts)
+	AccountID pgtype.UUID        `json:"account_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	ClosedAt  pgtype.Timestamptz `json:"closed_at"`
+	ClosedBy  pgtype.UUID        `json:"closed_by"`
+}
+
+// Сообщения в чате сотрудников, ответ на которые уходит клиенту
+type TelegramHandoffMessage struct {
+	ID             pgtype.UUID        `json:"id"`
+	HandoffID      pgtype.UUID        `json:"handoff_id"`
+	StaffChatID    int64              `json:"staff_chat_id"`
+	StaffMessageID int64              `json:"staff_message_id"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
 type TelegramKnowledgeBase struct {
 	ID         pgtype.UUID        `json:"id"`
 	ProfileID  pgtype.UUID        `json:"profile_id"`
@@ -1324,6 +1390,28 @@ type TelegramKnowledgeBase struct {
 	UpdatedAt  pgtype.Timestamptz `json:"updated_at"`
 }
 
+// Шаблоны сообщений клиентам по событиям CRM (аналог vk_message_templates).
+// bot_id = NULL - отправлять через первого активного бота профиля
+type TelegramMessageTemplate struct {
+	ID               pgtype.UUID        `json:"id"`
+	ProfileID        pgtype.UUID        `json:"profile_id"`
+	BotID            pgtype.UUID        `json:"bot_id"`
+	ModuleCode       string             `json:"module_code"`
+	TemplateKey      string             `json:"template_key"`
+	TemplateName     string             `json:"template_name"`
+	Description      pgtype.Text        `json:"description"`
+	MessageText      string             `json:"message_text"`
+	ParseMode        pgtype.Text        `json:"parse_mode"`
+	SendImmediately  pgtype.Bool        `json:"send_immediately"`
+	SendDelayMinutes pgtype.Int4        `json:"send_delay_minutes"`
+	TriggerEvent     pgtype.Text        `json:"trigger_event"`
+	Conditions       []byte             `json:"conditions"`
+	IsActive         pgtype.Bool        `json:"is_active"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	CreatedBy        pgtype.UUID        `json:"created_by"`
+}
+
 type TelegramMessagesLog struct {
 	ID             pgtype.UUID        `json:"id"`
 	ProfileID      pgtype.UUID        `json:"profile_id"`
@@ -1333,6 +1421,26 @@ type TelegramMessagesLog struct {
 	IsFromBot      bool               `json:"is_from_bot"`
 	Metadata       []byte             `json:"metadata"`
 	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+	// Оператор, отправивший сообщение (core_accounts), для ответов из handoff
+	AccountID pgtype.UUID `json:"account_id"`
+}
+
+// Отправки сообщений по шаблонам: status = 'sent' или 'failed'
+type TelegramTemplateSend struct {
+	ID                pgtype.UUID        `json:"id"`
+	ProfileID         pgtype.UUID        `json:"profile_id"`
+	BotID             pgtype.UUID        `json:"bot_id"`
+	TemplateID        pgtype.UUID        `json:"template_id"`
+	TemplateKey       string             `json:"template_key"`
+	TriggerEvent      string             `json:"trigger_event"`
+	EntityType        string             `json:"entity_type"`
+	EntityID          pgtype.UUID        `json:"entity_id"`
+	ChatID            int64              `json:"chat_id"`
+	MessageText       string             `json:"message_text"`
+	Status            string             `json:"status"`
+	TelegramMessageID pgtype.Int8        `json:"telegram_message_id"`
+	ErrorMessage      pgtype.Text        `json:"error_message"`
+	CreatedAt         pgtype.Timestamptz `json:"created_at"`
 }
 
 type TelegramWorkflow struct {
@@ -3,10 +3,25 @@ SELECT
     id, profile_id, bot_token, bot_username, bot_name, is_active,
     welcome_message, ai_enabled, ai_provider, ai_model, 
     ai_system_prompt, ai_temperature, ai_max_tokens,
-    created_at, updated_at
+    created_at, updated_at, settings, last_error, last_error_at
 FROM telegram_bots
 WHERE is_active = true;
 
+-- name: DisableBot :exec
+UPDATE telegram_bots
+SET is_active = false,
+    last_error = $2,
+    last_error_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1;
+
+-- name: UpdateBotProfile :exec
+UPDATE telegram_bots
+SET bot_username = $2,
+    bot_name = $3,
+    updated_at = NOW()
+WHERE id = $1;
+
 -- name: GetWorkflow :one
 SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
        trigger_type, trigger_config, is_active,
@@ -60,6 +75,29 @@ SET status = $2,
     finished_at = CASE WHEN $2 IN ('completed', 'failed') THEN NOW() ELSE finished_at END
 WHERE id = $1;
 
+-- name: GetExecution :one
+SELECT id, profile_id, workflow_id, telegram_user_id, chat_id,
+       status, input_data, output_data, error_message,
+       started_at, finished_at
+FROM telegram_executions
+WHERE id = $1;
+
+-- name: GetWaitingExecution :one
+SELECT e.id, e.profile_id, e.workflow_id, e.telegram_user_id, e.chat_id,
+       e.status, e.input_data, e.output_data, e.error_message,
+       e.started_at, e.finished_at
+FROM telegram_executions e
+JOIN telegram_workflows w ON w.id = e.workflow_id
+WHERE w.bot_id = $1 AND e.chat_id = $2 AND e.status = 'waiting'
+ORDER BY e.started_at DESC
+LIMIT 1;
+
+-- name: CancelWaitingExecutions :exec
+UPDATE telegram_executions
+SET status = 'cancelled', finished_at = NOW()
+WHERE chat_id = $2 AND status = 'waiting'
+  AND workflow_id IN (SELECT id FROM telegram_workflows WHERE bot_id = $1);
+
 -- name: CreateConversation :one
 INSERT INTO telegram_conversations (
     id, profile_id, telegram_user_id, chat_id, context, last_message_at
@@ -79,6 +117,12 @@ UPDATE telegram_conversations
 SET context = $2, last_message_at = NOW()
 WHERE id = $1;
 
+-- name: MergeConversationContext :exec
+UPDATE telegram_conversations
+SET context = COALESCE(context, '{}'::jsonb) || sqlc.arg(updates)::jsonb,
+    last_message_at = NOW()
+WHERE id = $1;
+
 -- name: GetKnowledgeBase :many
 SELECT id, profile_id, source_type, source_id, title, content,
        metadata, embedding, is_active, created_at, updated_at
@@ -101,3 +145,930 @@ INSERT INTO telegram_messages_log (
 ) VALUES (
     gen_random_uuid(), $1, $2, $3, $4, $5, $6, NOW()
 );
+
+-- name: LogOperatorMessage :exec
+INSERT INTO telegram_messages_log (
+    id, profile_id, telegram_user_id, chat_id, message_text,
+    is_from_bot, metadata, account_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, true, $5, $6, NOW()
+);
+
+-- name: RecordAIUsage :exec
+INSERT INTO telegram_ai_usage (
+    id, profile_id, bot_id, day, model, requests,
+    prompt_tokens, completion_tokens, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, CURRENT_DATE, $3, 1, $4, $5, NOW(), NOW()
+)
+ON CONFLICT (bot_id, day, model) DO UPDATE
+SET requests = telegram_ai_usage.requests + 1,
+    prompt_tokens = telegram_ai_usage.prompt_tokens + EXCLUDED.prompt_tokens,
+    completion_tokens = telegram_ai_usage.completion_tokens + EXCLUDED.completion_tokens,
+    updated_at = NOW();
+
+-- name: GetProfileAIUsage :one
+SELECT p.tariff,
+       COALESCE(SUM(u.prompt_tokens + u.completion_tokens), 0)::bigint AS tokens
+FROM core_profiles p
+LEFT JOIN telegram_ai_usage u ON u.profile_id = p.id AND u.day >= sqlc.arg(since)::date
+WHERE p.id = sqlc.arg(profile_id)
+GROUP BY p.id, p.tariff;
+
+-- name: CreateNotification :exec
+INSERT INTO core_notifications (
+    id, profile_id, type, title, message, payload, is_read, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, false, NOW()
+);
+
+-- name: CreateHandoff :one
+INSERT INTO telegram_handoffs (
+    id, profile_id, bot_id, chat_id, telegram_user_id, status, reason, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, 'open', $5, NOW()
+)
+RETURNING id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+          account_id, created_at, closed_at, closed_by;
+
+-- name: GetOpenHandoff :one
+SELECT id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+       account_id, created_at, closed_at, closed_by
+FROM telegram_handoffs
+WHERE bot_id = $1 AND chat_id = $2 AND status = 'open'
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: GetHandoff :one
+SELECT id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+       account_id, created_at, closed_at, closed_by
+FROM telegram_handoffs
+WHERE id = $1;
+
+-- name: GetHandoffByStaffMessage :one
+SELECT h.id, h.profile_id, h.bot_id, h.chat_id, h.telegram_user_id, h.status, h.reason,
+       h.account_id, h.created_at, h.closed_at, h.closed_by
+FROM telegram_handoffs h
+JOIN telegram_handoff_messages m ON m.handoff_id = h.id
+WHERE h.bot_id = $1 AND m.staff_chat_id = $2 AND m.staff_message_id = $3
+LIMIT 1;
+
+-- name: CreateHandoffMessage :exec
+INSERT INTO telegram_handoff_messages (
+    id, handoff_id, staff_chat_id, staff_message_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, NOW()
+);
+
+-- name: AssignHandoff :exec
+UPDATE telegram_handoffs
+SET account_id = $2
+WHERE id = $1 AND account_id IS NULL;
+
+-- name: CloseHandoff :execrows
+UPDATE telegram_handoffs
+SET status = 'closed', closed_at = NOW(), closed_by = $2
+WHERE id = $1 AND status = 'open';
+
+-- name: GetAccountByTelegram :one
+-- Сотрудник по числовому Telegram ID: username можно сменить и занять чужой
+SELECT id, profile_id, name, first_name, last_name, tg, role, perms, role_id
+FROM core_accounts
+WHERE profile_id = $1
+  AND COALESCE(active, true) AND NOT COALESCE(archived, false)
+  AND tg = sqlc.arg(telegram_user_id)::text
+LIMIT 1;
+
+-- name: IsProfileOwnerTelegram :one
+-- Владелец профиля по числовому Telegram ID (как и GetAccountByTelegram)
+SELECT EXISTS (
+    SELECT 1 FROM core_profiles
+    WHERE id = $1 AND contact_telegram = sqlc.arg(telegram_user_id)::text
+) AS is_owner;
+
+-- name: GetRolePermissions :many
+SELECT p.name
+FROM core_role_permissions rp
+JOIN core_permissions p ON p.id = rp.permission_id
+WHERE rp.profile_id = $1 AND rp.role_id = $2;
+
+-- name: GetTelegramDailyStats :one
+SELECT
+    (SELECT COUNT(DISTINCT chat_id) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= sqlc.arg(since) AND NOT is_from_bot)::bigint AS chats,
+    (SELECT COUNT(*) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= sqlc.arg(since) AND NOT is_from_bot)::bigint AS incoming,
+    (SELECT COUNT(*) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= sqlc.arg(since) AND is_from_bot)::bigint AS outgoing,
+    (SELECT COUNT(*) FROM telegram_customer_links
+     WHERE profile_id = $1 AND linked_at >= sqlc.arg(since))::bigint AS new_customers,
+    (SELECT COUNT(*) FROM payment_orders
+     WHERE profile_id = $1 AND created_at >= sqlc.arg(since))::bigint AS orders,
+    (SELECT COUNT(*) FROM payment_orders
+     WHERE profile_id = $1 AND paid_at >= sqlc.arg(since) AND status = 'paid')::bigint AS paid_orders,
+    (SELECT COUNT(*) FROM appointments
+     WHERE profile_id = $1 AND created_at >= sqlc.arg(since) AND NOT COALESCE(is_deleted, false))::bigint AS appointments;
+
+-- name: GetRecentCustomers :many
+SELECT id, name, phone, email, source, created_at
+FROM customers
+WHERE profile_id = $1 AND NOT COALESCE(is_deleted, false)
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: ListBroadcastChats :many
+SELECT DISTINCT chat_id
+FROM telegram_conversations
+WHERE profile_id = $1 AND chat_id > 0;
+
+-- name: UpsertAdminSubscription :exec
+INSERT INTO telegram_admin_subscriptions (
+    id, profile_id, bot_id, account_id, chat_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, NOW()
+)
+ON CONFLICT (bot_id, chat_id) DO UPDATE
+SET account_id = EXCLUDED.account_id;
+
+-- name: DeleteAdminSubscription :exec
+DELETE FROM telegram_admin_subscriptions
+WHERE bot_id = $1 AND chat_id = $2;
+
+-- name: ListAdminSubscriptions :many
+SELECT id, profile_id, bot_id, account_id, chat_id, created_at
+FROM telegram_admin_subscriptions
+WHERE bot_id = $1;
+
+-- name: GetNewPaymentOrders :many
+SELECT id, amount, description, status, payer_email, payer_phone, created_at
+FROM payment_orders
+WHERE profile_id = $1 AND created_at > sqlc.arg(since)
+ORDER BY created_at
+LIMIT 50;
+
+-- name: GetNewAppointments :many
+SELECT a.id, a.title, a.start_datetime, a.status, a.price,
+       c.name AS customer_name, a.created_at
+FROM appointments a
+LEFT JOIN customers c ON c.id = a.customer_id
+WHERE a.profile_id = $1 AND a.created_at > sqlc.arg(since)
+  AND NOT COALESCE(a.is_deleted, false)
+ORDER BY a.created_at
+LIMIT 50;
+
+-- name: GetTelegramCustomerLink :one
+SELECT id, profile_id, customer_id, telegram_user_id, telegram_username,
+       first_name, last_name, linked_at
+FROM telegram_customer_links
+WHERE profile_id = $1 AND telegram_user_id = $2;
+
+-- name: CreateCustomer :one
+INSERT INTO customers (
+    id, profile_id, customer_type, name, phone, email, source, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, 'individual', $2, $3, $4, 'telegram', NOW(), NOW()
+)
+RETURNING id;
+
+-- name: CreateTelegramCustomerLink :exec
+INSERT INTO telegram_customer_links (
+    id, profile_id, customer_id, telegram_user_id, telegram_username,
+    first_name, last_name, linked_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, NOW()
+)
+ON CONFLICT (profile_id, telegram_user_id) DO NOTHING;
+
+-- name: GetActivePaymentGateway :one
+SELECT id, profile_id, gateway_type, name, shop_id, secret_key, is_test_mode,
+       is_active, webhook_url, settings, created_at, updated_at, created_by
+FROM payment_gateways
+WHERE profile_id = $1 AND is_active = true
+  AND (sqlc.narg(gateway_id)::uuid IS NULL OR id = sqlc.narg(gateway_id))
+ORDER BY created_at
+LIMIT 1;
+
+-- name: GetProduct :one
+SELECT id, category_id, name, description, short_description, price, currency,
+       image_url, stock_quantity, track_inventory, status
+FROM products
+WHERE id = $1 AND profile_id = $2 AND NOT COALESCE(is_deleted, false);
+
+-- name: CreatePaymentOrder :one
+INSERT INTO payment_orders (
+    id, profile_id, customer_id, entity_type, entity_id, payment_gateway_id,
+    payer_email, payer_phone, amount, description, status, form_data,
+    expires_at, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending', $10, $11, NOW(), NOW()
+)
+RETURNING id, profile_id, customer_id, entity_type, entity_id, payment_form_id,
+          form_data, payment_gateway_id, payer_email, payer_phone, amount,
+          description, status, external_id, payment_url, external_data,
+          payment_id, expires_at, paid_at, created_at, updated_at, created_by;
+
+-- name: SetPaymentOrderURL :exec
+UPDATE payment_orders
+SET payment_url = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetPaymentOrder :one
+SELECT id, profile_id, customer_id, entity_type, entity_id, payment_form_id,
+       form_data, payment_gateway_id, payer_email, payer_phone, amount,
+       description, status, external_id, payment_url, external_data,
+       payment_id, expires_at, paid_at, created_at, updated_at, created_by
+FROM payment_orders
+WHERE id = $1;
+
+-- name: ExpirePaymentOrder :execrows
+UPDATE payment_orders
+SET status = 'expired', updated_at = NOW()
+WHERE id = $1 AND status = 'pending';
+
+-- name: GetPaymentExecution :one
+SELECT e.id, w.bot_id, e.chat_id
+FROM telegram_executions e
+JOIN telegram_workflows w ON w.id = e.workflow_id
+WHERE e.status = 'waiting'
+  AND e.output_data->'wait'->>'payment_order_id' = sqlc.arg(payment_order_id)::text
+LIMIT 1;
+
+-- name: GetCredential :one
+SELECT id, profile_id, name, credential_type, description, key1, key2, key3,
+       metadata, is_active, created_at, updated_at
+FROM credentials
+WHERE id = $1 AND profile_id = $2 AND is_active = true;
+
+-- name: CreatePayment :one
+INSERT INTO payments (
+    id, profile_id, customer_id, entity_type, entity_id, amount, payment_method,
+    status, external_id, external_data, description, paid_at, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW(), NOW()
+)
+RETURNING id;
+
+-- name: MarkPaymentOrderPaid :execrows
+UPDATE payment_orders
+SET status = 'paid', paid_at = NOW(), updated_at = NOW(),
+    payment_id = $2, external_id = $3, external_data = $4
+WHERE id = $1 AND status IN ('pending', 'expired');
+
+-- name: GetProductStock :one
+SELECT COALESCE(SUM(quantity - COALESCE(reserved_quantity, 0)), 0)::numeric AS available,
+       COUNT(*) AS warehouses
+FROM warehouse_stock
+WHERE product_id = $1;
+
+-- name: ListCatalogCategories :many
+SELECT id, name, icon
+FROM products_categories
+WHERE profile_id = $1 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false)
+  AND parent_id IS NOT DISTINCT FROM sqlc.narg(parent_id)::uuid
+ORDER BY COALESCE(sort_order, 0), name;
+
+-- name: GetCatalogCategory :one
+SELECT id, parent_id, name, description
+FROM products_categories
+WHERE id = $1 AND profile_id = $2 AND NOT COALESCE(is_deleted, false);
+
+-- name: ListCatalogProducts :many
+SELECT id, name, price, currency
+FROM products
+WHERE profile_id = $1 AND status = 'active' AND NOT COALESCE(is_deleted, false)
+  AND category_id IS NOT DISTINCT FROM sqlc.narg(category_id)::uuid
+ORDER BY COALESCE(is_featured, false) DESC, name
+LIMIT $2 OFFSET $3;
+
+-- name: CountCatalogProducts :one
+SELECT COUNT(*)
+FROM products
+WHERE profile_id = $1 AND status = 'active' AND NOT COALESCE(is_deleted, false)
+  AND category_id IS NOT DISTINCT FROM sqlc.narg(category_id)::uuid;
+
+-- name: ListProductVariants :many
+SELECT id, name, price_modifier, stock_quantity, is_default
+FROM products_variants
+WHERE product_id = $1 AND NOT COALESCE(is_deleted, false)
+ORDER BY COALESCE(is_default, false) DESC, name;
+
+-- name: GetProductVariant :one
+SELECT id, product_id, name, price_modifier, stock_quantity
+FROM products_variants
+WHERE id = $1 AND NOT COALESCE(is_deleted, false);
+
+-- name: GetPromoCode :one
+SELECT id, profile_id, code, description, discount_type, discount_value, max_uses,
+       used_count, max_uses_per_customer, min_order_amount, valid_from, valid_until,
+       ticket_type_ids, is_active, custom_fields, created_at, updated_at
+FROM promo_codes
+WHERE profile_id = $1 AND upper(code) = upper(sqlc.arg(code)::text);
+
+-- name: CountCustomerPromoUses :one
+SELECT COUNT(*)
+FROM payment_orders
+WHERE customer_id = sqlc.arg(customer_id) AND status = 'paid'
+  AND form_data->>'promo_code_id' = sqlc.arg(promo_code_id)::text;
+
+-- name: CountPromoCodeUse :execrows
+-- Отметка promo_counted в заказе делает учет идемпотентным
+WITH marked AS (
+    UPDATE payment_orders
+    SET form_data = form_data || '{"promo_counted": true}'::jsonb
+    WHERE payment_orders.id = $1 AND form_data ? 'promo_code_id'
+      AND NOT COALESCE((form_data->>'promo_counted')::boolean, false)
+    RETURNING (form_data->>'promo_code_id')::uuid AS promo_code_id
+)
+UPDATE promo_codes
+SET used_count = COALESCE(used_count, 0) + 1, updated_at = NOW()
+WHERE promo_codes.id = (SELECT promo_code_id FROM marked);
+
+-- name: ListUpcomingEvents :many
+SELECT id, title, start_date, city
+FROM events
+WHERE profile_id = $1 AND status = 'published' AND visibility = 'public'
+  AND start_date > NOW()
+ORDER BY start_date
+LIMIT $2 OFFSET $3;
+
+-- name: CountUpcomingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE profile_id = $1 AND status = 'published' AND visibility = 'public'
+  AND start_date > NOW();
+
+-- name: GetEvent :one
+SELECT e.id, e.title, e.description, e.short_description, e.start_date, e.end_date,
+       e.is_online, e.online_url, e.address, e.city, e.registration_required,
+       e.registration_start, e.registration_end, e.max_participants, e.cover_image,
+       e.status, e.visibility,
+       (SELECT COUNT(*) FROM event_registrations r
+        WHERE r.event_id = e.id AND r.status <> 'cancelled') AS registered
+FROM events e
+WHERE e.id = $1 AND e.profile_id = $2;
+
+-- name: GetCustomerEventRegistration :one
+SELECT id, qr_code, status
+FROM event_registrations
+WHERE event_id = $1 AND customer_id = $2 AND status <> 'cancelled'
+LIMIT 1;
+
+-- name: CreateEventRegistration :one
+-- Вставка не выполняется, если мест уже нет. Вызывается в транзакции после
+-- LockEvent, иначе одновременные регистрации превысят лимит
+INSERT INTO event_registrations (
+    id, profile_id, event_id, customer_id, name, email, phone, status, qr_code,
+    custom_fields, registered_at, updated_at
+)
+SELECT gen_random_uuid(), $1, $2, $3, $4, $5, $6, 'registered', $7, $8, NOW(), NOW()
+WHERE sqlc.narg(max_participants)::int IS NULL
+   OR (SELECT COUNT(*) FROM event_registrations
+       WHERE event_id = $2 AND status <> 'cancelled') < sqlc.narg(max_participants)::int
+RETURNING id;
+
+-- name: LockEvent :exec
+-- Блокировка мероприятия до конца транзакции: подсчет регистраций и вставка
+-- не пересекаются с параллельной регистрацией
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(event_id)::uuid::text, 0));
+
+-- name: GetEventRegistration :one
+SELECT r.id, r.status, r.qr_code, r.custom_fields, e.id AS event_id, e.title,
+       e.start_date, e.status AS event_status, e.is_online, e.online_url, e.address, e.city
+FROM event_registrations r
+JOIN events e ON e.id = r.event_id
+WHERE r.id = $1;
+
+-- name: ListEventSessions :many
+-- Сеансы продажи билетов на мероприятие: указанные в custom_fields.event_id
+-- или на той же площадке во время мероприятия
+SELECT ts.id, ts.title, ts.start_time, ts.capacity, COALESCE(ts.sold_count, 0)::int AS sold_count
+FROM ticket_sessions ts
+JOIN events e ON e.id = sqlc.arg(event_id)
+WHERE ts.profile_id = sqlc.arg(profile_id) AND ts.status NOT IN ('draft', 'cancelled', 'canceled')
+  AND ts.start_time > NOW()
+  AND (ts.custom_fields->>'event_id' = e.id::text
+       OR (ts.venue_id = e.venue_id
+           AND ts.start_time >= e.start_date
+           AND ts.start_time < COALESCE(e.end_date, e.start_date + INTERVAL '1 day')))
+ORDER BY ts.start_time;
+
+-- name: GetTicketSession :one
+SELECT ts.id, ts.title, ts.start_time, ts.end_time, ts.capacity,
+       COALESCE(ts.sold_count, 0)::int AS sold_count, ts.status,
+       v.name AS venue_name, v.address AS venue_address
+FROM ticket_sessions ts
+LEFT JOIN venues v ON v.id = ts.venue_id
+WHERE ts.id = $1 AND ts.profile_id = $2;
+
+-- name: ListTicketTypes :many
+SELECT id, name, description, price, max_per_order, min_per_order
+FROM ticket_types
+WHERE profile_id = $1 AND COALESCE(is_active, true)
+ORDER BY COALESCE(order_index, 0), price;
+
+-- name: GetTicketType :one
+SELECT id, name, description, price, max_per_order, min_per_order
+FROM ticket_types
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_active, true);
+
+-- name: CreateTicketOrder :one
+INSERT INTO ticket_orders (
+    id, profile_id, customer_id, session_id, order_number, buyer_name, buyer_email,
+    buyer_phone, subtotal, discount_amount, total_amount, status, payment_method,
+    promo_code_id, custom_fields, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending', $11, $12, $13, NOW(), NOW()
+)
+RETURNING id, order_number;
+
+-- name: GetTicketOrder :one
+SELECT id, profile_id, customer_id, session_id, order_number, buyer_name, buyer_email,
+       buyer_phone, subtotal, discount_amount, total_amount, status, payment_method,
+       payment_id, paid_at, promo_code_id, notes, custom_fields, created_at, updated_at
+FROM ticket_orders
+WHERE id = $1;
+
+-- name: MarkTicketOrderPaid :execrows
+UPDATE ticket_orders
+SET status = 'paid', paid_at = NOW(), updated_at = NOW(), payment_id = $2
+WHERE id = $1 AND status = 'pending';
+
+-- name: MarkTicketOrderSoldOut :execrows
+-- Отмена оплаченного заказа, на который не хватило мест: деньги нужно вернуть
+UPDATE ticket_orders
+SET status = 'cancelled', payment_id = $2, notes = $3, updated_at = NOW()
+WHERE id = $1 AND status = 'pending';
+
+-- name: CreateTicket :one
+INSERT INTO tickets (
+    id, profile_id, order_id, ticket_type_id, session_id, qr_code, price,
+    attendee_name, attendee_email, attendee_phone, status, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, 'active', NOW()
+)
+RETURNING id;
+
+-- name: AddTicketSessionSold :execrows
+-- Не обновляет сеанс, если мест не хватает: 0 строк - билеты распроданы
+UPDATE ticket_sessions
+SET sold_count = COALESCE(sold_count, 0) + sqlc.arg(quantity)::int, updated_at = NOW()
+WHERE id = sqlc.arg(id)
+  AND (capacity IS NULL OR capacity <= 0 OR COALESCE(sold_count, 0) + sqlc.arg(quantity)::int <= capacity);
+
+-- name: ListOrderTickets :many
+SELECT t.id, t.qr_code, t.status, tt.name AS type_name
+FROM tickets t
+LEFT JOIN ticket_types tt ON tt.id = t.ticket_type_id
+WHERE t.order_id = $1
+ORDER BY t.created_at, t.id;
+
+-- name: ListAppointmentReminders :many
+-- Предстоящие записи клиентов, связанных с Telegram, с началом до until
+SELECT DISTINCT a.id, a.start_datetime
+FROM appointments a
+JOIN telegram_customer_links l ON l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+WHERE a.profile_id = $1 AND NOT COALESCE(a.is_deleted, false)
+  AND a.status NOT IN ('cancelled', 'canceled', 'completed', 'no_show')
+  AND a.start_datetime > NOW() AND a.start_datetime <= sqlc.arg(until);
+
+-- name: ListLessonReminders :many
+-- Записи на предстоящие занятия учеников (student_id - клиент CRM), связанных с Telegram
+SELECT e.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at
+FROM education_lesson_enrollments e
+JOIN education_lessons l ON l.id = e.lesson_id
+WHERE e.profile_id = $1 AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (l.lesson_date + l.start_time)::timestamptz <= sqlc.arg(until)
+  AND EXISTS (SELECT 1 FROM telegram_customer_links tl
+              WHERE tl.customer_id = e.student_id AND tl.profile_id = e.profile_id);
+
+-- name: GetAppointmentReminder :one
+-- Запись и Telegram пользователь клиента (последняя привязка)
+SELECT a.id, a.title, a.start_datetime, a.status, COALESCE(a.is_deleted, false)::bool AS is_deleted,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM appointments a
+WHERE a.id = $1 AND a.profile_id = $2;
+
+-- name: GetLessonEnrollmentReminder :one
+SELECT e.id, COALESCE(e.status, 'enrolled')::text AS status, e.lesson_id,
+       (l.lesson_date + l.start_time)::timestamptz AS starts_at,
+       COALESCE(l.status, 'scheduled')::text AS lesson_status,
+       COALESCE(l.is_deleted, false)::bool AS lesson_deleted,
+       s.name AS subject_name,
+       COALESCE((SELECT tl.telegram_user_id FROM telegram_customer_links tl
+                 WHERE tl.customer_id = e.student_id AND tl.profile_id = e.profile_id
+                 ORDER BY tl.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM education_lesson_enrollments e
+JOIN education_lessons l ON l.id = e.lesson_id
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+WHERE e.id = $1 AND e.profile_id = $2;
+
+-- name: SetCustomerAppointmentStatus :execrows
+-- Статус меняется, только если запись принадлежит клиенту и еще не закрыта
+UPDATE appointments
+SET status = sqlc.arg(status), updated_at = NOW()
+WHERE id = sqlc.arg(id) AND profile_id = sqlc.arg(profile_id) AND customer_id = sqlc.arg(customer_id)
+  AND NOT COALESCE(is_deleted, false)
+  AND status NOT IN ('cancelled', 'canceled', 'completed', 'no_show');
+
+-- name: SetStudentEnrollmentStatus :execrows
+UPDATE education_lesson_enrollments
+SET status = sqlc.arg(status), updated_at = NOW()
+WHERE id = sqlc.arg(id) AND profile_id = sqlc.arg(profile_id) AND student_id = sqlc.arg(student_id)
+  AND COALESCE(status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent');
+
+-- name: ListProfileBotIDs :many
+-- Активные боты профиля, первым - самый старый
+SELECT id FROM telegram_bots
+WHERE profile_id = $1 AND is_active = true
+ORDER BY created_at;
+
+-- name: ListTriggeredTemplates :many
+-- Активные шаблоны профиля, срабатывающие на событие CRM
+SELECT id, profile_id, bot_id, module_code, template_key, template_name, description,
+       message_text, parse_mode, send_immediately, send_delay_minutes, trigger_event,
+       conditions, is_active, created_at, updated_at, created_by
+FROM telegram_message_templates
+WHERE profile_id = $1 AND trigger_event = $2 AND COALESCE(is_active, true)
+ORDER BY template_key;
+
+-- name: GetMessageTemplate :one
+SELECT id, profile_id, bot_id, module_code, template_key, template_name, description,
+       message_text, parse_mode, send_immediately, send_delay_minutes, trigger_event,
+       conditions, is_active, created_at, updated_at, created_by
+FROM telegram_message_templates
+WHERE id = $1 AND profile_id = $2;
+
+-- name: GetAppointmentTemplateData :one
+-- Поля записи для подстановки в шаблон и Telegram пользователь клиента
+SELECT a.id, a.title, a.description, a.start_datetime, a.end_datetime, a.status, a.price,
+       COALESCE(a.paid, false)::bool AS paid,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE(sp.display_name, TRIM(CONCAT(sp.first_name, ' ', sp.last_name)))::text AS specialist_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM appointments a
+LEFT JOIN customers c ON c.id = a.customer_id
+LEFT JOIN specialists sp ON sp.id = a.specialist_id
+WHERE a.id = $1 AND a.profile_id = $2 AND NOT COALESCE(a.is_deleted, false);
+
+-- name: GetPaymentOrderTemplateData :one
+-- Поля заказа для подстановки в шаблон. Получатель - клиент заказа или чат,
+-- из которого заказ оформлен в боте (form_data.chat_id)
+SELECT o.id, o.description, o.amount, o.status, o.payment_url,
+       COALESCE(o.entity_type, '')::text AS entity_type,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = o.customer_id AND l.profile_id = o.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1),
+                CASE WHEN o.form_data->>'chat_id' ~ '^-?[0-9]+$' THEN (o.form_data->>'chat_id')::bigint END,
+                0)::bigint AS telegram_user_id
+FROM payment_orders o
+LEFT JOIN customers c ON c.id = o.customer_id
+WHERE o.id = $1 AND o.profile_id = $2;
+
+-- name: GetHotelBookingTemplateData :one
+-- Поля бронирования для подстановки в шаблон (owner_id - клиент CRM)
+SELECT b.id, COALESCE(r.room_number, '')::text AS room_number, COALESCE(r.room_type, '')::text AS room_type,
+       b.check_in_date, b.check_out_date, b.status, b.total_price,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = b.owner_id AND l.profile_id = b.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM hotel_bookings b
+LEFT JOIN hotel_rooms r ON r.id = b.room_id
+LEFT JOIN customers c ON c.id = b.owner_id
+WHERE b.id = $1 AND b.profile_id = $2;
+
+-- name: CreateTemplateSend :exec
+-- Запись об отправке сообщения по шаблону
+INSERT INTO telegram_template_sends (
+    profile_id, bot_id, template_id, template_key, trigger_event,
+    entity_type, entity_id, chat_id, message_text, status,
+    telegram_message_id, error_message
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
+
+-- name: GetStudentBalance :one
+-- Баланс ученика в занятиях и сколько из них уже зарезервировано записями
+-- на предстоящие платные занятия, которые еще не списаны
+SELECT COALESCE((SELECT a.balance FROM education_balance_accounts a
+                 WHERE a.profile_id = $1 AND a.student_id = $2
+                 ORDER BY a.created_at LIMIT 1), 0)::int AS balance,
+       (SELECT COUNT(*) FROM education_lesson_enrollments e
+        JOIN education_lessons l ON l.id = e.lesson_id
+        WHERE e.profile_id = $1 AND e.student_id = $2
+          AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent')
+          AND NOT COALESCE(e.was_charged, false) AND COALESCE(l.payment_required, true)
+          AND NOT COALESCE(l.is_deleted, false)
+          AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+          AND (l.lesson_date + l.start_time)::timestamptz > NOW())::int AS reserved;
+
+-- name: ListStudentPackages :many
+-- Действующие абонементы ученика
+SELECT pp.id, p.name, pp.lessons_granted, COALESCE(pp.lessons_used, 0)::int AS lessons_used, pp.expires_at
+FROM education_package_purchases pp
+JOIN education_packages p ON p.id = pp.package_id
+WHERE pp.profile_id = $1 AND pp.student_id = $2 AND pp.status = 'active'
+  AND (pp.expires_at IS NULL OR pp.expires_at > NOW())
+ORDER BY pp.expires_at NULLS LAST;
+
+-- name: ListStudentLessons :many
+-- Предстоящие занятия групп ученика и занятия, на которые он записан
+SELECT l.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at,
+       s.name AS subject_name, g.name AS group_name,
+       e.id AS enrollment_id, COALESCE(e.status, '')::text AS enrollment_status
+FROM education_lessons l
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+LEFT JOIN education_groups g ON g.id = l.group_id
+LEFT JOIN education_lesson_enrollments e ON e.lesson_id = l.id AND e.student_id = sqlc.arg(student_id)
+WHERE l.profile_id = sqlc.arg(profile_id) AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (l.lesson_date + l.start_time)::timestamptz <= sqlc.arg(until)
+  AND (e.id IS NOT NULL OR l.group_id IN (
+        SELECT gs.group_id FROM education_group_students gs
+        WHERE gs.profile_id = l.profile_id AND gs.student_id = sqlc.arg(student_id)
+          AND COALESCE(gs.status, 'active') = 'active'))
+ORDER BY starts_at
+LIMIT sqlc.arg(limit_count);
+
+-- name: GetStudentLesson :one
+-- Предстоящее занятие, доступное ученику (его группа или он уже записан),
+-- с числом записанных и записью ученика
+SELECT l.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at, l.end_time,
+       s.name AS subject_name, g.name AS group_name, l.max_students,
+       COALESCE(l.payment_required, true)::bool AS payment_required,
+       (SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled'))::int AS enrolled,
+       e.id AS enrollment_id, COALESCE(e.status, '')::text AS enrollment_status
+FROM education_lessons l
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+LEFT JOIN education_groups g ON g.id = l.group_id
+LEFT JOIN education_lesson_enrollments e ON e.lesson_id = l.id AND e.student_id = sqlc.arg(student_id)
+WHERE l.id = sqlc.arg(id) AND l.profile_id = sqlc.arg(profile_id) AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (e.id IS NOT NULL OR l.group_id IN (
+        SELECT gs.group_id FROM education_group_students gs
+        WHERE gs.profile_id = l.profile_id AND gs.student_id = sqlc.arg(student_id)
+          AND COALESCE(gs.status, 'active') = 'active'));
+
+-- name: CreateLessonEnrollment :one
+-- Запись на занятие, если есть свободные места (max_students). Вызывается в
+-- транзакции после LockLesson, иначе одновременные записи превысят лимит
+INSERT INTO education_lesson_enrollments (profile_id, lesson_id, student_id, status, registered_at, notes)
+SELECT sqlc.arg(profile_id), l.id, sqlc.arg(student_id), 'enrolled', NOW(), 'Telegram'
+FROM education_lessons l
+WHERE l.id = sqlc.arg(lesson_id)
+  AND (l.max_students IS NULL OR (
+        SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled')) < l.max_students)
+RETURNING id;
+
+-- name: RestoreLessonEnrollment :execrows
+-- Повторная запись после отмены, если есть свободные места. Вызывается
+-- в транзакции после LockLesson
+UPDATE education_lesson_enrollments e
+SET status = 'enrolled', registered_at = NOW(), updated_at = NOW()
+FROM education_lessons l
+WHERE e.id = sqlc.arg(id) AND e.profile_id = sqlc.arg(profile_id) AND e.student_id = sqlc.arg(student_id)
+  AND e.status IN ('cancelled', 'canceled') AND l.id = e.lesson_id
+  AND (l.max_students IS NULL OR (
+        SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled')) < l.max_students);
+
+-- name: LockLesson :exec
+-- Блокировка занятия до конца транзакции: подсчет мест и запись не пересекаются
+-- с параллельной записью на то же занятие
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(lesson_id)::uuid::text, 0));
+
+-- name: LockStudentBalance :exec
+-- Блокировка баланса ученика до конца транзакции: проверка свободных занятий
+-- и запись не пересекаются с параллельной записью того же ученика
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(student_id)::uuid::text, 0));
+
+-- name: CancelStudentEnrollment :one
+-- Отмена записи ученика; списанное занятие (was_charged) возвращается на баланс
+-- вызывающим кодом, поэтому флаг сбрасывается здесь же
+UPDATE education_lesson_enrollments e
+SET status = 'cancelled', was_charged = false, updated_at = NOW()
+FROM education_lesson_enrollments old
+WHERE e.id = sqlc.arg(id) AND e.profile_id = sqlc.arg(profile_id) AND e.student_id = sqlc.arg(student_id)
+  AND old.id = e.id
+  AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent')
+RETURNING e.id, e.lesson_id, COALESCE(old.was_charged, false)::bool AS was_charged;
+
+-- name: AddEducationBalance :one
+-- Изменяет баланс ученика; возвращает баланс после изменения
+UPDATE education_balance_accounts
+SET balance = COALESCE(balance, 0) + sqlc.arg(amount), updated_at = NOW()
+WHERE id = (SELECT a.id FROM education_balance_accounts a
+            WHERE a.profile_id = sqlc.arg(profile_id) AND a.student_id = sqlc.arg(student_id)
+            ORDER BY a.created_at LIMIT 1)
+RETURNING balance::int;
+
+-- name: CreateEducationBalanceAccount :one
+INSERT INTO education_balance_accounts (profile_id, student_id, balance, currency_type)
+VALUES ($1, $2, $3, 'lessons')
+RETURNING balance::int;
+
+-- name: CreateEducationBalanceTransaction :exec
+INSERT INTO education_balance_transactions (
+    profile_id, student_id, transaction_type, amount, balance_before, balance_after,
+    related_lesson_id, related_package_id, related_enrollment_id, description
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: ListEducationPackages :many
+-- Абонементы, которые можно купить
+SELECT id, name, description, lessons_count, price, validity_days
+FROM education_packages
+WHERE profile_id = $1 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false)
+ORDER BY price, name;
+
+-- name: GetEducationPackage :one
+SELECT id, name, description, lessons_count, price, validity_days
+FROM education_packages
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false);
+
+-- name: CreatePackagePurchase :one
+-- Покупка абонемента, ожидающая оплаты
+INSERT INTO education_package_purchases (
+    profile_id, student_id, package_id, lessons_granted, lessons_used, price_paid,
+    status, payment_status, notes
+) VALUES ($1, $2, $3, $4, 0, $5, 'pending', 'pending', $6)
+RETURNING id;
+
+-- name: GetPackagePurchase :one
+SELECT pp.id, pp.profile_id, pp.student_id, pp.package_id, pp.lessons_granted,
+       COALESCE(pp.payment_status, 'pending')::text AS payment_status,
+       p.name AS package_name, p.validity_days, COALESCE(p.is_active, true)::bool AS package_active
+FROM education_package_purchases pp
+JOIN education_packages p ON p.id = pp.package_id
+WHERE pp.id = $1;
+
+-- name: ActivatePackagePurchase :execrows
+-- Активирует оплаченный абонемент; повторный вызов ничего не меняет
+UPDATE education_package_purchases
+SET status = 'active', payment_status = 'paid', purchased_at = NOW(),
+    expires_at = CASE WHEN sqlc.narg(validity_days)::int > 0
+                      THEN NOW() + make_interval(days => sqlc.narg(validity_days)::int) END,
+    updated_at = NOW()
+WHERE id = sqlc.arg(id) AND COALESCE(payment_status, 'pending') = 'pending';
+
+-- name: ListAvailableHotelRooms :many
+-- Свободные на даты номера отеля, вмещающие guests. Бронирование из бота,
+-- не оплаченное до custom_fields.hold_until, номер не занимает
+SELECT r.id, r.room_number, r.room_type, r.capacity, r.description, r.price_per_day
+FROM hotel_rooms r
+WHERE r.profile_id = sqlc.arg(profile_id) AND COALESCE(r.is_available, true)
+  AND r.capacity >= sqlc.arg(guests)::int
+  AND NOT EXISTS (
+      SELECT 1 FROM hotel_bookings b
+      WHERE b.room_id = r.id
+        AND b.check_in_date < sqlc.arg(check_out)::date AND b.check_out_date > sqlc.arg(check_in)::date
+        AND b.status NOT IN ('cancelled', 'canceled')
+        AND NOT (b.status = 'pending' AND (b.custom_fields->>'hold_until')::timestamptz < NOW()))
+ORDER BY r.price_per_day NULLS LAST, r.room_number;
+
+-- name: GetHotelRoom :one
+SELECT id, room_number, room_type, capacity, price_per_day
+FROM hotel_rooms
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_available, true);
+
+-- name: LockBookingResource :exec
+-- Блокировка номера или помещения до конца транзакции: проверка занятости
+-- и создание брони не пересекаются с параллельным бронированием
+SELECT pg_advisory_xact_lock(hashtextextended(sqlc.arg(resource_id)::uuid::text, 0));
+
+-- name: CreateHotelBookingHold :one
+-- Предварительное бронирование номера до оплаты (hold_until), если номер
+-- на эти даты свободен; иначе строк нет
+INSERT INTO hotel_bookings (
+    profile_id, room_id, owner_id, check_in_date, check_out_date, status,
+    total_price, notes, custom_fields
+)
+SELECT sqlc.arg(profile_id), sqlc.arg(room_id), sqlc.arg(owner_id), sqlc.arg(check_in_date), sqlc.arg(check_out_date), 'pending',
+       sqlc.arg(total_price), sqlc.arg(notes),
+       jsonb_build_object('source', 'telegram', 'guests', sqlc.arg(guests)::int, 'hold_until', sqlc.arg(hold_until)::timestamptz)
+WHERE NOT EXISTS (
+    SELECT 1 FROM hotel_bookings b
+    WHERE b.room_id = sqlc.arg(room_id)
+      AND b.check_in_date < sqlc.arg(check_out_date) AND b.check_out_date > sqlc.arg(check_in_date)
+      AND b.status NOT IN ('cancelled', 'canceled')
+      AND NOT (b.status = 'pending' AND (b.custom_fields->>'hold_until')::timestamptz < NOW()))
+RETURNING id;
+
+-- name: GetHotelBookingHold :one
+SELECT b.id, b.profile_id, b.room_id, b.status, (b.custom_fields->>'hold_until')::timestamptz AS hold_until,
+       COALESCE(r.room_number, '')::text AS room_number, b.check_in_date, b.check_out_date, b.total_price
+FROM hotel_bookings b
+LEFT JOIN hotel_rooms r ON r.id = b.room_id
+WHERE b.id = $1;
+
+-- name: ConfirmHotelBooking :execrows
+-- Оплаченное предварительное бронирование становится подтвержденным, если номер
+-- на эти даты никто не занял (истекшую бронь могли перебронировать).
+-- Вызывается в транзакции после LockBookingResource
+UPDATE hotel_bookings b
+SET status = 'confirmed', deposit_amount = b.total_price, deposit_paid = true,
+    custom_fields = b.custom_fields - 'hold_until', updated_at = NOW()
+WHERE b.id = $1 AND b.status = 'pending'
+  AND NOT EXISTS (
+    SELECT 1 FROM hotel_bookings o
+    WHERE o.room_id = b.room_id AND o.id <> b.id
+      AND o.check_in_date < b.check_out_date AND o.check_out_date > b.check_in_date
+      AND o.status NOT IN ('cancelled', 'canceled')
+      AND NOT (o.status = 'pending' AND (o.custom_fields->>'hold_until')::timestamptz < NOW()));
+
+-- name: ReleaseHotelBooking :execrows
+-- Отмена неоплаченного предварительного бронирования
+UPDATE hotel_bookings
+SET status = 'cancelled', updated_at = NOW()
+WHERE id = $1 AND status = 'pending';
+
+-- name: ListBookableSpaces :many
+-- Помещения, которые можно бронировать
+SELECT r.id, r.name, COALESCE(l.name, '')::text AS location_name, r.capacity,
+       r.min_booking_duration, r.max_booking_duration
+FROM spaces_rooms r
+LEFT JOIN spaces_locations l ON l.id = r.location_id
+WHERE r.profile_id = $1 AND COALESCE(r.is_active, true) AND COALESCE(r.is_bookable, true)
+  AND NOT COALESCE(r.is_deleted, false)
+ORDER BY l.name NULLS LAST, r.name;
+
+-- name: ListSpaceSchedules :many
+-- Часы работы помещений в день недели (0 - воскресенье)
+SELECT room_id, start_time, end_time
+FROM spaces_room_schedules
+WHERE profile_id = $1 AND day_of_week = $2 AND COALESCE(is_active, true)
+ORDER BY room_id, start_time;
+
+-- name: ListSpaceBusy :many
+-- Занятое время помещений в интервале: блокировки (в том числе брони) и занятия
+-- в этом помещении. Предварительная бронь из бота (telegram_hold) после
+-- hold_until время не занимает
+SELECT bl.room_id, bl.start_datetime AS starts_at, bl.end_datetime AS ends_at
+FROM spaces_room_blocks bl
+WHERE bl.profile_id = sqlc.arg(profile_id)
+  AND bl.start_datetime < sqlc.arg(until) AND bl.end_datetime > sqlc.arg(since)
+  AND NOT (bl.reason = 'telegram_hold' AND bl.hold_until < NOW())
+UNION ALL
+SELECT l.room_id, (l.lesson_date + l.start_time)::timestamptz, (l.lesson_date + l.end_time)::timestamptz
+FROM education_lessons l
+WHERE l.profile_id = sqlc.arg(profile_id) AND l.room_id IS NOT NULL AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+  AND (l.lesson_date + l.start_time)::timestamptz < sqlc.arg(until)
+  AND (l.lesson_date + l.end_time)::timestamptz > sqlc.arg(since);
+
+-- name: CreateSpaceBooking :one
+-- Бронь помещения - блокировка spaces_room_blocks с reason = 'telegram_hold'
+-- (до оплаты, до hold_until) или 'booking'. Если время уже занято, строк нет
+INSERT INTO spaces_room_blocks (profile_id, room_id, start_datetime, end_datetime, reason, notes, hold_until)
+SELECT sqlc.arg(profile_id), sqlc.arg(room_id), sqlc.arg(start_datetime), sqlc.arg(end_datetime), sqlc.arg(reason), sqlc.arg(notes), sqlc.narg(hold_until)
+WHERE NOT EXISTS (
+    SELECT 1 FROM spaces_room_blocks bl
+    WHERE bl.room_id = sqlc.arg(room_id)
+      AND bl.start_datetime < sqlc.arg(end_datetime) AND bl.end_datetime > sqlc.arg(start_datetime)
+      AND NOT (bl.reason = 'telegram_hold' AND bl.hold_until < NOW()))
+  AND NOT EXISTS (
+    SELECT 1 FROM education_lessons l
+    WHERE l.room_id = sqlc.arg(room_id) AND NOT COALESCE(l.is_deleted, false)
+      AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+      AND (l.lesson_date + l.start_time)::timestamptz < sqlc.arg(end_datetime)
+      AND (l.lesson_date + l.end_time)::timestamptz > sqlc.arg(start_datetime))
+RETURNING id;
+
+-- name: GetSpaceBooking :one
+SELECT bl.id, bl.profile_id, bl.room_id, bl.reason, bl.start_datetime, bl.end_datetime, bl.hold_until,
+       COALESCE(r.name, '')::text AS room_name
+FROM spaces_room_blocks bl
+LEFT JOIN spaces_rooms r ON r.id = bl.room_id
+WHERE bl.id = $1;
+
+-- name: ConfirmSpaceBooking :execrows
+-- Оплаченная предварительная бронь помещения становится постоянной, если это
+-- время никто не занял (истекшую бронь могли перебронировать).
+-- Вызывается в транзакции после LockBookingResource
+UPDATE spaces_room_blocks b SET reason = 'booking', hold_until = NULL
+WHERE b.id = $1 AND b.reason = 'telegram_hold'
+  AND NOT EXISTS (
+    SELECT 1 FROM spaces_room_blocks o
+    WHERE o.room_id = b.room_id AND o.id <> b.id
+      AND o.start_datetime < b.end_datetime AND o.end_datetime > b.start_datetime
+      AND NOT (o.reason = 'telegram_hold' AND o.hold_until < NOW()))
+  AND NOT EXISTS (
+    SELECT 1 FROM education_lessons l
+    WHERE l.room_id = b.room_id AND NOT COALESCE(l.is_deleted, false)
+      AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+      AND (l.lesson_date + l.start_time)::timestamptz < b.end_datetime
+      AND (l.lesson_date + l.end_time)::timestamptz > b.start_datetime);
+
+-- name: ReleaseSpaceHold :execrows
+-- Снятие неоплаченной предварительной брони помещения
+DELETE FROM spaces_room_blocks
+WHERE id = $1 AND reason = 'telegram_hold';
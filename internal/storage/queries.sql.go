@@ -12,6 +12,281 @@ import (
 	"github.com/pgvector/pgvector-go"
 )
 
+const activatePackagePurchase = `-- name: ActivatePackagePurchase :execrows
+UPDATE education_package_purchases
+SET status = 'active', payment_status = 'paid', purchased_at = NOW(),
+    expires_at = CASE WHEN $1::int > 0
+                      THEN NOW() + make_interval(days => $1::int) END,
+    updated_at = NOW()
+WHERE id = $2 AND COALESCE(payment_status, 'pending') = 'pending'
+`
+
+type ActivatePackagePurchaseParams struct {
+	ValidityDays pgtype.Int4 `json:"validity_days"`
+	ID           pgtype.UUID `json:"id"`
+}
+
+// Активирует оплаченный абонемент; повторный вызов ничего не меняет
+func (q *Queries) ActivatePackagePurchase(ctx context.Context, arg ActivatePackagePurchaseParams) (int64, error) {
+	result, err := q.db.Exec(ctx, activatePackagePurchase, arg.ValidityDays, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const addEducationBalance = `-- name: AddEducationBalance :one
+UPDATE education_balance_accounts
+SET balance = COALESCE(balance, 0) + $1, updated_at = NOW()
+WHERE id = (SELECT a.id FROM education_balance_accounts a
+            WHERE a.profile_id = $2 AND a.student_id = $3
+            ORDER BY a.created_at LIMIT 1)
+RETURNING balance::int
+`
+
+type AddEducationBalanceParams struct {
+	Amount    int32       `json:"amount"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+}
+
+// Изменяет баланс ученика; возвращает баланс после изменения
+func (q *Queries) AddEducationBalance(ctx context.Context, arg AddEducationBalanceParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addEducationBalance, arg.Amount, arg.ProfileID, arg.StudentID)
+	var balance int32
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const addTicketSessionSold = `-- name: AddTicketSessionSold :execrows
+UPDATE ticket_sessions
+SET sold_count = COALESCE(sold_count, 0) + $1::int, updated_at = NOW()
+WHERE id = $2
+  AND (capacity IS NULL OR capacity <= 0 OR COALESCE(sold_count, 0) + $1::int <= capacity)
+`
+
+type AddTicketSessionSoldParams struct {
+	Quantity int32       `json:"quantity"`
+	ID       pgtype.UUID `json:"id"`
+}
+
+// Не обновляет сеанс, если мест не хватает: 0 строк - билеты распроданы
+func (q *Queries) AddTicketSessionSold(ctx context.Context, arg AddTicketSessionSoldParams) (int64, error) {
+	result, err := q.db.Exec(ctx, addTicketSessionSold, arg.Quantity, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const assignHandoff = `-- name: AssignHandoff :exec
+UPDATE telegram_handoffs
+SET account_id = $2
+WHERE id = $1 AND account_id IS NULL
+`
+
+type AssignHandoffParams struct {
+	ID        pgtype.UUID `json:"id"`
+	AccountID pgtype.UUID `json:"account_id"`
+}
+
+func (q *Queries) AssignHandoff(ctx context.Context, arg AssignHandoffParams) error {
+	_, err := q.db.Exec(ctx, assignHandoff, arg.ID, arg.AccountID)
+	return err
+}
+
+const cancelStudentEnrollment = `-- name: CancelStudentEnrollment :one
+UPDATE education_lesson_enrollments e
+SET status = 'cancelled', was_charged = false, updated_at = NOW()
+FROM education_lesson_enrollments old
+WHERE e.id = $1 AND e.profile_id = $2 AND e.student_id = $3
+  AND old.id = e.id
+  AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent')
+RETURNING e.id, e.lesson_id, COALESCE(old.was_charged, false)::bool AS was_charged
+`
+
+type CancelStudentEnrollmentParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+}
+
+type CancelStudentEnrollmentRow struct {
+	ID         pgtype.UUID `json:"id"`
+	LessonID   pgtype.UUID `json:"lesson_id"`
+	WasCharged bool        `json:"was_charged"`
+}
+
+// Отмена записи ученика; списанное занятие (was_charged) возвращается на баланс
+// вызывающим кодом, поэтому флаг сбрасывается здесь же
+func (q *Queries) CancelStudentEnrollment(ctx context.Context, arg CancelStudentEnrollmentParams) (CancelStudentEnrollmentRow, error) {
+	row := q.db.QueryRow(ctx, cancelStudentEnrollment, arg.ID, arg.ProfileID, arg.StudentID)
+	var i CancelStudentEnrollmentRow
+	err := row.Scan(&i.ID, &i.LessonID, &i.WasCharged)
+	return i, err
+}
+
+const cancelWaitingExecutions = `-- name: CancelWaitingExecutions :exec
+UPDATE telegram_executions
+SET status = 'cancelled', finished_at = NOW()
+WHERE chat_id = $2 AND status = 'waiting'
+  AND workflow_id IN (SELECT id FROM telegram_workflows WHERE bot_id = $1)
+`
+
+type CancelWaitingExecutionsParams struct {
+	BotID  pgtype.UUID `json:"bot_id"`
+	ChatID int64       `json:"chat_id"`
+}
+
+func (q *Queries) CancelWaitingExecutions(ctx context.Context, arg CancelWaitingExecutionsParams) error {
+	_, err := q.db.Exec(ctx, cancelWaitingExecutions, arg.BotID, arg.ChatID)
+	return err
+}
+
+const closeHandoff = `-- name: CloseHandoff :execrows
+UPDATE telegram_handoffs
+SET status = 'closed', closed_at = NOW(), closed_by = $2
+WHERE id = $1 AND status = 'open'
+`
+
+type CloseHandoffParams struct {
+	ID       pgtype.UUID `json:"id"`
+	ClosedBy pgtype.UUID `json:"closed_by"`
+}
+
+func (q *Queries) CloseHandoff(ctx context.Context, arg CloseHandoffParams) (int64, error) {
+	result, err := q.db.Exec(ctx, closeHandoff, arg.ID, arg.ClosedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const confirmHotelBooking = `-- name: ConfirmHotelBooking :execrows
+UPDATE hotel_bookings b
+SET status = 'confirmed', deposit_amount = b.total_price, deposit_paid = true,
+    custom_fields = b.custom_fields - 'hold_until', updated_at = NOW()
+WHERE b.id = $1 AND b.status = 'pending'
+  AND NOT EXISTS (
+    SELECT 1 FROM hotel_bookings o
+    WHERE o.room_id = b.room_id AND o.id <> b.id
+      AND o.check_in_date < b.check_out_date AND o.check_out_date > b.check_in_date
+      AND o.status NOT IN ('cancelled', 'canceled')
+      AND NOT (o.status = 'pending' AND (o.custom_fields->>'hold_until')::timestamptz < NOW()))
+`
+
+// Оплаченное предварительное бронирование становится подтвержденным, если номер
+// на эти даты никто не занял (истекшую бронь могли перебронировать).
+// Вызывается в транзакции после LockBookingResource
+func (q *Queries) ConfirmHotelBooking(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, confirmHotelBooking, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const confirmSpaceBooking = `-- name: ConfirmSpaceBooking :execrows
+UPDATE spaces_room_blocks b SET reason = 'booking', hold_until = NULL
+WHERE b.id = $1 AND b.reason = 'telegram_hold'
+  AND NOT EXISTS (
+    SELECT 1 FROM spaces_room_blocks o
+    WHERE o.room_id = b.room_id AND o.id <> b.id
+      AND o.start_datetime < b.end_datetime AND o.end_datetime > b.start_datetime
+      AND NOT (o.reason = 'telegram_hold' AND o.hold_until < NOW()))
+  AND NOT EXISTS (
+    SELECT 1 FROM education_lessons l
+    WHERE l.room_id = b.room_id AND NOT COALESCE(l.is_deleted, false)
+      AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+      AND (l.lesson_date + l.start_time)::timestamptz < b.end_datetime
+      AND (l.lesson_date + l.end_time)::timestamptz > b.start_datetime)
+`
+
+// Оплаченная предварительная бронь помещения становится постоянной, если это
+// время никто не занял (истекшую бронь могли перебронировать).
+// Вызывается в транзакции после LockBookingResource
+func (q *Queries) ConfirmSpaceBooking(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, confirmSpaceBooking, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countCatalogProducts = `-- name: CountCatalogProducts :one
+SELECT COUNT(*)
+FROM products
+WHERE profile_id = $1 AND status = 'active' AND NOT COALESCE(is_deleted, false)
+  AND category_id IS NOT DISTINCT FROM $2::uuid
+`
+
+type CountCatalogProductsParams struct {
+	ProfileID  pgtype.UUID `json:"profile_id"`
+	CategoryID pgtype.UUID `json:"category_id"`
+}
+
+func (q *Queries) CountCatalogProducts(ctx context.Context, arg CountCatalogProductsParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countCatalogProducts, arg.ProfileID, arg.CategoryID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCustomerPromoUses = `-- name: CountCustomerPromoUses :one
+SELECT COUNT(*)
+FROM payment_orders
+WHERE customer_id = $1 AND status = 'paid'
+  AND form_data->>'promo_code_id' = $2::text
+`
+
+type CountCustomerPromoUsesParams struct {
+	CustomerID  pgtype.UUID `json:"customer_id"`
+	PromoCodeID string      `json:"promo_code_id"`
+}
+
+func (q *Queries) CountCustomerPromoUses(ctx context.Context, arg CountCustomerPromoUsesParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countCustomerPromoUses, arg.CustomerID, arg.PromoCodeID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPromoCodeUse = `-- name: CountPromoCodeUse :execrows
+WITH marked AS (
+    UPDATE payment_orders
+    SET form_data = form_data || '{"promo_counted": true}'::jsonb
+    WHERE payment_orders.id = $1 AND form_data ? 'promo_code_id'
+      AND NOT COALESCE((form_data->>'promo_counted')::boolean, false)
+    RETURNING (form_data->>'promo_code_id')::uuid AS promo_code_id
+)
+UPDATE promo_codes
+SET used_count = COALESCE(used_count, 0) + 1, updated_at = NOW()
+WHERE promo_codes.id = (SELECT promo_code_id FROM marked)
+`
+
+// Отметка promo_counted в заказе делает учет идемпотентным
+func (q *Queries) CountPromoCodeUse(ctx context.Context, paymentOrderID pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, countPromoCodeUse, paymentOrderID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countUpcomingEvents = `-- name: CountUpcomingEvents :one
+SELECT COUNT(*)
+FROM events
+WHERE profile_id = $1 AND status = 'published' AND visibility = 'public'
+  AND start_date > NOW()
+`
+
+func (q *Queries) CountUpcomingEvents(ctx context.Context, profileID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countUpcomingEvents, profileID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createConversation = `-- name: CreateConversation :one
 INSERT INTO telegram_conversations (
     id, profile_id, telegram_user_id, chat_id, context, last_message_at
@@ -47,6 +322,132 @@ func (q *Queries) CreateConversation(ctx context.Context, arg CreateConversation
 	return i, err
 }
 
+const createCustomer = `-- name: CreateCustomer :one
+INSERT INTO customers (
+    id, profile_id, customer_type, name, phone, email, source, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, 'individual', $2, $3, $4, 'telegram', NOW(), NOW()
+)
+RETURNING id
+`
+
+type CreateCustomerParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Name      string      `json:"name"`
+	Phone     pgtype.Text `json:"phone"`
+	Email     pgtype.Text `json:"email"`
+}
+
+func (q *Queries) CreateCustomer(ctx context.Context, arg CreateCustomerParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createCustomer,
+		arg.ProfileID,
+		arg.Name,
+		arg.Phone,
+		arg.Email,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createEducationBalanceAccount = `-- name: CreateEducationBalanceAccount :one
+INSERT INTO education_balance_accounts (profile_id, student_id, balance, currency_type)
+VALUES ($1, $2, $3, 'lessons')
+RETURNING balance::int
+`
+
+type CreateEducationBalanceAccountParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+	Balance   pgtype.Int4 `json:"balance"`
+}
+
+func (q *Queries) CreateEducationBalanceAccount(ctx context.Context, arg CreateEducationBalanceAccountParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createEducationBalanceAccount, arg.ProfileID, arg.StudentID, arg.Balance)
+	var balance int32
+	err := row.Scan(&balance)
+	return balance, err
+}
+
+const createEducationBalanceTransaction = `-- name: CreateEducationBalanceTransaction :exec
+INSERT INTO education_balance_transactions (
+    profile_id, student_id, transaction_type, amount, balance_before, balance_after,
+    related_lesson_id, related_package_id, related_enrollment_id, description
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+type CreateEducationBalanceTransactionParams struct {
+	ProfileID           pgtype.UUID `json:"profile_id"`
+	StudentID           pgtype.UUID `json:"student_id"`
+	TransactionType     string      `json:"transaction_type"`
+	Amount              int32       `json:"amount"`
+	BalanceBefore       int32       `json:"balance_before"`
+	BalanceAfter        int32       `json:"balance_after"`
+	RelatedLessonID     pgtype.UUID `json:"related_lesson_id"`
+	RelatedPackageID    pgtype.UUID `json:"related_package_id"`
+	RelatedEnrollmentID pgtype.UUID `json:"related_enrollment_id"`
+	Description         pgtype.Text `json:"description"`
+}
+
+func (q *Queries) CreateEducationBalanceTransaction(ctx context.Context, arg CreateEducationBalanceTransactionParams) error {
+	_, err := q.db.Exec(ctx, createEducationBalanceTransaction,
+		arg.ProfileID,
+		arg.StudentID,
+		arg.TransactionType,
+		arg.Amount,
+		arg.BalanceBefore,
+		arg.BalanceAfter,
+		arg.RelatedLessonID,
+		arg.RelatedPackageID,
+		arg.RelatedEnrollmentID,
+		arg.Description,
+	)
+	return err
+}
+
+const createEventRegistration = `-- name: CreateEventRegistration :one
+INSERT INTO event_registrations (
+    id, profile_id, event_id, customer_id, name, email, phone, status, qr_code,
+    custom_fields, registered_at, updated_at
+)
+SELECT gen_random_uuid(), $1, $2, $3, $4, $5, $6, 'registered', $7, $8, NOW(), NOW()
+WHERE $9::int IS NULL
+   OR (SELECT COUNT(*) FROM event_registrations
+       WHERE event_id = $2 AND status <> 'cancelled') < $9::int
+RETURNING id
+`
+
+type CreateEventRegistrationParams struct {
+	ProfileID       pgtype.UUID `json:"profile_id"`
+	EventID         pgtype.UUID `json:"event_id"`
+	CustomerID      pgtype.UUID `json:"customer_id"`
+	Name            string      `json:"name"`
+	Email           pgtype.Text `json:"email"`
+	Phone           pgtype.Text `json:"phone"`
+	QrCode          pgtype.Text `json:"qr_code"`
+	CustomFields    []byte      `json:"custom_fields"`
+	MaxParticipants pgtype.Int4 `json:"max_participants"`
+}
+
+// Вставка не выполняется, если мест уже нет. Вызывается в транзакции после
+// LockEvent, иначе одновременные регистрации превысят лимит
+func (q *Queries) CreateEventRegistration(ctx context.Context, arg CreateEventRegistrationParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createEventRegistration,
+		arg.ProfileID,
+		arg.EventID,
+		arg.CustomerID,
+		arg.Name,
+		arg.Email,
+		arg.Phone,
+		arg.QrCode,
+		arg.CustomFields,
+		arg.MaxParticipants,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
 const createExecution = `-- name: CreateExecution :one
 INSERT INTO telegram_executions (
     id, profile_id, workflow_id, telegram_user_id, chat_id,
@@ -94,52 +495,2937 @@ func (q *Queries) CreateExecution(ctx context.Context, arg CreateExecutionParams
 	return i, err
 }
 
-const getActiveWorkflowsByBot = `-- name: GetActiveWorkflowsByBot :many
-SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
-       trigger_type, trigger_config, is_active,
-       created_at, updated_at, created_by
-FROM telegram_workflows
-WHERE bot_id = $1 AND is_active = true
+const createHandoff = `-- name: CreateHandoff :one
+INSERT INTO telegram_handoffs (
+    id, profile_id, bot_id, chat_id, telegram_user_id, status, reason, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, 'open', $5, NOW()
+)
+RETURNING id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+          account_id, created_at, closed_at, closed_by
+`
+
+type CreateHandoffParams struct {
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	BotID          pgtype.UUID `json:"bot_id"`
+	ChatID         int64       `json:"chat_id"`
+	TelegramUserID int64       `json:"telegram_user_id"`
+	Reason         string      `json:"reason"`
+}
+
+func (q *Queries) CreateHandoff(ctx context.Context, arg CreateHandoffParams) (TelegramHandoff, error) {
+	row := q.db.QueryRow(ctx, createHandoff,
+		arg.ProfileID,
+		arg.BotID,
+		arg.ChatID,
+		arg.TelegramUserID,
+		arg.Reason,
+	)
+	var i TelegramHandoff
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.ChatID,
+		&i.TelegramUserID,
+		&i.Status,
+		&i.Reason,
+		&i.AccountID,
+		&i.CreatedAt,
+		&i.ClosedAt,
+		&i.ClosedBy,
+	)
+	return i, err
+}
+
+const createHandoffMessage = `-- name: CreateHandoffMessage :exec
+INSERT INTO telegram_handoff_messages (
+    id, handoff_id, staff_chat_id, staff_message_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, NOW()
+)
+`
+
+type CreateHandoffMessageParams struct {
+	HandoffID      pgtype.UUID `json:"handoff_id"`
+	StaffChatID    int64       `json:"staff_chat_id"`
+	StaffMessageID int64       `json:"staff_message_id"`
+}
+
+func (q *Queries) CreateHandoffMessage(ctx context.Context, arg CreateHandoffMessageParams) error {
+	_, err := q.db.Exec(ctx, createHandoffMessage, arg.HandoffID, arg.StaffChatID, arg.StaffMessageID)
+	return err
+}
+
+const createHotelBookingHold = `-- name: CreateHotelBookingHold :one
+INSERT INTO hotel_bookings (
+    profile_id, room_id, owner_id, check_in_date, check_out_date, status,
+    total_price, notes, custom_fields
+)
+SELECT $1, $2, $3, $4, $5, 'pending',
+       $6, $7,
+       jsonb_build_object('source', 'telegram', 'guests', $8::int, 'hold_until', $9::timestamptz)
+WHERE NOT EXISTS (
+    SELECT 1 FROM hotel_bookings b
+    WHERE b.room_id = $2
+      AND b.check_in_date < $5 AND b.check_out_date > $4
+      AND b.status NOT IN ('cancelled', 'canceled')
+      AND NOT (b.status = 'pending' AND (b.custom_fields->>'hold_until')::timestamptz < NOW()))
+RETURNING id
+`
+
+type CreateHotelBookingHoldParams struct {
+	ProfileID    pgtype.UUID        `json:"profile_id"`
+	RoomID       pgtype.UUID        `json:"room_id"`
+	OwnerID      pgtype.UUID        `json:"owner_id"`
+	CheckInDate  pgtype.Date        `json:"check_in_date"`
+	CheckOutDate pgtype.Date        `json:"check_out_date"`
+	TotalPrice   pgtype.Numeric     `json:"total_price"`
+	Notes        pgtype.Text        `json:"notes"`
+	Guests       int32              `json:"guests"`
+	HoldUntil    pgtype.Timestamptz `json:"hold_until"`
+}
+
+// Предварительное бронирование номера до оплаты (hold_until), если номер
+// на эти даты свободен; иначе строк нет
+func (q *Queries) CreateHotelBookingHold(ctx context.Context, arg CreateHotelBookingHoldParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createHotelBookingHold,
+		arg.ProfileID,
+		arg.RoomID,
+		arg.OwnerID,
+		arg.CheckInDate,
+		arg.CheckOutDate,
+		arg.TotalPrice,
+		arg.Notes,
+		arg.Guests,
+		arg.HoldUntil,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createLessonEnrollment = `-- name: CreateLessonEnrollment :one
+INSERT INTO education_lesson_enrollments (profile_id, lesson_id, student_id, status, registered_at, notes)
+SELECT $1, l.id, $2, 'enrolled', NOW(), 'Telegram'
+FROM education_lessons l
+WHERE l.id = $3
+  AND (l.max_students IS NULL OR (
+        SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled')) < l.max_students)
+RETURNING id
+`
+
+type CreateLessonEnrollmentParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+	LessonID  pgtype.UUID `json:"lesson_id"`
+}
+
+// Запись на занятие, если есть свободные места (max_students). Вызывается в
+// транзакции после LockLesson, иначе одновременные записи превысят лимит
+func (q *Queries) CreateLessonEnrollment(ctx context.Context, arg CreateLessonEnrollmentParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createLessonEnrollment, arg.ProfileID, arg.StudentID, arg.LessonID)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createNotification = `-- name: CreateNotification :exec
+INSERT INTO core_notifications (
+    id, profile_id, type, title, message, payload, is_read, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, false, NOW()
+)
+`
+
+type CreateNotificationParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Message   pgtype.Text `json:"message"`
+	Payload   []byte      `json:"payload"`
+}
+
+func (q *Queries) CreateNotification(ctx context.Context, arg CreateNotificationParams) error {
+	_, err := q.db.Exec(ctx, createNotification,
+		arg.ProfileID,
+		arg.Type,
+		arg.Title,
+		arg.Message,
+		arg.Payload,
+	)
+	return err
+}
+
+const createPackagePurchase = `-- name: CreatePackagePurchase :one
+INSERT INTO education_package_purchases (
+    profile_id, student_id, package_id, lessons_granted, lessons_used, price_paid,
+    status, payment_status, notes
+) VALUES ($1, $2, $3, $4, 0, $5, 'pending', 'pending', $6)
+RETURNING id
+`
+
+type CreatePackagePurchaseParams struct {
+	ProfileID      pgtype.UUID    `json:"profile_id"`
+	StudentID      pgtype.UUID    `json:"student_id"`
+	PackageID      pgtype.UUID    `json:"package_id"`
+	LessonsGranted int32          `json:"lessons_granted"`
+	PricePaid      pgtype.Numeric `json:"price_paid"`
+	Notes          pgtype.Text    `json:"notes"`
+}
+
+// Покупка абонемента, ожидающая оплаты
+func (q *Queries) CreatePackagePurchase(ctx context.Context, arg CreatePackagePurchaseParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createPackagePurchase,
+		arg.ProfileID,
+		arg.StudentID,
+		arg.PackageID,
+		arg.LessonsGranted,
+		arg.PricePaid,
+		arg.Notes,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createPayment = `-- name: CreatePayment :one
+INSERT INTO payments (
+    id, profile_id, customer_id, entity_type, entity_id, amount, payment_method,
+    status, external_id, external_data, description, paid_at, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NOW(), NOW(), NOW()
+)
+RETURNING id
+`
+
+type CreatePaymentParams struct {
+	ProfileID     pgtype.UUID    `json:"profile_id"`
+	CustomerID    pgtype.UUID    `json:"customer_id"`
+	EntityType    pgtype.Text    `json:"entity_type"`
+	EntityID      pgtype.UUID    `json:"entity_id"`
+	Amount        pgtype.Numeric `json:"amount"`
+	PaymentMethod string         `json:"payment_method"`
+	Status        string         `json:"status"`
+	ExternalID    pgtype.Text    `json:"external_id"`
+	ExternalData  []byte         `json:"external_data"`
+	Description   pgtype.Text    `json:"description"`
+}
+
+func (q *Queries) CreatePayment(ctx context.Context, arg CreatePaymentParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createPayment,
+		arg.ProfileID,
+		arg.CustomerID,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Amount,
+		arg.PaymentMethod,
+		arg.Status,
+		arg.ExternalID,
+		arg.ExternalData,
+		arg.Description,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createPaymentOrder = `-- name: CreatePaymentOrder :one
+INSERT INTO payment_orders (
+    id, profile_id, customer_id, entity_type, entity_id, payment_gateway_id,
+    payer_email, payer_phone, amount, description, status, form_data,
+    expires_at, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, 'pending', $10, $11, NOW(), NOW()
+)
+RETURNING id, profile_id, customer_id, entity_type, entity_id, payment_form_id,
+          form_data, payment_gateway_id, payer_email, payer_phone, amount,
+          description, status, external_id, payment_url, external_data,
+          payment_id, expires_at, paid_at, created_at, updated_at, created_by
+`
+
+type CreatePaymentOrderParams struct {
+	ProfileID        pgtype.UUID        `json:"profile_id"`
+	CustomerID       pgtype.UUID        `json:"customer_id"`
+	EntityType       pgtype.Text        `json:"entity_type"`
+	EntityID         pgtype.UUID        `json:"entity_id"`
+	PaymentGatewayID pgtype.UUID        `json:"payment_gateway_id"`
+	PayerEmail       pgtype.Text        `json:"payer_email"`
+	PayerPhone       pgtype.Text        `json:"payer_phone"`
+	Amount           pgtype.Numeric     `json:"amount"`
+	Description      string             `json:"description"`
+	FormData         []byte             `json:"form_data"`
+	ExpiresAt        pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreatePaymentOrder(ctx context.Context, arg CreatePaymentOrderParams) (PaymentOrder, error) {
+	row := q.db.QueryRow(ctx, createPaymentOrder,
+		arg.ProfileID,
+		arg.CustomerID,
+		arg.EntityType,
+		arg.EntityID,
+		arg.PaymentGatewayID,
+		arg.PayerEmail,
+		arg.PayerPhone,
+		arg.Amount,
+		arg.Description,
+		arg.FormData,
+		arg.ExpiresAt,
+	)
+	var i PaymentOrder
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.CustomerID,
+		&i.EntityType,
+		&i.EntityID,
+		&i.PaymentFormID,
+		&i.FormData,
+		&i.PaymentGatewayID,
+		&i.PayerEmail,
+		&i.PayerPhone,
+		&i.Amount,
+		&i.Description,
+		&i.Status,
+		&i.ExternalID,
+		&i.PaymentUrl,
+		&i.ExternalData,
+		&i.PaymentID,
+		&i.ExpiresAt,
+		&i.PaidAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CreatedBy,
+	)
+	return i, err
+}
+
+const createSpaceBooking = `-- name: CreateSpaceBooking :one
+INSERT INTO spaces_room_blocks (profile_id, room_id, start_datetime, end_datetime, reason, notes, hold_until)
+SELECT $1, $2, $3, $4, $5, $6, $7
+WHERE NOT EXISTS (
+    SELECT 1 FROM spaces_room_blocks bl
+    WHERE bl.room_id = $2
+      AND bl.start_datetime < $4 AND bl.end_datetime > $3
+      AND NOT (bl.reason = 'telegram_hold' AND bl.hold_until < NOW()))
+  AND NOT EXISTS (
+    SELECT 1 FROM education_lessons l
+    WHERE l.room_id = $2 AND NOT COALESCE(l.is_deleted, false)
+      AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+      AND (l.lesson_date + l.start_time)::timestamptz < $4
+      AND (l.lesson_date + l.end_time)::timestamptz > $3)
+RETURNING id
+`
+
+type CreateSpaceBookingParams struct {
+	ProfileID     pgtype.UUID        `json:"profile_id"`
+	RoomID        pgtype.UUID        `json:"room_id"`
+	StartDatetime pgtype.Timestamptz `json:"start_datetime"`
+	EndDatetime   pgtype.Timestamptz `json:"end_datetime"`
+	Reason        string             `json:"reason"`
+	Notes         pgtype.Text        `json:"notes"`
+	HoldUntil     pgtype.Timestamptz `json:"hold_until"`
+}
+
+// Бронь помещения - блокировка spaces_room_blocks с reason = 'telegram_hold'
+// (до оплаты, до hold_until) или 'booking'. Если время уже занято, строк нет
+func (q *Queries) CreateSpaceBooking(ctx context.Context, arg CreateSpaceBookingParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createSpaceBooking,
+		arg.ProfileID,
+		arg.RoomID,
+		arg.StartDatetime,
+		arg.EndDatetime,
+		arg.Reason,
+		arg.Notes,
+		arg.HoldUntil,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTelegramCustomerLink = `-- name: CreateTelegramCustomerLink :exec
+INSERT INTO telegram_customer_links (
+    id, profile_id, customer_id, telegram_user_id, telegram_username,
+    first_name, last_name, linked_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, NOW()
+)
+ON CONFLICT (profile_id, telegram_user_id) DO NOTHING
+`
+
+type CreateTelegramCustomerLinkParams struct {
+	ProfileID        pgtype.UUID `json:"profile_id"`
+	CustomerID       pgtype.UUID `json:"customer_id"`
+	TelegramUserID   int64       `json:"telegram_user_id"`
+	TelegramUsername pgtype.Text `json:"telegram_username"`
+	FirstName        pgtype.Text `json:"first_name"`
+	LastName         pgtype.Text `json:"last_name"`
+}
+
+func (q *Queries) CreateTelegramCustomerLink(ctx context.Context, arg CreateTelegramCustomerLinkParams) error {
+	_, err := q.db.Exec(ctx, createTelegramCustomerLink,
+		arg.ProfileID,
+		arg.CustomerID,
+		arg.TelegramUserID,
+		arg.TelegramUsername,
+		arg.FirstName,
+		arg.LastName,
+	)
+	return err
+}
+
+const createTemplateSend = `-- name: CreateTemplateSend :exec
+INSERT INTO telegram_template_sends (
+    profile_id, bot_id, template_id, template_key, trigger_event,
+    entity_type, entity_id, chat_id, message_text, status,
+    telegram_message_id, error_message
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+`
+
+type CreateTemplateSendParams struct {
+	ProfileID         pgtype.UUID `json:"profile_id"`
+	BotID             pgtype.UUID `json:"bot_id"`
+	TemplateID        pgtype.UUID `json:"template_id"`
+	TemplateKey       string      `json:"template_key"`
+	TriggerEvent      string      `json:"trigger_event"`
+	EntityType        string      `json:"entity_type"`
+	EntityID          pgtype.UUID `json:"entity_id"`
+	ChatID            int64       `json:"chat_id"`
+	MessageText       string      `json:"message_text"`
+	Status            string      `json:"status"`
+	TelegramMessageID pgtype.Int8 `json:"telegram_message_id"`
+	ErrorMessage      pgtype.Text `json:"error_message"`
+}
+
+// Запись об отправке сообщения по шаблону
+func (q *Queries) CreateTemplateSend(ctx context.Context, arg CreateTemplateSendParams) error {
+	_, err := q.db.Exec(ctx, createTemplateSend,
+		arg.ProfileID,
+		arg.BotID,
+		arg.TemplateID,
+		arg.TemplateKey,
+		arg.TriggerEvent,
+		arg.EntityType,
+		arg.EntityID,
+		arg.ChatID,
+		arg.MessageText,
+		arg.Status,
+		arg.TelegramMessageID,
+		arg.ErrorMessage,
+	)
+	return err
+}
+
+const createTicket = `-- name: CreateTicket :one
+INSERT INTO tickets (
+    id, profile_id, order_id, ticket_type_id, session_id, qr_code, price,
+    attendee_name, attendee_email, attendee_phone, status, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, 'active', NOW()
+)
+RETURNING id
+`
+
+type CreateTicketParams struct {
+	ProfileID     pgtype.UUID    `json:"profile_id"`
+	OrderID       pgtype.UUID    `json:"order_id"`
+	TicketTypeID  pgtype.UUID    `json:"ticket_type_id"`
+	SessionID     pgtype.UUID    `json:"session_id"`
+	QrCode        string         `json:"qr_code"`
+	Price         pgtype.Numeric `json:"price"`
+	AttendeeName  pgtype.Text    `json:"attendee_name"`
+	AttendeeEmail pgtype.Text    `json:"attendee_email"`
+	AttendeePhone pgtype.Text    `json:"attendee_phone"`
+}
+
+func (q *Queries) CreateTicket(ctx context.Context, arg CreateTicketParams) (pgtype.UUID, error) {
+	row := q.db.QueryRow(ctx, createTicket,
+		arg.ProfileID,
+		arg.OrderID,
+		arg.TicketTypeID,
+		arg.SessionID,
+		arg.QrCode,
+		arg.Price,
+		arg.AttendeeName,
+		arg.AttendeeEmail,
+		arg.AttendeePhone,
+	)
+	var id pgtype.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTicketOrder = `-- name: CreateTicketOrder :one
+INSERT INTO ticket_orders (
+    id, profile_id, customer_id, session_id, order_number, buyer_name, buyer_email,
+    buyer_phone, subtotal, discount_amount, total_amount, status, payment_method,
+    promo_code_id, custom_fields, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'pending', $11, $12, $13, NOW(), NOW()
+)
+RETURNING id, order_number
+`
+
+type CreateTicketOrderParams struct {
+	ProfileID      pgtype.UUID    `json:"profile_id"`
+	CustomerID     pgtype.UUID    `json:"customer_id"`
+	SessionID      pgtype.UUID    `json:"session_id"`
+	OrderNumber    string         `json:"order_number"`
+	BuyerName      pgtype.Text    `json:"buyer_name"`
+	BuyerEmail     pgtype.Text    `json:"buyer_email"`
+	BuyerPhone     pgtype.Text    `json:"buyer_phone"`
+	Subtotal       pgtype.Numeric `json:"subtotal"`
+	DiscountAmount pgtype.Numeric `json:"discount_amount"`
+	TotalAmount    pgtype.Numeric `json:"total_amount"`
+	PaymentMethod  pgtype.Text    `json:"payment_method"`
+	PromoCodeID    pgtype.UUID    `json:"promo_code_id"`
+	CustomFields   []byte         `json:"custom_fields"`
+}
+
+type CreateTicketOrderRow struct {
+	ID          pgtype.UUID `json:"id"`
+	OrderNumber string      `json:"order_number"`
+}
+
+func (q *Queries) CreateTicketOrder(ctx context.Context, arg CreateTicketOrderParams) (CreateTicketOrderRow, error) {
+	row := q.db.QueryRow(ctx, createTicketOrder,
+		arg.ProfileID,
+		arg.CustomerID,
+		arg.SessionID,
+		arg.OrderNumber,
+		arg.BuyerName,
+		arg.BuyerEmail,
+		arg.BuyerPhone,
+		arg.Subtotal,
+		arg.DiscountAmount,
+		arg.TotalAmount,
+		arg.PaymentMethod,
+		arg.PromoCodeID,
+		arg.CustomFields,
+	)
+	var i CreateTicketOrderRow
+	err := row.Scan(&i.ID, &i.OrderNumber)
+	return i, err
+}
+
+const deleteAdminSubscription = `-- name: DeleteAdminSubscription :exec
+DELETE FROM telegram_admin_subscriptions
+WHERE bot_id = $1 AND chat_id = $2
+`
+
+type DeleteAdminSubscriptionParams struct {
+	BotID  pgtype.UUID `json:"bot_id"`
+	ChatID int64       `json:"chat_id"`
+}
+
+func (q *Queries) DeleteAdminSubscription(ctx context.Context, arg DeleteAdminSubscriptionParams) error {
+	_, err := q.db.Exec(ctx, deleteAdminSubscription, arg.BotID, arg.ChatID)
+	return err
+}
+
+const disableBot = `-- name: DisableBot :exec
+UPDATE telegram_bots
+SET is_active = false,
+    last_error = $2,
+    last_error_at = NOW(),
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type DisableBotParams struct {
+	ID        pgtype.UUID `json:"id"`
+	LastError pgtype.Text `json:"last_error"`
+}
+
+func (q *Queries) DisableBot(ctx context.Context, arg DisableBotParams) error {
+	_, err := q.db.Exec(ctx, disableBot, arg.ID, arg.LastError)
+	return err
+}
+
+const expirePaymentOrder = `-- name: ExpirePaymentOrder :execrows
+UPDATE payment_orders
+SET status = 'expired', updated_at = NOW()
+WHERE id = $1 AND status = 'pending'
+`
+
+func (q *Queries) ExpirePaymentOrder(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, expirePaymentOrder, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getAccountByTelegram = `-- name: GetAccountByTelegram :one
+SELECT id, profile_id, name, first_name, last_name, tg, role, perms, role_id
+FROM core_accounts
+WHERE profile_id = $1
+  AND COALESCE(active, true) AND NOT COALESCE(archived, false)
+  AND tg = $2::text
+LIMIT 1
+`
+
+type GetAccountByTelegramParams struct {
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	TelegramUserID string      `json:"telegram_user_id"`
+}
+
+type GetAccountByTelegramRow struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Name      pgtype.Text `json:"name"`
+	FirstName pgtype.Text `json:"first_name"`
+	LastName  pgtype.Text `json:"last_name"`
+	Tg        pgtype.Text `json:"tg"`
+	Role      pgtype.Text `json:"role"`
+	Perms     pgtype.Text `json:"perms"`
+	RoleID    pgtype.UUID `json:"role_id"`
+}
+
+func (q *Queries) GetAccountByTelegram(ctx context.Context, arg GetAccountByTelegramParams) (GetAccountByTelegramRow, error) {
+	row := q.db.QueryRow(ctx, getAccountByTelegram, arg.ProfileID, arg.TelegramUserID)
+	var i GetAccountByTelegramRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.Name,
+		&i.FirstName,
+		&i.LastName,
+		&i.Tg,
+		&i.Role,
+		&i.Perms,
+		&i.RoleID,
+	)
+	return i, err
+}
+
+const getActivePaymentGateway = `-- name: GetActivePaymentGateway :one
+SELECT id, profile_id, gateway_type, name, shop_id, secret_key, is_test_mode,
+       is_active, webhook_url, settings, created_at, updated_at, created_by
+FROM payment_gateways
+WHERE profile_id = $1 AND is_active = true
+  AND ($2::uuid IS NULL OR id = $2)
+ORDER BY created_at
+LIMIT 1
+`
+
+type GetActivePaymentGatewayParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	GatewayID pgtype.UUID `json:"gateway_id"`
+}
+
+func (q *Queries) GetActivePaymentGateway(ctx context.Context, arg GetActivePaymentGatewayParams) (PaymentGateway, error) {
+	row := q.db.QueryRow(ctx, getActivePaymentGateway, arg.ProfileID, arg.GatewayID)
+	var i PaymentGateway
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.GatewayType,
+		&i.Name,
+		&i.ShopID,
+		&i.SecretKey,
+		&i.IsTestMode,
+		&i.IsActive,
+		&i.WebhookUrl,
+		&i.Settings,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CreatedBy,
+	)
+	return i, err
+}
+
+const getActiveWorkflowsByBot = `-- name: GetActiveWorkflowsByBot :many
+SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
+       trigger_type, trigger_config, is_active,
+       created_at, updated_at, created_by
+FROM telegram_workflows
+WHERE bot_id = $1 AND is_active = true
+`
+
+type GetActiveWorkflowsByBotRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	ProfileID     pgtype.UUID        `json:"profile_id"`
+	BotID         pgtype.UUID        `json:"bot_id"`
+	WorkflowName  string             `json:"workflow_name"`
+	WorkflowKey   string             `json:"workflow_key"`
+	Description   pgtype.Text        `json:"description"`
+	TriggerType   string             `json:"trigger_type"`
+	TriggerConfig []byte             `json:"trigger_config"`
+	IsActive      bool               `json:"is_active"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+}
+
+func (q *Queries) GetActiveWorkflowsByBot(ctx context.Context, botID pgtype.UUID) ([]GetActiveWorkflowsByBotRow, error) {
+	rows, err := q.db.Query(ctx, getActiveWorkflowsByBot, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetActiveWorkflowsByBotRow{}
+	for rows.Next() {
+		var i GetActiveWorkflowsByBotRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProfileID,
+			&i.BotID,
+			&i.WorkflowName,
+			&i.WorkflowKey,
+			&i.Description,
+			&i.TriggerType,
+			&i.TriggerConfig,
+			&i.IsActive,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getActiveWorkflowsByProfile = `-- name: GetActiveWorkflowsByProfile :many
+SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
+       trigger_type, trigger_config, is_active,
+       created_at, updated_at, created_by
+FROM telegram_workflows
+WHERE profile_id = $1 AND is_active = true
+`
+
+type GetActiveWorkflowsByProfileRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	ProfileID     pgtype.UUID        `json:"profile_id"`
+	BotID         pgtype.UUID        `json:"bot_id"`
+	WorkflowName  string             `json:"workflow_name"`
+	WorkflowKey   string             `json:"workflow_key"`
+	Description   pgtype.Text        `json:"description"`
+	TriggerType   string             `json:"trigger_type"`
+	TriggerConfig []byte             `json:"trigger_config"`
+	IsActive      bool               `json:"is_active"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+}
+
+func (q *Queries) GetActiveWorkflowsByProfile(ctx context.Context, profileID pgtype.UUID) ([]GetActiveWorkflowsByProfileRow, error) {
+	rows, err := q.db.Query(ctx, getActiveWorkflowsByProfile, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetActiveWorkflowsByProfileRow{}
+	for rows.Next() {
+		var i GetActiveWorkflowsByProfileRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProfileID,
+			&i.BotID,
+			&i.WorkflowName,
+			&i.WorkflowKey,
+			&i.Description,
+			&i.TriggerType,
+			&i.TriggerConfig,
+			&i.IsActive,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAllActiveBots = `-- name: GetAllActiveBots :many
+SELECT 
+    id, profile_id, bot_token, bot_username, bot_name, is_active,
+    welcome_message, ai_enabled, ai_provider, ai_model, 
+    ai_system_prompt, ai_temperature, ai_max_tokens,
+    created_at, updated_at, settings, last_error, last_error_at
+FROM telegram_bots
+WHERE is_active = true
+`
+
+func (q *Queries) GetAllActiveBots(ctx context.Context) ([]TelegramBot, error) {
+	rows, err := q.db.Query(ctx, getAllActiveBots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TelegramBot{}
+	for rows.Next() {
+		var i TelegramBot
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProfileID,
+			&i.BotToken,
+			&i.BotUsername,
+			&i.BotName,
+			&i.IsActive,
+			&i.WelcomeMessage,
+			&i.AiEnabled,
+			&i.AiProvider,
+			&i.AiModel,
+			&i.AiSystemPrompt,
+			&i.AiTemperature,
+			&i.AiMaxTokens,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Settings,
+			&i.LastError,
+			&i.LastErrorAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppointmentReminder = `-- name: GetAppointmentReminder :one
+SELECT a.id, a.title, a.start_datetime, a.status, COALESCE(a.is_deleted, false)::bool AS is_deleted,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM appointments a
+WHERE a.id = $1 AND a.profile_id = $2
+`
+
+type GetAppointmentReminderParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetAppointmentReminderRow struct {
+	ID             pgtype.UUID        `json:"id"`
+	Title          string             `json:"title"`
+	StartDatetime  pgtype.Timestamptz `json:"start_datetime"`
+	Status         string             `json:"status"`
+	IsDeleted      bool               `json:"is_deleted"`
+	TelegramUserID int64              `json:"telegram_user_id"`
+}
+
+// Запись и Telegram пользователь клиента (последняя привязка)
+func (q *Queries) GetAppointmentReminder(ctx context.Context, arg GetAppointmentReminderParams) (GetAppointmentReminderRow, error) {
+	row := q.db.QueryRow(ctx, getAppointmentReminder, arg.ID, arg.ProfileID)
+	var i GetAppointmentReminderRow
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.StartDatetime,
+		&i.Status,
+		&i.IsDeleted,
+		&i.TelegramUserID,
+	)
+	return i, err
+}
+
+const getAppointmentTemplateData = `-- name: GetAppointmentTemplateData :one
+SELECT a.id, a.title, a.description, a.start_datetime, a.end_datetime, a.status, a.price,
+       COALESCE(a.paid, false)::bool AS paid,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE(sp.display_name, TRIM(CONCAT(sp.first_name, ' ', sp.last_name)))::text AS specialist_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM appointments a
+LEFT JOIN customers c ON c.id = a.customer_id
+LEFT JOIN specialists sp ON sp.id = a.specialist_id
+WHERE a.id = $1 AND a.profile_id = $2 AND NOT COALESCE(a.is_deleted, false)
+`
+
+type GetAppointmentTemplateDataParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetAppointmentTemplateDataRow struct {
+	ID             pgtype.UUID        `json:"id"`
+	Title          string             `json:"title"`
+	Description    pgtype.Text        `json:"description"`
+	StartDatetime  pgtype.Timestamptz `json:"start_datetime"`
+	EndDatetime    pgtype.Timestamptz `json:"end_datetime"`
+	Status         string             `json:"status"`
+	Price          pgtype.Float8      `json:"price"`
+	Paid           bool               `json:"paid"`
+	CustomerName   string             `json:"customer_name"`
+	SpecialistName string             `json:"specialist_name"`
+	TelegramUserID int64              `json:"telegram_user_id"`
+}
+
+// Поля записи для подстановки в шаблон и Telegram пользователь клиента
+func (q *Queries) GetAppointmentTemplateData(ctx context.Context, arg GetAppointmentTemplateDataParams) (GetAppointmentTemplateDataRow, error) {
+	row := q.db.QueryRow(ctx, getAppointmentTemplateData, arg.ID, arg.ProfileID)
+	var i GetAppointmentTemplateDataRow
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.Description,
+		&i.StartDatetime,
+		&i.EndDatetime,
+		&i.Status,
+		&i.Price,
+		&i.Paid,
+		&i.CustomerName,
+		&i.SpecialistName,
+		&i.TelegramUserID,
+	)
+	return i, err
+}
+
+const getCatalogCategory = `-- name: GetCatalogCategory :one
+SELECT id, parent_id, name, description
+FROM products_categories
+WHERE id = $1 AND profile_id = $2 AND NOT COALESCE(is_deleted, false)
+`
+
+type GetCatalogCategoryParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetCatalogCategoryRow struct {
+	ID          pgtype.UUID `json:"id"`
+	ParentID    pgtype.UUID `json:"parent_id"`
+	Name        string      `json:"name"`
+	Description pgtype.Text `json:"description"`
+}
+
+func (q *Queries) GetCatalogCategory(ctx context.Context, arg GetCatalogCategoryParams) (GetCatalogCategoryRow, error) {
+	row := q.db.QueryRow(ctx, getCatalogCategory, arg.ID, arg.ProfileID)
+	var i GetCatalogCategoryRow
+	err := row.Scan(
+		&i.ID,
+		&i.ParentID,
+		&i.Name,
+		&i.Description,
+	)
+	return i, err
+}
+
+const getConversation = `-- name: GetConversation :one
+SELECT id, profile_id, telegram_user_id, chat_id, context, last_message_at
+FROM telegram_conversations
+WHERE profile_id = $1 AND chat_id = $2
+LIMIT 1
+`
+
+type GetConversationParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	ChatID    int64       `json:"chat_id"`
+}
+
+func (q *Queries) GetConversation(ctx context.Context, arg GetConversationParams) (TelegramConversation, error) {
+	row := q.db.QueryRow(ctx, getConversation, arg.ProfileID, arg.ChatID)
+	var i TelegramConversation
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.TelegramUserID,
+		&i.ChatID,
+		&i.Context,
+		&i.LastMessageAt,
+	)
+	return i, err
+}
+
+const getCredential = `-- name: GetCredential :one
+SELECT id, profile_id, name, credential_type, description, key1, key2, key3,
+       metadata, is_active, created_at, updated_at
+FROM credentials
+WHERE id = $1 AND profile_id = $2 AND is_active = true
+`
+
+type GetCredentialParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+func (q *Queries) GetCredential(ctx context.Context, arg GetCredentialParams) (Credential, error) {
+	row := q.db.QueryRow(ctx, getCredential, arg.ID, arg.ProfileID)
+	var i Credential
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.Name,
+		&i.CredentialType,
+		&i.Description,
+		&i.Key1,
+		&i.Key2,
+		&i.Key3,
+		&i.Metadata,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getCustomerEventRegistration = `-- name: GetCustomerEventRegistration :one
+SELECT id, qr_code, status
+FROM event_registrations
+WHERE event_id = $1 AND customer_id = $2 AND status <> 'cancelled'
+LIMIT 1
+`
+
+type GetCustomerEventRegistrationParams struct {
+	EventID    pgtype.UUID `json:"event_id"`
+	CustomerID pgtype.UUID `json:"customer_id"`
+}
+
+type GetCustomerEventRegistrationRow struct {
+	ID     pgtype.UUID `json:"id"`
+	QrCode pgtype.Text `json:"qr_code"`
+	Status string      `json:"status"`
+}
+
+func (q *Queries) GetCustomerEventRegistration(ctx context.Context, arg GetCustomerEventRegistrationParams) (GetCustomerEventRegistrationRow, error) {
+	row := q.db.QueryRow(ctx, getCustomerEventRegistration, arg.EventID, arg.CustomerID)
+	var i GetCustomerEventRegistrationRow
+	err := row.Scan(&i.ID, &i.QrCode, &i.Status)
+	return i, err
+}
+
+const getEducationPackage = `-- name: GetEducationPackage :one
+SELECT id, name, description, lessons_count, price, validity_days
+FROM education_packages
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false)
+`
+
+type GetEducationPackageParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetEducationPackageRow struct {
+	ID           pgtype.UUID    `json:"id"`
+	Name         string         `json:"name"`
+	Description  pgtype.Text    `json:"description"`
+	LessonsCount int32          `json:"lessons_count"`
+	Price        pgtype.Numeric `json:"price"`
+	ValidityDays pgtype.Int4    `json:"validity_days"`
+}
+
+func (q *Queries) GetEducationPackage(ctx context.Context, arg GetEducationPackageParams) (GetEducationPackageRow, error) {
+	row := q.db.QueryRow(ctx, getEducationPackage, arg.ID, arg.ProfileID)
+	var i GetEducationPackageRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.LessonsCount,
+		&i.Price,
+		&i.ValidityDays,
+	)
+	return i, err
+}
+
+const getEvent = `-- name: GetEvent :one
+SELECT e.id, e.title, e.description, e.short_description, e.start_date, e.end_date,
+       e.is_online, e.online_url, e.address, e.city, e.registration_required,
+       e.registration_start, e.registration_end, e.max_participants, e.cover_image,
+       e.status, e.visibility,
+       (SELECT COUNT(*) FROM event_registrations r
+        WHERE r.event_id = e.id AND r.status <> 'cancelled') AS registered
+FROM events e
+WHERE e.id = $1 AND e.profile_id = $2
+`
+
+type GetEventParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetEventRow struct {
+	ID                   pgtype.UUID        `json:"id"`
+	Title                string             `json:"title"`
+	Description          pgtype.Text        `json:"description"`
+	ShortDescription     pgtype.Text        `json:"short_description"`
+	StartDate            pgtype.Timestamptz `json:"start_date"`
+	EndDate              pgtype.Timestamptz `json:"end_date"`
+	IsOnline             pgtype.Bool        `json:"is_online"`
+	OnlineUrl            pgtype.Text        `json:"online_url"`
+	Address              pgtype.Text        `json:"address"`
+	City                 pgtype.Text        `json:"city"`
+	RegistrationRequired pgtype.Bool        `json:"registration_required"`
+	RegistrationStart    pgtype.Timestamptz `json:"registration_start"`
+	RegistrationEnd      pgtype.Timestamptz `json:"registration_end"`
+	MaxParticipants      pgtype.Int4        `json:"max_participants"`
+	CoverImage           pgtype.Text        `json:"cover_image"`
+	Status               string             `json:"status"`
+	Visibility           string             `json:"visibility"`
+	Registered           int64              `json:"registered"`
+}
+
+func (q *Queries) GetEvent(ctx context.Context, arg GetEventParams) (GetEventRow, error) {
+	row := q.db.QueryRow(ctx, getEvent, arg.ID, arg.ProfileID)
+	var i GetEventRow
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.Description,
+		&i.ShortDescription,
+		&i.StartDate,
+		&i.EndDate,
+		&i.IsOnline,
+		&i.OnlineUrl,
+		&i.Address,
+		&i.City,
+		&i.RegistrationRequired,
+		&i.RegistrationStart,
+		&i.RegistrationEnd,
+		&i.MaxParticipants,
+		&i.CoverImage,
+		&i.Status,
+		&i.Visibility,
+		&i.Registered,
+	)
+	return i, err
+}
+
+const getEventRegistration = `-- name: GetEventRegistration :one
+SELECT r.id, r.status, r.qr_code, r.custom_fields, e.id AS event_id, e.title,
+       e.start_date, e.status AS event_status, e.is_online, e.online_url, e.address, e.city
+FROM event_registrations r
+JOIN events e ON e.id = r.event_id
+WHERE r.id = $1
+`
+
+type GetEventRegistrationRow struct {
+	ID           pgtype.UUID        `json:"id"`
+	Status       string             `json:"status"`
+	QrCode       pgtype.Text        `json:"qr_code"`
+	CustomFields []byte             `json:"custom_fields"`
+	EventID      pgtype.UUID        `json:"event_id"`
+	Title        string             `json:"title"`
+	StartDate    pgtype.Timestamptz `json:"start_date"`
+	EventStatus  string             `json:"event_status"`
+	IsOnline     pgtype.Bool        `json:"is_online"`
+	OnlineUrl    pgtype.Text        `json:"online_url"`
+	Address      pgtype.Text        `json:"address"`
+	City         pgtype.Text        `json:"city"`
+}
+
+func (q *Queries) GetEventRegistration(ctx context.Context, id pgtype.UUID) (GetEventRegistrationRow, error) {
+	row := q.db.QueryRow(ctx, getEventRegistration, id)
+	var i GetEventRegistrationRow
+	err := row.Scan(
+		&i.ID,
+		&i.Status,
+		&i.QrCode,
+		&i.CustomFields,
+		&i.EventID,
+		&i.Title,
+		&i.StartDate,
+		&i.EventStatus,
+		&i.IsOnline,
+		&i.OnlineUrl,
+		&i.Address,
+		&i.City,
+	)
+	return i, err
+}
+
+const getExecution = `-- name: GetExecution :one
+SELECT id, profile_id, workflow_id, telegram_user_id, chat_id,
+       status, input_data, output_data, error_message,
+       started_at, finished_at
+FROM telegram_executions
+WHERE id = $1
+`
+
+func (q *Queries) GetExecution(ctx context.Context, id pgtype.UUID) (TelegramExecution, error) {
+	row := q.db.QueryRow(ctx, getExecution, id)
+	var i TelegramExecution
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.WorkflowID,
+		&i.TelegramUserID,
+		&i.ChatID,
+		&i.Status,
+		&i.InputData,
+		&i.OutputData,
+		&i.ErrorMessage,
+		&i.StartedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const getHandoff = `-- name: GetHandoff :one
+SELECT id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+       account_id, created_at, closed_at, closed_by
+FROM telegram_handoffs
+WHERE id = $1
+`
+
+func (q *Queries) GetHandoff(ctx context.Context, id pgtype.UUID) (TelegramHandoff, error) {
+	row := q.db.QueryRow(ctx, getHandoff, id)
+	var i TelegramHandoff
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.ChatID,
+		&i.TelegramUserID,
+		&i.Status,
+		&i.Reason,
+		&i.AccountID,
+		&i.CreatedAt,
+		&i.ClosedAt,
+		&i.ClosedBy,
+	)
+	return i, err
+}
+
+const getHandoffByStaffMessage = `-- name: GetHandoffByStaffMessage :one
+SELECT h.id, h.profile_id, h.bot_id, h.chat_id, h.telegram_user_id, h.status, h.reason,
+       h.account_id, h.created_at, h.closed_at, h.closed_by
+FROM telegram_handoffs h
+JOIN telegram_handoff_messages m ON m.handoff_id = h.id
+WHERE h.bot_id = $1 AND m.staff_chat_id = $2 AND m.staff_message_id = $3
+LIMIT 1
+`
+
+type GetHandoffByStaffMessageParams struct {
+	BotID          pgtype.UUID `json:"bot_id"`
+	StaffChatID    int64       `json:"staff_chat_id"`
+	StaffMessageID int64       `json:"staff_message_id"`
+}
+
+func (q *Queries) GetHandoffByStaffMessage(ctx context.Context, arg GetHandoffByStaffMessageParams) (TelegramHandoff, error) {
+	row := q.db.QueryRow(ctx, getHandoffByStaffMessage, arg.BotID, arg.StaffChatID, arg.StaffMessageID)
+	var i TelegramHandoff
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.ChatID,
+		&i.TelegramUserID,
+		&i.Status,
+		&i.Reason,
+		&i.AccountID,
+		&i.CreatedAt,
+		&i.ClosedAt,
+		&i.ClosedBy,
+	)
+	return i, err
+}
+
+const getHotelBookingHold = `-- name: GetHotelBookingHold :one
+SELECT b.id, b.profile_id, b.room_id, b.status, (b.custom_fields->>'hold_until')::timestamptz AS hold_until,
+       COALESCE(r.room_number, '')::text AS room_number, b.check_in_date, b.check_out_date, b.total_price
+FROM hotel_bookings b
+LEFT JOIN hotel_rooms r ON r.id = b.room_id
+WHERE b.id = $1
+`
+
+type GetHotelBookingHoldRow struct {
+	ID           pgtype.UUID        `json:"id"`
+	ProfileID    pgtype.UUID        `json:"profile_id"`
+	RoomID       pgtype.UUID        `json:"room_id"`
+	Status       string             `json:"status"`
+	HoldUntil    pgtype.Timestamptz `json:"hold_until"`
+	RoomNumber   string             `json:"room_number"`
+	CheckInDate  pgtype.Date        `json:"check_in_date"`
+	CheckOutDate pgtype.Date        `json:"check_out_date"`
+	TotalPrice   pgtype.Numeric     `json:"total_price"`
+}
+
+func (q *Queries) GetHotelBookingHold(ctx context.Context, id pgtype.UUID) (GetHotelBookingHoldRow, error) {
+	row := q.db.QueryRow(ctx, getHotelBookingHold, id)
+	var i GetHotelBookingHoldRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.RoomID,
+		&i.Status,
+		&i.HoldUntil,
+		&i.RoomNumber,
+		&i.CheckInDate,
+		&i.CheckOutDate,
+		&i.TotalPrice,
+	)
+	return i, err
+}
+
+const getHotelBookingTemplateData = `-- name: GetHotelBookingTemplateData :one
+SELECT b.id, COALESCE(r.room_number, '')::text AS room_number, COALESCE(r.room_type, '')::text AS room_type,
+       b.check_in_date, b.check_out_date, b.status, b.total_price,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = b.owner_id AND l.profile_id = b.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM hotel_bookings b
+LEFT JOIN hotel_rooms r ON r.id = b.room_id
+LEFT JOIN customers c ON c.id = b.owner_id
+WHERE b.id = $1 AND b.profile_id = $2
+`
+
+type GetHotelBookingTemplateDataParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetHotelBookingTemplateDataRow struct {
+	ID             pgtype.UUID    `json:"id"`
+	RoomNumber     string         `json:"room_number"`
+	RoomType       string         `json:"room_type"`
+	CheckInDate    pgtype.Date    `json:"check_in_date"`
+	CheckOutDate   pgtype.Date    `json:"check_out_date"`
+	Status         string         `json:"status"`
+	TotalPrice     pgtype.Numeric `json:"total_price"`
+	CustomerName   string         `json:"customer_name"`
+	TelegramUserID int64          `json:"telegram_user_id"`
+}
+
+// Поля бронирования для подстановки в шаблон (owner_id - клиент CRM)
+func (q *Queries) GetHotelBookingTemplateData(ctx context.Context, arg GetHotelBookingTemplateDataParams) (GetHotelBookingTemplateDataRow, error) {
+	row := q.db.QueryRow(ctx, getHotelBookingTemplateData, arg.ID, arg.ProfileID)
+	var i GetHotelBookingTemplateDataRow
+	err := row.Scan(
+		&i.ID,
+		&i.RoomNumber,
+		&i.RoomType,
+		&i.CheckInDate,
+		&i.CheckOutDate,
+		&i.Status,
+		&i.TotalPrice,
+		&i.CustomerName,
+		&i.TelegramUserID,
+	)
+	return i, err
+}
+
+const getHotelRoom = `-- name: GetHotelRoom :one
+SELECT id, room_number, room_type, capacity, price_per_day
+FROM hotel_rooms
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_available, true)
+`
+
+type GetHotelRoomParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetHotelRoomRow struct {
+	ID          pgtype.UUID    `json:"id"`
+	RoomNumber  string         `json:"room_number"`
+	RoomType    string         `json:"room_type"`
+	Capacity    int32          `json:"capacity"`
+	PricePerDay pgtype.Numeric `json:"price_per_day"`
+}
+
+func (q *Queries) GetHotelRoom(ctx context.Context, arg GetHotelRoomParams) (GetHotelRoomRow, error) {
+	row := q.db.QueryRow(ctx, getHotelRoom, arg.ID, arg.ProfileID)
+	var i GetHotelRoomRow
+	err := row.Scan(
+		&i.ID,
+		&i.RoomNumber,
+		&i.RoomType,
+		&i.Capacity,
+		&i.PricePerDay,
+	)
+	return i, err
+}
+
+const getKnowledgeBase = `-- name: GetKnowledgeBase :many
+SELECT id, profile_id, source_type, source_id, title, content,
+       metadata, embedding, is_active, created_at, updated_at
+FROM telegram_knowledge_base
+WHERE profile_id = $1 AND is_active = true
+`
+
+func (q *Queries) GetKnowledgeBase(ctx context.Context, profileID pgtype.UUID) ([]TelegramKnowledgeBase, error) {
+	rows, err := q.db.Query(ctx, getKnowledgeBase, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TelegramKnowledgeBase{}
+	for rows.Next() {
+		var i TelegramKnowledgeBase
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProfileID,
+			&i.SourceType,
+			&i.SourceID,
+			&i.Title,
+			&i.Content,
+			&i.Metadata,
+			&i.Embedding,
+			&i.IsActive,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLessonEnrollmentReminder = `-- name: GetLessonEnrollmentReminder :one
+SELECT e.id, COALESCE(e.status, 'enrolled')::text AS status, e.lesson_id,
+       (l.lesson_date + l.start_time)::timestamptz AS starts_at,
+       COALESCE(l.status, 'scheduled')::text AS lesson_status,
+       COALESCE(l.is_deleted, false)::bool AS lesson_deleted,
+       s.name AS subject_name,
+       COALESCE((SELECT tl.telegram_user_id FROM telegram_customer_links tl
+                 WHERE tl.customer_id = e.student_id AND tl.profile_id = e.profile_id
+                 ORDER BY tl.linked_at DESC LIMIT 1), 0)::bigint AS telegram_user_id
+FROM education_lesson_enrollments e
+JOIN education_lessons l ON l.id = e.lesson_id
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+WHERE e.id = $1 AND e.profile_id = $2
+`
+
+type GetLessonEnrollmentReminderParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetLessonEnrollmentReminderRow struct {
+	ID             pgtype.UUID        `json:"id"`
+	Status         string             `json:"status"`
+	LessonID       pgtype.UUID        `json:"lesson_id"`
+	StartsAt       pgtype.Timestamptz `json:"starts_at"`
+	LessonStatus   string             `json:"lesson_status"`
+	LessonDeleted  bool               `json:"lesson_deleted"`
+	SubjectName    pgtype.Text        `json:"subject_name"`
+	TelegramUserID int64              `json:"telegram_user_id"`
+}
+
+func (q *Queries) GetLessonEnrollmentReminder(ctx context.Context, arg GetLessonEnrollmentReminderParams) (GetLessonEnrollmentReminderRow, error) {
+	row := q.db.QueryRow(ctx, getLessonEnrollmentReminder, arg.ID, arg.ProfileID)
+	var i GetLessonEnrollmentReminderRow
+	err := row.Scan(
+		&i.ID,
+		&i.Status,
+		&i.LessonID,
+		&i.StartsAt,
+		&i.LessonStatus,
+		&i.LessonDeleted,
+		&i.SubjectName,
+		&i.TelegramUserID,
+	)
+	return i, err
+}
+
+const getMessageTemplate = `-- name: GetMessageTemplate :one
+SELECT id, profile_id, bot_id, module_code, template_key, template_name, description,
+       message_text, parse_mode, send_immediately, send_delay_minutes, trigger_event,
+       conditions, is_active, created_at, updated_at, created_by
+FROM telegram_message_templates
+WHERE id = $1 AND profile_id = $2
+`
+
+type GetMessageTemplateParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+func (q *Queries) GetMessageTemplate(ctx context.Context, arg GetMessageTemplateParams) (TelegramMessageTemplate, error) {
+	row := q.db.QueryRow(ctx, getMessageTemplate, arg.ID, arg.ProfileID)
+	var i TelegramMessageTemplate
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.ModuleCode,
+		&i.TemplateKey,
+		&i.TemplateName,
+		&i.Description,
+		&i.MessageText,
+		&i.ParseMode,
+		&i.SendImmediately,
+		&i.SendDelayMinutes,
+		&i.TriggerEvent,
+		&i.Conditions,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CreatedBy,
+	)
+	return i, err
+}
+
+const getNewAppointments = `-- name: GetNewAppointments :many
+SELECT a.id, a.title, a.start_datetime, a.status, a.price,
+       c.name AS customer_name, a.created_at
+FROM appointments a
+LEFT JOIN customers c ON c.id = a.customer_id
+WHERE a.profile_id = $1 AND a.created_at > $2
+  AND NOT COALESCE(a.is_deleted, false)
+ORDER BY a.created_at
+LIMIT 50
+`
+
+type GetNewAppointmentsParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Since     pgtype.Timestamptz `json:"since"`
+}
+
+type GetNewAppointmentsRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	Title         string             `json:"title"`
+	StartDatetime pgtype.Timestamptz `json:"start_datetime"`
+	Status        string             `json:"status"`
+	Price         pgtype.Float8      `json:"price"`
+	CustomerName  pgtype.Text        `json:"customer_name"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetNewAppointments(ctx context.Context, arg GetNewAppointmentsParams) ([]GetNewAppointmentsRow, error) {
+	rows, err := q.db.Query(ctx, getNewAppointments, arg.ProfileID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetNewAppointmentsRow{}
+	for rows.Next() {
+		var i GetNewAppointmentsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.StartDatetime,
+			&i.Status,
+			&i.Price,
+			&i.CustomerName,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getNewPaymentOrders = `-- name: GetNewPaymentOrders :many
+SELECT id, amount, description, status, payer_email, payer_phone, created_at
+FROM payment_orders
+WHERE profile_id = $1 AND created_at > $2
+ORDER BY created_at
+LIMIT 50
+`
+
+type GetNewPaymentOrdersParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Since     pgtype.Timestamptz `json:"since"`
+}
+
+type GetNewPaymentOrdersRow struct {
+	ID          pgtype.UUID        `json:"id"`
+	Amount      pgtype.Numeric     `json:"amount"`
+	Description string             `json:"description"`
+	Status      string             `json:"status"`
+	PayerEmail  pgtype.Text        `json:"payer_email"`
+	PayerPhone  pgtype.Text        `json:"payer_phone"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetNewPaymentOrders(ctx context.Context, arg GetNewPaymentOrdersParams) ([]GetNewPaymentOrdersRow, error) {
+	rows, err := q.db.Query(ctx, getNewPaymentOrders, arg.ProfileID, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetNewPaymentOrdersRow{}
+	for rows.Next() {
+		var i GetNewPaymentOrdersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Amount,
+			&i.Description,
+			&i.Status,
+			&i.PayerEmail,
+			&i.PayerPhone,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOpenHandoff = `-- name: GetOpenHandoff :one
+SELECT id, profile_id, bot_id, chat_id, telegram_user_id, status, reason,
+       account_id, created_at, closed_at, closed_by
+FROM telegram_handoffs
+WHERE bot_id = $1 AND chat_id = $2 AND status = 'open'
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+type GetOpenHandoffParams struct {
+	BotID  pgtype.UUID `json:"bot_id"`
+	ChatID int64       `json:"chat_id"`
+}
+
+func (q *Queries) GetOpenHandoff(ctx context.Context, arg GetOpenHandoffParams) (TelegramHandoff, error) {
+	row := q.db.QueryRow(ctx, getOpenHandoff, arg.BotID, arg.ChatID)
+	var i TelegramHandoff
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.ChatID,
+		&i.TelegramUserID,
+		&i.Status,
+		&i.Reason,
+		&i.AccountID,
+		&i.CreatedAt,
+		&i.ClosedAt,
+		&i.ClosedBy,
+	)
+	return i, err
+}
+
+const getPackagePurchase = `-- name: GetPackagePurchase :one
+SELECT pp.id, pp.profile_id, pp.student_id, pp.package_id, pp.lessons_granted,
+       COALESCE(pp.payment_status, 'pending')::text AS payment_status,
+       p.name AS package_name, p.validity_days, COALESCE(p.is_active, true)::bool AS package_active
+FROM education_package_purchases pp
+JOIN education_packages p ON p.id = pp.package_id
+WHERE pp.id = $1
+`
+
+type GetPackagePurchaseRow struct {
+	ID             pgtype.UUID `json:"id"`
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	StudentID      pgtype.UUID `json:"student_id"`
+	PackageID      pgtype.UUID `json:"package_id"`
+	LessonsGranted int32       `json:"lessons_granted"`
+	PaymentStatus  string      `json:"payment_status"`
+	PackageName    string      `json:"package_name"`
+	ValidityDays   pgtype.Int4 `json:"validity_days"`
+	PackageActive  bool        `json:"package_active"`
+}
+
+func (q *Queries) GetPackagePurchase(ctx context.Context, id pgtype.UUID) (GetPackagePurchaseRow, error) {
+	row := q.db.QueryRow(ctx, getPackagePurchase, id)
+	var i GetPackagePurchaseRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.StudentID,
+		&i.PackageID,
+		&i.LessonsGranted,
+		&i.PaymentStatus,
+		&i.PackageName,
+		&i.ValidityDays,
+		&i.PackageActive,
+	)
+	return i, err
+}
+
+const getPaymentExecution = `-- name: GetPaymentExecution :one
+SELECT e.id, w.bot_id, e.chat_id
+FROM telegram_executions e
+JOIN telegram_workflows w ON w.id = e.workflow_id
+WHERE e.status = 'waiting'
+  AND e.output_data->'wait'->>'payment_order_id' = $1::text
+LIMIT 1
+`
+
+type GetPaymentExecutionRow struct {
+	ID     pgtype.UUID `json:"id"`
+	BotID  pgtype.UUID `json:"bot_id"`
+	ChatID int64       `json:"chat_id"`
+}
+
+func (q *Queries) GetPaymentExecution(ctx context.Context, paymentOrderID string) (GetPaymentExecutionRow, error) {
+	row := q.db.QueryRow(ctx, getPaymentExecution, paymentOrderID)
+	var i GetPaymentExecutionRow
+	err := row.Scan(&i.ID, &i.BotID, &i.ChatID)
+	return i, err
+}
+
+const getPaymentOrder = `-- name: GetPaymentOrder :one
+SELECT id, profile_id, customer_id, entity_type, entity_id, payment_form_id,
+       form_data, payment_gateway_id, payer_email, payer_phone, amount,
+       description, status, external_id, payment_url, external_data,
+       payment_id, expires_at, paid_at, created_at, updated_at, created_by
+FROM payment_orders
+WHERE id = $1
+`
+
+func (q *Queries) GetPaymentOrder(ctx context.Context, id pgtype.UUID) (PaymentOrder, error) {
+	row := q.db.QueryRow(ctx, getPaymentOrder, id)
+	var i PaymentOrder
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.CustomerID,
+		&i.EntityType,
+		&i.EntityID,
+		&i.PaymentFormID,
+		&i.FormData,
+		&i.PaymentGatewayID,
+		&i.PayerEmail,
+		&i.PayerPhone,
+		&i.Amount,
+		&i.Description,
+		&i.Status,
+		&i.ExternalID,
+		&i.PaymentUrl,
+		&i.ExternalData,
+		&i.PaymentID,
+		&i.ExpiresAt,
+		&i.PaidAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CreatedBy,
+	)
+	return i, err
+}
+
+const getPaymentOrderTemplateData = `-- name: GetPaymentOrderTemplateData :one
+SELECT o.id, o.description, o.amount, o.status, o.payment_url,
+       COALESCE(o.entity_type, '')::text AS entity_type,
+       COALESCE(c.display_name, c.name, '')::text AS customer_name,
+       COALESCE((SELECT l.telegram_user_id FROM telegram_customer_links l
+                 WHERE l.customer_id = o.customer_id AND l.profile_id = o.profile_id
+                 ORDER BY l.linked_at DESC LIMIT 1),
+                CASE WHEN o.form_data->>'chat_id' ~ '^-?[0-9]+$' THEN (o.form_data->>'chat_id')::bigint END,
+                0)::bigint AS telegram_user_id
+FROM payment_orders o
+LEFT JOIN customers c ON c.id = o.customer_id
+WHERE o.id = $1 AND o.profile_id = $2
+`
+
+type GetPaymentOrderTemplateDataParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetPaymentOrderTemplateDataRow struct {
+	ID             pgtype.UUID    `json:"id"`
+	Description    string         `json:"description"`
+	Amount         pgtype.Numeric `json:"amount"`
+	Status         string         `json:"status"`
+	PaymentUrl     pgtype.Text    `json:"payment_url"`
+	EntityType     string         `json:"entity_type"`
+	CustomerName   string         `json:"customer_name"`
+	TelegramUserID int64          `json:"telegram_user_id"`
+}
+
+// Поля заказа для подстановки в шаблон. Получатель - клиент заказа или чат,
+// из которого заказ оформлен в боте (form_data.chat_id)
+func (q *Queries) GetPaymentOrderTemplateData(ctx context.Context, arg GetPaymentOrderTemplateDataParams) (GetPaymentOrderTemplateDataRow, error) {
+	row := q.db.QueryRow(ctx, getPaymentOrderTemplateData, arg.ID, arg.ProfileID)
+	var i GetPaymentOrderTemplateDataRow
+	err := row.Scan(
+		&i.ID,
+		&i.Description,
+		&i.Amount,
+		&i.Status,
+		&i.PaymentUrl,
+		&i.EntityType,
+		&i.CustomerName,
+		&i.TelegramUserID,
+	)
+	return i, err
+}
+
+const getProduct = `-- name: GetProduct :one
+SELECT id, category_id, name, description, short_description, price, currency,
+       image_url, stock_quantity, track_inventory, status
+FROM products
+WHERE id = $1 AND profile_id = $2 AND NOT COALESCE(is_deleted, false)
+`
+
+type GetProductParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetProductRow struct {
+	ID               pgtype.UUID    `json:"id"`
+	CategoryID       pgtype.UUID    `json:"category_id"`
+	Name             string         `json:"name"`
+	Description      pgtype.Text    `json:"description"`
+	ShortDescription pgtype.Text    `json:"short_description"`
+	Price            pgtype.Numeric `json:"price"`
+	Currency         pgtype.Text    `json:"currency"`
+	ImageUrl         pgtype.Text    `json:"image_url"`
+	StockQuantity    pgtype.Numeric `json:"stock_quantity"`
+	TrackInventory   pgtype.Bool    `json:"track_inventory"`
+	Status           pgtype.Text    `json:"status"`
+}
+
+func (q *Queries) GetProduct(ctx context.Context, arg GetProductParams) (GetProductRow, error) {
+	row := q.db.QueryRow(ctx, getProduct, arg.ID, arg.ProfileID)
+	var i GetProductRow
+	err := row.Scan(
+		&i.ID,
+		&i.CategoryID,
+		&i.Name,
+		&i.Description,
+		&i.ShortDescription,
+		&i.Price,
+		&i.Currency,
+		&i.ImageUrl,
+		&i.StockQuantity,
+		&i.TrackInventory,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getProductStock = `-- name: GetProductStock :one
+SELECT COALESCE(SUM(quantity - COALESCE(reserved_quantity, 0)), 0)::numeric AS available,
+       COUNT(*) AS warehouses
+FROM warehouse_stock
+WHERE product_id = $1
+`
+
+type GetProductStockRow struct {
+	Available  pgtype.Numeric `json:"available"`
+	Warehouses int64          `json:"warehouses"`
+}
+
+func (q *Queries) GetProductStock(ctx context.Context, productID pgtype.UUID) (GetProductStockRow, error) {
+	row := q.db.QueryRow(ctx, getProductStock, productID)
+	var i GetProductStockRow
+	err := row.Scan(&i.Available, &i.Warehouses)
+	return i, err
+}
+
+const getProductVariant = `-- name: GetProductVariant :one
+SELECT id, product_id, name, price_modifier, stock_quantity
+FROM products_variants
+WHERE id = $1 AND NOT COALESCE(is_deleted, false)
+`
+
+type GetProductVariantRow struct {
+	ID            pgtype.UUID    `json:"id"`
+	ProductID     pgtype.UUID    `json:"product_id"`
+	Name          string         `json:"name"`
+	PriceModifier pgtype.Numeric `json:"price_modifier"`
+	StockQuantity pgtype.Numeric `json:"stock_quantity"`
+}
+
+func (q *Queries) GetProductVariant(ctx context.Context, id pgtype.UUID) (GetProductVariantRow, error) {
+	row := q.db.QueryRow(ctx, getProductVariant, id)
+	var i GetProductVariantRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProductID,
+		&i.Name,
+		&i.PriceModifier,
+		&i.StockQuantity,
+	)
+	return i, err
+}
+
+const getProfileAIUsage = `-- name: GetProfileAIUsage :one
+SELECT p.tariff,
+       COALESCE(SUM(u.prompt_tokens + u.completion_tokens), 0)::bigint AS tokens
+FROM core_profiles p
+LEFT JOIN telegram_ai_usage u ON u.profile_id = p.id AND u.day >= $1::date
+WHERE p.id = $2
+GROUP BY p.id, p.tariff
+`
+
+type GetProfileAIUsageParams struct {
+	Since     pgtype.Date `json:"since"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetProfileAIUsageRow struct {
+	Tariff pgtype.Text `json:"tariff"`
+	Tokens int64       `json:"tokens"`
+}
+
+func (q *Queries) GetProfileAIUsage(ctx context.Context, arg GetProfileAIUsageParams) (GetProfileAIUsageRow, error) {
+	row := q.db.QueryRow(ctx, getProfileAIUsage, arg.Since, arg.ProfileID)
+	var i GetProfileAIUsageRow
+	err := row.Scan(&i.Tariff, &i.Tokens)
+	return i, err
+}
+
+const getPromoCode = `-- name: GetPromoCode :one
+SELECT id, profile_id, code, description, discount_type, discount_value, max_uses,
+       used_count, max_uses_per_customer, min_order_amount, valid_from, valid_until,
+       ticket_type_ids, is_active, custom_fields, created_at, updated_at
+FROM promo_codes
+WHERE profile_id = $1 AND upper(code) = upper($2::text)
+`
+
+type GetPromoCodeParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Code      string      `json:"code"`
+}
+
+func (q *Queries) GetPromoCode(ctx context.Context, arg GetPromoCodeParams) (PromoCode, error) {
+	row := q.db.QueryRow(ctx, getPromoCode, arg.ProfileID, arg.Code)
+	var i PromoCode
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.Code,
+		&i.Description,
+		&i.DiscountType,
+		&i.DiscountValue,
+		&i.MaxUses,
+		&i.UsedCount,
+		&i.MaxUsesPerCustomer,
+		&i.MinOrderAmount,
+		&i.ValidFrom,
+		&i.ValidUntil,
+		&i.TicketTypeIds,
+		&i.IsActive,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getRecentCustomers = `-- name: GetRecentCustomers :many
+SELECT id, name, phone, email, source, created_at
+FROM customers
+WHERE profile_id = $1 AND NOT COALESCE(is_deleted, false)
+ORDER BY created_at DESC
+LIMIT $2
+`
+
+type GetRecentCustomersParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Limit     int32       `json:"limit"`
+}
+
+type GetRecentCustomersRow struct {
+	ID        pgtype.UUID        `json:"id"`
+	Name      string             `json:"name"`
+	Phone     pgtype.Text        `json:"phone"`
+	Email     pgtype.Text        `json:"email"`
+	Source    pgtype.Text        `json:"source"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetRecentCustomers(ctx context.Context, arg GetRecentCustomersParams) ([]GetRecentCustomersRow, error) {
+	rows, err := q.db.Query(ctx, getRecentCustomers, arg.ProfileID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetRecentCustomersRow{}
+	for rows.Next() {
+		var i GetRecentCustomersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Phone,
+			&i.Email,
+			&i.Source,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRolePermissions = `-- name: GetRolePermissions :many
+SELECT p.name
+FROM core_role_permissions rp
+JOIN core_permissions p ON p.id = rp.permission_id
+WHERE rp.profile_id = $1 AND rp.role_id = $2
+`
+
+type GetRolePermissionsParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	RoleID    pgtype.UUID `json:"role_id"`
+}
+
+func (q *Queries) GetRolePermissions(ctx context.Context, arg GetRolePermissionsParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, getRolePermissions, arg.ProfileID, arg.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSpaceBooking = `-- name: GetSpaceBooking :one
+SELECT bl.id, bl.profile_id, bl.room_id, bl.reason, bl.start_datetime, bl.end_datetime, bl.hold_until,
+       COALESCE(r.name, '')::text AS room_name
+FROM spaces_room_blocks bl
+LEFT JOIN spaces_rooms r ON r.id = bl.room_id
+WHERE bl.id = $1
+`
+
+type GetSpaceBookingRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	ProfileID     pgtype.UUID        `json:"profile_id"`
+	RoomID        pgtype.UUID        `json:"room_id"`
+	Reason        string             `json:"reason"`
+	StartDatetime pgtype.Timestamptz `json:"start_datetime"`
+	EndDatetime   pgtype.Timestamptz `json:"end_datetime"`
+	HoldUntil     pgtype.Timestamptz `json:"hold_until"`
+	RoomName      string             `json:"room_name"`
+}
+
+func (q *Queries) GetSpaceBooking(ctx context.Context, id pgtype.UUID) (GetSpaceBookingRow, error) {
+	row := q.db.QueryRow(ctx, getSpaceBooking, id)
+	var i GetSpaceBookingRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.RoomID,
+		&i.Reason,
+		&i.StartDatetime,
+		&i.EndDatetime,
+		&i.HoldUntil,
+		&i.RoomName,
+	)
+	return i, err
+}
+
+const getStudentBalance = `-- name: GetStudentBalance :one
+SELECT COALESCE((SELECT a.balance FROM education_balance_accounts a
+                 WHERE a.profile_id = $1 AND a.student_id = $2
+                 ORDER BY a.created_at LIMIT 1), 0)::int AS balance,
+       (SELECT COUNT(*) FROM education_lesson_enrollments e
+        JOIN education_lessons l ON l.id = e.lesson_id
+        WHERE e.profile_id = $1 AND e.student_id = $2
+          AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent')
+          AND NOT COALESCE(e.was_charged, false) AND COALESCE(l.payment_required, true)
+          AND NOT COALESCE(l.is_deleted, false)
+          AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+          AND (l.lesson_date + l.start_time)::timestamptz > NOW())::int AS reserved
+`
+
+type GetStudentBalanceParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+}
+
+type GetStudentBalanceRow struct {
+	Balance  int32 `json:"balance"`
+	Reserved int32 `json:"reserved"`
+}
+
+// Баланс ученика в занятиях и сколько из них уже зарезервировано записями
+// на предстоящие платные занятия, которые еще не списаны
+func (q *Queries) GetStudentBalance(ctx context.Context, arg GetStudentBalanceParams) (GetStudentBalanceRow, error) {
+	row := q.db.QueryRow(ctx, getStudentBalance, arg.ProfileID, arg.StudentID)
+	var i GetStudentBalanceRow
+	err := row.Scan(&i.Balance, &i.Reserved)
+	return i, err
+}
+
+const getStudentLesson = `-- name: GetStudentLesson :one
+SELECT l.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at, l.end_time,
+       s.name AS subject_name, g.name AS group_name, l.max_students,
+       COALESCE(l.payment_required, true)::bool AS payment_required,
+       (SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled'))::int AS enrolled,
+       e.id AS enrollment_id, COALESCE(e.status, '')::text AS enrollment_status
+FROM education_lessons l
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+LEFT JOIN education_groups g ON g.id = l.group_id
+LEFT JOIN education_lesson_enrollments e ON e.lesson_id = l.id AND e.student_id = $1
+WHERE l.id = $2 AND l.profile_id = $3 AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (e.id IS NOT NULL OR l.group_id IN (
+        SELECT gs.group_id FROM education_group_students gs
+        WHERE gs.profile_id = l.profile_id AND gs.student_id = $1
+          AND COALESCE(gs.status, 'active') = 'active'))
+`
+
+type GetStudentLessonParams struct {
+	StudentID pgtype.UUID `json:"student_id"`
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetStudentLessonRow struct {
+	ID               pgtype.UUID        `json:"id"`
+	StartsAt         pgtype.Timestamptz `json:"starts_at"`
+	EndTime          pgtype.Time        `json:"end_time"`
+	SubjectName      pgtype.Text        `json:"subject_name"`
+	GroupName        pgtype.Text        `json:"group_name"`
+	MaxStudents      pgtype.Int4        `json:"max_students"`
+	PaymentRequired  bool               `json:"payment_required"`
+	Enrolled         int32              `json:"enrolled"`
+	EnrollmentID     pgtype.UUID        `json:"enrollment_id"`
+	EnrollmentStatus string             `json:"enrollment_status"`
+}
+
+// Предстоящее занятие, доступное ученику (его группа или он уже записан),
+// с числом записанных и записью ученика
+func (q *Queries) GetStudentLesson(ctx context.Context, arg GetStudentLessonParams) (GetStudentLessonRow, error) {
+	row := q.db.QueryRow(ctx, getStudentLesson, arg.StudentID, arg.ID, arg.ProfileID)
+	var i GetStudentLessonRow
+	err := row.Scan(
+		&i.ID,
+		&i.StartsAt,
+		&i.EndTime,
+		&i.SubjectName,
+		&i.GroupName,
+		&i.MaxStudents,
+		&i.PaymentRequired,
+		&i.Enrolled,
+		&i.EnrollmentID,
+		&i.EnrollmentStatus,
+	)
+	return i, err
+}
+
+const getTelegramCustomerLink = `-- name: GetTelegramCustomerLink :one
+SELECT id, profile_id, customer_id, telegram_user_id, telegram_username,
+       first_name, last_name, linked_at
+FROM telegram_customer_links
+WHERE profile_id = $1 AND telegram_user_id = $2
+`
+
+type GetTelegramCustomerLinkParams struct {
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	TelegramUserID int64       `json:"telegram_user_id"`
+}
+
+func (q *Queries) GetTelegramCustomerLink(ctx context.Context, arg GetTelegramCustomerLinkParams) (TelegramCustomerLink, error) {
+	row := q.db.QueryRow(ctx, getTelegramCustomerLink, arg.ProfileID, arg.TelegramUserID)
+	var i TelegramCustomerLink
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.CustomerID,
+		&i.TelegramUserID,
+		&i.TelegramUsername,
+		&i.FirstName,
+		&i.LastName,
+		&i.LinkedAt,
+	)
+	return i, err
+}
+
+const getTelegramDailyStats = `-- name: GetTelegramDailyStats :one
+SELECT
+    (SELECT COUNT(DISTINCT chat_id) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= $2 AND NOT is_from_bot)::bigint AS chats,
+    (SELECT COUNT(*) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= $2 AND NOT is_from_bot)::bigint AS incoming,
+    (SELECT COUNT(*) FROM telegram_messages_log
+     WHERE profile_id = $1 AND created_at >= $2 AND is_from_bot)::bigint AS outgoing,
+    (SELECT COUNT(*) FROM telegram_customer_links
+     WHERE profile_id = $1 AND linked_at >= $2)::bigint AS new_customers,
+    (SELECT COUNT(*) FROM payment_orders
+     WHERE profile_id = $1 AND created_at >= $2)::bigint AS orders,
+    (SELECT COUNT(*) FROM payment_orders
+     WHERE profile_id = $1 AND paid_at >= $2 AND status = 'paid')::bigint AS paid_orders,
+    (SELECT COUNT(*) FROM appointments
+     WHERE profile_id = $1 AND created_at >= $2 AND NOT COALESCE(is_deleted, false))::bigint AS appointments
+`
+
+type GetTelegramDailyStatsParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Since     pgtype.Timestamptz `json:"since"`
+}
+
+type GetTelegramDailyStatsRow struct {
+	Chats        int64 `json:"chats"`
+	Incoming     int64 `json:"incoming"`
+	Outgoing     int64 `json:"outgoing"`
+	NewCustomers int64 `json:"new_customers"`
+	Orders       int64 `json:"orders"`
+	PaidOrders   int64 `json:"paid_orders"`
+	Appointments int64 `json:"appointments"`
+}
+
+func (q *Queries) GetTelegramDailyStats(ctx context.Context, arg GetTelegramDailyStatsParams) (GetTelegramDailyStatsRow, error) {
+	row := q.db.QueryRow(ctx, getTelegramDailyStats, arg.ProfileID, arg.Since)
+	var i GetTelegramDailyStatsRow
+	err := row.Scan(
+		&i.Chats,
+		&i.Incoming,
+		&i.Outgoing,
+		&i.NewCustomers,
+		&i.Orders,
+		&i.PaidOrders,
+		&i.Appointments,
+	)
+	return i, err
+}
+
+const getTicketOrder = `-- name: GetTicketOrder :one
+SELECT id, profile_id, customer_id, session_id, order_number, buyer_name, buyer_email,
+       buyer_phone, subtotal, discount_amount, total_amount, status, payment_method,
+       payment_id, paid_at, promo_code_id, notes, custom_fields, created_at, updated_at
+FROM ticket_orders
+WHERE id = $1
+`
+
+func (q *Queries) GetTicketOrder(ctx context.Context, id pgtype.UUID) (TicketOrder, error) {
+	row := q.db.QueryRow(ctx, getTicketOrder, id)
+	var i TicketOrder
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.CustomerID,
+		&i.SessionID,
+		&i.OrderNumber,
+		&i.BuyerName,
+		&i.BuyerEmail,
+		&i.BuyerPhone,
+		&i.Subtotal,
+		&i.DiscountAmount,
+		&i.TotalAmount,
+		&i.Status,
+		&i.PaymentMethod,
+		&i.PaymentID,
+		&i.PaidAt,
+		&i.PromoCodeID,
+		&i.Notes,
+		&i.CustomFields,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getTicketSession = `-- name: GetTicketSession :one
+SELECT ts.id, ts.title, ts.start_time, ts.end_time, ts.capacity,
+       COALESCE(ts.sold_count, 0)::int AS sold_count, ts.status,
+       v.name AS venue_name, v.address AS venue_address
+FROM ticket_sessions ts
+LEFT JOIN venues v ON v.id = ts.venue_id
+WHERE ts.id = $1 AND ts.profile_id = $2
+`
+
+type GetTicketSessionParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetTicketSessionRow struct {
+	ID           pgtype.UUID        `json:"id"`
+	Title        pgtype.Text        `json:"title"`
+	StartTime    pgtype.Timestamptz `json:"start_time"`
+	EndTime      pgtype.Timestamptz `json:"end_time"`
+	Capacity     pgtype.Int4        `json:"capacity"`
+	SoldCount    int32              `json:"sold_count"`
+	Status       string             `json:"status"`
+	VenueName    pgtype.Text        `json:"venue_name"`
+	VenueAddress pgtype.Text        `json:"venue_address"`
+}
+
+func (q *Queries) GetTicketSession(ctx context.Context, arg GetTicketSessionParams) (GetTicketSessionRow, error) {
+	row := q.db.QueryRow(ctx, getTicketSession, arg.ID, arg.ProfileID)
+	var i GetTicketSessionRow
+	err := row.Scan(
+		&i.ID,
+		&i.Title,
+		&i.StartTime,
+		&i.EndTime,
+		&i.Capacity,
+		&i.SoldCount,
+		&i.Status,
+		&i.VenueName,
+		&i.VenueAddress,
+	)
+	return i, err
+}
+
+const getTicketType = `-- name: GetTicketType :one
+SELECT id, name, description, price, max_per_order, min_per_order
+FROM ticket_types
+WHERE id = $1 AND profile_id = $2 AND COALESCE(is_active, true)
+`
+
+type GetTicketTypeParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type GetTicketTypeRow struct {
+	ID          pgtype.UUID    `json:"id"`
+	Name        string         `json:"name"`
+	Description pgtype.Text    `json:"description"`
+	Price       pgtype.Numeric `json:"price"`
+	MaxPerOrder pgtype.Int4    `json:"max_per_order"`
+	MinPerOrder pgtype.Int4    `json:"min_per_order"`
+}
+
+func (q *Queries) GetTicketType(ctx context.Context, arg GetTicketTypeParams) (GetTicketTypeRow, error) {
+	row := q.db.QueryRow(ctx, getTicketType, arg.ID, arg.ProfileID)
+	var i GetTicketTypeRow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Price,
+		&i.MaxPerOrder,
+		&i.MinPerOrder,
+	)
+	return i, err
+}
+
+const getWaitingExecution = `-- name: GetWaitingExecution :one
+SELECT e.id, e.profile_id, e.workflow_id, e.telegram_user_id, e.chat_id,
+       e.status, e.input_data, e.output_data, e.error_message,
+       e.started_at, e.finished_at
+FROM telegram_executions e
+JOIN telegram_workflows w ON w.id = e.workflow_id
+WHERE w.bot_id = $1 AND e.chat_id = $2 AND e.status = 'waiting'
+ORDER BY e.started_at DESC
+LIMIT 1
+`
+
+type GetWaitingExecutionParams struct {
+	BotID  pgtype.UUID `json:"bot_id"`
+	ChatID int64       `json:"chat_id"`
+}
+
+func (q *Queries) GetWaitingExecution(ctx context.Context, arg GetWaitingExecutionParams) (TelegramExecution, error) {
+	row := q.db.QueryRow(ctx, getWaitingExecution, arg.BotID, arg.ChatID)
+	var i TelegramExecution
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.WorkflowID,
+		&i.TelegramUserID,
+		&i.ChatID,
+		&i.Status,
+		&i.InputData,
+		&i.OutputData,
+		&i.ErrorMessage,
+		&i.StartedAt,
+		&i.FinishedAt,
+	)
+	return i, err
+}
+
+const getWorkflow = `-- name: GetWorkflow :one
+SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
+       trigger_type, trigger_config, is_active,
+       created_at, updated_at, created_by
+FROM telegram_workflows
+WHERE id = $1
+`
+
+type GetWorkflowRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	ProfileID     pgtype.UUID        `json:"profile_id"`
+	BotID         pgtype.UUID        `json:"bot_id"`
+	WorkflowName  string             `json:"workflow_name"`
+	WorkflowKey   string             `json:"workflow_key"`
+	Description   pgtype.Text        `json:"description"`
+	TriggerType   string             `json:"trigger_type"`
+	TriggerConfig []byte             `json:"trigger_config"`
+	IsActive      bool               `json:"is_active"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
+	CreatedBy     pgtype.UUID        `json:"created_by"`
+}
+
+func (q *Queries) GetWorkflow(ctx context.Context, id pgtype.UUID) (GetWorkflowRow, error) {
+	row := q.db.QueryRow(ctx, getWorkflow, id)
+	var i GetWorkflowRow
+	err := row.Scan(
+		&i.ID,
+		&i.ProfileID,
+		&i.BotID,
+		&i.WorkflowName,
+		&i.WorkflowKey,
+		&i.Description,
+		&i.TriggerType,
+		&i.TriggerConfig,
+		&i.IsActive,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CreatedBy,
+	)
+	return i, err
+}
+
+const getWorkflowEdges = `-- name: GetWorkflowEdges :many
+SELECT id, workflow_id, source_node_id, target_node_id,
+       condition_field, condition_operator, condition_value, created_at
+FROM telegram_workflow_edges
+WHERE workflow_id = $1
+`
+
+func (q *Queries) GetWorkflowEdges(ctx context.Context, workflowID pgtype.UUID) ([]TelegramWorkflowEdge, error) {
+	rows, err := q.db.Query(ctx, getWorkflowEdges, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TelegramWorkflowEdge{}
+	for rows.Next() {
+		var i TelegramWorkflowEdge
+		if err := rows.Scan(
+			&i.ID,
+			&i.WorkflowID,
+			&i.SourceNodeID,
+			&i.TargetNodeID,
+			&i.ConditionField,
+			&i.ConditionOperator,
+			&i.ConditionValue,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkflowNodes = `-- name: GetWorkflowNodes :many
+SELECT id, workflow_id, node_key, node_type, node_label,
+       position_x, position_y, config, credentials_id, created_at
+FROM telegram_workflow_nodes
+WHERE workflow_id = $1
+ORDER BY position_y, position_x
+`
+
+type GetWorkflowNodesRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	WorkflowID    pgtype.UUID        `json:"workflow_id"`
+	NodeKey       string             `json:"node_key"`
+	NodeType      string             `json:"node_type"`
+	NodeLabel     pgtype.Text        `json:"node_label"`
+	PositionX     pgtype.Int4        `json:"position_x"`
+	PositionY     pgtype.Int4        `json:"position_y"`
+	Config        []byte             `json:"config"`
+	CredentialsID pgtype.UUID        `json:"credentials_id"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+func (q *Queries) GetWorkflowNodes(ctx context.Context, workflowID pgtype.UUID) ([]GetWorkflowNodesRow, error) {
+	rows, err := q.db.Query(ctx, getWorkflowNodes, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []GetWorkflowNodesRow{}
+	for rows.Next() {
+		var i GetWorkflowNodesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.WorkflowID,
+			&i.NodeKey,
+			&i.NodeType,
+			&i.NodeLabel,
+			&i.PositionX,
+			&i.PositionY,
+			&i.Config,
+			&i.CredentialsID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const isProfileOwnerTelegram = `-- name: IsProfileOwnerTelegram :one
+SELECT EXISTS (
+    SELECT 1 FROM core_profiles
+    WHERE id = $1 AND contact_telegram = $2::text
+) AS is_owner
+`
+
+type IsProfileOwnerTelegramParams struct {
+	ID             pgtype.UUID `json:"id"`
+	TelegramUserID string      `json:"telegram_user_id"`
+}
+
+func (q *Queries) IsProfileOwnerTelegram(ctx context.Context, arg IsProfileOwnerTelegramParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isProfileOwnerTelegram, arg.ID, arg.TelegramUserID)
+	var is_owner bool
+	err := row.Scan(&is_owner)
+	return is_owner, err
+}
+
+const listAdminSubscriptions = `-- name: ListAdminSubscriptions :many
+SELECT id, profile_id, bot_id, account_id, chat_id, created_at
+FROM telegram_admin_subscriptions
+WHERE bot_id = $1
+`
+
+func (q *Queries) ListAdminSubscriptions(ctx context.Context, botID pgtype.UUID) ([]TelegramAdminSubscription, error) {
+	rows, err := q.db.Query(ctx, listAdminSubscriptions, botID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TelegramAdminSubscription{}
+	for rows.Next() {
+		var i TelegramAdminSubscription
+		if err := rows.Scan(
+			&i.ID,
+			&i.ProfileID,
+			&i.BotID,
+			&i.AccountID,
+			&i.ChatID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAppointmentReminders = `-- name: ListAppointmentReminders :many
+SELECT DISTINCT a.id, a.start_datetime
+FROM appointments a
+JOIN telegram_customer_links l ON l.customer_id = a.customer_id AND l.profile_id = a.profile_id
+WHERE a.profile_id = $1 AND NOT COALESCE(a.is_deleted, false)
+  AND a.status NOT IN ('cancelled', 'canceled', 'completed', 'no_show')
+  AND a.start_datetime > NOW() AND a.start_datetime <= $2
+`
+
+type ListAppointmentRemindersParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Until     pgtype.Timestamptz `json:"until"`
+}
+
+type ListAppointmentRemindersRow struct {
+	ID            pgtype.UUID        `json:"id"`
+	StartDatetime pgtype.Timestamptz `json:"start_datetime"`
+}
+
+// Предстоящие записи клиентов, связанных с Telegram, с началом до until
+func (q *Queries) ListAppointmentReminders(ctx context.Context, arg ListAppointmentRemindersParams) ([]ListAppointmentRemindersRow, error) {
+	rows, err := q.db.Query(ctx, listAppointmentReminders, arg.ProfileID, arg.Until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAppointmentRemindersRow{}
+	for rows.Next() {
+		var i ListAppointmentRemindersRow
+		if err := rows.Scan(&i.ID, &i.StartDatetime); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listAvailableHotelRooms = `-- name: ListAvailableHotelRooms :many
+SELECT r.id, r.room_number, r.room_type, r.capacity, r.description, r.price_per_day
+FROM hotel_rooms r
+WHERE r.profile_id = $1 AND COALESCE(r.is_available, true)
+  AND r.capacity >= $2::int
+  AND NOT EXISTS (
+      SELECT 1 FROM hotel_bookings b
+      WHERE b.room_id = r.id
+        AND b.check_in_date < $3::date AND b.check_out_date > $4::date
+        AND b.status NOT IN ('cancelled', 'canceled')
+        AND NOT (b.status = 'pending' AND (b.custom_fields->>'hold_until')::timestamptz < NOW()))
+ORDER BY r.price_per_day NULLS LAST, r.room_number
+`
+
+type ListAvailableHotelRoomsParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Guests    int32       `json:"guests"`
+	CheckOut  pgtype.Date `json:"check_out"`
+	CheckIn   pgtype.Date `json:"check_in"`
+}
+
+type ListAvailableHotelRoomsRow struct {
+	ID          pgtype.UUID    `json:"id"`
+	RoomNumber  string         `json:"room_number"`
+	RoomType    string         `json:"room_type"`
+	Capacity    int32          `json:"capacity"`
+	Description pgtype.Text    `json:"description"`
+	PricePerDay pgtype.Numeric `json:"price_per_day"`
+}
+
+// Свободные на даты номера отеля, вмещающие guests. Бронирование из бота,
+// не оплаченное до custom_fields.hold_until, номер не занимает
+func (q *Queries) ListAvailableHotelRooms(ctx context.Context, arg ListAvailableHotelRoomsParams) ([]ListAvailableHotelRoomsRow, error) {
+	rows, err := q.db.Query(ctx, listAvailableHotelRooms,
+		arg.ProfileID,
+		arg.Guests,
+		arg.CheckOut,
+		arg.CheckIn,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListAvailableHotelRoomsRow{}
+	for rows.Next() {
+		var i ListAvailableHotelRoomsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoomNumber,
+			&i.RoomType,
+			&i.Capacity,
+			&i.Description,
+			&i.PricePerDay,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBookableSpaces = `-- name: ListBookableSpaces :many
+SELECT r.id, r.name, COALESCE(l.name, '')::text AS location_name, r.capacity,
+       r.min_booking_duration, r.max_booking_duration
+FROM spaces_rooms r
+LEFT JOIN spaces_locations l ON l.id = r.location_id
+WHERE r.profile_id = $1 AND COALESCE(r.is_active, true) AND COALESCE(r.is_bookable, true)
+  AND NOT COALESCE(r.is_deleted, false)
+ORDER BY l.name NULLS LAST, r.name
+`
+
+type ListBookableSpacesRow struct {
+	ID                 pgtype.UUID `json:"id"`
+	Name               string      `json:"name"`
+	LocationName       string      `json:"location_name"`
+	Capacity           pgtype.Int4 `json:"capacity"`
+	MinBookingDuration pgtype.Int4 `json:"min_booking_duration"`
+	MaxBookingDuration pgtype.Int4 `json:"max_booking_duration"`
+}
+
+// Помещения, которые можно бронировать
+func (q *Queries) ListBookableSpaces(ctx context.Context, profileID pgtype.UUID) ([]ListBookableSpacesRow, error) {
+	rows, err := q.db.Query(ctx, listBookableSpaces, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListBookableSpacesRow{}
+	for rows.Next() {
+		var i ListBookableSpacesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.LocationName,
+			&i.Capacity,
+			&i.MinBookingDuration,
+			&i.MaxBookingDuration,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listBroadcastChats = `-- name: ListBroadcastChats :many
+SELECT DISTINCT chat_id
+FROM telegram_conversations
+WHERE profile_id = $1 AND chat_id > 0
+`
+
+func (q *Queries) ListBroadcastChats(ctx context.Context, profileID pgtype.UUID) ([]int64, error) {
+	rows, err := q.db.Query(ctx, listBroadcastChats, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []int64{}
+	for rows.Next() {
+		var chat_id int64
+		if err := rows.Scan(&chat_id); err != nil {
+			return nil, err
+		}
+		items = append(items, chat_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCatalogCategories = `-- name: ListCatalogCategories :many
+SELECT id, name, icon
+FROM products_categories
+WHERE profile_id = $1 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false)
+  AND parent_id IS NOT DISTINCT FROM $2::uuid
+ORDER BY COALESCE(sort_order, 0), name
+`
+
+type ListCatalogCategoriesParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	ParentID  pgtype.UUID `json:"parent_id"`
+}
+
+type ListCatalogCategoriesRow struct {
+	ID   pgtype.UUID `json:"id"`
+	Name string      `json:"name"`
+	Icon pgtype.Text `json:"icon"`
+}
+
+func (q *Queries) ListCatalogCategories(ctx context.Context, arg ListCatalogCategoriesParams) ([]ListCatalogCategoriesRow, error) {
+	rows, err := q.db.Query(ctx, listCatalogCategories, arg.ProfileID, arg.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListCatalogCategoriesRow{}
+	for rows.Next() {
+		var i ListCatalogCategoriesRow
+		if err := rows.Scan(&i.ID, &i.Name, &i.Icon); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCatalogProducts = `-- name: ListCatalogProducts :many
+SELECT id, name, price, currency
+FROM products
+WHERE profile_id = $1 AND status = 'active' AND NOT COALESCE(is_deleted, false)
+  AND category_id IS NOT DISTINCT FROM $4::uuid
+ORDER BY COALESCE(is_featured, false) DESC, name
+LIMIT $2 OFFSET $3
+`
+
+type ListCatalogProductsParams struct {
+	ProfileID  pgtype.UUID `json:"profile_id"`
+	Limit      int32       `json:"limit"`
+	Offset     int32       `json:"offset"`
+	CategoryID pgtype.UUID `json:"category_id"`
+}
+
+type ListCatalogProductsRow struct {
+	ID       pgtype.UUID    `json:"id"`
+	Name     string         `json:"name"`
+	Price    pgtype.Numeric `json:"price"`
+	Currency pgtype.Text    `json:"currency"`
+}
+
+func (q *Queries) ListCatalogProducts(ctx context.Context, arg ListCatalogProductsParams) ([]ListCatalogProductsRow, error) {
+	rows, err := q.db.Query(ctx, listCatalogProducts,
+		arg.ProfileID,
+		arg.Limit,
+		arg.Offset,
+		arg.CategoryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListCatalogProductsRow{}
+	for rows.Next() {
+		var i ListCatalogProductsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Price,
+			&i.Currency,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEducationPackages = `-- name: ListEducationPackages :many
+SELECT id, name, description, lessons_count, price, validity_days
+FROM education_packages
+WHERE profile_id = $1 AND COALESCE(is_active, true) AND NOT COALESCE(is_deleted, false)
+ORDER BY price, name
+`
+
+type ListEducationPackagesRow struct {
+	ID           pgtype.UUID    `json:"id"`
+	Name         string         `json:"name"`
+	Description  pgtype.Text    `json:"description"`
+	LessonsCount int32          `json:"lessons_count"`
+	Price        pgtype.Numeric `json:"price"`
+	ValidityDays pgtype.Int4    `json:"validity_days"`
+}
+
+// Абонементы, которые можно купить
+func (q *Queries) ListEducationPackages(ctx context.Context, profileID pgtype.UUID) ([]ListEducationPackagesRow, error) {
+	rows, err := q.db.Query(ctx, listEducationPackages, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListEducationPackagesRow{}
+	for rows.Next() {
+		var i ListEducationPackagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.LessonsCount,
+			&i.Price,
+			&i.ValidityDays,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEventSessions = `-- name: ListEventSessions :many
+SELECT ts.id, ts.title, ts.start_time, ts.capacity, COALESCE(ts.sold_count, 0)::int AS sold_count
+FROM ticket_sessions ts
+JOIN events e ON e.id = $1
+WHERE ts.profile_id = $2 AND ts.status NOT IN ('draft', 'cancelled', 'canceled')
+  AND ts.start_time > NOW()
+  AND (ts.custom_fields->>'event_id' = e.id::text
+       OR (ts.venue_id = e.venue_id
+           AND ts.start_time >= e.start_date
+           AND ts.start_time < COALESCE(e.end_date, e.start_date + INTERVAL '1 day')))
+ORDER BY ts.start_time
+`
+
+type ListEventSessionsParams struct {
+	EventID   pgtype.UUID `json:"event_id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+}
+
+type ListEventSessionsRow struct {
+	ID        pgtype.UUID        `json:"id"`
+	Title     pgtype.Text        `json:"title"`
+	StartTime pgtype.Timestamptz `json:"start_time"`
+	Capacity  pgtype.Int4        `json:"capacity"`
+	SoldCount int32              `json:"sold_count"`
+}
+
+// Сеансы продажи билетов на мероприятие: указанные в custom_fields.event_id
+// или на той же площадке во время мероприятия
+func (q *Queries) ListEventSessions(ctx context.Context, arg ListEventSessionsParams) ([]ListEventSessionsRow, error) {
+	rows, err := q.db.Query(ctx, listEventSessions, arg.EventID, arg.ProfileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListEventSessionsRow{}
+	for rows.Next() {
+		var i ListEventSessionsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.StartTime,
+			&i.Capacity,
+			&i.SoldCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLessonReminders = `-- name: ListLessonReminders :many
+SELECT e.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at
+FROM education_lesson_enrollments e
+JOIN education_lessons l ON l.id = e.lesson_id
+WHERE e.profile_id = $1 AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND COALESCE(e.status, 'enrolled') NOT IN ('cancelled', 'canceled')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (l.lesson_date + l.start_time)::timestamptz <= $2
+  AND EXISTS (SELECT 1 FROM telegram_customer_links tl
+              WHERE tl.customer_id = e.student_id AND tl.profile_id = e.profile_id)
 `
 
-type GetActiveWorkflowsByBotRow struct {
-	ID            pgtype.UUID        `json:"id"`
-	ProfileID     pgtype.UUID        `json:"profile_id"`
-	BotID         pgtype.UUID        `json:"bot_id"`
-	WorkflowName  string             `json:"workflow_name"`
-	WorkflowKey   string             `json:"workflow_key"`
-	Description   pgtype.Text        `json:"description"`
-	TriggerType   string             `json:"trigger_type"`
-	TriggerConfig []byte             `json:"trigger_config"`
-	IsActive      bool               `json:"is_active"`
-	CreatedAt     pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
-	CreatedBy     pgtype.UUID        `json:"created_by"`
+type ListLessonRemindersParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Until     pgtype.Timestamptz `json:"until"`
 }
 
-func (q *Queries) GetActiveWorkflowsByBot(ctx context.Context, botID pgtype.UUID) ([]GetActiveWorkflowsByBotRow, error) {
-	rows, err := q.db.Query(ctx, getActiveWorkflowsByBot, botID)
+type ListLessonRemindersRow struct {
+	ID       pgtype.UUID        `json:"id"`
+	StartsAt pgtype.Timestamptz `json:"starts_at"`
+}
+
+// Записи на предстоящие занятия учеников (student_id - клиент CRM), связанных с Telegram
+func (q *Queries) ListLessonReminders(ctx context.Context, arg ListLessonRemindersParams) ([]ListLessonRemindersRow, error) {
+	rows, err := q.db.Query(ctx, listLessonReminders, arg.ProfileID, arg.Until)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetActiveWorkflowsByBotRow{}
+	items := []ListLessonRemindersRow{}
 	for rows.Next() {
-		var i GetActiveWorkflowsByBotRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.ProfileID,
-			&i.BotID,
-			&i.WorkflowName,
-			&i.WorkflowKey,
-			&i.Description,
-			&i.TriggerType,
-			&i.TriggerConfig,
-			&i.IsActive,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.CreatedBy,
-		); err != nil {
+		var i ListLessonRemindersRow
+		if err := rows.Scan(&i.ID, &i.StartsAt); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -150,51 +3436,35 @@ func (q *Queries) GetActiveWorkflowsByBot(ctx context.Context, botID pgtype.UUID
 	return items, nil
 }
 
-const getActiveWorkflowsByProfile = `-- name: GetActiveWorkflowsByProfile :many
-SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
-       trigger_type, trigger_config, is_active,
-       created_at, updated_at, created_by
-FROM telegram_workflows
-WHERE profile_id = $1 AND is_active = true
+const listOrderTickets = `-- name: ListOrderTickets :many
+SELECT t.id, t.qr_code, t.status, tt.name AS type_name
+FROM tickets t
+LEFT JOIN ticket_types tt ON tt.id = t.ticket_type_id
+WHERE t.order_id = $1
+ORDER BY t.created_at, t.id
 `
 
-type GetActiveWorkflowsByProfileRow struct {
-	ID            pgtype.UUID        `json:"id"`
-	ProfileID     pgtype.UUID        `json:"profile_id"`
-	BotID         pgtype.UUID        `json:"bot_id"`
-	WorkflowName  string             `json:"workflow_name"`
-	WorkflowKey   string             `json:"workflow_key"`
-	Description   pgtype.Text        `json:"description"`
-	TriggerType   string             `json:"trigger_type"`
-	TriggerConfig []byte             `json:"trigger_config"`
-	IsActive      bool               `json:"is_active"`
-	CreatedAt     pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
-	CreatedBy     pgtype.UUID        `json:"created_by"`
+type ListOrderTicketsRow struct {
+	ID       pgtype.UUID `json:"id"`
+	QrCode   string      `json:"qr_code"`
+	Status   string      `json:"status"`
+	TypeName pgtype.Text `json:"type_name"`
 }
 
-func (q *Queries) GetActiveWorkflowsByProfile(ctx context.Context, profileID pgtype.UUID) ([]GetActiveWorkflowsByProfileRow, error) {
-	rows, err := q.db.Query(ctx, getActiveWorkflowsByProfile, profileID)
+func (q *Queries) ListOrderTickets(ctx context.Context, orderID pgtype.UUID) ([]ListOrderTicketsRow, error) {
+	rows, err := q.db.Query(ctx, listOrderTickets, orderID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetActiveWorkflowsByProfileRow{}
+	items := []ListOrderTicketsRow{}
 	for rows.Next() {
-		var i GetActiveWorkflowsByProfileRow
+		var i ListOrderTicketsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.ProfileID,
-			&i.BotID,
-			&i.WorkflowName,
-			&i.WorkflowKey,
-			&i.Description,
-			&i.TriggerType,
-			&i.TriggerConfig,
-			&i.IsActive,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.CreatedBy,
+			&i.QrCode,
+			&i.Status,
+			&i.TypeName,
 		); err != nil {
 			return nil, err
 		}
@@ -206,41 +3476,36 @@ func (q *Queries) GetActiveWorkflowsByProfile(ctx context.Context, profileID pgt
 	return items, nil
 }
 
-const getAllActiveBots = `-- name: GetAllActiveBots :many
-SELECT 
-    id, profile_id, bot_token, bot_username, bot_name, is_active,
-    welcome_message, ai_enabled, ai_provider, ai_model, 
-    ai_system_prompt, ai_temperature, ai_max_tokens,
-    created_at, updated_at
-FROM telegram_bots
-WHERE is_active = true
+const listProductVariants = `-- name: ListProductVariants :many
+SELECT id, name, price_modifier, stock_quantity, is_default
+FROM products_variants
+WHERE product_id = $1 AND NOT COALESCE(is_deleted, false)
+ORDER BY COALESCE(is_default, false) DESC, name
 `
 
-func (q *Queries) GetAllActiveBots(ctx context.Context) ([]TelegramBot, error) {
-	rows, err := q.db.Query(ctx, getAllActiveBots)
+type ListProductVariantsRow struct {
+	ID            pgtype.UUID    `json:"id"`
+	Name          string         `json:"name"`
+	PriceModifier pgtype.Numeric `json:"price_modifier"`
+	StockQuantity pgtype.Numeric `json:"stock_quantity"`
+	IsDefault     pgtype.Bool    `json:"is_default"`
+}
+
+func (q *Queries) ListProductVariants(ctx context.Context, productID pgtype.UUID) ([]ListProductVariantsRow, error) {
+	rows, err := q.db.Query(ctx, listProductVariants, productID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []TelegramBot{}
+	items := []ListProductVariantsRow{}
 	for rows.Next() {
-		var i TelegramBot
+		var i ListProductVariantsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.ProfileID,
-			&i.BotToken,
-			&i.BotUsername,
-			&i.BotName,
-			&i.IsActive,
-			&i.WelcomeMessage,
-			&i.AiEnabled,
-			&i.AiProvider,
-			&i.AiModel,
-			&i.AiSystemPrompt,
-			&i.AiTemperature,
-			&i.AiMaxTokens,
-			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.Name,
+			&i.PriceModifier,
+			&i.StockQuantity,
+			&i.IsDefault,
 		); err != nil {
 			return nil, err
 		}
@@ -252,60 +3517,180 @@ func (q *Queries) GetAllActiveBots(ctx context.Context) ([]TelegramBot, error) {
 	return items, nil
 }
 
-const getConversation = `-- name: GetConversation :one
-SELECT id, profile_id, telegram_user_id, chat_id, context, last_message_at
-FROM telegram_conversations
-WHERE profile_id = $1 AND chat_id = $2
-LIMIT 1
+const listProfileBotIDs = `-- name: ListProfileBotIDs :many
+SELECT id FROM telegram_bots
+WHERE profile_id = $1 AND is_active = true
+ORDER BY created_at
 `
 
-type GetConversationParams struct {
+// Активные боты профиля, первым - самый старый
+func (q *Queries) ListProfileBotIDs(ctx context.Context, profileID pgtype.UUID) ([]pgtype.UUID, error) {
+	rows, err := q.db.Query(ctx, listProfileBotIDs, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []pgtype.UUID{}
+	for rows.Next() {
+		var id pgtype.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSpaceBusy = `-- name: ListSpaceBusy :many
+SELECT bl.room_id, bl.start_datetime AS starts_at, bl.end_datetime AS ends_at
+FROM spaces_room_blocks bl
+WHERE bl.profile_id = $1
+  AND bl.start_datetime < $2 AND bl.end_datetime > $3
+  AND NOT (bl.reason = 'telegram_hold' AND bl.hold_until < NOW())
+UNION ALL
+SELECT l.room_id, (l.lesson_date + l.start_time)::timestamptz, (l.lesson_date + l.end_time)::timestamptz
+FROM education_lessons l
+WHERE l.profile_id = $1 AND l.room_id IS NOT NULL AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled')
+  AND (l.lesson_date + l.start_time)::timestamptz < $2
+  AND (l.lesson_date + l.end_time)::timestamptz > $3
+`
+
+type ListSpaceBusyParams struct {
+	ProfileID pgtype.UUID        `json:"profile_id"`
+	Until     pgtype.Timestamptz `json:"until"`
+	Since     pgtype.Timestamptz `json:"since"`
+}
+
+type ListSpaceBusyRow struct {
+	RoomID   pgtype.UUID        `json:"room_id"`
+	StartsAt pgtype.Timestamptz `json:"starts_at"`
+	EndsAt   pgtype.Timestamptz `json:"ends_at"`
+}
+
+// Занятое время помещений в интервале: блокировки (в том числе брони) и занятия
+// в этом помещении. Предварительная бронь из бота (telegram_hold) после
+// hold_until время не занимает
+func (q *Queries) ListSpaceBusy(ctx context.Context, arg ListSpaceBusyParams) ([]ListSpaceBusyRow, error) {
+	rows, err := q.db.Query(ctx, listSpaceBusy, arg.ProfileID, arg.Until, arg.Since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListSpaceBusyRow{}
+	for rows.Next() {
+		var i ListSpaceBusyRow
+		if err := rows.Scan(&i.RoomID, &i.StartsAt, &i.EndsAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSpaceSchedules = `-- name: ListSpaceSchedules :many
+SELECT room_id, start_time, end_time
+FROM spaces_room_schedules
+WHERE profile_id = $1 AND day_of_week = $2 AND COALESCE(is_active, true)
+ORDER BY room_id, start_time
+`
+
+type ListSpaceSchedulesParams struct {
 	ProfileID pgtype.UUID `json:"profile_id"`
-	ChatID    int64       `json:"chat_id"`
+	DayOfWeek int32       `json:"day_of_week"`
 }
 
-func (q *Queries) GetConversation(ctx context.Context, arg GetConversationParams) (TelegramConversation, error) {
-	row := q.db.QueryRow(ctx, getConversation, arg.ProfileID, arg.ChatID)
-	var i TelegramConversation
-	err := row.Scan(
-		&i.ID,
-		&i.ProfileID,
-		&i.TelegramUserID,
-		&i.ChatID,
-		&i.Context,
-		&i.LastMessageAt,
-	)
-	return i, err
+type ListSpaceSchedulesRow struct {
+	RoomID    pgtype.UUID `json:"room_id"`
+	StartTime pgtype.Time `json:"start_time"`
+	EndTime   pgtype.Time `json:"end_time"`
 }
 
-const getKnowledgeBase = `-- name: GetKnowledgeBase :many
-SELECT id, profile_id, source_type, source_id, title, content,
-       metadata, embedding, is_active, created_at, updated_at
-FROM telegram_knowledge_base
-WHERE profile_id = $1 AND is_active = true
+// Часы работы помещений в день недели (0 - воскресенье)
+func (q *Queries) ListSpaceSchedules(ctx context.Context, arg ListSpaceSchedulesParams) ([]ListSpaceSchedulesRow, error) {
+	rows, err := q.db.Query(ctx, listSpaceSchedules, arg.ProfileID, arg.DayOfWeek)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListSpaceSchedulesRow{}
+	for rows.Next() {
+		var i ListSpaceSchedulesRow
+		if err := rows.Scan(&i.RoomID, &i.StartTime, &i.EndTime); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStudentLessons = `-- name: ListStudentLessons :many
+SELECT l.id, (l.lesson_date + l.start_time)::timestamptz AS starts_at,
+       s.name AS subject_name, g.name AS group_name,
+       e.id AS enrollment_id, COALESCE(e.status, '')::text AS enrollment_status
+FROM education_lessons l
+LEFT JOIN education_subjects s ON s.id = l.subject_id
+LEFT JOIN education_groups g ON g.id = l.group_id
+LEFT JOIN education_lesson_enrollments e ON e.lesson_id = l.id AND e.student_id = $1
+WHERE l.profile_id = $2 AND NOT COALESCE(l.is_deleted, false)
+  AND COALESCE(l.status, 'scheduled') NOT IN ('cancelled', 'canceled', 'completed')
+  AND (l.lesson_date + l.start_time)::timestamptz > NOW()
+  AND (l.lesson_date + l.start_time)::timestamptz <= $3
+  AND (e.id IS NOT NULL OR l.group_id IN (
+        SELECT gs.group_id FROM education_group_students gs
+        WHERE gs.profile_id = l.profile_id AND gs.student_id = $1
+          AND COALESCE(gs.status, 'active') = 'active'))
+ORDER BY starts_at
+LIMIT $4
 `
 
-func (q *Queries) GetKnowledgeBase(ctx context.Context, profileID pgtype.UUID) ([]TelegramKnowledgeBase, error) {
-	rows, err := q.db.Query(ctx, getKnowledgeBase, profileID)
+type ListStudentLessonsParams struct {
+	StudentID  pgtype.UUID        `json:"student_id"`
+	ProfileID  pgtype.UUID        `json:"profile_id"`
+	Until      pgtype.Timestamptz `json:"until"`
+	LimitCount int32              `json:"limit_count"`
+}
+
+type ListStudentLessonsRow struct {
+	ID               pgtype.UUID        `json:"id"`
+	StartsAt         pgtype.Timestamptz `json:"starts_at"`
+	SubjectName      pgtype.Text        `json:"subject_name"`
+	GroupName        pgtype.Text        `json:"group_name"`
+	EnrollmentID     pgtype.UUID        `json:"enrollment_id"`
+	EnrollmentStatus string             `json:"enrollment_status"`
+}
+
+// Предстоящие занятия групп ученика и занятия, на которые он записан
+func (q *Queries) ListStudentLessons(ctx context.Context, arg ListStudentLessonsParams) ([]ListStudentLessonsRow, error) {
+	rows, err := q.db.Query(ctx, listStudentLessons,
+		arg.StudentID,
+		arg.ProfileID,
+		arg.Until,
+		arg.LimitCount,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []TelegramKnowledgeBase{}
+	items := []ListStudentLessonsRow{}
 	for rows.Next() {
-		var i TelegramKnowledgeBase
+		var i ListStudentLessonsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.ProfileID,
-			&i.SourceType,
-			&i.SourceID,
-			&i.Title,
-			&i.Content,
-			&i.Metadata,
-			&i.Embedding,
-			&i.IsActive,
-			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.StartsAt,
+			&i.SubjectName,
+			&i.GroupName,
+			&i.EnrollmentID,
+			&i.EnrollmentStatus,
 		); err != nil {
 			return nil, err
 		}
@@ -317,74 +3702,87 @@ func (q *Queries) GetKnowledgeBase(ctx context.Context, profileID pgtype.UUID) (
 	return items, nil
 }
 
-const getWorkflow = `-- name: GetWorkflow :one
-SELECT id, profile_id, bot_id, workflow_name, workflow_key, description,
-       trigger_type, trigger_config, is_active,
-       created_at, updated_at, created_by
-FROM telegram_workflows
-WHERE id = $1
+const listStudentPackages = `-- name: ListStudentPackages :many
+SELECT pp.id, p.name, pp.lessons_granted, COALESCE(pp.lessons_used, 0)::int AS lessons_used, pp.expires_at
+FROM education_package_purchases pp
+JOIN education_packages p ON p.id = pp.package_id
+WHERE pp.profile_id = $1 AND pp.student_id = $2 AND pp.status = 'active'
+  AND (pp.expires_at IS NULL OR pp.expires_at > NOW())
+ORDER BY pp.expires_at NULLS LAST
 `
 
-type GetWorkflowRow struct {
-	ID            pgtype.UUID        `json:"id"`
-	ProfileID     pgtype.UUID        `json:"profile_id"`
-	BotID         pgtype.UUID        `json:"bot_id"`
-	WorkflowName  string             `json:"workflow_name"`
-	WorkflowKey   string             `json:"workflow_key"`
-	Description   pgtype.Text        `json:"description"`
-	TriggerType   string             `json:"trigger_type"`
-	TriggerConfig []byte             `json:"trigger_config"`
-	IsActive      bool               `json:"is_active"`
-	CreatedAt     pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt     pgtype.Timestamptz `json:"updated_at"`
-	CreatedBy     pgtype.UUID        `json:"created_by"`
+type ListStudentPackagesParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
 }
 
-func (q *Queries) GetWorkflow(ctx context.Context, id pgtype.UUID) (GetWorkflowRow, error) {
-	row := q.db.QueryRow(ctx, getWorkflow, id)
-	var i GetWorkflowRow
-	err := row.Scan(
-		&i.ID,
-		&i.ProfileID,
-		&i.BotID,
-		&i.WorkflowName,
-		&i.WorkflowKey,
-		&i.Description,
-		&i.TriggerType,
-		&i.TriggerConfig,
-		&i.IsActive,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.CreatedBy,
-	)
-	return i, err
+type ListStudentPackagesRow struct {
+	ID             pgtype.UUID        `json:"id"`
+	Name           string             `json:"name"`
+	LessonsGranted int32              `json:"lessons_granted"`
+	LessonsUsed    int32              `json:"lessons_used"`
+	ExpiresAt      pgtype.Timestamptz `json:"expires_at"`
+}
+
+// Действующие абонементы ученика
+func (q *Queries) ListStudentPackages(ctx context.Context, arg ListStudentPackagesParams) ([]ListStudentPackagesRow, error) {
+	rows, err := q.db.Query(ctx, listStudentPackages, arg.ProfileID, arg.StudentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListStudentPackagesRow{}
+	for rows.Next() {
+		var i ListStudentPackagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.LessonsGranted,
+			&i.LessonsUsed,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getWorkflowEdges = `-- name: GetWorkflowEdges :many
-SELECT id, workflow_id, source_node_id, target_node_id,
-       condition_field, condition_operator, condition_value, created_at
-FROM telegram_workflow_edges
-WHERE workflow_id = $1
+const listTicketTypes = `-- name: ListTicketTypes :many
+SELECT id, name, description, price, max_per_order, min_per_order
+FROM ticket_types
+WHERE profile_id = $1 AND COALESCE(is_active, true)
+ORDER BY COALESCE(order_index, 0), price
 `
 
-func (q *Queries) GetWorkflowEdges(ctx context.Context, workflowID pgtype.UUID) ([]TelegramWorkflowEdge, error) {
-	rows, err := q.db.Query(ctx, getWorkflowEdges, workflowID)
+type ListTicketTypesRow struct {
+	ID          pgtype.UUID    `json:"id"`
+	Name        string         `json:"name"`
+	Description pgtype.Text    `json:"description"`
+	Price       pgtype.Numeric `json:"price"`
+	MaxPerOrder pgtype.Int4    `json:"max_per_order"`
+	MinPerOrder pgtype.Int4    `json:"min_per_order"`
+}
+
+func (q *Queries) ListTicketTypes(ctx context.Context, profileID pgtype.UUID) ([]ListTicketTypesRow, error) {
+	rows, err := q.db.Query(ctx, listTicketTypes, profileID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []TelegramWorkflowEdge{}
+	items := []ListTicketTypesRow{}
 	for rows.Next() {
-		var i TelegramWorkflowEdge
+		var i ListTicketTypesRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.WorkflowID,
-			&i.SourceNodeID,
-			&i.TargetNodeID,
-			&i.ConditionField,
-			&i.ConditionOperator,
-			&i.ConditionValue,
-			&i.CreatedAt,
+			&i.Name,
+			&i.Description,
+			&i.Price,
+			&i.MaxPerOrder,
+			&i.MinPerOrder,
 		); err != nil {
 			return nil, err
 		}
@@ -396,47 +3794,95 @@ func (q *Queries) GetWorkflowEdges(ctx context.Context, workflowID pgtype.UUID)
 	return items, nil
 }
 
-const getWorkflowNodes = `-- name: GetWorkflowNodes :many
-SELECT id, workflow_id, node_key, node_type, node_label,
-       position_x, position_y, config, credentials_id, created_at
-FROM telegram_workflow_nodes
-WHERE workflow_id = $1
-ORDER BY position_y, position_x
+const listTriggeredTemplates = `-- name: ListTriggeredTemplates :many
+SELECT id, profile_id, bot_id, module_code, template_key, template_name, description,
+       message_text, parse_mode, send_immediately, send_delay_minutes, trigger_event,
+       conditions, is_active, created_at, updated_at, created_by
+FROM telegram_message_templates
+WHERE profile_id = $1 AND trigger_event = $2 AND COALESCE(is_active, true)
+ORDER BY template_key
 `
 
-type GetWorkflowNodesRow struct {
-	ID            pgtype.UUID        `json:"id"`
-	WorkflowID    pgtype.UUID        `json:"workflow_id"`
-	NodeKey       string             `json:"node_key"`
-	NodeType      string             `json:"node_type"`
-	NodeLabel     pgtype.Text        `json:"node_label"`
-	PositionX     pgtype.Int4        `json:"position_x"`
-	PositionY     pgtype.Int4        `json:"position_y"`
-	Config        []byte             `json:"config"`
-	CredentialsID pgtype.UUID        `json:"credentials_id"`
-	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+type ListTriggeredTemplatesParams struct {
+	ProfileID    pgtype.UUID `json:"profile_id"`
+	TriggerEvent pgtype.Text `json:"trigger_event"`
 }
 
-func (q *Queries) GetWorkflowNodes(ctx context.Context, workflowID pgtype.UUID) ([]GetWorkflowNodesRow, error) {
-	rows, err := q.db.Query(ctx, getWorkflowNodes, workflowID)
+// Активные шаблоны профиля, срабатывающие на событие CRM
+func (q *Queries) ListTriggeredTemplates(ctx context.Context, arg ListTriggeredTemplatesParams) ([]TelegramMessageTemplate, error) {
+	rows, err := q.db.Query(ctx, listTriggeredTemplates, arg.ProfileID, arg.TriggerEvent)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []GetWorkflowNodesRow{}
+	items := []TelegramMessageTemplate{}
 	for rows.Next() {
-		var i GetWorkflowNodesRow
+		var i TelegramMessageTemplate
 		if err := rows.Scan(
 			&i.ID,
-			&i.WorkflowID,
-			&i.NodeKey,
-			&i.NodeType,
-			&i.NodeLabel,
-			&i.PositionX,
-			&i.PositionY,
-			&i.Config,
-			&i.CredentialsID,
+			&i.ProfileID,
+			&i.BotID,
+			&i.ModuleCode,
+			&i.TemplateKey,
+			&i.TemplateName,
+			&i.Description,
+			&i.MessageText,
+			&i.ParseMode,
+			&i.SendImmediately,
+			&i.SendDelayMinutes,
+			&i.TriggerEvent,
+			&i.Conditions,
+			&i.IsActive,
 			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.CreatedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUpcomingEvents = `-- name: ListUpcomingEvents :many
+SELECT id, title, start_date, city
+FROM events
+WHERE profile_id = $1 AND status = 'published' AND visibility = 'public'
+  AND start_date > NOW()
+ORDER BY start_date
+LIMIT $2 OFFSET $3
+`
+
+type ListUpcomingEventsParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	Limit     int32       `json:"limit"`
+	Offset    int32       `json:"offset"`
+}
+
+type ListUpcomingEventsRow struct {
+	ID        pgtype.UUID        `json:"id"`
+	Title     string             `json:"title"`
+	StartDate pgtype.Timestamptz `json:"start_date"`
+	City      pgtype.Text        `json:"city"`
+}
+
+func (q *Queries) ListUpcomingEvents(ctx context.Context, arg ListUpcomingEventsParams) ([]ListUpcomingEventsRow, error) {
+	rows, err := q.db.Query(ctx, listUpcomingEvents, arg.ProfileID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListUpcomingEventsRow{}
+	for rows.Next() {
+		var i ListUpcomingEventsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.StartDate,
+			&i.City,
 		); err != nil {
 			return nil, err
 		}
@@ -448,6 +3894,50 @@ func (q *Queries) GetWorkflowNodes(ctx context.Context, workflowID pgtype.UUID)
 	return items, nil
 }
 
+const lockBookingResource = `-- name: LockBookingResource :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::uuid::text, 0))
+`
+
+// Блокировка номера или помещения до конца транзакции: проверка занятости
+// и создание брони не пересекаются с параллельным бронированием
+func (q *Queries) LockBookingResource(ctx context.Context, resourceID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, lockBookingResource, resourceID)
+	return err
+}
+
+const lockEvent = `-- name: LockEvent :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::uuid::text, 0))
+`
+
+// Блокировка мероприятия до конца транзакции: подсчет регистраций и вставка
+// не пересекаются с параллельной регистрацией
+func (q *Queries) LockEvent(ctx context.Context, eventID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, lockEvent, eventID)
+	return err
+}
+
+const lockLesson = `-- name: LockLesson :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::uuid::text, 0))
+`
+
+// Блокировка занятия до конца транзакции: подсчет мест и запись не пересекаются
+// с параллельной записью на то же занятие
+func (q *Queries) LockLesson(ctx context.Context, lessonID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, lockLesson, lessonID)
+	return err
+}
+
+const lockStudentBalance = `-- name: LockStudentBalance :exec
+SELECT pg_advisory_xact_lock(hashtextextended($1::uuid::text, 0))
+`
+
+// Блокировка баланса ученика до конца транзакции: проверка свободных занятий
+// и запись не пересекаются с параллельной записью того же ученика
+func (q *Queries) LockStudentBalance(ctx context.Context, studentID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, lockStudentBalance, studentID)
+	return err
+}
+
 const logMessage = `-- name: LogMessage :exec
 INSERT INTO telegram_messages_log (
     id, profile_id, telegram_user_id, chat_id, message_text,
@@ -478,6 +3968,209 @@ func (q *Queries) LogMessage(ctx context.Context, arg LogMessageParams) error {
 	return err
 }
 
+const logOperatorMessage = `-- name: LogOperatorMessage :exec
+INSERT INTO telegram_messages_log (
+    id, profile_id, telegram_user_id, chat_id, message_text,
+    is_from_bot, metadata, account_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, true, $5, $6, NOW()
+)
+`
+
+type LogOperatorMessageParams struct {
+	ProfileID      pgtype.UUID `json:"profile_id"`
+	TelegramUserID int64       `json:"telegram_user_id"`
+	ChatID         int64       `json:"chat_id"`
+	MessageText    pgtype.Text `json:"message_text"`
+	Metadata       []byte      `json:"metadata"`
+	AccountID      pgtype.UUID `json:"account_id"`
+}
+
+func (q *Queries) LogOperatorMessage(ctx context.Context, arg LogOperatorMessageParams) error {
+	_, err := q.db.Exec(ctx, logOperatorMessage,
+		arg.ProfileID,
+		arg.TelegramUserID,
+		arg.ChatID,
+		arg.MessageText,
+		arg.Metadata,
+		arg.AccountID,
+	)
+	return err
+}
+
+const markPaymentOrderPaid = `-- name: MarkPaymentOrderPaid :execrows
+UPDATE payment_orders
+SET status = 'paid', paid_at = NOW(), updated_at = NOW(),
+    payment_id = $2, external_id = $3, external_data = $4
+WHERE id = $1 AND status IN ('pending', 'expired')
+`
+
+type MarkPaymentOrderPaidParams struct {
+	ID           pgtype.UUID `json:"id"`
+	PaymentID    pgtype.UUID `json:"payment_id"`
+	ExternalID   pgtype.Text `json:"external_id"`
+	ExternalData []byte      `json:"external_data"`
+}
+
+func (q *Queries) MarkPaymentOrderPaid(ctx context.Context, arg MarkPaymentOrderPaidParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markPaymentOrderPaid,
+		arg.ID,
+		arg.PaymentID,
+		arg.ExternalID,
+		arg.ExternalData,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markTicketOrderPaid = `-- name: MarkTicketOrderPaid :execrows
+UPDATE ticket_orders
+SET status = 'paid', paid_at = NOW(), updated_at = NOW(), payment_id = $2
+WHERE id = $1 AND status = 'pending'
+`
+
+type MarkTicketOrderPaidParams struct {
+	ID        pgtype.UUID `json:"id"`
+	PaymentID pgtype.Text `json:"payment_id"`
+}
+
+func (q *Queries) MarkTicketOrderPaid(ctx context.Context, arg MarkTicketOrderPaidParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markTicketOrderPaid, arg.ID, arg.PaymentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markTicketOrderSoldOut = `-- name: MarkTicketOrderSoldOut :execrows
+UPDATE ticket_orders
+SET status = 'cancelled', payment_id = $2, notes = $3, updated_at = NOW()
+WHERE id = $1 AND status = 'pending'
+`
+
+type MarkTicketOrderSoldOutParams struct {
+	ID        pgtype.UUID `json:"id"`
+	PaymentID pgtype.Text `json:"payment_id"`
+	Notes     pgtype.Text `json:"notes"`
+}
+
+// Отмена оплаченного заказа, на который не хватило мест: деньги нужно вернуть
+func (q *Queries) MarkTicketOrderSoldOut(ctx context.Context, arg MarkTicketOrderSoldOutParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markTicketOrderSoldOut, arg.ID, arg.PaymentID, arg.Notes)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const mergeConversationContext = `-- name: MergeConversationContext :exec
+UPDATE telegram_conversations
+SET context = COALESCE(context, '{}'::jsonb) || $2::jsonb,
+    last_message_at = NOW()
+WHERE id = $1
+`
+
+type MergeConversationContextParams struct {
+	ID      pgtype.UUID `json:"id"`
+	Updates []byte      `json:"updates"`
+}
+
+func (q *Queries) MergeConversationContext(ctx context.Context, arg MergeConversationContextParams) error {
+	_, err := q.db.Exec(ctx, mergeConversationContext, arg.ID, arg.Updates)
+	return err
+}
+
+const recordAIUsage = `-- name: RecordAIUsage :exec
+INSERT INTO telegram_ai_usage (
+    id, profile_id, bot_id, day, model, requests,
+    prompt_tokens, completion_tokens, created_at, updated_at
+) VALUES (
+    gen_random_uuid(), $1, $2, CURRENT_DATE, $3, 1, $4, $5, NOW(), NOW()
+)
+ON CONFLICT (bot_id, day, model) DO UPDATE
+SET requests = telegram_ai_usage.requests + 1,
+    prompt_tokens = telegram_ai_usage.prompt_tokens + EXCLUDED.prompt_tokens,
+    completion_tokens = telegram_ai_usage.completion_tokens + EXCLUDED.completion_tokens,
+    updated_at = NOW()
+`
+
+type RecordAIUsageParams struct {
+	ProfileID        pgtype.UUID `json:"profile_id"`
+	BotID            pgtype.UUID `json:"bot_id"`
+	Model            string      `json:"model"`
+	PromptTokens     int64       `json:"prompt_tokens"`
+	CompletionTokens int64       `json:"completion_tokens"`
+}
+
+func (q *Queries) RecordAIUsage(ctx context.Context, arg RecordAIUsageParams) error {
+	_, err := q.db.Exec(ctx, recordAIUsage,
+		arg.ProfileID,
+		arg.BotID,
+		arg.Model,
+		arg.PromptTokens,
+		arg.CompletionTokens,
+	)
+	return err
+}
+
+const releaseHotelBooking = `-- name: ReleaseHotelBooking :execrows
+UPDATE hotel_bookings
+SET status = 'cancelled', updated_at = NOW()
+WHERE id = $1 AND status = 'pending'
+`
+
+// Отмена неоплаченного предварительного бронирования
+func (q *Queries) ReleaseHotelBooking(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, releaseHotelBooking, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const releaseSpaceHold = `-- name: ReleaseSpaceHold :execrows
+DELETE FROM spaces_room_blocks
+WHERE id = $1 AND reason = 'telegram_hold'
+`
+
+// Снятие неоплаченной предварительной брони помещения
+func (q *Queries) ReleaseSpaceHold(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, releaseSpaceHold, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreLessonEnrollment = `-- name: RestoreLessonEnrollment :execrows
+UPDATE education_lesson_enrollments e
+SET status = 'enrolled', registered_at = NOW(), updated_at = NOW()
+FROM education_lessons l
+WHERE e.id = $1 AND e.profile_id = $2 AND e.student_id = $3
+  AND e.status IN ('cancelled', 'canceled') AND l.id = e.lesson_id
+  AND (l.max_students IS NULL OR (
+        SELECT COUNT(*) FROM education_lesson_enrollments x
+        WHERE x.lesson_id = l.id AND COALESCE(x.status, 'enrolled') NOT IN ('cancelled', 'canceled')) < l.max_students)
+`
+
+type RestoreLessonEnrollmentParams struct {
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+}
+
+// Повторная запись после отмены, если есть свободные места. Вызывается
+// в транзакции после LockLesson
+func (q *Queries) RestoreLessonEnrollment(ctx context.Context, arg RestoreLessonEnrollmentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreLessonEnrollment, arg.ID, arg.ProfileID, arg.StudentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const searchKnowledge = `-- name: SearchKnowledge :many
 SELECT id, profile_id, source_type, source_id, title, content,
        metadata, is_active,
@@ -536,6 +4229,97 @@ func (q *Queries) SearchKnowledge(ctx context.Context, arg SearchKnowledgeParams
 	return items, nil
 }
 
+const setCustomerAppointmentStatus = `-- name: SetCustomerAppointmentStatus :execrows
+UPDATE appointments
+SET status = $1, updated_at = NOW()
+WHERE id = $2 AND profile_id = $3 AND customer_id = $4
+  AND NOT COALESCE(is_deleted, false)
+  AND status NOT IN ('cancelled', 'canceled', 'completed', 'no_show')
+`
+
+type SetCustomerAppointmentStatusParams struct {
+	Status     string      `json:"status"`
+	ID         pgtype.UUID `json:"id"`
+	ProfileID  pgtype.UUID `json:"profile_id"`
+	CustomerID pgtype.UUID `json:"customer_id"`
+}
+
+// Статус меняется, только если запись принадлежит клиенту и еще не закрыта
+func (q *Queries) SetCustomerAppointmentStatus(ctx context.Context, arg SetCustomerAppointmentStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setCustomerAppointmentStatus,
+		arg.Status,
+		arg.ID,
+		arg.ProfileID,
+		arg.CustomerID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setPaymentOrderURL = `-- name: SetPaymentOrderURL :exec
+UPDATE payment_orders
+SET payment_url = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetPaymentOrderURLParams struct {
+	ID         pgtype.UUID `json:"id"`
+	PaymentUrl pgtype.Text `json:"payment_url"`
+}
+
+func (q *Queries) SetPaymentOrderURL(ctx context.Context, arg SetPaymentOrderURLParams) error {
+	_, err := q.db.Exec(ctx, setPaymentOrderURL, arg.ID, arg.PaymentUrl)
+	return err
+}
+
+const setStudentEnrollmentStatus = `-- name: SetStudentEnrollmentStatus :execrows
+UPDATE education_lesson_enrollments
+SET status = $1, updated_at = NOW()
+WHERE id = $2 AND profile_id = $3 AND student_id = $4
+  AND COALESCE(status, 'enrolled') NOT IN ('cancelled', 'canceled', 'attended', 'absent')
+`
+
+type SetStudentEnrollmentStatusParams struct {
+	Status    pgtype.Text `json:"status"`
+	ID        pgtype.UUID `json:"id"`
+	ProfileID pgtype.UUID `json:"profile_id"`
+	StudentID pgtype.UUID `json:"student_id"`
+}
+
+func (q *Queries) SetStudentEnrollmentStatus(ctx context.Context, arg SetStudentEnrollmentStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setStudentEnrollmentStatus,
+		arg.Status,
+		arg.ID,
+		arg.ProfileID,
+		arg.StudentID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateBotProfile = `-- name: UpdateBotProfile :exec
+UPDATE telegram_bots
+SET bot_username = $2,
+    bot_name = $3,
+    updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateBotProfileParams struct {
+	ID          pgtype.UUID `json:"id"`
+	BotUsername pgtype.Text `json:"bot_username"`
+	BotName     pgtype.Text `json:"bot_name"`
+}
+
+func (q *Queries) UpdateBotProfile(ctx context.Context, arg UpdateBotProfileParams) error {
+	_, err := q.db.Exec(ctx, updateBotProfile, arg.ID, arg.BotUsername, arg.BotName)
+	return err
+}
+
 const updateConversation = `-- name: UpdateConversation :exec
 UPDATE telegram_conversations
 SET context = $2, last_message_at = NOW()
@@ -577,3 +4361,30 @@ func (q *Queries) UpdateExecution(ctx context.Context, arg UpdateExecutionParams
 	)
 	return err
 }
+
+const upsertAdminSubscription = `-- name: UpsertAdminSubscription :exec
+INSERT INTO telegram_admin_subscriptions (
+    id, profile_id, bot_id, account_id, chat_id, created_at
+) VALUES (
+    gen_random_uuid(), $1, $2, $3, $4, NOW()
+)
+ON CONFLICT (bot_id, chat_id) DO UPDATE
+SET account_id = EXCLUDED.account_id
+`
+
+type UpsertAdminSubscriptionParams struct {
+	ProfileID pgtype.UUID `json:"profile_id"`
+	BotID     pgtype.UUID `json:"bot_id"`
+	AccountID pgtype.UUID `json:"account_id"`
+	ChatID    int64       `json:"chat_id"`
+}
+
+func (q *Queries) UpsertAdminSubscription(ctx context.Context, arg UpsertAdminSubscriptionParams) error {
+	_, err := q.db.Exec(ctx, upsertAdminSubscription,
+		arg.ProfileID,
+		arg.BotID,
+		arg.AccountID,
+		arg.ChatID,
+	)
+	return err
+}
@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Carrier встраивается в payload задачи, чтобы обработчик продолжил трейс,
+// в котором задача была поставлена
+type Carrier struct {
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// AsynqMiddleware - спан на обработку каждой задачи Asynq, дочерний
+// к трейсу из payload (если задача его содержит)
+func AsynqMiddleware(next asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, t *asynq.Task) error {
+		var carrier Carrier
+		_ = json.Unmarshal(t.Payload(), &carrier)
+
+		ctx, span := tracer.Start(Extract(ctx, carrier.TraceContext), "asynq "+t.Type(),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("asynq.task_type", t.Type())),
+		)
+
+		err := next.ProcessTask(ctx, t)
+		End(span, err)
+		return err
+	})
+}
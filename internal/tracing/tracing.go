@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultServiceName - имя сервиса в трейсах, если не задан OTEL_SERVICE_NAME
+const defaultServiceName = "sambacrm-business-tg"
+
+var tracer = otel.Tracer("github.com/botjoker/sambacrm-business-tg")
+
+// Setup настраивает экспорт трейсов по OTLP/HTTP.
+//
+// Экспорт включается, если задан OTEL_EXPORTER_OTLP_ENDPOINT или
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT; остальные стандартные переменные OTEL_*
+// (заголовки, сэмплирование, имя сервиса) читает SDK. Без них спаны не создаются.
+// Возвращает функцию, которая досылает накопленные спаны при остановке.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{}
+	if os.Getenv("OTEL_SERVICE_NAME") == "" {
+		attrs = append(attrs, attribute.String("service.name", defaultServiceName))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start начинает спан; если экспорт не настроен, спан ничего не стоит
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End завершает спан, отмечая ошибку
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID возвращает идентификатор трейса из контекста (пусто, если трейса нет)
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Inject сохраняет контекст трейса для передачи в payload задачи
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract восстанавливает контекст трейса из payload задачи
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
@@ -0,0 +1,444 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/metrics"
+	"github.com/botjoker/sambacrm-business-tg/internal/queue"
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/botjoker/sambacrm-business-tg/internal/tracing"
+	"github.com/botjoker/sambacrm-business-tg/pkg/utils"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.opentelemetry.io/otel/attribute"
+	tele "gopkg.in/telebot.v3"
+)
+
+// Статусы выполнения workflow (telegram_executions.status)
+const (
+	StatusRunning   = "running"
+	StatusWaiting   = "waiting"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+var logger = utils.Module("workflow")
+
+// maxSteps - защита от бесконечных циклов в графе
+const maxSteps = 100
+
+// Node - узел workflow
+type Node = storage.GetWorkflowNodesRow
+
+// Result - результат выполнения узла
+type Result struct {
+	// Outcome - исход узла. Пустой исход - обычный путь по ребрам без условия
+	// или с условиями на переменные. Непустой исход (например "timeout")
+	// ведет только по ребру с condition_field = "outcome" и таким же значением.
+	Outcome string
+	// Wait - узел ждет ввода пользователя, выполнение приостанавливается
+	Wait *Wait
+}
+
+// Wait - описание ожидания ввода пользователя
+type Wait struct {
+	// Kind - какой ввод ожидается ("callback", "input")
+	Kind string `json:"kind"`
+	// MessageID - сообщение с кнопками, нажатия на которые ждем
+	MessageID int `json:"message_id,omitempty"`
+	// Token - идентификатор ожидания для отложенного таймаута
+	Token string `json:"token,omitempty"`
+	// Attempts - количество некорректных ответов
+	Attempts int `json:"attempts,omitempty"`
+	// PaymentOrderID - заказ, оплату которого ждем (узел payment)
+	PaymentOrderID string `json:"payment_order_id,omitempty"`
+}
+
+// Input - ввод пользователя для продолжения ожидающего выполнения
+type Input struct {
+	Text         string
+	CallbackData string
+	// MessageID - сообщение, к которому была привязана нажатая кнопка
+	MessageID int
+	// Contact - контакт, отправленный кнопкой request_contact
+	Contact *tele.Contact
+	// TimeoutToken - заполнен, если ввод не пришел вовремя (см. Wait.Token)
+	TimeoutToken string
+	// PaymentOrderID и PaymentStatus - сменился статус заказа на оплату
+	PaymentOrderID string
+	PaymentStatus  string
+}
+
+// NodeExecutor выполняет узел определенного типа
+type NodeExecutor interface {
+	Execute(ctx context.Context, exec *Execution, node Node) (Result, error)
+}
+
+// InputHandler - узел, который умеет продолжить выполнение после ввода пользователя.
+// Возвращает false, если ввод предназначен не этому узлу.
+type InputHandler interface {
+	HandleInput(ctx context.Context, exec *Execution, node Node, input Input) (Result, bool, error)
+}
+
+// Trigger - событие, запустившее workflow
+type Trigger struct {
+	Type      string
+	ChatID    int64
+	UserID    int64
+	Variables map[string]interface{}
+}
+
+// Engine выполняет workflows одного бота
+type Engine struct {
+	queries   *storage.Queries
+	bot       *tele.Bot
+	botConfig storage.TelegramBot
+	tasks     *asynq.Client
+	executors map[string]NodeExecutor
+}
+
+func NewEngine(queries *storage.Queries, bot *tele.Bot, botConfig storage.TelegramBot, tasks *asynq.Client) *Engine {
+	e := &Engine{
+		queries:   queries,
+		bot:       bot,
+		botConfig: botConfig,
+		tasks:     tasks,
+		executors: make(map[string]NodeExecutor),
+	}
+
+	// Встроенные типы узлов
+	e.Register("trigger", passNode{})
+	e.Register("start", passNode{})
+	e.Register("condition", passNode{})
+	e.Register("send_message", &sendMessageNode{engine: e})
+	e.Register("question", &questionNode{engine: e})
+
+	return e
+}
+
+// Register регистрирует обработчик для типа узла
+func (e *Engine) Register(nodeType string, executor NodeExecutor) {
+	e.executors[nodeType] = executor
+}
+
+// Bot возвращает Telegram бота, от имени которого выполняются workflows
+func (e *Engine) Bot() *tele.Bot {
+	return e.bot
+}
+
+// Start запускает новое выполнение workflow
+func (e *Engine) Start(ctx context.Context, workflowID pgtype.UUID, trigger Trigger) error {
+	g, err := e.loadGraph(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+
+	start, ok := g.startNode()
+	if !ok {
+		return fmt.Errorf("workflow has no nodes")
+	}
+
+	// Новый сценарий в чате отменяет все ожидающие ввода
+	if err := e.queries.CancelWaitingExecutions(ctx, storage.CancelWaitingExecutionsParams{
+		BotID:  e.botConfig.ID,
+		ChatID: trigger.ChatID,
+	}); err != nil {
+		logger.ErrorContext(ctx, "Failed to cancel waiting executions", "error", err)
+	}
+
+	variables := make(map[string]interface{}, len(trigger.Variables))
+	for k, v := range trigger.Variables {
+		variables[k] = v
+	}
+
+	inputData, _ := json.Marshal(map[string]interface{}{
+		"trigger":   trigger.Type,
+		"variables": variables,
+	})
+
+	row, err := e.queries.CreateExecution(ctx, storage.CreateExecutionParams{
+		ProfileID:      e.botConfig.ProfileID,
+		WorkflowID:     workflowID,
+		TelegramUserID: trigger.UserID,
+		ChatID:         trigger.ChatID,
+		Status:         StatusRunning,
+		InputData:      inputData,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create execution: %w", err)
+	}
+
+	ctx = executionLogContext(ctx, row.ID, workflowID)
+
+	exec := &Execution{
+		ID:         row.ID,
+		WorkflowID: workflowID,
+		ProfileID:  e.botConfig.ProfileID,
+		ChatID:     trigger.ChatID,
+		UserID:     trigger.UserID,
+		Variables:  variables,
+	}
+
+	return e.run(ctx, exec, g, start)
+}
+
+// HandleInput передает ввод пользователя ожидающему выполнению в чате.
+// Возвращает false, если в чате нет выполнения, ожидающего такой ввод.
+func (e *Engine) HandleInput(ctx context.Context, chatID int64, input Input) (bool, error) {
+	row, err := e.queries.GetWaitingExecution(ctx, storage.GetWaitingExecutionParams{
+		BotID:  e.botConfig.ID,
+		ChatID: chatID,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load waiting execution: %w", err)
+	}
+
+	return e.resume(ctx, row, input)
+}
+
+// Resume продолжает конкретное ожидающее выполнение (например, из отложенной задачи)
+func (e *Engine) Resume(ctx context.Context, executionID pgtype.UUID, input Input) (bool, error) {
+	row, err := e.queries.GetExecution(ctx, executionID)
+	if err != nil {
+		return false, fmt.Errorf("failed to load execution: %w", err)
+	}
+	if row.Status != StatusWaiting {
+		return false, nil
+	}
+
+	return e.resume(ctx, row, input)
+}
+
+func (e *Engine) resume(ctx context.Context, row storage.TelegramExecution, input Input) (bool, error) {
+	exec, err := executionFromRow(row)
+	if err != nil {
+		return false, err
+	}
+	ctx = executionLogContext(ctx, exec.ID, exec.WorkflowID)
+
+	g, err := e.loadGraph(ctx, exec.WorkflowID)
+	if err != nil {
+		return false, err
+	}
+
+	node, ok := g.nodes[exec.NodeID.Bytes]
+	if !ok {
+		return false, e.fail(ctx, exec, fmt.Errorf("waiting node not found"))
+	}
+
+	handler, ok := e.executors[node.NodeType].(InputHandler)
+	if !ok {
+		return false, e.fail(ctx, exec, fmt.Errorf("node type %q does not accept input", node.NodeType))
+	}
+
+	nodeCtx, span := tracing.Start(ctx, "workflow.input "+node.NodeType, nodeAttributes(exec, node)...)
+	result, accepted, err := handler.HandleInput(nodeCtx, exec, node, input)
+	span.SetAttributes(attribute.Bool("workflow.input_accepted", accepted))
+	tracing.End(span, err)
+
+	if !accepted {
+		return false, nil
+	}
+	if err != nil {
+		return true, e.fail(ctx, exec, err)
+	}
+
+	return true, e.advance(ctx, exec, g, node, result)
+}
+
+// run выполняет узлы, начиная с node, пока граф не закончится или узел не перейдет в ожидание
+func (e *Engine) run(ctx context.Context, exec *Execution, g *graph, node Node) error {
+	for step := 0; step < maxSteps; step++ {
+		executor, ok := e.executors[node.NodeType]
+		if !ok {
+			return e.fail(ctx, exec, fmt.Errorf("unknown node type %q", node.NodeType))
+		}
+
+		exec.Steps = append(exec.Steps, node.NodeKey)
+
+		result, err := e.executeNode(ctx, executor, exec, node)
+		if err != nil {
+			return e.fail(ctx, exec, fmt.Errorf("node %s: %w", node.NodeKey, err))
+		}
+
+		if result.Wait != nil {
+			return e.wait(ctx, exec, node, result.Wait)
+		}
+
+		next, ok := g.next(node, result.Outcome, exec.Variables)
+		if !ok {
+			return e.complete(ctx, exec)
+		}
+		node = next
+	}
+
+	return e.fail(ctx, exec, fmt.Errorf("workflow exceeded %d steps", maxSteps))
+}
+
+// executeNode выполняет узел со спаном и метриками
+func (e *Engine) executeNode(ctx context.Context, executor NodeExecutor, exec *Execution, node Node) (Result, error) {
+	ctx, span := tracing.Start(ctx, "workflow.node "+node.NodeType, nodeAttributes(exec, node)...)
+
+	started := time.Now()
+	result, err := executor.Execute(ctx, exec, node)
+	metrics.WorkflowNodeDuration.WithLabelValues(node.NodeType).Observe(time.Since(started).Seconds())
+	metrics.WorkflowNodes.WithLabelValues(node.NodeType, metrics.Status(err)).Inc()
+
+	if result.Outcome != "" {
+		span.SetAttributes(attribute.String("workflow.outcome", result.Outcome))
+	}
+	span.SetAttributes(attribute.Bool("workflow.wait", result.Wait != nil))
+	tracing.End(span, err)
+
+	return result, err
+}
+
+func nodeAttributes(exec *Execution, node Node) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("workflow.execution_id", uuidString(exec.ID)),
+		attribute.String("workflow.id", uuidString(exec.WorkflowID)),
+		attribute.String("workflow.node_key", node.NodeKey),
+		attribute.String("workflow.node_type", node.NodeType),
+	}
+}
+
+// advance обрабатывает результат ожидавшего узла и продолжает выполнение
+func (e *Engine) advance(ctx context.Context, exec *Execution, g *graph, node Node, result Result) error {
+	if result.Wait != nil {
+		return e.wait(ctx, exec, node, result.Wait)
+	}
+
+	exec.NodeID = pgtype.UUID{}
+	exec.Wait = nil
+
+	next, ok := g.next(node, result.Outcome, exec.Variables)
+	if !ok {
+		return e.complete(ctx, exec)
+	}
+
+	return e.run(ctx, exec, g, next)
+}
+
+func (e *Engine) wait(ctx context.Context, exec *Execution, node Node, wait *Wait) error {
+	exec.NodeID = node.ID
+	exec.Wait = wait
+	return e.save(ctx, exec, StatusWaiting, "")
+}
+
+func (e *Engine) complete(ctx context.Context, exec *Execution) error {
+	return e.save(ctx, exec, StatusCompleted, "")
+}
+
+func (e *Engine) fail(ctx context.Context, exec *Execution, cause error) error {
+	logger.ErrorContext(ctx, "❌ Workflow execution failed", "error", cause)
+	if err := e.save(ctx, exec, StatusFailed, cause.Error()); err != nil {
+		logger.ErrorContext(ctx, "Failed to save execution", "error", err)
+	}
+	return cause
+}
+
+func (e *Engine) save(ctx context.Context, exec *Execution, status, errorMessage string) error {
+	outputData, err := json.Marshal(exec.state())
+	if err != nil {
+		return fmt.Errorf("failed to marshal execution state: %w", err)
+	}
+
+	var errText pgtype.Text
+	if errorMessage != "" {
+		errText = pgtype.Text{String: errorMessage, Valid: true}
+	}
+
+	metrics.WorkflowExecutions.WithLabelValues(uuidString(e.botConfig.ID), uuidString(e.botConfig.ProfileID), status).Inc()
+
+	return e.queries.UpdateExecution(ctx, storage.UpdateExecutionParams{
+		ID:           exec.ID,
+		Status:       status,
+		OutputData:   outputData,
+		ErrorMessage: errText,
+	})
+}
+
+// ScheduleTimeout ставит отложенную задачу на таймаут ожидания: через timeout
+// узел получит Input с TimeoutToken = token
+func (e *Engine) ScheduleTimeout(ctx context.Context, exec *Execution, token string, timeout time.Duration) error {
+	if e.tasks == nil {
+		return fmt.Errorf("task queue is not configured")
+	}
+
+	task, err := queue.NewWorkflowTimeoutTask(queue.WorkflowTimeoutPayload{
+		BotID:       uuid.UUID(e.botConfig.ID.Bytes),
+		ExecutionID: uuid.UUID(exec.ID.Bytes),
+		ChatID:      exec.ChatID,
+		Token:       token,
+		Carrier:     tracing.Carrier{TraceContext: tracing.Inject(ctx)},
+	}, timeout)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.tasks.EnqueueContext(ctx, task)
+	return err
+}
+
+// logOutgoing записывает отправленное workflow сообщение в лог
+func (e *Engine) logOutgoing(ctx context.Context, exec *Execution, text string, extra map[string]interface{}) {
+	meta := map[string]interface{}{
+		"workflow_id":  uuidString(exec.WorkflowID),
+		"execution_id": uuidString(exec.ID),
+	}
+	for k, v := range extra {
+		meta[k] = v
+	}
+	metadata, _ := json.Marshal(meta)
+
+	e.queries.LogMessage(ctx, storage.LogMessageParams{
+		ProfileID:      exec.ProfileID,
+		TelegramUserID: exec.UserID,
+		ChatID:         exec.ChatID,
+		MessageText:    pgtype.Text{String: text, Valid: true},
+		IsFromBot:      true,
+		Metadata:       metadata,
+	})
+}
+
+func (e *Engine) loadGraph(ctx context.Context, workflowID pgtype.UUID) (*graph, error) {
+	nodes, err := e.queries.GetWorkflowNodes(ctx, workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load nodes: %w", err)
+	}
+
+	edges, err := e.queries.GetWorkflowEdges(ctx, workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load edges: %w", err)
+	}
+
+	return newGraph(nodes, edges), nil
+}
+
+// passNode - узел без действия (триггер, точка ветвления по условиям ребер)
+type passNode struct{}
+
+func (passNode) Execute(ctx context.Context, exec *Execution, node Node) (Result, error) {
+	return Result{}, nil
+}
+
+// executionLogContext добавляет к логам идентификаторы выполнения
+func executionLogContext(ctx context.Context, executionID, workflowID pgtype.UUID) context.Context {
+	return utils.WithLogAttrs(ctx, "execution_id", uuidString(executionID), "workflow_id", uuidString(workflowID))
+}
+
+func uuidString(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return uuid.UUID(id.Bytes).String()
+}
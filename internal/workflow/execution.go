@@ -0,0 +1,96 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Execution - состояние одного выполнения workflow
+type Execution struct {
+	ID         pgtype.UUID
+	WorkflowID pgtype.UUID
+	ProfileID  pgtype.UUID
+	ChatID     int64
+	UserID     int64
+	// Variables - переменные, которые передаются между узлами
+	Variables map[string]interface{}
+	// Steps - ключи пройденных узлов
+	Steps []string
+	// NodeID и Wait заполнены, пока выполнение ждет ввода пользователя
+	NodeID pgtype.UUID
+	Wait   *Wait
+}
+
+// executionState - то, что сохраняется в telegram_executions.output_data
+type executionState struct {
+	Variables map[string]interface{} `json:"variables"`
+	Steps     []string               `json:"steps"`
+	NodeID    pgtype.UUID            `json:"waiting_node_id"`
+	Wait      *Wait                  `json:"wait,omitempty"`
+}
+
+func (e *Execution) state() executionState {
+	return executionState{
+		Variables: e.Variables,
+		Steps:     e.Steps,
+		NodeID:    e.NodeID,
+		Wait:      e.Wait,
+	}
+}
+
+// executionFromRow восстанавливает выполнение из БД
+func executionFromRow(row storage.TelegramExecution) (*Execution, error) {
+	var state executionState
+	if len(row.OutputData) > 0 {
+		if err := json.Unmarshal(row.OutputData, &state); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal execution state: %w", err)
+		}
+	}
+	if state.Variables == nil {
+		state.Variables = make(map[string]interface{})
+	}
+
+	return &Execution{
+		ID:         row.ID,
+		WorkflowID: row.WorkflowID,
+		ProfileID:  row.ProfileID,
+		ChatID:     row.ChatID,
+		UserID:     row.TelegramUserID,
+		Variables:  state.Variables,
+		Steps:      state.Steps,
+		NodeID:     state.NodeID,
+		Wait:       state.Wait,
+	}, nil
+}
+
+// Set сохраняет переменную
+func (e *Execution) Set(name string, value interface{}) {
+	e.Variables[name] = value
+}
+
+// Lookup возвращает переменную по имени; поддерживает вложенные поля через точку ("user.first_name")
+func (e *Execution) Lookup(name string) (interface{}, bool) {
+	return lookup(e.Variables, name)
+}
+
+func lookup(vars map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := vars[name]; ok {
+		return v, true
+	}
+
+	var current interface{} = vars
+	for _, part := range strings.Split(name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
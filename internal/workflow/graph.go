@@ -0,0 +1,144 @@
+package workflow
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/botjoker/sambacrm-business-tg/internal/storage"
+)
+
+// outcomeField - специальное поле условия ребра: сравнивается с исходом узла, а не с переменной
+const outcomeField = "outcome"
+
+// graph - узлы и связи workflow
+type graph struct {
+	nodes    map[[16]byte]Node
+	order    []Node
+	outgoing map[[16]byte][]storage.TelegramWorkflowEdge
+	incoming map[[16]byte]int
+}
+
+func newGraph(nodes []storage.GetWorkflowNodesRow, edges []storage.TelegramWorkflowEdge) *graph {
+	g := &graph{
+		nodes:    make(map[[16]byte]Node, len(nodes)),
+		order:    nodes,
+		outgoing: make(map[[16]byte][]storage.TelegramWorkflowEdge),
+		incoming: make(map[[16]byte]int),
+	}
+
+	for _, n := range nodes {
+		g.nodes[n.ID.Bytes] = n
+	}
+	for _, edge := range edges {
+		g.outgoing[edge.SourceNodeID.Bytes] = append(g.outgoing[edge.SourceNodeID.Bytes], edge)
+		g.incoming[edge.TargetNodeID.Bytes]++
+	}
+
+	return g
+}
+
+// startNode возвращает узел-триггер, либо первый узел без входящих связей
+func (g *graph) startNode() (Node, bool) {
+	for _, n := range g.order {
+		if n.NodeType == "trigger" || n.NodeType == "start" {
+			return n, true
+		}
+	}
+	for _, n := range g.order {
+		if g.incoming[n.ID.Bytes] == 0 {
+			return n, true
+		}
+	}
+	if len(g.order) > 0 {
+		return g.order[0], true
+	}
+	return Node{}, false
+}
+
+// next выбирает следующий узел.
+//
+// Непустой исход ведет только по ребру с condition_field = "outcome".
+// При пустом исходе выбирается первое ребро, чье условие на переменные выполнено,
+// а если таких нет - ребро без условия.
+func (g *graph) next(node Node, outcome string, vars map[string]interface{}) (Node, bool) {
+	var fallback *storage.TelegramWorkflowEdge
+
+	for i, edge := range g.outgoing[node.ID.Bytes] {
+		field := edge.ConditionField.String
+		switch {
+		case field == outcomeField:
+			if outcome != "" && edge.ConditionValue.String == outcome {
+				return g.target(edge)
+			}
+		case outcome != "":
+			continue
+		case !edge.ConditionField.Valid || field == "":
+			if fallback == nil {
+				fallback = &g.outgoing[node.ID.Bytes][i]
+			}
+		default:
+			if MatchCondition(vars, field, edge.ConditionOperator.String, edge.ConditionValue.String) {
+				return g.target(edge)
+			}
+		}
+	}
+
+	if fallback != nil {
+		return g.target(*fallback)
+	}
+	return Node{}, false
+}
+
+func (g *graph) target(edge storage.TelegramWorkflowEdge) (Node, bool) {
+	n, ok := g.nodes[edge.TargetNodeID.Bytes]
+	return n, ok
+}
+
+// MatchCondition проверяет условие на переменную (ребра workflow, шаблоны сообщений)
+func MatchCondition(vars map[string]interface{}, field, operator, expected string) bool {
+	value, exists := lookup(vars, field)
+	actual := ""
+	if exists && value != nil {
+		actual = fmt.Sprint(value)
+	}
+
+	switch operator {
+	case "", "equals", "eq", "==":
+		return exists && actual == expected
+	case "not_equals", "neq", "!=":
+		return actual != expected
+	case "contains":
+		return strings.Contains(strings.ToLower(actual), strings.ToLower(expected))
+	case "not_contains":
+		return !strings.Contains(strings.ToLower(actual), strings.ToLower(expected))
+	case "starts_with":
+		return strings.HasPrefix(actual, expected)
+	case "exists", "is_set":
+		return exists && actual != ""
+	case "not_exists", "is_empty":
+		return !exists || actual == ""
+	case "regex":
+		re, err := regexp.Compile(expected)
+		return err == nil && re.MatchString(actual)
+	case "gt", "gte", "lt", "lte", ">", ">=", "<", "<=":
+		a, errA := strconv.ParseFloat(actual, 64)
+		b, errB := strconv.ParseFloat(expected, 64)
+		if errA != nil || errB != nil {
+			return false
+		}
+		switch operator {
+		case "gt", ">":
+			return a > b
+		case "gte", ">=":
+			return a >= b
+		case "lt", "<":
+			return a < b
+		default:
+			return a <= b
+		}
+	}
+
+	return false
+}
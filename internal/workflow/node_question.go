@@ -0,0 +1,156 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Исходы узла question
+const (
+	OutcomeTimeout = "timeout"
+	OutcomeInvalid = "invalid"
+)
+
+// QuestionConfig - конфигурация узла question: задать вопрос и дождаться ответа.
+//
+// Пример:
+//
+//	{
+//	  "text": "Оставьте email, и мы пришлем предложение",
+//	  "variable": "email",
+//	  "validation": {"type": "email"},
+//	  "error_text": "Похоже, это не email. Попробуйте еще раз",
+//	  "timeout_minutes": 60,
+//	  "max_attempts": 3
+//	}
+//
+// Ответ принимается текстом, нажатием inline кнопки или контактом (для phone).
+// Если ответа нет дольше timeout_minutes - выполнение идет по ребру с исходом "timeout",
+// после max_attempts неверных ответов - по ребру с исходом "invalid".
+type QuestionConfig struct {
+	SendMessageConfig
+
+	Validation     ValidationConfig `json:"validation"`
+	ErrorText      string           `json:"error_text"`
+	TimeoutMinutes int              `json:"timeout_minutes"`
+	TimeoutText    string           `json:"timeout_text"`
+	MaxAttempts    int              `json:"max_attempts"`
+}
+
+const defaultQuestionErrorText = "Не удалось распознать ответ. Попробуйте еще раз."
+
+type questionNode struct {
+	engine *Engine
+}
+
+func (n *questionNode) Execute(ctx context.Context, exec *Execution, node Node) (Result, error) {
+	cfg, err := parseQuestionConfig(node)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Для выбора из списка без своей клавиатуры показываем варианты кнопками
+	if cfg.Validation.Type == "choice" && len(cfg.InlineKeyboard) == 0 && len(cfg.ReplyKeyboard) == 0 {
+		for _, choice := range cfg.Validation.Choices {
+			cfg.InlineKeyboard = append(cfg.InlineKeyboard, []ButtonConfig{{Text: choice, Data: choice}})
+		}
+	}
+	// Для телефона предлагаем отправить контакт
+	if cfg.Validation.Type == "phone" && len(cfg.ReplyKeyboard) == 0 && len(cfg.InlineKeyboard) == 0 {
+		cfg.ReplyKeyboard = [][]ButtonConfig{{{Text: "📱 Отправить номер", RequestContact: true}}}
+		cfg.ResizeKeyboard = true
+		cfg.OneTimeKeyboard = true
+	}
+
+	msg, err := n.engine.SendMessage(ctx, exec, cfg.SendMessageConfig)
+	if err != nil {
+		return Result{}, err
+	}
+
+	wait := &Wait{Kind: "input", Token: uuid.NewString()}
+	if msg != nil {
+		wait.MessageID = msg.ID
+	}
+
+	if cfg.TimeoutMinutes > 0 {
+		timeout := time.Duration(cfg.TimeoutMinutes) * time.Minute
+		if err := n.engine.ScheduleTimeout(ctx, exec, wait.Token, timeout); err != nil {
+			logger.WarnContext(ctx, "⚠️ Не удалось запланировать таймаут вопроса", "node", node.NodeKey, "error", err)
+		}
+	}
+
+	return Result{Wait: wait}, nil
+}
+
+func (n *questionNode) HandleInput(ctx context.Context, exec *Execution, node Node, input Input) (Result, bool, error) {
+	if exec.Wait == nil {
+		return Result{}, false, nil
+	}
+
+	cfg, err := parseQuestionConfig(node)
+	if err != nil {
+		return Result{}, true, err
+	}
+
+	// Таймаут: принимаем только для текущего ожидания
+	if input.TimeoutToken != "" {
+		if input.TimeoutToken != exec.Wait.Token {
+			return Result{}, false, nil
+		}
+		if cfg.TimeoutText != "" {
+			n.engine.sendText(ctx, exec, cfg.TimeoutText, cfg.ParseMode)
+		}
+		return Result{Outcome: OutcomeTimeout}, true, nil
+	}
+
+	var answer string
+	switch {
+	case input.Contact != nil:
+		answer = input.Contact.PhoneNumber
+	case input.CallbackData != "":
+		// Кнопки со старых сообщений не являются ответом на текущий вопрос
+		if exec.Wait.MessageID != 0 && input.MessageID != exec.Wait.MessageID {
+			return Result{}, false, nil
+		}
+		answer = input.CallbackData
+	default:
+		answer = input.Text
+	}
+
+	value, err := cfg.Validation.Validate(answer)
+	if err != nil {
+		exec.Wait.Attempts++
+		if cfg.MaxAttempts > 0 && exec.Wait.Attempts >= cfg.MaxAttempts {
+			return Result{Outcome: OutcomeInvalid}, true, nil
+		}
+
+		errorText := cfg.ErrorText
+		if errorText == "" {
+			errorText = defaultQuestionErrorText
+		}
+		n.engine.sendText(ctx, exec, errorText, cfg.ParseMode)
+
+		// Продолжаем ждать ответ на тот же вопрос
+		return Result{Wait: exec.Wait}, true, nil
+	}
+
+	variable := cfg.Variable
+	if variable == "" {
+		variable = node.NodeKey
+	}
+	exec.Set(variable, value)
+
+	return Result{}, true, nil
+}
+
+func parseQuestionConfig(node Node) (QuestionConfig, error) {
+	var cfg QuestionConfig
+	if err := json.Unmarshal(node.Config, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid question config: %w", err)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,288 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// maxCallbackDataLen - ограничение Bot API на callback_data
+const maxCallbackDataLen = 64
+
+// Ограничения Bot API на число элементов в sendMediaGroup
+const (
+	minAlbumSize = 2
+	maxAlbumSize = 10
+)
+
+// defaultCallbackVariable - переменная, в которую сохраняется нажатая кнопка
+const defaultCallbackVariable = "callback_data"
+
+// SendMessageConfig - конфигурация узла send_message.
+//
+// Пример:
+//
+//	{
+//	  "text": "Здравствуйте, {{first_name}}! Ваш заказ *{{order_id}}*",
+//	  "parse_mode": "MarkdownV2",
+//	  "photo": "https://example.com/promo.jpg",
+//	  "inline_keyboard": [[{"text": "Да", "data": "yes"}, {"text": "Сайт", "url": "https://example.com"}]],
+//	  "variable": "answer"
+//	}
+type SendMessageConfig struct {
+	// Text - текст сообщения (или подпись к медиа), поддерживает {{переменные}}
+	Text string `json:"text"`
+	// ParseMode - "MarkdownV2", "HTML" или пусто для обычного текста
+	ParseMode string `json:"parse_mode"`
+	// Photo / Document - URL или file_id
+	Photo    string `json:"photo"`
+	Document string `json:"document"`
+	// Album - несколько фото/документов одним сообщением
+	Album []MediaConfig `json:"album"`
+
+	InlineKeyboard  [][]ButtonConfig `json:"inline_keyboard"`
+	ReplyKeyboard   [][]ButtonConfig `json:"reply_keyboard"`
+	ResizeKeyboard  bool             `json:"resize_keyboard"`
+	OneTimeKeyboard bool             `json:"one_time_keyboard"`
+	RemoveKeyboard  bool             `json:"remove_keyboard"`
+	DisablePreview  bool             `json:"disable_preview"`
+
+	// WaitForCallback - ждать нажатия inline кнопки перед переходом дальше.
+	// По умолчанию ждем, если в клавиатуре есть кнопки с data.
+	WaitForCallback *bool `json:"wait_for_callback"`
+	// Variable - переменная для данных нажатой кнопки (по умолчанию "callback_data")
+	Variable string `json:"variable"`
+}
+
+// MediaConfig - элемент альбома
+type MediaConfig struct {
+	// Type - "photo" или "document"
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
+}
+
+// ButtonConfig - кнопка клавиатуры
+type ButtonConfig struct {
+	Text string `json:"text"`
+	// Data - callback_data для inline кнопки
+	Data string `json:"data"`
+	// URL - ссылка для inline кнопки
+	URL string `json:"url"`
+	// RequestContact / RequestLocation - для reply кнопок
+	RequestContact  bool `json:"request_contact"`
+	RequestLocation bool `json:"request_location"`
+}
+
+// sendMessageNode отправляет сообщение и при необходимости ждет нажатия кнопки
+type sendMessageNode struct {
+	engine *Engine
+}
+
+func (n *sendMessageNode) Execute(ctx context.Context, exec *Execution, node Node) (Result, error) {
+	var cfg SendMessageConfig
+	if err := json.Unmarshal(node.Config, &cfg); err != nil {
+		return Result{}, fmt.Errorf("invalid send_message config: %w", err)
+	}
+
+	msg, err := n.engine.SendMessage(ctx, exec, cfg)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if cfg.waitsForCallback() && msg != nil {
+		return Result{Wait: &Wait{Kind: "callback", MessageID: msg.ID}}, nil
+	}
+
+	return Result{}, nil
+}
+
+func (n *sendMessageNode) HandleInput(ctx context.Context, exec *Execution, node Node, input Input) (Result, bool, error) {
+	if input.CallbackData == "" || exec.Wait == nil {
+		return Result{}, false, nil
+	}
+	// Кнопки со старых сообщений не продолжают текущий шаг
+	if exec.Wait.MessageID != 0 && input.MessageID != exec.Wait.MessageID {
+		return Result{}, false, nil
+	}
+
+	var cfg SendMessageConfig
+	if err := json.Unmarshal(node.Config, &cfg); err != nil {
+		return Result{}, true, fmt.Errorf("invalid send_message config: %w", err)
+	}
+
+	variable := cfg.Variable
+	if variable == "" {
+		variable = defaultCallbackVariable
+	}
+	exec.Set(variable, input.CallbackData)
+
+	return Result{}, true, nil
+}
+
+// waitsForCallback - нужно ли ждать нажатия inline кнопки
+func (cfg SendMessageConfig) waitsForCallback() bool {
+	if cfg.WaitForCallback != nil {
+		return *cfg.WaitForCallback
+	}
+	for _, row := range cfg.InlineKeyboard {
+		for _, btn := range row {
+			if btn.Data != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SendMessage отправляет сообщение по конфигурации в чат выполнения.
+// Возвращает последнее отправленное сообщение (к нему привязана клавиатура).
+func (e *Engine) SendMessage(ctx context.Context, exec *Execution, cfg SendMessageConfig) (*tele.Message, error) {
+	mode := ParseMode(cfg.ParseMode)
+	text := Render(cfg.Text, exec.Variables, mode)
+	to := tele.ChatID(exec.ChatID)
+
+	markup, err := cfg.markup(exec.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &tele.SendOptions{
+		ParseMode:             mode,
+		ReplyMarkup:           markup,
+		DisableWebPagePreview: cfg.DisablePreview,
+	}
+
+	var msg *tele.Message
+	switch {
+	case len(cfg.Album) > 0:
+		if len(cfg.Album) < minAlbumSize || len(cfg.Album) > maxAlbumSize {
+			return nil, fmt.Errorf("album must contain %d to %d items, got %d", minAlbumSize, maxAlbumSize, len(cfg.Album))
+		}
+		album := make(tele.Album, 0, len(cfg.Album))
+		for i, item := range cfg.Album {
+			caption := Render(item.Caption, exec.Variables, mode)
+			// Если клавиатуры нет, текст узла становится подписью первого элемента
+			if i == 0 && caption == "" && markup == nil {
+				caption = text
+			}
+			album = append(album, mediaItem(item, exec.Variables, caption))
+		}
+
+		msgs, err := e.bot.SendAlbum(to, album, &tele.SendOptions{ParseMode: mode})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send album: %w", err)
+		}
+		if len(msgs) > 0 {
+			msg = &msgs[len(msgs)-1]
+		}
+
+		// Альбом не поддерживает клавиатуру - отправляем ее отдельным сообщением
+		if markup != nil {
+			if text == "" {
+				return nil, fmt.Errorf("text is required to send keyboard with album")
+			}
+			if msg, err = e.bot.Send(to, text, opts); err != nil {
+				return nil, fmt.Errorf("failed to send message: %w", err)
+			}
+		}
+	case cfg.Photo != "":
+		photo := &tele.Photo{File: fileFrom(Render(cfg.Photo, exec.Variables, tele.ModeDefault)), Caption: text}
+		if msg, err = e.bot.Send(to, photo, opts); err != nil {
+			return nil, fmt.Errorf("failed to send photo: %w", err)
+		}
+	case cfg.Document != "":
+		doc := &tele.Document{File: fileFrom(Render(cfg.Document, exec.Variables, tele.ModeDefault)), Caption: text}
+		if msg, err = e.bot.Send(to, doc, opts); err != nil {
+			return nil, fmt.Errorf("failed to send document: %w", err)
+		}
+	default:
+		if text == "" {
+			return nil, fmt.Errorf("message text is empty")
+		}
+		if msg, err = e.bot.Send(to, text, opts); err != nil {
+			return nil, fmt.Errorf("failed to send message: %w", err)
+		}
+	}
+
+	e.logOutgoing(ctx, exec, text, nil)
+
+	return msg, nil
+}
+
+// sendText отправляет простой текст (служебные сообщения узлов); ошибки только логируются
+func (e *Engine) sendText(ctx context.Context, exec *Execution, text, mode string) {
+	if _, err := e.SendMessage(ctx, exec, SendMessageConfig{Text: text, ParseMode: mode}); err != nil {
+		logger.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// markup строит клавиатуру из конфигурации
+func (cfg SendMessageConfig) markup(vars map[string]interface{}) (*tele.ReplyMarkup, error) {
+	switch {
+	case len(cfg.InlineKeyboard) > 0:
+		rows := make([][]tele.InlineButton, 0, len(cfg.InlineKeyboard))
+		for _, row := range cfg.InlineKeyboard {
+			buttons := make([]tele.InlineButton, 0, len(row))
+			for _, btn := range row {
+				b := tele.InlineButton{
+					Text: Render(btn.Text, vars, tele.ModeDefault),
+					URL:  Render(btn.URL, vars, tele.ModeDefault),
+					Data: Render(btn.Data, vars, tele.ModeDefault),
+				}
+				if b.URL == "" && b.Data == "" {
+					b.Data = b.Text
+				}
+				if len(b.Data) > maxCallbackDataLen {
+					return nil, fmt.Errorf("callback data %q is longer than %d bytes", b.Data, maxCallbackDataLen)
+				}
+				buttons = append(buttons, b)
+			}
+			rows = append(rows, buttons)
+		}
+		return &tele.ReplyMarkup{InlineKeyboard: rows}, nil
+
+	case len(cfg.ReplyKeyboard) > 0:
+		rows := make([][]tele.ReplyButton, 0, len(cfg.ReplyKeyboard))
+		for _, row := range cfg.ReplyKeyboard {
+			buttons := make([]tele.ReplyButton, 0, len(row))
+			for _, btn := range row {
+				buttons = append(buttons, tele.ReplyButton{
+					Text:     Render(btn.Text, vars, tele.ModeDefault),
+					Contact:  btn.RequestContact,
+					Location: btn.RequestLocation,
+				})
+			}
+			rows = append(rows, buttons)
+		}
+		return &tele.ReplyMarkup{
+			ReplyKeyboard:   rows,
+			ResizeKeyboard:  cfg.ResizeKeyboard,
+			OneTimeKeyboard: cfg.OneTimeKeyboard,
+		}, nil
+
+	case cfg.RemoveKeyboard:
+		return &tele.ReplyMarkup{RemoveKeyboard: true}, nil
+	}
+
+	return nil, nil
+}
+
+func mediaItem(item MediaConfig, vars map[string]interface{}, caption string) tele.Inputtable {
+	file := fileFrom(Render(item.URL, vars, tele.ModeDefault))
+	if item.Type == "document" {
+		return &tele.Document{File: file, Caption: caption}
+	}
+	return &tele.Photo{File: file, Caption: caption}
+}
+
+// fileFrom - URL файла или file_id, уже загруженного в Telegram
+func fileFrom(ref string) tele.File {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return tele.FromURL(ref)
+	}
+	return tele.File{FileID: ref}
+}
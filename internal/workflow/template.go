@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// placeholderRe находит подстановки вида {{name}} или {{user.first_name}}
+var placeholderRe = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+// Render подставляет переменные workflow в шаблон.
+// Значения экранируются под parse mode, сам шаблон - нет: разметку в нем пишет автор workflow.
+// Неизвестные переменные заменяются пустой строкой.
+func Render(template string, vars map[string]interface{}, mode tele.ParseMode) string {
+	return placeholderRe.ReplaceAllStringFunc(template, func(match string) string {
+		name := placeholderRe.FindStringSubmatch(match)[1]
+		value, ok := lookup(vars, name)
+		if !ok || value == nil {
+			return ""
+		}
+		return Escape(formatValue(value), mode)
+	})
+}
+
+// Escape экранирует текст для выбранного parse mode
+func Escape(text string, mode tele.ParseMode) string {
+	switch mode {
+	case tele.ModeMarkdownV2:
+		return markdownV2Replacer.Replace(text)
+	case tele.ModeMarkdown:
+		return markdownReplacer.Replace(text)
+	case tele.ModeHTML:
+		return html.EscapeString(text)
+	default:
+		return text
+	}
+}
+
+// Символы, которые нужно экранировать в MarkdownV2 (см. Bot API, Formatting options)
+var markdownV2Replacer = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+var markdownReplacer = strings.NewReplacer(
+	"_", `\_`, "*", `\*`, "`", "\\`", "[", `\[`,
+)
+
+// ParseMode приводит значение из конфигурации (узла, шаблона) к tele.ParseMode
+func ParseMode(mode string) tele.ParseMode {
+	switch strings.ToLower(mode) {
+	case "markdownv2":
+		return tele.ModeMarkdownV2
+	case "markdown":
+		return tele.ModeMarkdown
+	case "html":
+		return tele.ModeHTML
+	default:
+		return tele.ModeDefault
+	}
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		// Числа из JSON приходят как float64 - не показываем лишние нули
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprintf("%g", v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,112 @@
+package workflow
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationConfig - правила проверки ответа пользователя
+type ValidationConfig struct {
+	// Type - "text", "email", "phone", "number", "date", "regex", "choice"
+	Type string `json:"type"`
+	// Min / Max - диапазон для number, длина для text
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+	// Pattern - регулярное выражение для regex
+	Pattern string `json:"pattern"`
+	// Choices - допустимые варианты для choice
+	Choices []string `json:"choices"`
+	// DateFormat - формат даты в нотации Go (по умолчанию "02.01.2006")
+	DateFormat string `json:"date_format"`
+}
+
+const defaultDateFormat = "02.01.2006"
+
+var phoneCleanupRe = regexp.MustCompile(`[\s()\-.]`)
+
+// Validate проверяет ответ и возвращает нормализованное значение для сохранения в переменную
+func (v ValidationConfig) Validate(answer string) (interface{}, error) {
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return nil, fmt.Errorf("empty answer")
+	}
+
+	switch v.Type {
+	case "", "text":
+		length := float64(len([]rune(answer)))
+		if v.Min != nil && length < *v.Min {
+			return nil, fmt.Errorf("answer is too short")
+		}
+		if v.Max != nil && length > *v.Max {
+			return nil, fmt.Errorf("answer is too long")
+		}
+		return answer, nil
+
+	case "email":
+		addr, err := mail.ParseAddress(answer)
+		if err != nil || addr.Address != answer || !strings.Contains(answer[strings.Index(answer, "@"):], ".") {
+			return nil, fmt.Errorf("invalid email")
+		}
+		return strings.ToLower(answer), nil
+
+	case "phone":
+		phone := phoneCleanupRe.ReplaceAllString(answer, "")
+		digits := strings.TrimPrefix(phone, "+")
+		if _, err := strconv.ParseUint(digits, 10, 64); err != nil || len(digits) < 10 || len(digits) > 15 {
+			return nil, fmt.Errorf("invalid phone")
+		}
+		// Российские номера приводим к +7
+		if len(digits) == 11 && digits[0] == '8' {
+			digits = "7" + digits[1:]
+		}
+		return "+" + digits, nil
+
+	case "number":
+		n, err := strconv.ParseFloat(strings.Replace(answer, ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number")
+		}
+		if v.Min != nil && n < *v.Min {
+			return nil, fmt.Errorf("number is less than %v", *v.Min)
+		}
+		if v.Max != nil && n > *v.Max {
+			return nil, fmt.Errorf("number is greater than %v", *v.Max)
+		}
+		return n, nil
+
+	case "date":
+		format := v.DateFormat
+		if format == "" {
+			format = defaultDateFormat
+		}
+		d, err := time.Parse(format, answer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date")
+		}
+		return d.Format("2006-01-02"), nil
+
+	case "regex":
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		if !re.MatchString(answer) {
+			return nil, fmt.Errorf("answer does not match pattern")
+		}
+		return answer, nil
+
+	case "choice":
+		for _, choice := range v.Choices {
+			if strings.EqualFold(choice, answer) {
+				return choice, nil
+			}
+		}
+		return nil, fmt.Errorf("answer is not one of the choices")
+	}
+
+	return nil, fmt.Errorf("unknown validation type %q", v.Type)
+}
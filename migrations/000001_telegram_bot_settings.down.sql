@@ -0,0 +1 @@
+ALTER TABLE telegram_bots DROP COLUMN IF EXISTS settings;
@@ -0,0 +1,4 @@
+-- Настройки бота, которые редактируются в CRM
+ALTER TABLE telegram_bots ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN telegram_bots.settings IS 'Дополнительные настройки бота (JSON)';
@@ -0,0 +1,16 @@
+-- Расход токенов AI по ботам за день: основа месячных лимитов тарифа
+CREATE TABLE IF NOT EXISTS telegram_ai_usage (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES core_profiles(id) ON DELETE CASCADE,
+    bot_id UUID NOT NULL REFERENCES telegram_bots(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    model TEXT NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    prompt_tokens BIGINT NOT NULL DEFAULT 0,
+    completion_tokens BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (bot_id, day, model)
+);
+
+CREATE INDEX IF NOT EXISTS idx_telegram_ai_usage_profile_day ON telegram_ai_usage (profile_id, day);
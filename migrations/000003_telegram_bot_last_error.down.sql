@@ -0,0 +1,3 @@
+ALTER TABLE telegram_bots
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS last_error_at;
@@ -0,0 +1,7 @@
+-- Бот с отозванным токеном выключается сервисом: причина видна в CRM
+ALTER TABLE telegram_bots
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN telegram_bots.last_error IS 'Последняя фатальная ошибка бота (например, отозванный токен)';
+
@@ -0,0 +1,3 @@
+ALTER TABLE telegram_messages_log DROP COLUMN IF EXISTS account_id;
+DROP TABLE IF EXISTS telegram_handoff_messages;
+DROP TABLE IF EXISTS telegram_handoffs;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS telegram_handoffs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES core_profiles(id) ON DELETE CASCADE,
+    bot_id UUID NOT NULL REFERENCES telegram_bots(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    telegram_user_id BIGINT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open',
+    reason TEXT NOT NULL DEFAULT '',
+    account_id UUID REFERENCES core_accounts(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    closed_at TIMESTAMPTZ,
+    closed_by UUID REFERENCES core_accounts(id) ON DELETE SET NULL
+);
+
+COMMENT ON TABLE telegram_handoffs IS 'Передача диалога оператору: пока status = ''open'', AI и workflows в чате не работают';
+COMMENT ON COLUMN telegram_handoffs.account_id IS 'Оператор, взявший диалог (core_accounts)';
+
+CREATE INDEX IF NOT EXISTS idx_telegram_handoffs_chat ON telegram_handoffs (bot_id, chat_id, status);
+
+CREATE TABLE IF NOT EXISTS telegram_handoff_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    handoff_id UUID NOT NULL REFERENCES telegram_handoffs(id) ON DELETE CASCADE,
+    staff_chat_id BIGINT NOT NULL,
+    staff_message_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+COMMENT ON TABLE telegram_handoff_messages IS 'Сообщения в чате сотрудников, ответ на которые уходит клиенту';
+
+CREATE INDEX IF NOT EXISTS idx_telegram_handoff_messages_staff ON telegram_handoff_messages (staff_chat_id, staff_message_id);
+
+ALTER TABLE telegram_messages_log ADD COLUMN IF NOT EXISTS account_id UUID REFERENCES core_accounts(id) ON DELETE SET NULL;
+
+COMMENT ON COLUMN telegram_messages_log.account_id IS 'Оператор, отправивший сообщение (core_accounts), для ответов из handoff';
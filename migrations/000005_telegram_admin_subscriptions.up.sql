@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS telegram_admin_subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES core_profiles(id) ON DELETE CASCADE,
+    bot_id UUID NOT NULL REFERENCES telegram_bots(id) ON DELETE CASCADE,
+    account_id UUID REFERENCES core_accounts(id) ON DELETE CASCADE,
+    chat_id BIGINT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (bot_id, chat_id)
+);
+
+COMMENT ON TABLE telegram_admin_subscriptions IS 'Сотрудники, получающие в боте уведомления о новых заказах и записях';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS telegram_template_sends;
+DROP TABLE IF EXISTS telegram_message_templates;
@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS telegram_message_templates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES core_profiles(id) ON DELETE CASCADE,
+    bot_id UUID REFERENCES telegram_bots(id) ON DELETE SET NULL,
+    module_code TEXT NOT NULL,
+    template_key TEXT NOT NULL,
+    template_name TEXT NOT NULL,
+    description TEXT,
+    message_text TEXT NOT NULL,
+    parse_mode TEXT DEFAULT 'HTML',
+    send_immediately BOOLEAN DEFAULT true,
+    send_delay_minutes INTEGER DEFAULT 0,
+    trigger_event TEXT,
+    conditions JSONB,
+    is_active BOOLEAN DEFAULT true,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW(),
+    created_by UUID REFERENCES core_accounts(id) ON DELETE SET NULL
+);
+
+COMMENT ON TABLE telegram_message_templates IS 'Шаблоны сообщений клиентам по событиям CRM (аналог vk_message_templates).
+bot_id = NULL - отправлять через первого активного бота профиля';
+
+CREATE INDEX IF NOT EXISTS idx_telegram_message_templates_trigger ON telegram_message_templates (profile_id, trigger_event);
+
+CREATE TABLE IF NOT EXISTS telegram_template_sends (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES core_profiles(id) ON DELETE CASCADE,
+    bot_id UUID NOT NULL REFERENCES telegram_bots(id) ON DELETE CASCADE,
+    template_id UUID NOT NULL REFERENCES telegram_message_templates(id) ON DELETE CASCADE,
+    template_key TEXT NOT NULL,
+    trigger_event TEXT NOT NULL,
+    entity_type TEXT NOT NULL,
+    entity_id UUID NOT NULL,
+    chat_id BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    status TEXT NOT NULL,
+    telegram_message_id BIGINT,
+    error_message TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+COMMENT ON TABLE telegram_template_sends IS 'Отправки сообщений по шаблонам: status = ''sent'' или ''failed''';
+
+CREATE INDEX IF NOT EXISTS idx_telegram_template_sends_entity ON telegram_template_sends (entity_type, entity_id);
@@ -0,0 +1,2 @@
+ALTER TABLE spaces_room_blocks
+    DROP COLUMN IF EXISTS hold_until;
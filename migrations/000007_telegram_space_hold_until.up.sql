@@ -0,0 +1,11 @@
+-- Срок предварительной брони помещения из бота хранится в самой брони: смена
+-- booking_hold_minutes не продлевает и не сокращает уже созданные брони
+ALTER TABLE spaces_room_blocks
+    ADD COLUMN IF NOT EXISTS hold_until TIMESTAMPTZ;
+
+COMMENT ON COLUMN spaces_room_blocks.hold_until IS 'До какого времени держится предварительная бронь из бота (reason = telegram_hold)';
+
+-- Брони, созданные до миграции, держатся срок по умолчанию (30 минут)
+UPDATE spaces_room_blocks
+SET hold_until = created_at + INTERVAL '30 minutes'
+WHERE reason = 'telegram_hold' AND hold_until IS NULL;
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrLockNotAcquired - блокировку не удалось получить до отмены контекста
+var ErrLockNotAcquired = errors.New("lock not acquired")
+
+// releaseScript удаляет ключ, только если блокировка все еще наша
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// extendScript продлевает блокировку, только если она все еще наша
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RedisLock - распределенная блокировка на Redis (SET NX PX) с автоматическим продлением
+type RedisLock struct {
+	client *redis.Client
+	key    string
+	token  string
+	ttl    time.Duration
+	stop   chan struct{}
+	once   sync.Once
+}
+
+// AcquireLock ждет блокировку key, пока не отменен ctx.
+// Пока блокировка удерживается, она продлевается каждые ttl/3,
+// поэтому ttl ограничивает только время жизни блокировки упавшего процесса.
+func AcquireLock(ctx context.Context, client *redis.Client, key string, ttl time.Duration) (*RedisLock, error) {
+	token := uuid.NewString()
+	backoff := 20 * time.Millisecond
+
+	for {
+		ok, err := TryAcquireLease(ctx, client, key, token, ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrLockNotAcquired
+		case <-time.After(backoff):
+		}
+		if backoff < 500*time.Millisecond {
+			backoff *= 2
+		}
+	}
+
+	l := &RedisLock{
+		client: client,
+		key:    key,
+		token:  token,
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+	}
+	go l.keepAlive()
+
+	return l, nil
+}
+
+func (l *RedisLock) keepAlive() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			RenewLease(ctx, l.client, l.key, l.token, l.ttl)
+			cancel()
+		}
+	}
+}
+
+// Release освобождает блокировку
+func (l *RedisLock) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ReleaseLease(ctx, l.client, l.key, l.token)
+	})
+}
+
+// TryAcquireLease пытается занять ключ за владельцем owner на ttl (без ожидания)
+func TryAcquireLease(ctx context.Context, client *redis.Client, key, owner string, ttl time.Duration) (bool, error) {
+	return client.SetNX(ctx, key, owner, ttl).Result()
+}
+
+// RenewLease продлевает ключ, если он все еще принадлежит owner.
+// Возвращает false, если ключ истек или его занял кто-то другой.
+func RenewLease(ctx context.Context, client *redis.Client, key, owner string, ttl time.Duration) (bool, error) {
+	n, err := extendScript.Run(ctx, client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ReleaseLease удаляет ключ, если он принадлежит owner
+func ReleaseLease(ctx context.Context, client *redis.Client, key, owner string) error {
+	return releaseScript.Run(ctx, client, []string{key}, owner).Err()
+}
@@ -1,33 +1,247 @@
 package utils
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
 )
 
-// Logger простой логгер (в будущем можно заменить на zap/zerolog)
-type Logger struct {
-	*log.Logger
+// Структурированное логирование на slog.
+//
+// Каждый модуль берет логгер через Module("bot"), а поля запроса (bot_id, profile_id,
+// chat_id, update_id, execution_id) кладутся в контекст через WithLogAttrs и попадают
+// в каждую строку, записанную с этим контекстом (logger.InfoContext(ctx, ...)).
+//
+// Настройки приходят из config.Log (LOG_FORMAT, LOG_LEVEL, LOG_LEVELS, LOG_REDACT).
+// До вызова SetupLogger пишется текст в stderr - этого хватает для ошибок загрузки настроек.
+
+// redactedKeys - поля, значения которых не попадают в лог (тексты сообщений и секреты)
+var redactedKeys = map[string]bool{
+	"text":         true,
+	"message_text": true,
+	"caption":      true,
+	"response":     true,
+	"answer":       true,
+	"token":        true,
+	"bot_token":    true,
+	"api_key":      true,
+	"password":     true,
+}
+
+// botTokenPattern - токен Telegram бота (например, в URL из ошибки HTTP клиента)
+var botTokenPattern = regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`)
+
+type logConfig struct {
+	next         slog.Handler
+	defaultLevel slog.Level
+	levels       map[string]slog.Level
+	redact       bool
+}
+
+var currentLogConfig atomic.Pointer[logConfig]
+
+func init() {
+	currentLogConfig.Store(&logConfig{
+		next:         slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		defaultLevel: slog.LevelInfo,
+		redact:       true,
+	})
 }
 
-func NewLogger() *Logger {
-	return &Logger{
-		Logger: log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile),
+// LogOptions - настройки логирования
+type LogOptions struct {
+	// Format - json (по умолчанию) или text
+	Format string
+	// Level - уровень по умолчанию: debug, info, warn, error
+	Level string
+	// Levels - уровни отдельных модулей, например {"workflow": "debug"}
+	Levels map[string]string
+	// Redact - скрывать тексты сообщений и секреты (выключать только для отладки)
+	Redact bool
+}
+
+// SetupLogger настраивает логирование и делает его логгером по умолчанию
+// (в том числе для стандартного пакета log)
+func SetupLogger(opts LogOptions) {
+	SetupLoggerTo(os.Stdout, opts)
+}
+
+// SetupLoggerTo - как SetupLogger, но с явным выводом
+func SetupLoggerTo(w io.Writer, opts LogOptions) {
+	handlerOpts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var next slog.Handler
+	if strings.EqualFold(opts.Format, "text") {
+		next = slog.NewTextHandler(w, handlerOpts)
+	} else {
+		next = slog.NewJSONHandler(w, handlerOpts)
 	}
+
+	cfg := &logConfig{
+		next:         next,
+		defaultLevel: parseLevel(opts.Level, slog.LevelInfo),
+		levels:       make(map[string]slog.Level),
+		redact:       opts.Redact,
+	}
+
+	for module, level := range opts.Levels {
+		cfg.levels[module] = parseLevel(level, cfg.defaultLevel)
+	}
+
+	currentLogConfig.Store(cfg)
+	slog.SetDefault(slog.New(&contextHandler{}))
+}
+
+// Module возвращает логгер модуля; уровень модуля задается в LOG_LEVELS
+func Module(name string) *slog.Logger {
+	return slog.New(&contextHandler{module: name, attrs: []slog.Attr{slog.String("module", name)}})
 }
 
-func (l *Logger) Info(msg string, args ...interface{}) {
-	l.Printf("[INFO] "+msg, args...)
+type logAttrsKey struct{}
+
+// WithLogAttrs добавляет поля ко всем строкам лога, записанным с этим контекстом.
+// Аргументы - как у slog: пары ключ-значение или slog.Attr.
+func WithLogAttrs(ctx context.Context, args ...any) context.Context {
+	var r slog.Record
+	r.Add(args...)
+
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	attrs := make([]slog.Attr, 0, len(existing)+r.NumAttrs())
+	added := make(map[string]bool, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		added[a.Key] = true
+		return true
+	})
+
+	// Новое значение ключа заменяет старое
+	for _, a := range existing {
+		if !added[a.Key] {
+			attrs = append(attrs, a)
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
 }
 
-func (l *Logger) Error(msg string, args ...interface{}) {
-	l.Printf("[ERROR] "+msg, args...)
+// contextHandler добавляет поля из контекста, фильтрует по уровню модуля
+// и скрывает чувствительные данные. Конфигурация берется на момент записи,
+// поэтому логгеры модулей можно создавать до SetupLogger.
+type contextHandler struct {
+	module string
+	attrs  []slog.Attr
+	group  string
 }
 
-func (l *Logger) Debug(msg string, args ...interface{}) {
-	l.Printf("[DEBUG] "+msg, args...)
+func (h *contextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	cfg := currentLogConfig.Load()
+	if moduleLevel, ok := cfg.levels[h.module]; ok {
+		return level >= moduleLevel
+	}
+	return level >= cfg.defaultLevel
 }
 
-func (l *Logger) Warn(msg string, args ...interface{}) {
-	l.Printf("[WARN] "+msg, args...)
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	cfg := currentLogConfig.Load()
+
+	out := slog.NewRecord(r.Time, r.Level, cfg.redactString(r.Message), r.PC)
+	if ctx != nil {
+		if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+			for _, a := range attrs {
+				out.AddAttrs(cfg.redactAttr(a))
+			}
+		}
+	}
+	for _, a := range h.attrs {
+		out.AddAttrs(cfg.redactAttr(a))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if h.group != "" {
+			a.Key = h.group + "." + a.Key
+		}
+		out.AddAttrs(cfg.redactAttr(a))
+		return true
+	})
+
+	return cfg.next.Handle(ctx, out)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := &contextHandler{
+		module: h.module,
+		group:  h.group,
+		attrs:  append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+	for _, a := range attrs {
+		if a.Key == "module" {
+			next.module = a.Value.String()
+		}
+	}
+	return next
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	group := name
+	if h.group != "" {
+		group = h.group + "." + name
+	}
+	return &contextHandler{module: h.module, attrs: h.attrs, group: group}
+}
+
+func (cfg *logConfig) redactAttr(a slog.Attr) slog.Attr {
+	if !cfg.redact {
+		return a
+	}
+
+	value := a.Value.Resolve()
+	switch {
+	case redactedKeys[strings.ToLower(a.Key)]:
+		if value.Kind() == slog.KindString {
+			return slog.String(a.Key, fmt.Sprintf("[redacted, %d chars]", len([]rune(value.String()))))
+		}
+		return slog.String(a.Key, "[redacted]")
+	case value.Kind() == slog.KindString:
+		return slog.String(a.Key, cfg.redactString(value.String()))
+	case value.Kind() == slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, 0, len(group))
+		for _, ga := range group {
+			attrs = append(attrs, cfg.redactAttr(ga))
+		}
+		return slog.Group(a.Key, attrs...)
+	case value.Kind() == slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, cfg.redactString(err.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
+
+func (cfg *logConfig) redactString(s string) string {
+	if !cfg.redact {
+		return s
+	}
+	return RedactBotToken(s)
+}
+
+// RedactBotToken скрывает токены ботов в тексте ошибки перед тем, как показать
+// или сохранить его (статус бота, last_error в БД). Не зависит от LOG_REDACT
+func RedactBotToken(s string) string {
+	return botTokenPattern.ReplaceAllString(s, "[bot-token]")
+}
+
+func parseLevel(value string, fallback slog.Level) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return fallback
+	}
+	return level
 }